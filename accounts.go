@@ -0,0 +1,67 @@
+package wservice
+
+// Accounts are served as resources by the /v1 API (see v1.go): GetAccounts and GetAccount return them with their balances at the scale of the store,
+// while GetTable and GetWallet keep serving the formatted rows of the legacy /accounts
+
+// GetAccounts is a ledger type method that fetches every account ordered by ID, or only the accounts of the given wallet ordered by currency
+func (l ledger) GetAccounts(walletID string) ([]Account, error) {
+	var accounts []Account
+	err := l.store.View(func(tx LedgerTx) error {
+		var err error
+		if walletID != "" {
+			accounts, err = tx.WalletAccounts(walletID)
+		} else {
+			accounts, err = tx.Accounts()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// A wallet only exists through the accounts grouped under it
+	if walletID != "" && len(accounts) == 0 {
+		var ErrNoWallet = newError(ErrWalletNotFound, "The wallet "+walletID+" does not exist")
+		return nil, ErrNoWallet
+	}
+
+	results := make([]Account, 0, len(accounts))
+	for _, a := range accounts {
+		if a, err = scaledAccount(a); err != nil {
+			return nil, err
+		}
+		results = append(results, a)
+	}
+	return results, nil
+}
+
+// GetAccount is a ledger type method that fetches a single account by its ID
+func (l ledger) GetAccount(id string) (Account, error) {
+	var a Account
+	err := l.store.View(func(tx LedgerTx) error {
+		var err error
+		a, err = tx.Account(id)
+		if err == errNotFound {
+			var ErrNoAccount = newError(ErrAccountNotFound, "The account "+id+" does not exist")
+			return ErrNoAccount
+		}
+		return err
+	})
+	if err != nil {
+		return Account{}, err
+	}
+	return scaledAccount(a)
+}
+
+// scaledAccount formats the balances of an account at the scale of the store, whatever the representation its store reads them with
+func scaledAccount(a Account) (Account, error) {
+	balance, err := parseAmount(a.Balance)
+	if err != nil {
+		return Account{}, err
+	}
+	initial, err := parseAmount(a.InitialBalance)
+	if err != nil {
+		return Account{}, err
+	}
+	a.Balance, a.InitialBalance = balance.FloatString(storeAmountScale), initial.FloatString(storeAmountScale)
+	return a, nil
+}
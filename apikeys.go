@@ -0,0 +1,237 @@
+package wservice
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// API keys authenticate the clients of the API (see auth.go). They are issued by the "apikeys" command of the server, and every key is made of its ID
+// and of a random secret ("wsk_<ID>_<secret>"). Only the SHA-256 hash of a key is kept, so a key is only shown when it is issued. A key is granted some
+// scopes, each of which lets it call a group of endpoints, and it can be rotated, the rotated key staying valid for a grace period so its clients can
+// move to the new one, or revoked at once
+
+// The scopes an API key can be granted: read calls the endpoints that read the ledger, transfer the ones that move funds and admin the ones that
+// configure the service (currencies, interest and webhooks). The scopes are independent of each other, a key that moves funds usually reads too
+const (
+	ScopeRead     = "read"
+	ScopeTransfer = "transfer"
+	ScopeAdmin    = "admin"
+)
+
+// apiKeyScopes are the scopes an API key can be granted
+var apiKeyScopes = []string{ScopeRead, ScopeTransfer, ScopeAdmin}
+
+// apiKeyPrefix starts every API key so that a leaked one is easy to spot
+const apiKeyPrefix = "wsk_"
+
+// APIKey is a key the clients of the API authenticate with. Key is the key itself and is only returned when the key is issued, Hash is what is kept of it.
+// A rotated key expires at ExpiresAt and a revoked one was revoked at RevokedAt
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Key       string     `json:"key,omitempty"`
+	Hash      string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// allows reports whether the key was granted a scope
+func (k APIKey) allows(scope string) bool {
+	return contains(k.Scopes, scope)
+}
+
+// newAPIKey returns a new key with the given ID and the hash it is kept as
+func newAPIKey(id string) (string, string, error) {
+	var secret [32]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return "", "", err
+	}
+	key := apiKeyPrefix + id + "_" + hex.EncodeToString(secret[:])
+	return key, hashAPIKey(key), nil
+}
+
+// hashAPIKey returns the hash an API key is kept as. The secret of a key is random, so a plain SHA-256 is enough to keep it from being read back
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyID returns the ID of the API key a client presented, false when it is not shaped like a key
+func apiKeyID(key string) (string, bool) {
+	parts := strings.Split(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if !strings.HasPrefix(key, apiKeyPrefix) || len(parts) != 2 || !isULID(parts[0]) {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// apiKeyKey returns the ULID of an API key out of the ID it was requested with
+func apiKeyKey(id string) (string, error) {
+	if uid := strings.ToUpper(id); isULID(uid) {
+		return uid, nil
+	}
+	var ErrID = newError(ErrInvalidRequest, "The API key ID must be a ULID")
+	return "", ErrID
+}
+
+// The errors of the API keys: errNoAPIKey when the requested key does not exist, and the reasons a request is not authenticated
+var (
+	errNoAPIKey         = newError(ErrAPIKeyNotFound, "The API key does not exist")
+	errAPIKeyMissing    = newError(ErrUnauthenticated, "An API key is required, as a bearer token of the Authorization header or in the X-API-Key header")
+	errAPIKeyInvalid    = newError(ErrUnauthenticated, "The API key is not valid")
+	errAPIKeyRevoked    = newError(ErrUnauthenticated, "The API key was revoked")
+	errAPIKeyExpired    = newError(ErrUnauthenticated, "The API key expired")
+	errAPIKeyWasRevoked = newError(ErrAPIKeyRevoked, "The API key was already revoked")
+)
+
+// CreateAPIKey is a ledger type method that issues a new API key with a name and some scopes. The key is returned with its secret, which is never returned again
+func (l ledger) CreateAPIKey(name string, scopes []string) (APIKey, error) {
+	id, err := newULID(l.now())
+	if err != nil {
+		return APIKey{}, err
+	}
+	key, hash, err := newAPIKey(id)
+	if err != nil {
+		return APIKey{}, err
+	}
+	k := APIKey{ID: id, Name: name, Scopes: scopes, Hash: hash, CreatedAt: l.clockTime()}
+	err = l.store.Update(func(tx LedgerTx) error {
+		var err error
+		k, err = tx.InsertAPIKey(k)
+		return err
+	})
+	if err != nil {
+		return APIKey{}, err
+	}
+	k.Key = key
+	return k, nil
+}
+
+// GetAPIKeys is a ledger type method that lists every API key, revoked and expired ones included
+func (l ledger) GetAPIKeys() ([]APIKey, error) {
+	var keys []APIKey
+	err := l.store.View(func(tx LedgerTx) error {
+		var err error
+		keys, err = tx.APIKeys()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RotateAPIKey is a ledger type method that issues a new API key with the name and scopes of an existing one, which expires once the grace period is
+// over (at once when it is 0). The new key is returned with its secret
+func (l ledger) RotateAPIKey(id string, grace time.Duration) (APIKey, error) {
+	uid, err := apiKeyKey(id)
+	if err != nil {
+		return APIKey{}, err
+	}
+	newID, err := newULID(l.now())
+	if err != nil {
+		return APIKey{}, err
+	}
+	key, hash, err := newAPIKey(newID)
+	if err != nil {
+		return APIKey{}, err
+	}
+	var k APIKey
+	err = l.store.Update(func(tx LedgerTx) error {
+		old, err := tx.APIKey(uid)
+		if err == errNotFound {
+			return errNoAPIKey
+		}
+		if err != nil {
+			return err
+		}
+		if old.RevokedAt != nil {
+			return newError(ErrAPIKeyRevoked, "The API key was revoked, it cannot be rotated")
+		}
+		// A key that already expires within the grace period keeps its expiry
+		expiresAt := l.now().Add(grace).UTC()
+		if old.ExpiresAt == nil || expiresAt.Before(*old.ExpiresAt) {
+			old.ExpiresAt = &expiresAt
+		}
+		if err := tx.UpdateAPIKey(old); err != nil {
+			return err
+		}
+		k, err = tx.InsertAPIKey(APIKey{ID: newID, Name: old.Name, Scopes: old.Scopes, Hash: hash, CreatedAt: l.clockTime()})
+		return err
+	})
+	if err != nil {
+		return APIKey{}, err
+	}
+	k.Key = key
+	return k, nil
+}
+
+// RevokeAPIKey is a ledger type method that revokes an API key, which fails to authenticate from then on
+func (l ledger) RevokeAPIKey(id string) (APIKey, error) {
+	uid, err := apiKeyKey(id)
+	if err != nil {
+		return APIKey{}, err
+	}
+	var k APIKey
+	err = l.store.Update(func(tx LedgerTx) error {
+		var err error
+		if k, err = tx.APIKey(uid); err == errNotFound {
+			return errNoAPIKey
+		}
+		if err != nil {
+			return err
+		}
+		if k.RevokedAt != nil {
+			return errAPIKeyWasRevoked
+		}
+		revokedAt := l.now().UTC()
+		k.RevokedAt = &revokedAt
+		return tx.UpdateAPIKey(k)
+	})
+	if err != nil {
+		return APIKey{}, err
+	}
+	return k, nil
+}
+
+// Authenticate is a ledger type method that returns the API key a client presented, as long as it is known, was not revoked and did not expire. When the
+// service requires no API key every request is let through with every scope
+func (l ledger) Authenticate(key string) (APIKey, error) {
+	if !l.apiKeysRequired {
+		return APIKey{Scopes: apiKeyScopes}, nil
+	}
+	if key == "" {
+		return APIKey{}, errAPIKeyMissing
+	}
+	id, ok := apiKeyID(key)
+	if !ok {
+		return APIKey{}, errAPIKeyInvalid
+	}
+	var k APIKey
+	err := l.store.View(func(tx LedgerTx) error {
+		var err error
+		if k, err = tx.APIKey(id); err == errNotFound {
+			return errAPIKeyInvalid
+		}
+		return err
+	})
+	if err != nil {
+		return APIKey{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hashAPIKey(key))) != 1 {
+		return APIKey{}, errAPIKeyInvalid
+	}
+	if k.RevokedAt != nil {
+		return APIKey{}, errAPIKeyRevoked
+	}
+	if k.ExpiresAt != nil && !l.now().Before(*k.ExpiresAt) {
+		return APIKey{}, errAPIKeyExpired
+	}
+	return k, nil
+}
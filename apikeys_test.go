@@ -0,0 +1,109 @@
+package wservice
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// requireAPIKeys returns the wallet service with API keys required, on a clock the test moves
+func requireAPIKeys(svc WalletService, clock *time.Time) WalletService {
+	l := svc.(ledger)
+	l.apiKeysRequired = true
+	l.clock = func() time.Time { return *clock }
+	return l
+}
+
+func TestAPIKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		clock := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		svc = requireAPIKeys(svc, &clock)
+
+		created, err := svc.CreateAPIKey("ci", []string{ScopeRead, ScopeTransfer})
+		assert.Nil(t, err)
+		assert.True(t, isULID(created.ID))
+		assert.True(t, strings.HasPrefix(created.Key, "wsk_"+created.ID+"_"))
+		assert.Equal(t, clock, created.CreatedAt.UTC())
+		k, err := svc.Authenticate(created.Key)
+		assert.Nil(t, err)
+		assert.Equal(t, created.ID, k.ID)
+		assert.Equal(t, []string{ScopeRead, ScopeTransfer}, k.Scopes)
+
+		// Only the hash of the key is kept, and the key is never returned again
+		keys, err := svc.GetAPIKeys()
+		assert.Nil(t, err)
+		assert.Len(t, keys, 1)
+		assert.Empty(t, keys[0].Key)
+		assert.Equal(t, hashAPIKey(created.Key), keys[0].Hash)
+
+		// A missing, malformed, unknown or forged key is not authenticated
+		for _, key := range []string{"", "secret", "wsk_01ARZ3NDEKTSV4RRFFQ69G5FAV_" + strings.Repeat("0", 64), created.Key[:len(created.Key)-1] + "x"} {
+			_, err = svc.Authenticate(key)
+			assert.True(t, errors.Is(err, ErrUnauthenticated), key)
+		}
+
+		// A rotated key keeps working during the grace period, alongside the new one
+		clock = clock.Add(time.Minute)
+		rotated, err := svc.RotateAPIKey(strings.ToLower(created.ID), time.Hour)
+		assert.Nil(t, err)
+		assert.NotEqual(t, created.ID, rotated.ID)
+		assert.Equal(t, "ci", rotated.Name)
+		assert.Equal(t, created.Scopes, rotated.Scopes)
+		_, err = svc.Authenticate(created.Key)
+		assert.Nil(t, err)
+		clock = clock.Add(time.Hour)
+		_, err = svc.Authenticate(created.Key)
+		assert.EqualError(t, err, "The API key expired")
+		_, err = svc.Authenticate(rotated.Key)
+		assert.Nil(t, err)
+
+		// A revoked key fails at once and cannot be rotated or revoked again
+		revoked, err := svc.RevokeAPIKey(rotated.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, clock, revoked.RevokedAt.UTC())
+		_, err = svc.Authenticate(rotated.Key)
+		assert.EqualError(t, err, "The API key was revoked")
+		_, err = svc.RevokeAPIKey(rotated.ID)
+		assert.True(t, errors.Is(err, ErrAPIKeyRevoked))
+		_, err = svc.RotateAPIKey(rotated.ID, 0)
+		assert.True(t, errors.Is(err, ErrAPIKeyRevoked))
+
+		keys, err = svc.GetAPIKeys()
+		assert.Nil(t, err)
+		assert.Len(t, keys, 2)
+		assert.Equal(t, clock, keys[0].ExpiresAt.UTC())
+		assert.Nil(t, keys[0].RevokedAt)
+		assert.NotNil(t, keys[1].RevokedAt)
+
+		_, err = svc.RevokeAPIKey("key")
+		assert.True(t, errors.Is(err, ErrInvalidRequest))
+		_, err = svc.RotateAPIKey("01ARZ3NDEKTSV4RRFFQ69G5FAV", time.Hour)
+		assert.True(t, errors.Is(err, ErrAPIKeyNotFound))
+	})
+}
+
+func TestAPIKeysNotRequired(t *testing.T) {
+	// Without authentication every request is let through with every scope
+	k, err := newMemoryService(t).Authenticate("")
+	assert.Nil(t, err)
+	assert.Equal(t, apiKeyScopes, k.Scopes)
+}
+
+func TestAPIKeyValidation(t *testing.T) {
+	svc := NewValidating(newMemoryService(t))
+	_, err := svc.CreateAPIKey(" ", []string{"write", ScopeRead, ScopeRead})
+	var verr ValidationError
+	assert.True(t, errors.As(err, &verr))
+	assert.Equal(t, []FieldError{
+		{"name", "is required"},
+		{"scopes", "must only list read, transfer, admin, got \"write\""},
+		{"scopes", "must not list \"read\" twice"},
+	}, verr.Fields)
+	_, err = svc.CreateAPIKey("ci", nil)
+	assert.EqualError(t, err, "Validation failed: scopes must list at least one of read, transfer, admin")
+	_, err = svc.RotateAPIKey("01ARZ3NDEKTSV4RRFFQ69G5FAV", -time.Hour)
+	assert.EqualError(t, err, "Validation failed: grace must not be negative (0 expires the rotated key at once)")
+}
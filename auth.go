@@ -0,0 +1,101 @@
+package wservice
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"google.golang.org/grpc/metadata"
+)
+
+// Auth requires every request of the API to carry an API key (see apikeys.go) granted the scope of its endpoint. The transports put the key a client
+// presented in the context of its request, as a bearer token of the Authorization header or in the X-API-Key header (the "authorization" and
+// "x-api-key" metadata with gRPC). The HTTP routes check the key before they validate and decode a request (see newHandler), the JSON-RPC endpoint
+// authenticates it before it reads a request and the Authorize endpoint middleware checks it for the gRPC calls and for the scope of every JSON-RPC call.
+// A request without a valid key fails with ErrUnauthenticated and one whose key is not granted the scope with ErrForbidden. The health checks and the
+// API documentation need no key
+
+// contextKey is the type of the keys of the values the transports put in the context of a request
+type contextKey int
+
+// contextKeyAPIKey holds the API key presented with a request, if any
+const contextKeyAPIKey contextKey = iota
+
+// accessTokenParam is the query parameter AuthorizeHandler also reads the API key from, for the clients that cannot set headers (e.g. EventSource)
+const accessTokenParam = "access_token"
+
+// Authorize exported to be accessible from outside the package (from main)
+// Authorize returns the endpoint middleware that authenticates the API key of a request and checks that it is granted the scope before calling the endpoint
+func Authorize(svc WalletService, scope string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			key, _ := ctx.Value(contextKeyAPIKey).(string)
+			if err := authorize(svc, key, scope); err != nil {
+				return nil, err
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// authorize authenticates an API key and checks that it is granted the scope
+func authorize(svc WalletService, key string, scope string) error {
+	k, err := svc.Authenticate(key)
+	if err != nil {
+		return err
+	}
+	if !k.allows(scope) {
+		return newError(ErrForbidden, "The API key is not granted the \""+scope+"\" scope")
+	}
+	return nil
+}
+
+// populateAPIKey puts the API key of an HTTP request in its context, for the JSON-RPC calls
+func populateAPIKey(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, contextKeyAPIKey, httpAPIKey(r.Header))
+}
+
+// httpAPIKey returns the API key of the headers of a request, the bearer token of the Authorization header first
+func httpAPIKey(h http.Header) string {
+	if auth := h.Get("Authorization"); len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return strings.TrimSpace(h.Get("X-API-Key"))
+}
+
+// populateGRPCAPIKey is the go-kit ServerBefore of the gRPC handlers, it puts the API key of the metadata of the call in its context
+func populateGRPCAPIKey(ctx context.Context, md metadata.MD) context.Context {
+	h := http.Header{}
+	for _, name := range []string{"authorization", "x-api-key"} {
+		if values := md.Get(name); len(values) > 0 {
+			h.Set(name, values[0])
+		}
+	}
+	return context.WithValue(ctx, contextKeyAPIKey, httpAPIKey(h))
+}
+
+// AuthorizeHandler exported to be accessible from outside the package (from main)
+// AuthorizeHandler serves the requests of a plain HTTP handler (the live feed with the read scope, the metrics with the admin scope) whose API key is
+// granted the scope, and answers the others with problem details. The key can also be given in the access_token query parameter, as browsers cannot
+// set the headers of an EventSource
+func AuthorizeHandler(svc WalletService, scope string, next http.Handler) http.Handler {
+	return authorizeRequests(svc, scope, true, next)
+}
+
+// authorizeRequests serves the requests whose API key is granted the scope and answers the others with problem details. The key is read from the
+// headers of a request, and from its access_token query parameter as well with withAccessToken
+func authorizeRequests(svc WalletService, scope string, withAccessToken bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := httpAPIKey(r.Header)
+		if key == "" && withAccessToken {
+			key = r.URL.Query().Get(accessTokenParam)
+		}
+		if err := authorize(svc, key, scope); err != nil {
+			EncodeError(httptransport.PopulateRequestContext(r.Context(), r), err, w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,163 @@
+package wservice
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vstoianovici/wservice/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// serveHTTPWithKey serves a request of the HTTP transport presenting an API key as a bearer token, none when it is empty
+func serveHTTPWithKey(svc WalletService, method string, target string, body string, key string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	rec := httptest.NewRecorder()
+	NewHTTPTransport(svc).ServeHTTP(rec, req)
+	return rec
+}
+
+// issueAPIKey issues an API key with some scopes, failing the test if it cannot be issued
+func issueAPIKey(t *testing.T, svc WalletService, scopes ...string) string {
+	k, err := svc.CreateAPIKey(strings.Join(scopes, "+"), scopes)
+	assert.Nil(t, err)
+	return k.Key
+}
+
+func TestAuthorizationHTTP(t *testing.T) {
+	clock := time.Now()
+	svc := requireAPIKeys(newMemoryService(t), &clock)
+	read, transfer, admin := issueAPIKey(t, svc, ScopeRead), issueAPIKey(t, svc, ScopeTransfer), issueAPIKey(t, svc, ScopeAdmin)
+	transferBody := `{"from":"bob123","to":"alice456","amount":"1"}`
+	for _, c := range []struct {
+		method, target, body string
+		allowed, denied      string
+		status               int
+	}{
+		{http.MethodGet, "/accounts", "", read, transfer, http.StatusOK},
+		{http.MethodGet, "/transfers", "", read, admin, http.StatusOK},
+		{http.MethodGet, "/currencies", "", read, transfer, http.StatusOK},
+		{http.MethodGet, "/v1/accounts/bob123", "", read, transfer, http.StatusOK},
+		{http.MethodGet, "/v1/transfers", "", read, transfer, http.StatusOK},
+		{http.MethodPost, "/submittransfer", transferBody, transfer, read, http.StatusOK},
+		{http.MethodPost, "/v1/transfers", transferBody, transfer, read, http.StatusCreated},
+		{http.MethodPost, "/admin/currencies", `{"code":"JPY","enabled":true}`, admin, transfer, http.StatusOK},
+		{http.MethodGet, "/webhooks", "", admin, read, http.StatusOK},
+		{http.MethodGet, "/metrics", "", admin, read, http.StatusOK},
+	} {
+		// A request without a key, or with a key that is not valid, is not authenticated
+		for _, key := range []string{"", "wsk_nope"} {
+			rec := serveHTTPWithKey(svc, c.method, c.target, c.body, key)
+			assert.Equal(t, http.StatusUnauthorized, rec.Code, c.target)
+			assert.Equal(t, `Bearer realm="wservice"`, rec.Header().Get("WWW-Authenticate"), c.target)
+			assert.Contains(t, rec.Body.String(), `"code":"unauthenticated"`, c.target)
+		}
+		rec := serveHTTPWithKey(svc, c.method, c.target, c.body, c.denied)
+		assert.Equal(t, http.StatusForbidden, rec.Code, c.target)
+		assert.Contains(t, rec.Body.String(), `"code":"forbidden"`, c.target)
+		rec = serveHTTPWithKey(svc, c.method, c.target, c.body, c.allowed)
+		assert.Equal(t, c.status, rec.Code, c.target)
+	}
+
+	// The key is checked before the request is validated and decoded, a request without a valid key learns nothing of the requests a route takes
+	for _, c := range []struct{ method, target, body string }{
+		{http.MethodPost, "/v1/transfers", `{"from":"bob123"}`},
+		{http.MethodPost, "/submittransfer", `{"from":`},
+		{http.MethodPost, "/admin/currencies", `{"code":"JPY","enabled":true,"unknown":1}`},
+		{http.MethodGet, "/v1/transfers?limit=nope", ""},
+		{http.MethodGet, "/transfers/nope", ""},
+	} {
+		rec := serveHTTPWithKey(svc, c.method, c.target, c.body, "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, c.target)
+		assert.Contains(t, rec.Body.String(), `"code":"unauthenticated"`, c.target)
+	}
+
+	// The key can also be presented in the X-API-Key header
+	req := httptest.NewRequest(http.MethodGet, "/v1/accounts", nil)
+	req.Header.Set("X-API-Key", read)
+	rec := httptest.NewRecorder()
+	NewHTTPTransport(svc).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// The API documentation needs no key
+	for _, target := range []string{"/openapi.json", "/docs"} {
+		assert.Equal(t, http.StatusOK, serveHTTPWithKey(svc, http.MethodGet, target, "", "").Code, target)
+	}
+}
+
+func TestAuthorizeHandler(t *testing.T) {
+	clock := time.Now()
+	svc := requireAPIKeys(newMemoryService(t), &clock)
+	read := issueAPIKey(t, svc, ScopeRead)
+	h := AuthorizeHandler(svc, ScopeRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for target, want := range map[string]int{
+		"/transfers/stream":                      http.StatusUnauthorized,
+		"/transfers/stream?access_token=nope":    http.StatusUnauthorized,
+		"/transfers/stream?access_token=" + read: http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, want, rec.Code, target)
+	}
+}
+
+func TestAuthorizationJSONRPC(t *testing.T) {
+	clock := time.Now()
+	svc := requireAPIKeys(newMemoryService(t), &clock)
+	read := issueAPIKey(t, svc, ScopeRead)
+	body := `[
+		{"jsonrpc":"2.0","method":"wallet.listAccounts","id":1},
+		{"jsonrpc":"2.0","method":"wallet.transfer","params":{"from":"bob123","to":"alice456","amount":"1"},"id":2}
+	]`
+	// Every call of a batch is authorized on its own
+	rec := serveHTTPWithKey(svc, http.MethodPost, "/rpc", body, read)
+	var responses []rpcResult
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &responses))
+	if assert.Len(t, responses, 2) {
+		assert.Nil(t, responses[0].Error)
+		assert.Equal(t, JSONRPCForbidden, responses[1].Error.Code)
+	}
+	// A request without a valid key is answered with a single error before it is read, even a batch or a request that is not JSON
+	for _, body := range []string{body, `{"jsonrpc":`} {
+		rec = serveHTTPWithKey(svc, http.MethodPost, "/rpc", body, "")
+		var response rpcResult
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		if assert.NotNil(t, response.Error) {
+			assert.Equal(t, JSONRPCUnauthenticated, response.Error.Code)
+		}
+		assert.Equal(t, json.RawMessage("null"), response.ID)
+	}
+}
+
+func TestAuthorizationGRPC(t *testing.T) {
+	clock := time.Now()
+	svc := requireAPIKeys(newMemoryService(t), &clock)
+	read := issueAPIKey(t, svc, ScopeRead)
+	client := pb.NewWalletClient(newTestGRPCClient(t, svc, NewDrainer()))
+
+	_, err := client.ListAccounts(context.Background(), &pb.ListAccountsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+read)
+	_, err = client.ListAccounts(ctx, &pb.ListAccountsRequest{})
+	assert.Nil(t, err)
+	_, err = client.SubmitTransfer(ctx, &pb.SubmitTransferRequest{From: "bob123", To: "alice456", Amount: "1"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "x-api-key", read)
+	_, err = client.ListAccounts(ctx, &pb.ListAccountsRequest{})
+	assert.Nil(t, err)
+}
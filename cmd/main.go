@@ -1,19 +1,32 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	wservice "github.com/vstoianovici/wservice"
+	"google.golang.org/grpc"
 )
 
 func main() {
-	// Define a cutom logger with the specified format
-	logger := createLogger()
+	// Define a cutom logger with the specified format, swapped for one filtered by the configured log level once the configuration is loaded
+	baseLogger := createLogger()
+	logger := &log.SwapLogger{}
+	logger.Swap(baseLogger)
 	startLogger := log.With(logger, "tag", "start")
 	startLogger.Log("msg", "created logger")
 
@@ -32,21 +45,209 @@ func main() {
 		Help:      "Total duration of requests in microseconds.",
 	}, fieldKeys)
 
+	// Define whether pending schema migrations are applied on startup and how many migrations "migrate down" reverts, the other flags are parsed by LoadConfig
+	autoMigrate := flag.Bool("migrate", false, "Apply pending schema migrations before serving.")
+	steps := flag.Int("steps", 1, "Number of migrations reverted by \"migrate down\".")
+	// Define the API key that "apikeys create" issues and the one "apikeys rotate" and "apikeys revoke" act on
+	keyName := flag.String("name", "", "Name of the API key issued by \"apikeys create\" (e.g. the client it is issued to).")
+	keyScopes := flag.String("scopes", wservice.ScopeRead, "Comma separated scopes of the API key issued by \"apikeys create\" (read, transfer, admin).")
+	keyID := flag.String("id", "", "ID of the API key rotated by \"apikeys rotate\" or revoked by \"apikeys revoke\".")
+	keyGrace := flag.Duration("grace", 24*time.Hour, "How long the key rotated by \"apikeys rotate\" stays valid (0 expires it at once).")
+
+	// "wService migrate up|down|status [flags]" manages the database schema, "wService config print [flags]" shows the effective configuration and
+	// "wService apikeys create|list|rotate|revoke [flags]" manages the API keys instead of serving the API, the command and its action are taken out of
+	// the arguments so the flags still parse
+	var command, action string
+	if len(os.Args) > 1 && (os.Args[1] == "migrate" || os.Args[1] == "config" || os.Args[1] == "apikeys") {
+		command = os.Args[1]
+		if len(os.Args) > 2 {
+			action = os.Args[2]
+			os.Args = append(os.Args[:1], os.Args[3:]...)
+		} else {
+			os.Args = os.Args[:1]
+		}
+		if action == "" && command == "migrate" {
+			action = "status"
+		}
+		if action == "" && command == "config" {
+			action = "print"
+		}
+		if action == "" && command == "apikeys" {
+			action = "list"
+		}
+	}
+
+	// Load the configuration from the defaults, the configuration file, the WSERVICE_* environment variables and the flags
+	loader := wservice.NewConfigLoader()
+	cfg, err := loader.Load()
+	if err != nil {
+		startLogger.Log("msg", "failed to load configuration", "err", err)
+		os.Exit(1)
+	}
+	if command == "config" {
+		os.Exit(printConfig(cfg, action, os.Stdout, startLogger))
+	}
+
 	var svc wservice.WalletService
-	var err error
-	var port int
-	// Create a new wallet service and get a post where to listen and serve
-	svc, port, err = wservice.NewService()
+	// Create a new wallet service out of the validated configuration
+	svc, err = wservice.NewServiceFromConfig(cfg)
 	// In case of any issues return the error to the log
 	if err != nil {
-		startLogger.Log("msg", "failed to connect to database", "err", err)
+		startLogger.Log("msg", "invalid configuration", "err", err)
 		os.Exit(1)
 	}
+	port := cfg.Port
+	logger.Swap(wservice.LevelFilter(baseLogger, cfg.LogLevel))
+	wservice.SetMaxRequestBodySize(cfg.MaxRequestBody)
+	if command == "migrate" {
+		os.Exit(migrate(svc, action, *steps, log.With(logger, "tag", "migrate")))
+	}
+	if command == "apikeys" && cfg.Storage == wservice.StorageMemory {
+		startLogger.Log("msg", "the apikeys command cannot reach the memory of the server, use the key it prints on startup", "storage", cfg.Storage)
+		os.Exit(1)
+	}
+	if command == "apikeys" {
+		os.Exit(apiKeys(wservice.NewValidating(svc), action, *keyName, *keyScopes, *keyID, *keyGrace, os.Stdout, log.With(logger, "tag", "apikeys")))
+	}
+	// Only the Postgres ledger has a schema to migrate and verify, the SQLite one creates its tables itself and the in-memory one has none
+	database := cfg.Storage == wservice.StorageDatabase
+	postgres := database && cfg.Database.Driver == wservice.DriverPostgres
+	if *autoMigrate && postgres {
+		if migrate(svc, "up", 0, log.With(logger, "tag", "migrate")) != 0 {
+			os.Exit(1)
+		}
+	}
+	// Make sure the configured tables and sequence exist before serving any request
+	if postgres {
+		if err = wservice.VerifySchema(svc); err != nil {
+			startLogger.Log("msg", "database schema is not ready", "err", err)
+			os.Exit(1)
+		}
+	} else if database {
+		startLogger.Log("msg", "keeping the ledger in a SQLite database file", "file", cfg.Database.Name)
+	} else {
+		startLogger.Log("msg", "keeping the ledger in memory, it is lost on restart", "storage", cfg.Storage)
+		// The apikeys command cannot reach the memory of the server, so it starts with a key of its own, shown once like the ones the command issues
+		if cfg.Auth.Enabled {
+			key, err := svc.CreateAPIKey("startup", []string{wservice.ScopeRead, wservice.ScopeTransfer, wservice.ScopeAdmin})
+			if err != nil {
+				startLogger.Log("msg", "could not issue the API key of the in-memory ledger", "err", err)
+				os.Exit(1)
+			}
+			startLogger.Log("msg", "issued an API key with every scope, it is printed on stdout and lost on restart", "id", key.ID)
+			fmt.Fprintln(os.Stdout, key.Key)
+		}
+	}
+	if !cfg.Auth.Enabled {
+		startLogger.Log("msg", "API keys are not required, anyone who reaches the API can move funds", "auth", "disabled")
+	}
 	sPortNumber := ":" + strconv.Itoa(port)
+	// Keep the core service around, its connection pool is resized when the configuration is reloaded
+	core := svc
+	// Add a layer of input validation in front of the core wallet service so malformed transfers never reach the database
+	svc = wservice.NewValidating(svc)
 	// Add a layer of logging on top of the core wallet service
 	svc = wservice.NewLogging(logger, svc)
 	// Add a layer of instrumenting on top of the core wallet service
 	svc = wservice.NewInstrumenting(requestCount, requestLatency, svc)
+	// Start the interest accrual and posting job in the background on top of the wrapped service so its calls are logged and instrumented too
+	interest := &interestJob{svc: svc, logger: log.With(logger, "tag", "interest")}
+	interest.start(time.Duration(cfg.InterestInterval))
+	// Publish the events of the outbox to the configured sink in the background, the core service is enough as the relay goes straight to the store
+	publisher, err := wservice.NewEventPublisher(cfg.Outbox)
+	if err != nil {
+		startLogger.Log("msg", "could not create the outbox publisher", "err", err)
+		os.Exit(1)
+	}
+	// With webhooks enabled the relay also queues the events for the subscribed webhooks, which the dispatcher sends in the background
+	var relayed []wservice.EventPublisher
+	stopDispatcher, dispatcherDone := make(chan struct{}), make(chan struct{})
+	if cfg.Webhooks.Enabled {
+		dispatcher, err := wservice.NewWebhookDispatcher(core, cfg.Webhooks)
+		if err != nil {
+			startLogger.Log("msg", "could not create the webhook dispatcher", "err", err)
+			os.Exit(1)
+		}
+		relayed = append(relayed, dispatcher)
+		go func() {
+			wservice.RunWebhookDispatcher(dispatcher, log.With(logger, "tag", "webhooks"), stopDispatcher)
+			close(dispatcherDone)
+		}()
+	} else {
+		close(dispatcherDone)
+	}
+	if publisher != nil {
+		relayed = append(relayed, publisher)
+	}
+	// Listen for the transfers announced by every instance, so that the relay and the live feed pick them up right away rather than at their next interval
+	var listener *wservice.ChangeListener
+	stopListener, listenerDone := make(chan struct{}), make(chan struct{})
+	if cfg.Notifications.Enabled {
+		listener, err = wservice.NewChangeListener(core, cfg.Notifications)
+		if err != nil {
+			startLogger.Log("msg", "could not create the notification listener", "err", err)
+			os.Exit(1)
+		}
+		go func() {
+			wservice.RunChangeListener(listener, log.With(logger, "tag", "notifications"), stopListener)
+			close(listenerDone)
+		}()
+	} else {
+		close(listenerDone)
+	}
+	stopRelay, relayDone := make(chan struct{}), make(chan struct{})
+	if len(relayed) > 0 {
+		var relayWake chan struct{}
+		if listener != nil {
+			relayWake = make(chan struct{}, 1)
+			listener.Subscribe(func(wservice.Notification) {
+				select {
+				case relayWake <- struct{}{}:
+				default:
+				}
+			})
+		}
+		go func() {
+			wservice.RunOutboxRelay(core, wservice.NewMultiPublisher(relayed...), time.Duration(cfg.Outbox.Interval), cfg.Outbox.BatchSize, log.With(logger, "tag", "outbox"), relayWake, stopRelay)
+			close(relayDone)
+		}()
+	} else {
+		close(relayDone)
+	}
+	// Serve the live feed of /transfers/stream, every instance tails the outbox on its own so its streams get the transfers of the other instances too
+	var stream *wservice.TransferStream
+	stopStream, streamDone := make(chan struct{}), make(chan struct{})
+	if cfg.Stream.Enabled {
+		stream, err = wservice.NewTransferStream(core, cfg.Stream)
+		if err != nil {
+			startLogger.Log("msg", "could not create the live feed", "err", err)
+			os.Exit(1)
+		}
+		if listener != nil {
+			listener.Subscribe(func(wservice.Notification) { stream.Wake() })
+		}
+		go func() {
+			wservice.RunTransferStream(stream, log.With(logger, "tag", "stream"), stopStream)
+			close(streamDone)
+		}()
+	} else {
+		close(streamDone)
+	}
+
+	// Reload the configuration on SIGHUP (and when the configuration file changes if watch_interval is set) and apply the settings that can change at runtime
+	reloader := wservice.NewReloader(cfg, loader.Load, log.With(logger, "tag", "config"))
+	reloader.OnReload(func(cfg wservice.Config) {
+		logger.Swap(wservice.LevelFilter(baseLogger, cfg.LogLevel))
+		wservice.SetMaxRequestBodySize(cfg.MaxRequestBody)
+		if database {
+			wservice.ConfigurePool(core, cfg.Database)
+		}
+		interest.start(time.Duration(cfg.InterestInterval))
+	})
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	stopWatch := make(chan struct{})
+	go reloader.Watch(hup, loader.FileName, stopWatch)
 	// Create a new HTTP Transport layer for the wallet service to serve its API
 	httpTransport := wservice.NewHTTPTransport(svc)
 	// Add some informational logging messages
@@ -54,8 +255,173 @@ func main() {
 	startLogger.Log("msg", "GET Transfers Table here: http://127.0.0.1:8080/transfers")
 	startLogger.Log("msg", "GET Metrics & Instrumentation here: http://127.0.0.1:8080/metrics")
 	startLogger.Log("msg", "HTTP serving", "addr", port)
-	// Start the server and log whatever it has to say
-	startLogger.Log(http.ListenAndServe(sPortNumber, httpTransport))
+	// Serve the API behind the drainer, which turns new requests away once the shutdown has started
+	drainer := wservice.NewDrainer()
+	// The health endpoints stay outside of the drainer so they keep answering (and /readyz reports the drain) during the shutdown
+	health := wservice.NewHealth(time.Duration(cfg.ReadinessCache))
+	if database {
+		health.AddCheck("database", wservice.DatabaseCheck(core), true)
+	}
+	if postgres {
+		health.AddCheck("migrations", wservice.MigrationsCheck(core), true)
+	}
+	health.AddCheck("shutdown", wservice.DrainingCheck(drainer), false)
+	reloader.OnReload(func(cfg wservice.Config) {
+		health.SetCacheTTL(time.Duration(cfg.ReadinessCache))
+	})
+	root := http.NewServeMux()
+	root.Handle("/healthz", health.LivenessHandler())
+	root.Handle("/readyz", health.ReadinessHandler())
+	if stream != nil {
+		root.Handle("/transfers/stream", drainer.Handler(wservice.AuthorizeHandler(svc, wservice.ScopeRead, stream)))
+	}
+	root.Handle("/", drainer.Handler(httpTransport))
+	server := &http.Server{Addr: sPortNumber, Handler: root}
+	serverErr := make(chan error, 2)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+	// Serve the gRPC API on its own port, behind the same drainer as the HTTP API
+	var grpcServer *grpc.Server
+	if cfg.GRPCPort != 0 {
+		grpcListener, err := net.Listen("tcp", ":"+strconv.Itoa(cfg.GRPCPort))
+		if err != nil {
+			startLogger.Log("msg", "could not listen for gRPC", "err", err)
+			os.Exit(1)
+		}
+		grpcServer = wservice.NewGRPCServer(svc, drainer)
+		startLogger.Log("msg", "gRPC serving", "addr", cfg.GRPCPort)
+		go func() {
+			serverErr <- grpcServer.Serve(grpcListener)
+		}()
+	}
+
+	// Shut down gracefully on SIGTERM (docker stop) or SIGINT (Ctrl+C), the drain timeout is taken from the configuration as last reloaded
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err = <-serverErr:
+		startLogger.Log("msg", "HTTP server failed", "err", err)
+		os.Exit(1)
+	case sig := <-term:
+		shutdownLogger := log.With(logger, "tag", "shutdown")
+		shutdownLogger.Log("msg", "received signal", "signal", sig)
+		stopJobs := func() {
+			close(stopWatch)
+			interest.shutdown()
+			close(stopListener)
+			<-listenerDone
+			close(stopRelay)
+			<-relayDone
+			close(stopDispatcher)
+			<-dispatcherDone
+			if c, ok := publisher.(io.Closer); ok {
+				c.Close()
+			}
+		}
+		// The streams never end on their own, so they are ended before the drain and their clients resume from another instance
+		endStreams := func() {
+			close(stopStream)
+			<-streamDone
+		}
+		os.Exit(shutdown(server, grpcServer, drainer, time.Duration(reloader.Config().DrainTimeout), endStreams, stopJobs, core, shutdownLogger))
+	}
+}
+
+// interestJob runs the interest accrual and posting job and restarts it when its interval changes
+type interestJob struct {
+	mu       sync.Mutex
+	svc      wservice.WalletService
+	logger   log.Logger
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// start (re)starts the job with the given interval, 0 stops it. It is not restarted when the interval did not change
+func (j *interestJob) start(interval time.Duration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stop != nil && interval == j.interval {
+		return
+	}
+	j.halt()
+	j.interval = interval
+	if interval > 0 {
+		stop, done := make(chan struct{}), make(chan struct{})
+		j.stop, j.done = stop, done
+		go func() {
+			wservice.RunInterestJob(j.svc, interval, j.logger, stop)
+			close(done)
+		}()
+	}
+}
+
+// halt stops the job and waits for the run in progress, if any, to finish. The caller holds the lock
+func (j *interestJob) halt() {
+	if j.stop == nil {
+		return
+	}
+	close(j.stop)
+	<-j.done
+	j.stop, j.done = nil, nil
+}
+
+// shutdown stops the job for good
+func (j *interestJob) shutdown() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.halt()
+	j.interval = 0
+}
+
+// shutdown stops the service gracefully and returns the exit code of the process: the live streams are ended, the new requests are turned away while
+// the ones in flight get the drain timeout to complete, then the background jobs are stopped, the HTTP and gRPC servers are closed and finally the store (the
+// database pool) is closed
+func shutdown(server *http.Server, grpcServer *grpc.Server, drainer *wservice.Drainer, timeout time.Duration, endStreams func(), stopJobs func(), core wservice.WalletService, logger log.Logger) int {
+	logger.Log("msg", "shutdown started", "drain_timeout", timeout, "in_flight", drainer.InFlight())
+	endStreams()
+	logger.Log("msg", "live streams ended")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	code := 0
+	if err := drainer.Drain(ctx); err != nil {
+		logger.Log("msg", "requests not drained", "err", err)
+		code = 1
+	} else {
+		logger.Log("msg", "requests drained")
+	}
+	stopJobs()
+	logger.Log("msg", "background jobs stopped")
+	// The connections still open once the drain timeout is over are closed rather than waited for
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		logger.Log("msg", "HTTP server closed", "err", err)
+	} else {
+		logger.Log("msg", "HTTP server stopped")
+	}
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+			logger.Log("msg", "gRPC server stopped")
+		case <-ctx.Done():
+			grpcServer.Stop()
+			logger.Log("msg", "gRPC server closed", "err", ctx.Err())
+		}
+	}
+	if err := wservice.ClosePool(core); err != nil {
+		logger.Log("msg", "store not closed", "err", err)
+		code = 1
+	} else {
+		logger.Log("msg", "store closed")
+	}
+	logger.Log("msg", "shutdown complete")
+	return code
 }
 
 // createLogger implements the disred log format
@@ -63,3 +429,108 @@ func createLogger() log.Logger {
 	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout))
 	return log.With(logger, "time", log.DefaultTimestampUTC())
 }
+
+// migrate runs a migration action ("up", "down" or "status") against the database of the wallet service and returns the exit code of the process
+func migrate(svc wservice.WalletService, action string, steps int, logger log.Logger) int {
+	migrator, err := wservice.NewMigrator(svc)
+	if err != nil {
+		logger.Log("msg", "cannot migrate", "err", err)
+		return 1
+	}
+	var migrations []wservice.Migration
+	switch action {
+	case "up":
+		migrations, err = migrator.Up()
+		for _, m := range migrations {
+			logger.Log("msg", "applied migration", "version", m.Version, "name", m.Name)
+		}
+	case "down":
+		migrations, err = migrator.Down(steps)
+		for _, m := range migrations {
+			logger.Log("msg", "reverted migration", "version", m.Version, "name", m.Name)
+		}
+	case "status":
+		migrations, err = migrator.Status()
+		for _, m := range migrations {
+			if m.Applied() {
+				logger.Log("version", m.Version, "name", m.Name, "status", "applied", "applied_at", m.AppliedAt.Format(time.RFC3339))
+			} else {
+				logger.Log("version", m.Version, "name", m.Name, "status", "pending")
+			}
+		}
+	default:
+		logger.Log("msg", "unknown migrate action, expected up, down or status", "action", action)
+		return 2
+	}
+	if err != nil {
+		logger.Log("msg", "migration failed", "err", err)
+		return 1
+	}
+	return 0
+}
+
+// printConfig writes the effective configuration as YAML with its secrets masked and returns the exit code of the process, 1 if the configuration is invalid
+func printConfig(cfg wservice.Config, action string, w io.Writer, logger log.Logger) int {
+	if action != "print" {
+		logger.Log("msg", "unknown config action, expected print", "action", action)
+		return 2
+	}
+	out, err := cfg.Redacted().YAML()
+	if err != nil {
+		logger.Log("msg", "cannot print configuration", "err", err)
+		return 1
+	}
+	fmt.Fprint(w, out)
+	if err := cfg.Validate(); err != nil {
+		logger.Log("msg", "invalid configuration", "err", err)
+		return 1
+	}
+	return 0
+}
+
+// apiKeys runs an API key action ("create", "list", "rotate" or "revoke") and returns the exit code of the process. The key issued by "create" or "rotate"
+// is written to w, as it is the only time it is shown
+func apiKeys(svc wservice.WalletService, action string, name string, scopes string, id string, grace time.Duration, w io.Writer, logger log.Logger) int {
+	var key wservice.APIKey
+	var err error
+	switch action {
+	case "create":
+		key, err = svc.CreateAPIKey(name, strings.Split(scopes, ","))
+		if err == nil {
+			logger.Log("msg", "issued API key", "id", key.ID, "name", key.Name, "scopes", strings.Join(key.Scopes, ","))
+		}
+	case "list":
+		var keys []wservice.APIKey
+		keys, err = svc.GetAPIKeys()
+		for _, k := range keys {
+			status := "active"
+			if k.RevokedAt != nil {
+				status = "revoked"
+			} else if k.ExpiresAt != nil {
+				status = "expires " + k.ExpiresAt.Format(time.RFC3339)
+			}
+			logger.Log("id", k.ID, "name", k.Name, "scopes", strings.Join(k.Scopes, ","), "created_at", k.CreatedAt.Format(time.RFC3339), "status", status)
+		}
+	case "rotate":
+		key, err = svc.RotateAPIKey(id, grace)
+		if err == nil {
+			logger.Log("msg", "rotated API key", "id", id, "new_id", key.ID, "grace", grace)
+		}
+	case "revoke":
+		key, err = svc.RevokeAPIKey(id)
+		if err == nil {
+			logger.Log("msg", "revoked API key", "id", key.ID, "name", key.Name)
+		}
+	default:
+		logger.Log("msg", "unknown apikeys action, expected create, list, rotate or revoke", "action", action)
+		return 2
+	}
+	if err != nil {
+		logger.Log("msg", "API key action failed", "action", action, "err", err)
+		return 1
+	}
+	if key.Key != "" {
+		fmt.Fprintln(w, key.Key)
+	}
+	return 0
+}
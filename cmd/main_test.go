@@ -81,8 +81,13 @@ func TestExternalServiceCreation(t *testing.T) {
 	httpTransport := wservice.NewHTTPTransport(svc)
 	assert.IsType(t, httpTransport, f)
 	http.ListenAndServe(portNumber, httpTransport)
+	key, err := svc.CreateAPIKey("main_test", []string{wservice.ScopeRead})
+	assert.Nil(t, err)
 	request, _ := http.NewRequest("GET", "/accounts", nil)
+	request.Header.Set("Authorization", "Bearer "+key.Key)
 	response := httptest.NewRecorder()
 	httpTransport.ServeHTTP(response, request)
 	assert.Equal(t, 200, response.Code, "OK response is expected")
+	_, err = svc.RevokeAPIKey(key.ID)
+	assert.Nil(t, err)
 }
@@ -0,0 +1,788 @@
+package wservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The configuration of the wallet service is built from the defaults below, then a YAML, JSON or legacy configuration file,
+// then the WSERVICE_* environment variables (e.g. WSERVICE_DATABASE_HOST for database.host) and finally the command line flags, each one
+// overriding the previous ones
+
+// Config is the effective configuration of the wallet service
+type Config struct {
+	Port             int                 `yaml:"port" json:"port"`
+	GRPCPort         int                 `yaml:"grpc_port" json:"grpc_port"`
+	LogLevel         string              `yaml:"log_level" json:"log_level"`
+	InterestInterval Duration            `yaml:"interest_interval" json:"interest_interval"`
+	MaxRequestBody   int                 `yaml:"max_request_body" json:"max_request_body"`
+	WatchInterval    Duration            `yaml:"watch_interval" json:"watch_interval"`
+	DrainTimeout     Duration            `yaml:"drain_timeout" json:"drain_timeout"`
+	ReadinessCache   Duration            `yaml:"readiness_cache" json:"readiness_cache"`
+	Storage          string              `yaml:"storage" json:"storage"`
+	Database         DatabaseConfig      `yaml:"database" json:"database"`
+	Outbox           OutboxConfig        `yaml:"outbox" json:"outbox"`
+	Webhooks         WebhooksConfig      `yaml:"webhooks" json:"webhooks"`
+	Stream           StreamConfig        `yaml:"stream" json:"stream"`
+	Notifications    NotificationsConfig `yaml:"notifications" json:"notifications"`
+	Auth             AuthConfig          `yaml:"auth" json:"auth"`
+}
+
+// DatabaseConfig is the configuration of the Postgres database of the wallet service. A DSN (either a "postgres://" URL or "key=value" pairs)
+// takes the place of the individual connection settings when it is set. The password can be read from PasswordFile (e.g. a Docker or Kubernetes secret),
+// which takes precedence over Password, or looked up in the PassFile pgpass file when no password is configured (see secrets.go)
+type DatabaseConfig struct {
+	Driver          string       `yaml:"driver" json:"driver"`
+	DSN             string       `yaml:"dsn" json:"dsn"`
+	Host            string       `yaml:"host" json:"host"`
+	Port            int          `yaml:"port" json:"port"`
+	User            string       `yaml:"user" json:"user"`
+	Password        string       `yaml:"password" json:"password"`
+	PasswordFile    string       `yaml:"password_file" json:"password_file"`
+	PassFile        string       `yaml:"passfile" json:"passfile"`
+	MaxOpenConns    int          `yaml:"max_open_conns" json:"max_open_conns"`
+	MaxIdleConns    int          `yaml:"max_idle_conns" json:"max_idle_conns"`
+	ConnMaxLifetime Duration     `yaml:"conn_max_lifetime" json:"conn_max_lifetime"`
+	Name            string       `yaml:"name" json:"name"`
+	SSLMode         string       `yaml:"sslmode" json:"sslmode"`
+	Schema          string       `yaml:"schema" json:"schema"`
+	Sequence        string       `yaml:"sequence" json:"sequence"`
+	Tables          TablesConfig `yaml:"tables" json:"tables"`
+}
+
+// poolSettings returns the sizing settings of the connection pool
+func (db DatabaseConfig) poolSettings() poolSettings {
+	return poolSettings{maxOpenConns: db.MaxOpenConns, maxIdleConns: db.MaxIdleConns, connMaxLifetime: time.Duration(db.ConnMaxLifetime)}
+}
+
+// TablesConfig names the tables of a ledger (see schema.go)
+type TablesConfig struct {
+	Accounts              string `yaml:"accounts" json:"accounts"`
+	Transfers             string `yaml:"transfers" json:"transfers"`
+	Currencies            string `yaml:"currencies" json:"currencies"`
+	TransferStatusChanges string `yaml:"transfer_status_changes" json:"transfer_status_changes"`
+	FailedTransfers       string `yaml:"failed_transfers" json:"failed_transfers"`
+	InterestRates         string `yaml:"interest_rates" json:"interest_rates"`
+	InterestAccruals      string `yaml:"interest_accruals" json:"interest_accruals"`
+	Outbox                string `yaml:"outbox" json:"outbox"`
+	Webhooks              string `yaml:"webhooks" json:"webhooks"`
+	WebhookDeliveries     string `yaml:"webhook_deliveries" json:"webhook_deliveries"`
+	APIKeys               string `yaml:"api_keys" json:"api_keys"`
+	Migrations            string `yaml:"migrations" json:"migrations"`
+}
+
+// OutboxConfig is the configuration of the outbox relay, which publishes the events of the committed transfers to a sink (see outbox.go): none, stdout,
+// a file of JSON lines (Path) or an HTTP endpoint the events are POSTed to (URL). The pending events are published every Interval, BatchSize at a time.
+// A transfer that takes its source account below LowBalanceThreshold (none when empty) also writes an account.low_balance event
+type OutboxConfig struct {
+	Sink                string   `yaml:"sink" json:"sink"`
+	Path                string   `yaml:"path" json:"path"`
+	URL                 string   `yaml:"url" json:"url"`
+	Timeout             Duration `yaml:"timeout" json:"timeout"`
+	Interval            Duration `yaml:"interval" json:"interval"`
+	BatchSize           int      `yaml:"batch_size" json:"batch_size"`
+	LowBalanceThreshold string   `yaml:"low_balance_threshold" json:"low_balance_threshold"`
+}
+
+// WebhooksConfig is the configuration of the webhook dispatcher (see webhook_dispatcher.go), which delivers the relayed events to the webhooks subscribed
+// to them when Enabled. The due deliveries are sent every Interval, BatchSize at a time, each request failing after Timeout. A failed delivery is retried
+// after Backoff, doubled after every attempt up to MaxBackoff, until it was tried MaxAttempts times, and a webhook is disabled after DisableAfter
+// consecutive failed attempts (0 never disables it)
+type WebhooksConfig struct {
+	Enabled      bool     `yaml:"enabled" json:"enabled"`
+	Interval     Duration `yaml:"interval" json:"interval"`
+	Timeout      Duration `yaml:"timeout" json:"timeout"`
+	BatchSize    int      `yaml:"batch_size" json:"batch_size"`
+	MaxAttempts  int      `yaml:"max_attempts" json:"max_attempts"`
+	Backoff      Duration `yaml:"backoff" json:"backoff"`
+	MaxBackoff   Duration `yaml:"max_backoff" json:"max_backoff"`
+	DisableAfter int      `yaml:"disable_after" json:"disable_after"`
+}
+
+// StreamConfig is the configuration of the live feed of /transfers/stream (see stream.go), served when Enabled. The outbox is tailed every Interval,
+// BatchSize events at a time, a subscriber more than Buffer events behind is dropped (it resumes from its last event ID) and every stream is sent a
+// keep-alive every Heartbeat. MaxSubscribers caps the streams an instance serves at once (0 means unlimited)
+type StreamConfig struct {
+	Enabled        bool     `yaml:"enabled" json:"enabled"`
+	Interval       Duration `yaml:"interval" json:"interval"`
+	BatchSize      int      `yaml:"batch_size" json:"batch_size"`
+	Buffer         int      `yaml:"buffer" json:"buffer"`
+	Heartbeat      Duration `yaml:"heartbeat" json:"heartbeat"`
+	MaxSubscribers int      `yaml:"max_subscribers" json:"max_subscribers"`
+}
+
+// NotificationsConfig is the configuration of the announcements of the committed transfers (see notify.go), made on Channel when Enabled. The
+// listener of Postgres waits MinReconnect before re-establishing a lost connection, doubling the wait after every failed attempt up to MaxReconnect
+type NotificationsConfig struct {
+	Enabled      bool     `yaml:"enabled" json:"enabled"`
+	Channel      string   `yaml:"channel" json:"channel"`
+	MinReconnect Duration `yaml:"min_reconnect" json:"min_reconnect"`
+	MaxReconnect Duration `yaml:"max_reconnect" json:"max_reconnect"`
+}
+
+// AuthConfig is the configuration of the authentication of the API (see auth.go). When Enabled every request but the health checks and the API
+// documentation must carry an API key issued by the "apikeys" command with the scope its endpoint requires, the metrics requiring the admin scope.
+// The "apikeys" command cannot reach an in-memory ledger, which is given a key with every scope when the server starts instead
+type AuthConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+}
+
+// Duration is a time.Duration written as a string (e.g. "1h30m") in configuration files and environment variables
+type Duration time.Duration
+
+// MarshalText writes the duration as a string
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText reads the duration from a string
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return errors.New("must be a duration such as \"90s\" or \"1h\"")
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// envPrefix is the prefix of the environment variables that override the configuration
+const envPrefix = "WSERVICE"
+
+// logLevels are the levels log_level accepts, from the most to the least verbose
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// sslModes are the sslmode values Postgres understands
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// DefaultConfig returns the configuration used for every setting that is not configured otherwise
+func DefaultConfig() Config {
+	return Config{
+		Port:             8080,
+		GRPCPort:         8081,
+		LogLevel:         "info",
+		InterestInterval: Duration(time.Hour),
+		MaxRequestBody:   maxRequestBodySize,
+		DrainTimeout:     Duration(30 * time.Second),
+		ReadinessCache:   Duration(5 * time.Second),
+		Storage:          StorageDatabase,
+		Database: DatabaseConfig{
+			Driver:       DriverPostgres,
+			Host:         "127.0.0.1",
+			Port:         5432,
+			User:         "postgres",
+			Name:         "postgres",
+			SSLMode:      "disable",
+			MaxIdleConns: 2,
+			Sequence:     defaultSequence,
+			Tables: TablesConfig{
+				Accounts:              AccountsTable,
+				Transfers:             TransfersTable,
+				Currencies:            defaultCurrenciesTable,
+				TransferStatusChanges: defaultTransferStatusChangesTable,
+				FailedTransfers:       defaultFailedTransfersTable,
+				InterestRates:         defaultInterestRatesTable,
+				InterestAccruals:      defaultInterestAccrualsTable,
+				Outbox:                defaultOutboxTable,
+				Webhooks:              defaultWebhooksTable,
+				WebhookDeliveries:     defaultWebhookDeliveriesTable,
+				APIKeys:               defaultAPIKeysTable,
+				Migrations:            defaultMigrationsTable,
+			},
+		},
+		Outbox: OutboxConfig{
+			Sink:      SinkNone,
+			Timeout:   Duration(10 * time.Second),
+			Interval:  Duration(time.Second),
+			BatchSize: 100,
+		},
+		Webhooks: WebhooksConfig{
+			Interval:     Duration(time.Second),
+			Timeout:      Duration(10 * time.Second),
+			BatchSize:    100,
+			MaxAttempts:  8,
+			Backoff:      Duration(10 * time.Second),
+			MaxBackoff:   Duration(time.Hour),
+			DisableAfter: 20,
+		},
+		Stream: StreamConfig{
+			Enabled:        true,
+			Interval:       Duration(500 * time.Millisecond),
+			BatchSize:      100,
+			Buffer:         256,
+			Heartbeat:      Duration(15 * time.Second),
+			MaxSubscribers: 1000,
+		},
+		Notifications: NotificationsConfig{
+			Enabled:      true,
+			Channel:      "wservice_transfers",
+			MinReconnect: Duration(time.Second),
+			MaxReconnect: Duration(time.Minute),
+		},
+		Auth: AuthConfig{
+			Enabled: true,
+		},
+	}
+}
+
+// ReadConfigFile reads a configuration file on top of cfg. The format follows the extension (".yaml", ".yml" or ".json"), any other file is
+// read as JSON if it starts with "{", in the legacy "key : value," format if it looks like it, and as YAML otherwise
+func ReadConfigFile(cfg Config, fileName string) (Config, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		sError := "There was a problem opening file " + fileName + " "
+		var ErrReadFile = errors.New(sError)
+		return cfg, errors.New(ErrReadFile.Error() + err.Error())
+	}
+	trimmed := bytes.TrimSpace(content)
+	switch ext := strings.ToLower(filepath.Ext(fileName)); {
+	case ext == ".json" || (ext != ".yaml" && ext != ".yml" && bytes.HasPrefix(trimmed, []byte("{"))):
+		dec := json.NewDecoder(bytes.NewReader(content))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			return cfg, errors.New("err: config file " + fileName + " is not valid JSON: " + err.Error())
+		}
+	case ext != ".yaml" && ext != ".yml" && isLegacyConfig(trimmed):
+		return readLegacyConfig(cfg, content)
+	default:
+		if err := yaml.UnmarshalStrict(content, &cfg); err != nil {
+			return cfg, errors.New("err: config file " + fileName + " is not valid YAML: " + err.Error())
+		}
+	}
+	return cfg, nil
+}
+
+// isLegacyConfig reports whether the content of a configuration file is in the legacy "key : value," format, which always starts with the sqlDriver line
+func isLegacyConfig(content []byte) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return strings.HasPrefix(line, "sqlDriver ")
+	}
+	return false
+}
+
+// legacyKeys are the optional keys that can follow the 9 lines of the legacy format, with the setting each one configures
+var legacyKeys = map[string]func(c *DatabaseConfig) *string{
+	"schema":                     func(c *DatabaseConfig) *string { return &c.Schema },
+	"currenciesTable":            func(c *DatabaseConfig) *string { return &c.Tables.Currencies },
+	"transferStatusChangesTable": func(c *DatabaseConfig) *string { return &c.Tables.TransferStatusChanges },
+	"failedTransfersTable":       func(c *DatabaseConfig) *string { return &c.Tables.FailedTransfers },
+	"interestRatesTable":         func(c *DatabaseConfig) *string { return &c.Tables.InterestRates },
+	"interestAccrualsTable":      func(c *DatabaseConfig) *string { return &c.Tables.InterestAccruals },
+	"outboxTable":                func(c *DatabaseConfig) *string { return &c.Tables.Outbox },
+	"webhooksTable":              func(c *DatabaseConfig) *string { return &c.Tables.Webhooks },
+	"webhookDeliveriesTable":     func(c *DatabaseConfig) *string { return &c.Tables.WebhookDeliveries },
+	"apiKeysTable":               func(c *DatabaseConfig) *string { return &c.Tables.APIKeys },
+	"migrationsTable":            func(c *DatabaseConfig) *string { return &c.Tables.Migrations },
+	"sequence":                   func(c *DatabaseConfig) *string { return &c.Sequence },
+}
+
+// readLegacyConfig reads the legacy configuration format: 9 lines of "key : value," (driver, host, port, user, password, database name, sslmode,
+// accounts table and transfers table, in that order) optionally followed by the other object names of the ledger. Blank lines and "#" comments are skipped
+func readLegacyConfig(cfg Config, content []byte) (Config, error) {
+	// Read file and split each line on the " : " separator and then split the string to the right of
+	// the separtor by another spearator (",") and keep the string to the left of separator
+	cSlice := []string{}
+	kSlice := []string{}
+	for _, item := range strings.Split(strings.Replace(string(content), "\r\n", "\n", -1), "\n") {
+		if trimmed := strings.TrimSpace(item); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.Index(item, " : ") == -1 {
+			// Check if there is actually a " : " delimiter on the line, if not then there is a formating issue
+			var ErrFormat = errors.New("err: postgres config file error, no ' : ' delimiter found")
+			return cfg, ErrFormat
+		}
+		if strings.LastIndex(item, " : ") != strings.Index(item, " : ") {
+			// Check if there are more than one " : " delimiter on the line, if so then there is a formating issue
+			var ErrFormat = errors.New("err: postgres config file error, too many ' : ' delimiters found")
+			return cfg, ErrFormat
+		}
+		s := strings.Split(item, " : ")
+		v := strings.Split(s[1], ",")
+		kSlice = append(kSlice, strings.TrimSpace(s[0]))
+		cSlice = append(cSlice, strings.TrimSpace(v[0]))
+	}
+	// If there are less than 9 lines in the config file, then there is a fomatting issue
+	if len(cSlice) < 9 {
+		var ErrFormat = errors.New("err: postgres config file error, the number of lines in the config file is not the expected one (9)")
+		return cfg, ErrFormat
+	}
+	port, err := strconv.Atoi(cSlice[2])
+	if err != nil {
+		var ErrFormat = errors.New("err: postgres config file error, sqlPort \"" + cSlice[2] + "\" is not a number")
+		return cfg, ErrFormat
+	}
+	db := &cfg.Database
+	db.Driver, db.Host, db.Port, db.User, db.Password, db.Name, db.SSLMode = cSlice[0], cSlice[1], port, cSlice[3], cSlice[4], cSlice[5], cSlice[6]
+	db.Tables.Accounts, db.Tables.Transfers = cSlice[7], cSlice[8]
+	// Any line after the first 9 names one of the other objects of the ledger (schema, tables or sequence), each at most once
+	seen := map[string]bool{}
+	for i := 9; i < len(cSlice); i++ {
+		field, ok := legacyKeys[kSlice[i]]
+		if !ok || seen[kSlice[i]] {
+			var ErrFormat = errors.New("err: postgres config file error, unexpected or repeated key \"" + kSlice[i] + "\" after the 9 expected lines")
+			return cfg, ErrFormat
+		}
+		seen[kSlice[i]] = true
+		*field(db) = cSlice[i]
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides the configuration with the WSERVICE_* environment variables, named after the path of each setting (e.g. WSERVICE_PORT,
+// WSERVICE_DATABASE_DSN or WSERVICE_DATABASE_TABLES_ACCOUNTS)
+func ApplyEnv(cfg Config, lookup func(string) (string, bool)) (Config, error) {
+	err := applyEnv(reflect.ValueOf(&cfg).Elem(), envPrefix, "", lookup)
+	return cfg, err
+}
+
+// applyEnv walks the settings of a configuration struct and sets the ones that have an environment variable
+func applyEnv(v reflect.Value, prefix string, path string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		name := prefix + "_" + strings.ToUpper(key)
+		setting := key
+		if path != "" {
+			setting = path + "." + key
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, name, setting, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setSetting(field, value); err != nil {
+			return fmt.Errorf("err: environment variable %s (%s) %v", name, setting, err)
+		}
+	}
+	return nil
+}
+
+// setSetting sets a single setting from its string form
+func setSetting(field reflect.Value, value string) error {
+	if u, ok := field.Addr().Interface().(interface{ UnmarshalText([]byte) error }); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+		field.SetBool(b)
+	default:
+		return errors.New("cannot be set from the environment")
+	}
+	return nil
+}
+
+// Validate checks every setting and returns all the invalid ones in a single error, each one prefixed by its path in the configuration
+func (c Config) Validate() error {
+	var problems []string
+	fail := func(setting string, msg string) {
+		problems = append(problems, setting+": "+msg)
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		fail("port", "must be between 1 and 65535, got "+strconv.Itoa(c.Port))
+	}
+	if c.GRPCPort < 0 || c.GRPCPort > 65535 {
+		fail("grpc_port", "must be between 1 and 65535 (0 disables the gRPC API), got "+strconv.Itoa(c.GRPCPort))
+	} else if c.GRPCPort == c.Port {
+		fail("grpc_port", "must not be the HTTP port, got "+strconv.Itoa(c.GRPCPort))
+	}
+	if !contains(logLevels, c.LogLevel) {
+		fail("log_level", "must be one of "+strings.Join(logLevels, ", ")+", got \""+c.LogLevel+"\"")
+	}
+	if c.InterestInterval < 0 {
+		fail("interest_interval", "must not be negative (0 disables the interest job)")
+	}
+	if c.MaxRequestBody < 1 {
+		fail("max_request_body", "must be a positive number of bytes, got "+strconv.Itoa(c.MaxRequestBody))
+	}
+	if c.WatchInterval < 0 {
+		fail("watch_interval", "must not be negative (0 only reloads on SIGHUP)")
+	}
+	if c.DrainTimeout < 0 {
+		fail("drain_timeout", "must not be negative (0 does not wait for the requests in flight)")
+	}
+	if c.ReadinessCache < 0 {
+		fail("readiness_cache", "must not be negative (0 checks the dependencies on every request)")
+	}
+	if !contains(storageBackends, c.Storage) {
+		fail("storage", "must be one of "+strings.Join(storageBackends, ", ")+", got \""+c.Storage+"\"")
+	}
+	db := c.Database
+	if !contains(databaseDrivers, db.Driver) {
+		fail("database.driver", "must be one of "+strings.Join(databaseDrivers, ", ")+", got \""+db.Driver+"\"")
+	}
+	if db.Driver == DriverSQLite {
+		// The SQLite ledger is a single file named by database.name, none of the connection settings of Postgres apply to it
+		if db.Name == "" {
+			fail("database.name", "is required, it is the path of the SQLite database file")
+		}
+		if db.DSN != "" {
+			fail("database.dsn", "is not supported with the sqlite3 driver, set database.name to the path of the database file")
+		}
+		if db.Schema != "" {
+			fail("database.schema", "is not supported with the sqlite3 driver, use a separate database file instead")
+		}
+	} else if db.DSN != "" {
+		if err := validateDSN(db.DSN); err != nil {
+			fail("database.dsn", err.Error())
+		}
+	} else {
+		if db.Host == "" {
+			fail("database.host", "is required")
+		}
+		if db.Port < 1 || db.Port > 65535 {
+			fail("database.port", "must be between 1 and 65535, got "+strconv.Itoa(db.Port))
+		}
+		if db.User == "" {
+			fail("database.user", "is required")
+		}
+		if db.Name == "" {
+			fail("database.name", "is required")
+		}
+		if !contains(sslModes, db.SSLMode) {
+			fail("database.sslmode", "must be one of "+strings.Join(sslModes, ", ")+", got \""+db.SSLMode+"\"")
+		}
+	}
+	if db.MaxOpenConns < 0 {
+		fail("database.max_open_conns", "must not be negative (0 means unlimited)")
+	}
+	if db.MaxIdleConns < 0 {
+		fail("database.max_idle_conns", "must not be negative")
+	}
+	if db.ConnMaxLifetime < 0 {
+		fail("database.conn_max_lifetime", "must not be negative (0 means unlimited)")
+	}
+	if db.PassFile != "" && db.DSN != "" {
+		fail("database.passfile", "cannot be combined with database.dsn, put the password in the DSN or use database.password_file")
+	}
+	if db.Schema != "" && !identifierPattern.MatchString(db.Schema) {
+		fail("database.schema", "must be a plain SQL identifier, got \""+db.Schema+"\"")
+	}
+	if !identifierPattern.MatchString(db.Sequence) {
+		fail("database.sequence", "must be a plain SQL identifier, got \""+db.Sequence+"\"")
+	}
+	tables := reflect.ValueOf(db.Tables)
+	for i := 0; i < tables.NumField(); i++ {
+		if name := tables.Field(i).String(); !identifierPattern.MatchString(name) {
+			fail("database.tables."+tables.Type().Field(i).Tag.Get("yaml"), "must be a plain SQL identifier, got \""+name+"\"")
+		}
+	}
+	out := c.Outbox
+	if !contains(outboxSinks, out.Sink) {
+		fail("outbox.sink", "must be one of "+strings.Join(outboxSinks, ", ")+", got \""+out.Sink+"\"")
+	}
+	if out.Sink == SinkFile && out.Path == "" {
+		fail("outbox.path", "is required with the file sink")
+	}
+	if u, err := url.Parse(out.URL); out.Sink == SinkHTTP && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+		fail("outbox.url", "must be an http:// or https:// URL with the http sink, got \""+out.URL+"\"")
+	}
+	if out.Timeout < 0 {
+		fail("outbox.timeout", "must not be negative (0 means no timeout)")
+	}
+	if out.Interval <= 0 {
+		fail("outbox.interval", "must be positive")
+	}
+	if out.BatchSize < 1 {
+		fail("outbox.batch_size", "must be at least 1, got "+strconv.Itoa(out.BatchSize))
+	}
+	if out.LowBalanceThreshold != "" && !amountPattern.MatchString(out.LowBalanceThreshold) {
+		fail("outbox.low_balance_threshold", "must be a positive decimal number (e.g. \"100\"), got \""+out.LowBalanceThreshold+"\"")
+	}
+	hooks := c.Webhooks
+	if hooks.Interval <= 0 {
+		fail("webhooks.interval", "must be positive")
+	}
+	if hooks.Timeout <= 0 {
+		fail("webhooks.timeout", "must be positive")
+	}
+	if hooks.BatchSize < 1 {
+		fail("webhooks.batch_size", "must be at least 1, got "+strconv.Itoa(hooks.BatchSize))
+	}
+	if hooks.MaxAttempts < 1 {
+		fail("webhooks.max_attempts", "must be at least 1, got "+strconv.Itoa(hooks.MaxAttempts))
+	}
+	if hooks.Backoff <= 0 {
+		fail("webhooks.backoff", "must be positive")
+	}
+	if hooks.MaxBackoff < hooks.Backoff {
+		fail("webhooks.max_backoff", "must not be shorter than webhooks.backoff")
+	}
+	if hooks.DisableAfter < 0 {
+		fail("webhooks.disable_after", "must not be negative (0 never disables a webhook)")
+	}
+	stream := c.Stream
+	if stream.Interval <= 0 {
+		fail("stream.interval", "must be positive")
+	}
+	if stream.BatchSize < 1 {
+		fail("stream.batch_size", "must be at least 1, got "+strconv.Itoa(stream.BatchSize))
+	}
+	if stream.Buffer < 1 {
+		fail("stream.buffer", "must be at least 1, got "+strconv.Itoa(stream.Buffer))
+	}
+	if stream.Heartbeat <= 0 {
+		fail("stream.heartbeat", "must be positive")
+	}
+	if stream.MaxSubscribers < 0 {
+		fail("stream.max_subscribers", "must not be negative (0 means unlimited)")
+	}
+	notify := c.Notifications
+	if notify.Enabled && !identifierPattern.MatchString(notify.Channel) {
+		fail("notifications.channel", "must be a plain SQL identifier, got \""+notify.Channel+"\"")
+	}
+	if notify.MinReconnect <= 0 {
+		fail("notifications.min_reconnect", "must be positive")
+	}
+	if notify.MaxReconnect < notify.MinReconnect {
+		fail("notifications.max_reconnect", "must not be shorter than notifications.min_reconnect")
+	}
+	if len(problems) > 0 {
+		return errors.New("err: invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validateDSN checks that a DSN is either a postgres URL or a list of key=value pairs
+func validateDSN(dsn string) error {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return errors.New("is not a valid postgres URL")
+		}
+		if u.Host == "" {
+			return errors.New("must name a host")
+		}
+		return nil
+	}
+	if !strings.Contains(dsn, "=") {
+		return errors.New("must be a postgres:// URL or key=value pairs")
+	}
+	return nil
+}
+
+// contains reports whether the list holds the value
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// secretMask replaces secrets when the configuration is shown
+const secretMask = "********"
+
+// dsnPasswordPattern finds the password of a key=value DSN
+var dsnPasswordPattern = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// Redacted returns a copy of the configuration with every secret masked, safe to print or log
+func (c Config) Redacted() Config {
+	if c.Database.Password != "" {
+		c.Database.Password = secretMask
+	}
+	c.Database.DSN = redactDSN(c.Database.DSN)
+	return c
+}
+
+// redactDSN masks the password of a DSN, whether it is a URL or key=value pairs
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), secretMask)
+			// The URL escapes the '*' of the mask, which is put back for readability
+			return strings.Replace(u.String(), strings.Repeat("%2A", len(secretMask)), secretMask, 1)
+		}
+		return dsn
+	}
+	return dsnPasswordPattern.ReplaceAllString(dsn, "${1}"+secretMask)
+}
+
+// YAML returns the configuration in the YAML format of the configuration file
+func (c Config) YAML() (string, error) {
+	out, err := yaml.Marshal(c)
+	return string(out), err
+}
+
+// newSQLDBTx builds the Postgres wallet service from its database configuration
+func newSQLDBTx(db DatabaseConfig) sqlDBTx {
+	s := sqlDBTx{
+		sqlDriver:                  db.Driver,
+		dsn:                        db.DSN,
+		sqlHost:                    db.Host,
+		sqlPort:                    strconv.Itoa(db.Port),
+		sqlUser:                    db.User,
+		sqlPassword:                db.Password,
+		sqlDbName:                  db.Name,
+		sslmode:                    db.SSLMode,
+		accountsTable:              db.Tables.Accounts,
+		transfersTable:             db.Tables.Transfers,
+		schema:                     db.Schema,
+		currenciesTable:            db.Tables.Currencies,
+		transferStatusChangesTable: db.Tables.TransferStatusChanges,
+		failedTransfersTable:       db.Tables.FailedTransfers,
+		interestRatesTable:         db.Tables.InterestRates,
+		interestAccrualsTable:      db.Tables.InterestAccruals,
+		outboxTable:                db.Tables.Outbox,
+		webhooksTable:              db.Tables.Webhooks,
+		webhookDeliveriesTable:     db.Tables.WebhookDeliveries,
+		apiKeysTable:               db.Tables.APIKeys,
+		migrationsTable:            db.Tables.Migrations,
+		sequence:                   db.Sequence,
+		pool:                       &connPool{settings: db.poolSettings()},
+	}
+	s.setSchemaDefaults()
+	return s
+}
+
+// NewServiceFromConfig exported to be accessible from outside the package (from main)
+// NewServiceFromConfig validates the configuration and creates the wallet service it describes, on top of the configured storage backend. The secrets
+// of the database are only read when the ledger is kept in Postgres
+func NewServiceFromConfig(cfg Config) (WalletService, error) {
+	if err := cfg.Validate(); err != nil {
+		return ledger{}, err
+	}
+	l := ledger{accountsTable: cfg.Database.Tables.Accounts, transfersTable: cfg.Database.Tables.Transfers, lowBalance: cfg.Outbox.LowBalanceThreshold}
+	if cfg.Notifications.Enabled {
+		l.notifyChannel = cfg.Notifications.Channel
+	}
+	l.apiKeysRequired = cfg.Auth.Enabled
+	if cfg.Storage == StorageMemory {
+		store, err := newMemStore()
+		if err != nil {
+			return ledger{}, err
+		}
+		l.store = store
+		return l, nil
+	}
+	if cfg.Database.Driver == DriverSQLite {
+		l.store = newSQLiteStore(cfg.Database)
+		return l, nil
+	}
+	db, err := resolveSecrets(cfg.Database)
+	if err != nil {
+		return ledger{}, err
+	}
+	l.store = newSQLDBTx(db)
+	return l, nil
+}
+
+// ConfigLoader builds the configuration out of its sources: the defaults, then the configuration file ("-file", skipped when the default file does not exist),
+// then the WSERVICE_* environment variables and finally the flags set on the command line. It keeps the parsed flags so the configuration can be built again on reload
+type ConfigLoader struct {
+	FileName string
+	flags    Config
+	set      map[string]bool
+	lookup   func(string) (string, bool)
+}
+
+// NewConfigLoader exported to be accessible from outside the package (from main)
+// NewConfigLoader parses the cli arguments
+func NewConfigLoader() *ConfigLoader {
+	l := &ConfigLoader{flags: DefaultConfig(), set: map[string]bool{}, lookup: os.LookupEnv}
+	// Parse the configuration file name and path. if not deifned the default is "postgresql.cfg" from /cmd
+	flag.StringVar(&l.FileName, "file", "./postgresql.cfg", "Path of the configuration file to be parsed (YAML, JSON or the legacy postgresql.cfg format).")
+	// Parse the port number that the server uses to listen and serve. If none is defined the default is 8080
+	flag.IntVar(&l.flags.Port, "port", l.flags.Port, "Port on which the server will listen and serve.")
+	// Parse the port number that the gRPC API is served on. If none is defined the default is 8081
+	flag.IntVar(&l.flags.GRPCPort, "grpc-port", l.flags.GRPCPort, "Port on which the gRPC API is served (0 disables it).")
+	// Parse how often the interest accrual and posting job runs
+	interest := flag.Duration("interest", time.Duration(l.flags.InterestInterval), "How often the interest accrual and posting job runs (0 disables it).")
+	flag.Parse()
+	l.flags.InterestInterval = Duration(*interest)
+	flag.Visit(func(f *flag.Flag) { l.set[f.Name] = true })
+	return l
+}
+
+// Load builds the configuration out of its sources as they are now. The result is not validated yet
+func (l *ConfigLoader) Load() (Config, error) {
+	cfg := DefaultConfig()
+	var err error
+	if _, statErr := os.Stat(l.FileName); statErr == nil || l.set["file"] {
+		if cfg, err = ReadConfigFile(cfg, l.FileName); err != nil {
+			return cfg, err
+		}
+	}
+	if cfg, err = ApplyEnv(cfg, l.lookup); err != nil {
+		return cfg, err
+	}
+	if l.set["port"] {
+		cfg.Port = l.flags.Port
+	}
+	if l.set["grpc-port"] {
+		cfg.GRPCPort = l.flags.GRPCPort
+	}
+	if l.set["interest"] {
+		cfg.InterestInterval = l.flags.InterestInterval
+	}
+	return cfg, nil
+}
+
+// LoadConfig exported to be accessible from outside the package (from main)
+// LoadConfig parses the cli arguments and builds the effective configuration once (see ConfigLoader)
+func LoadConfig() (Config, error) {
+	return NewConfigLoader().Load()
+}
+
+// connectionString returns the configured DSN, or builds one out of the individual connection settings. Without a password the driver falls back
+// to PGPASSWORD and then to the pgpass file (PGPASSFILE or ~/.pgpass)
+func (s sqlDBTx) connectionString() string {
+	if s.dsn != "" {
+		return s.dsn
+	}
+	settings := []struct{ key, value string }{
+		{"host", s.sqlHost}, {"port", s.sqlPort}, {"user", s.sqlUser}, {"password", s.sqlPassword}, {"dbname", s.sqlDbName}, {"sslmode", s.sslmode},
+	}
+	pairs := make([]string, 0, len(settings))
+	for _, kv := range settings {
+		if kv.key == "password" && kv.value == "" {
+			continue
+		}
+		pairs = append(pairs, kv.key+"="+dsnValue(kv.value))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// dsnValue quotes a value of a key=value DSN when it is empty or contains spaces, quotes or backslashes
+func dsnValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t'\\") {
+		return v
+	}
+	return "'" + strings.NewReplacer("\\", "\\\\", "'", "\\'").Replace(v) + "'"
+}
@@ -0,0 +1,234 @@
+package wservice
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeConfig writes a configuration file with the given name and content in a temporary directory
+func writeConfig(t *testing.T, name string, content string) string {
+	dir, err := ioutil.TempDir("", "wservice")
+	assert.Nil(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	fileName := filepath.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(fileName, []byte(content), 0600))
+	return fileName
+}
+
+func TestReadConfigFile(t *testing.T) {
+	yamlFile := writeConfig(t, "wservice.yaml", "# comment\nport: 9090\ninterest_interval: 30m\ndatabase:\n  host: db\n  tables:\n    accounts: Accts\n")
+	cfg, err := ReadConfigFile(DefaultConfig(), yamlFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 9090, cfg.Port)
+	assert.Equal(t, Duration(30*time.Minute), cfg.InterestInterval)
+	assert.Equal(t, "db", cfg.Database.Host)
+	assert.Equal(t, "Accts", cfg.Database.Tables.Accounts)
+	assert.Equal(t, TransfersTable, cfg.Database.Tables.Transfers)
+	assert.Equal(t, 5432, cfg.Database.Port)
+
+	jsonFile := writeConfig(t, "wservice.json", `{"database":{"dsn":"postgres://bob:secret@db:5432/ledger?sslmode=require"}}`)
+	cfg, err = ReadConfigFile(DefaultConfig(), jsonFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "postgres://bob:secret@db:5432/ledger?sslmode=require", cfg.Database.DSN)
+	assert.Nil(t, cfg.Validate())
+
+	// The legacy format accepts blank lines and comments
+	legacy, err := ioutil.ReadFile("./cmd/postgresql.cfg")
+	assert.Nil(t, err)
+	cfg, err = ReadConfigFile(DefaultConfig(), writeConfig(t, "postgresql.cfg", "# legacy\n\n"+string(legacy)+"\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "password", cfg.Database.Password)
+	assert.Equal(t, TransfersTable, cfg.Database.Tables.Transfers)
+
+	for name, content := range map[string]string{
+		"unknown.yaml": "database:\n  hots: db\n",
+		"unknown.json": `{"prot": 9090}`,
+		"port.yaml":    "port: eighty\n",
+	} {
+		_, err := ReadConfigFile(DefaultConfig(), writeConfig(t, name, content))
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"WSERVICE_PORT":                     "9090",
+		"WSERVICE_INTEREST_INTERVAL":        "0s",
+		"WSERVICE_DATABASE_HOST":            "postgresdb",
+		"WSERVICE_DATABASE_TABLES_ACCOUNTS": "Accts",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	cfg, err := ApplyEnv(DefaultConfig(), lookup)
+	assert.Nil(t, err)
+	assert.Equal(t, 9090, cfg.Port)
+	assert.Equal(t, Duration(0), cfg.InterestInterval)
+	assert.Equal(t, "postgresdb", cfg.Database.Host)
+	assert.Equal(t, "Accts", cfg.Database.Tables.Accounts)
+
+	env["WSERVICE_DATABASE_PORT"] = "five"
+	_, err = ApplyEnv(DefaultConfig(), lookup)
+	assert.EqualError(t, err, "err: environment variable WSERVICE_DATABASE_PORT (database.port) must be a number")
+}
+
+func TestApplyEnvBool(t *testing.T) {
+	env := map[string]string{
+		"WSERVICE_WEBHOOKS_ENABLED":      "true",
+		"WSERVICE_STREAM_ENABLED":        "false",
+		"WSERVICE_NOTIFICATIONS_ENABLED": "0",
+		"WSERVICE_AUTH_ENABLED":          "FALSE",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	cfg, err := ApplyEnv(DefaultConfig(), lookup)
+	assert.Nil(t, err)
+	assert.True(t, cfg.Webhooks.Enabled)
+	assert.False(t, cfg.Stream.Enabled)
+	assert.False(t, cfg.Notifications.Enabled)
+	assert.False(t, cfg.Auth.Enabled)
+
+	env["WSERVICE_AUTH_ENABLED"] = "no"
+	_, err = ApplyEnv(DefaultConfig(), lookup)
+	assert.EqualError(t, err, "err: environment variable WSERVICE_AUTH_ENABLED (auth.enabled) must be true or false")
+}
+
+func TestConfigValidate(t *testing.T) {
+	assert.Nil(t, DefaultConfig().Validate())
+
+	cfg := DefaultConfig()
+	cfg.Port = 0
+	cfg.Database.SSLMode = "maybe"
+	cfg.Database.Tables.Transfers = "Transfers;DROP TABLE Accounts"
+	assert.EqualError(t, cfg.Validate(), "err: invalid configuration: port: must be between 1 and 65535, got 0; "+
+		"database.sslmode: must be one of disable, allow, prefer, require, verify-ca, verify-full, got \"maybe\"; "+
+		"database.tables.transfers: must be a plain SQL identifier, got \"Transfers;DROP TABLE Accounts\"")
+
+	// The individual connection settings are not checked when a DSN is set
+	cfg = DefaultConfig()
+	cfg.Database.Host = ""
+	cfg.Database.DSN = "host=db user=bob password=secret dbname=ledger"
+	assert.Nil(t, cfg.Validate())
+	cfg.Database.DSN = "db:5432"
+	assert.EqualError(t, cfg.Validate(), "err: invalid configuration: database.dsn: must be a postgres:// URL or key=value pairs")
+
+	cfg = DefaultConfig()
+	cfg.Storage = "sqlite"
+	assert.EqualError(t, cfg.Validate(), "err: invalid configuration: storage: must be one of database, memory, got \"sqlite\"")
+
+	// SQLite only needs the path of its database file
+	cfg = DefaultConfig()
+	cfg.Database.Driver = DriverSQLite
+	cfg.Database.Host, cfg.Database.User, cfg.Database.SSLMode, cfg.Database.Name = "", "", "", "/var/lib/wservice/ledger.db"
+	assert.Nil(t, cfg.Validate())
+	cfg.Database.Name, cfg.Database.DSN, cfg.Database.Schema = "", "ledger.db", "ledger"
+	assert.EqualError(t, cfg.Validate(), "err: invalid configuration: database.name: is required, it is the path of the SQLite database file; "+
+		"database.dsn: is not supported with the sqlite3 driver, set database.name to the path of the database file; "+
+		"database.schema: is not supported with the sqlite3 driver, use a separate database file instead")
+	cfg.Database.Driver = "mysql"
+	assert.Contains(t, cfg.Validate().Error(), "database.driver: must be one of postgres, sqlite3, got \"mysql\"")
+
+	cfg = DefaultConfig()
+	cfg.Outbox.Sink = SinkHTTP
+	cfg.Outbox.URL = "ftp://events"
+	cfg.Outbox.BatchSize = 0
+	assert.EqualError(t, cfg.Validate(), "err: invalid configuration: outbox.url: must be an http:// or https:// URL with the http sink, got \"ftp://events\"; "+
+		"outbox.batch_size: must be at least 1, got 0")
+	cfg.Outbox.URL, cfg.Outbox.BatchSize = "https://events.example.com/wservice", 100
+	assert.Nil(t, cfg.Validate())
+	cfg.Outbox.Sink = "kafka"
+	assert.EqualError(t, cfg.Validate(), "err: invalid configuration: outbox.sink: must be one of none, stdout, file, http, got \"kafka\"")
+
+	cfg = DefaultConfig()
+	cfg.Outbox.LowBalanceThreshold = "-5"
+	cfg.Webhooks.MaxAttempts, cfg.Webhooks.MaxBackoff, cfg.Webhooks.DisableAfter = 0, Duration(time.Second), -1
+	assert.EqualError(t, cfg.Validate(), "err: invalid configuration: outbox.low_balance_threshold: must be a positive decimal number (e.g. \"100\"), got \"-5\"; "+
+		"webhooks.max_attempts: must be at least 1, got 0; webhooks.max_backoff: must not be shorter than webhooks.backoff; "+
+		"webhooks.disable_after: must not be negative (0 never disables a webhook)")
+	cfg.Outbox.LowBalanceThreshold = "100"
+	cfg.Webhooks.MaxAttempts, cfg.Webhooks.MaxBackoff, cfg.Webhooks.DisableAfter = 5, Duration(time.Minute), 0
+	assert.Nil(t, cfg.Validate())
+
+	cfg = DefaultConfig()
+	cfg.GRPCPort = cfg.Port
+	assert.EqualError(t, cfg.Validate(), "err: invalid configuration: grpc_port: must not be the HTTP port, got 8080")
+	cfg.GRPCPort = -1
+	assert.EqualError(t, cfg.Validate(), "err: invalid configuration: grpc_port: must be between 1 and 65535 (0 disables the gRPC API), got -1")
+	cfg.GRPCPort = 0
+	assert.Nil(t, cfg.Validate())
+
+	cfg = DefaultConfig()
+	cfg.Notifications.Channel, cfg.Notifications.MaxReconnect = "transfers; DROP TABLE accounts", Duration(time.Millisecond)
+	assert.EqualError(t, cfg.Validate(), "err: invalid configuration: notifications.channel: must be a plain SQL identifier, got \"transfers; DROP TABLE accounts\"; "+
+		"notifications.max_reconnect: must not be shorter than notifications.min_reconnect")
+	cfg.Notifications.Enabled, cfg.Notifications.MaxReconnect = false, Duration(time.Minute)
+	assert.Nil(t, cfg.Validate())
+	// The in-memory ledger is picked with a single setting, with or without authentication
+	cfg = DefaultConfig()
+	cfg.Storage = StorageMemory
+	assert.Nil(t, cfg.Validate())
+	cfg.Auth.Enabled = false
+	assert.Nil(t, cfg.Validate())
+}
+
+func TestConfigLoaderMemory(t *testing.T) {
+	for _, c := range []struct {
+		env    map[string]string
+		status int
+	}{
+		// The invocations of the README
+		{map[string]string{"WSERVICE_STORAGE": "memory"}, http.StatusUnauthorized},
+		{map[string]string{"WSERVICE_STORAGE": "memory", "WSERVICE_AUTH_ENABLED": "false"}, http.StatusOK},
+	} {
+		loader := &ConfigLoader{FileName: "./wservice-missing.yaml", flags: DefaultConfig(), set: map[string]bool{}, lookup: func(name string) (string, bool) {
+			v, ok := c.env[name]
+			return v, ok
+		}}
+		cfg, err := loader.Load()
+		assert.Nil(t, err)
+		svc, err := NewServiceFromConfig(cfg)
+		assert.Nil(t, err)
+		assert.Equal(t, c.status, serveHTTPWithKey(svc, http.MethodGet, "/accounts", "", "").Code)
+		// The in-memory ledger holds the API keys issued to it
+		key, err := svc.CreateAPIKey("startup", []string{ScopeRead, ScopeTransfer, ScopeAdmin})
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, serveHTTPWithKey(svc, http.MethodGet, "/accounts", "", key.Key).Code)
+	}
+}
+
+func TestConfigRedacted(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Database.Password = "secret"
+	cfg.Database.DSN = "postgres://bob:secret@db:5432/ledger"
+	out, err := cfg.Redacted().YAML()
+	assert.Nil(t, err)
+	assert.NotContains(t, out, "secret")
+	assert.Contains(t, out, "password: '********'")
+	assert.Contains(t, out, "postgres://bob:********@db:5432/ledger")
+	assert.Equal(t, "secret", cfg.Database.Password)
+
+	assert.Equal(t, "host=db password=******** dbname=ledger", redactDSN("host=db password=secret dbname=ledger"))
+	assert.Equal(t, "host=db password=******** dbname=ledger", redactDSN("host=db password='sec ret' dbname=ledger"))
+}
+
+func TestConnectionString(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Database.Password = "it's secret"
+	s := newSQLDBTx(cfg.Database)
+	assert.Equal(t, `host=127.0.0.1 port=5432 user=postgres password='it\'s secret' dbname=postgres sslmode=disable`, s.connectionString())
+
+	cfg.Database.DSN = "postgres://bob:secret@db/ledger"
+	s = newSQLDBTx(cfg.Database)
+	assert.Equal(t, cfg.Database.DSN, s.connectionString())
+	assert.False(t, strings.Contains(s.connectionString(), "127.0.0.1"))
+}
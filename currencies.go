@@ -0,0 +1,97 @@
+package wservice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Currencies is the registry of ISO 4217 currencies the wallet service knows about. Accounts and transfers reference it with a foreign key
+// and every transfer amount is checked against the number of minor units (decimal places) of its currency
+
+// GetCurrencies is a ledger type method that fetches the whole currency registry, one formatted string per currency
+func (l ledger) GetCurrencies() ([]string, error) {
+	var currencies []Currency
+	err := l.store.View(func(tx LedgerTx) error {
+		var err error
+		currencies, err = tx.Currencies()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []string
+	for _, c := range currencies {
+		rString := "Currency: " + c.Code + " (" + c.NumericCode + ")  Minor units = " + strconv.Itoa(c.MinorUnits) + "  Name = " + c.Name + "  Enabled = " + strconv.FormatBool(c.Enabled)
+		results = append(results, rString)
+	}
+	results = append(results, "Success.")
+	return results, nil
+}
+
+// SetCurrencyEnabled is a ledger type method that enables or disables a currency of the registry. Transfers in a disabled currency are refused
+// by DoTransfer, while the accounts and the already committed transfers in that currency are left untouched
+func (l ledger) SetCurrencyEnabled(code string, enabled bool) (string, error) {
+	err := l.store.Update(func(tx LedgerTx) error {
+		return tx.SetCurrencyEnabled(code, enabled)
+	})
+	// If the currency was not found the code is not part of the registry
+	if err == errNotFound {
+		var ErrNoCurrency = newError(ErrCurrencyNotFound, "The currency "+code+" does not exist")
+		return "error", ErrNoCurrency
+	}
+	if err != nil {
+		return "error", err
+	}
+	return "success", nil
+}
+
+// checkCurrency verifies, inside the transfer's transaction, that the currency is enabled in the registry and that the amount
+// does not have more decimal places than the currency's minor units (e.g. none for JPY, two for USD, three for KWD)
+func checkCurrency(tx LedgerTx, currency string, amount string) error {
+	c, err := tx.Currency(currency)
+	if err != nil {
+		if err == errNotFound {
+			var ErrNoCurrency = newError(ErrCurrencyNotFound, "The currency "+currency+" does not exist")
+			return ErrNoCurrency
+		}
+		return err
+	}
+	if !c.Enabled {
+		var ErrDisabled = newError(ErrCurrencyDisabled, "The currency "+currency+" is disabled")
+		return ErrDisabled
+	}
+	if amountScale(amount) > c.MinorUnits {
+		var ErrScale = newError(ErrInvalidAmount, fmt.Sprintf("The amount has more decimal places than currency %s allows (%d)", currency, c.MinorUnits))
+		return ErrScale
+	}
+	return nil
+}
+
+// checkAmount verifies that a transfer amount is a positive decimal in plain notation. The HTTP transport validates the amounts it is sent, but the
+// gRPC and JSON-RPC ones pass them on as they are, so signs, exponents (e.g. "1e-5"), "NaN", "Inf" and zero are refused here as well
+func checkAmount(amount string) error {
+	switch {
+	case strings.HasPrefix(amount, "-"):
+		var ErrNegative = newError(ErrInvalidAmount, "The amount cannot be negative")
+		return ErrNegative
+	case !amountPattern.MatchString(amount):
+		var ErrNotDecimal = newError(ErrInvalidAmount, "The amount must be a positive decimal number (e.g. \"30\" or \"12.50\")")
+		return ErrNotDecimal
+	case strings.Trim(amount, "0.") == "":
+		var ErrZero = newError(ErrInvalidAmount, "The amount must be greater than zero")
+		return ErrZero
+	}
+	return nil
+}
+
+// amountScale returns the number of significant decimal places of an amount string ("20" is 0, "20.5" and "20.50" are 1)
+func amountScale(amount string) int {
+	amount = strings.TrimSpace(amount)
+	i := strings.Index(amount, ".")
+	if i == -1 {
+		return 0
+	}
+	return len(strings.TrimRight(amount[i+1:], "0"))
+}
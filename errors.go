@@ -0,0 +1,67 @@
+package wservice
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+)
+
+// Errors is the taxonomy of the errors returned by the wallet service. Every error the service returns on purpose is of one of the kinds below,
+// which callers can check with errors.Is while the message stays descriptive (e.g. "The source account does not exist" is an ErrAccountNotFound)
+
+// The kinds of errors returned by the wallet service
+var (
+	ErrInvalidRequest        = errors.New("invalid request")
+	ErrValidation            = errors.New("validation failed")
+	ErrRequestTooLarge       = errors.New("request body too large")
+	ErrMethodNotAllowed      = errors.New("method not allowed")
+	ErrNotFound              = errors.New("not found")
+	ErrInvalidAmount         = errors.New("invalid amount")
+	ErrSameAccount           = errors.New("same source and destination account")
+	ErrAccountNotFound       = errors.New("account not found")
+	ErrWalletNotFound        = errors.New("wallet not found")
+	ErrTransferNotFound      = errors.New("transfer not found")
+	ErrCurrencyNotFound      = errors.New("currency not found")
+	ErrCurrencyDisabled      = errors.New("currency disabled")
+	ErrCurrencyMismatch      = errors.New("currency mismatch")
+	ErrInsufficientFunds     = errors.New("insufficient funds")
+	ErrInvalidTransferStatus = errors.New("invalid transfer status")
+	ErrWebhookNotFound       = errors.New("webhook not found")
+	ErrDeliveryNotFound      = errors.New("webhook delivery not found")
+	ErrWebhookDisabled       = errors.New("webhook disabled")
+	ErrUnauthenticated       = errors.New("unauthenticated")
+	ErrForbidden             = errors.New("forbidden")
+	ErrAPIKeyNotFound        = errors.New("API key not found")
+	ErrAPIKeyRevoked         = errors.New("API key revoked")
+	ErrUnavailable           = errors.New("service unavailable")
+)
+
+// serviceError is an error of one of the kinds above with its own descriptive message
+type serviceError struct {
+	kind error
+	msg  string
+}
+
+// Error returns the descriptive message of the error
+func (e serviceError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the kind of the error so that errors.Is can match it
+func (e serviceError) Unwrap() error {
+	return e.kind
+}
+
+// newError creates an error of the given kind with a descriptive message
+func newError(kind error, msg string) error {
+	return serviceError{kind: kind, msg: msg}
+}
+
+// dbError prefixes a database error with a descriptive message, and classifies it as ErrUnavailable if the database could not be reached
+func dbError(prefix error, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) || err == driver.ErrBadConn {
+		return newError(ErrUnavailable, prefix.Error()+err.Error())
+	}
+	return errors.New(prefix.Error() + err.Error())
+}
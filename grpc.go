@@ -0,0 +1,267 @@
+package wservice
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	"github.com/vstoianovici/wservice/pb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The gRPC transport exposes the accounts, the transfers and the transfer submission to the other services with typed clients generated from
+// pb/wservice.proto. It serves the same endpoints as the HTTP transport, on a port of its own, and fails with the gRPC status matching the problem
+// details the HTTP transport would answer with (see grpcStatus)
+
+// grpcErrorDomain is the domain of the google.rpc.ErrorInfo of the errors of the gRPC transport
+const grpcErrorDomain = "wservice"
+
+// grpcCodes maps the HTTP status codes of the problem details to the gRPC status codes, the errors of any other status are internal errors
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusRequestEntityTooLarge: codes.ResourceExhausted,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusMethodNotAllowed:      codes.Unimplemented,
+	http.StatusConflict:              codes.FailedPrecondition,
+	http.StatusUnprocessableEntity:   codes.FailedPrecondition,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+}
+
+// grpcServer implements the gRPC service of pb/wservice.proto with a go-kit gRPC handler for each of its methods
+type grpcServer struct {
+	pb.UnimplementedWalletServer
+	listAccounts   grpctransport.Handler
+	listTransfers  grpctransport.Handler
+	getTransfer    grpctransport.Handler
+	submitTransfer grpctransport.Handler
+}
+
+// NewGRPCServer exported to be accessible from outside the package (from main)
+// NewGRPCServer creates a gRPC server serving the wallet service API, with server reflection so that clients can discover it. The requests go through
+// the drainer, like the ones of the HTTP transport
+func NewGRPCServer(svc WalletService, drainer *Drainer) *grpc.Server {
+	s := grpc.NewServer(grpc.UnaryInterceptor(drainer.UnaryServerInterceptor))
+	pb.RegisterWalletServer(s, NewGRPCTransport(svc))
+	reflection.Register(s)
+	return s
+}
+
+// NewGRPCTransport exported to be accessible from outside the package (from main)
+// NewGRPCTransport creates a new gRPC transport out of the same endpoints as the HTTP transport, authorized the same way
+func NewGRPCTransport(svc WalletService) pb.WalletServer {
+	// Every handler makes the API key of the metadata of the call available to Authorize
+	options := []grpctransport.ServerOption{
+		grpctransport.ServerBefore(populateGRPCAPIKey),
+	}
+	return &grpcServer{
+		// define a way to service a request for the AccountsEndpoint
+		listAccounts: grpctransport.NewServer(
+			Authorize(svc, ScopeRead)(MakeAccountsEndpoint(svc)),
+			decodeGRPCListAccountsRequest,
+			encodeGRPCListAccountsResponse,
+			options...,
+		),
+		// define a way to service a request for the TransfersEndpoint
+		listTransfers: grpctransport.NewServer(
+			Authorize(svc, ScopeRead)(MakeTransfersEndpoint(svc)),
+			decodeGRPCListTransfersRequest,
+			encodeGRPCListTransfersResponse,
+			options...,
+		),
+		// define a way to service a request for the TransferEndpoint
+		getTransfer: grpctransport.NewServer(
+			Authorize(svc, ScopeRead)(MakeTransferEndpoint(svc)),
+			decodeGRPCGetTransferRequest,
+			encodeGRPCTransferResponse,
+			options...,
+		),
+		// define a way to service a request for the submitTransferEndpoint
+		submitTransfer: grpctransport.NewServer(
+			Authorize(svc, ScopeTransfer)(MakeSubmitTransferEndpoint(svc)),
+			decodeGRPCSubmitTransferRequest,
+			encodeGRPCSubmitTransferResponse,
+			options...,
+		),
+	}
+}
+
+// ListAccounts serves the ListAccounts method
+func (s *grpcServer) ListAccounts(ctx context.Context, req *pb.ListAccountsRequest) (*pb.ListAccountsResponse, error) {
+	_, resp, err := s.listAccounts.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return resp.(*pb.ListAccountsResponse), nil
+}
+
+// ListTransfers serves the ListTransfers method
+func (s *grpcServer) ListTransfers(ctx context.Context, req *pb.ListTransfersRequest) (*pb.ListTransfersResponse, error) {
+	_, resp, err := s.listTransfers.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return resp.(*pb.ListTransfersResponse), nil
+}
+
+// GetTransfer serves the GetTransfer method
+func (s *grpcServer) GetTransfer(ctx context.Context, req *pb.GetTransferRequest) (*pb.Transfer, error) {
+	_, resp, err := s.getTransfer.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return resp.(*pb.Transfer), nil
+}
+
+// SubmitTransfer serves the SubmitTransfer method
+func (s *grpcServer) SubmitTransfer(ctx context.Context, req *pb.SubmitTransferRequest) (*pb.SubmitTransferResponse, error) {
+	_, resp, err := s.submitTransfer.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return resp.(*pb.SubmitTransferResponse), nil
+}
+
+// decodeGRPCListAccountsRequest decodes a ListAccounts request into the request of the AccountsEndpoint
+func decodeGRPCListAccountsRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(*pb.ListAccountsRequest)
+	return accountsRequest{S: req.Wallet}, nil
+}
+
+// encodeGRPCListAccountsResponse encodes the response of the AccountsEndpoint as a ListAccounts response
+func encodeGRPCListAccountsResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(accountsResponse)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	return &pb.ListAccountsResponse{Rows: resp.V}, nil
+}
+
+// decodeGRPCListTransfersRequest decodes a ListTransfers request, the TransfersEndpoint takes no request
+func decodeGRPCListTransfersRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return nil, nil
+}
+
+// encodeGRPCListTransfersResponse encodes the response of the TransfersEndpoint as a ListTransfers response
+func encodeGRPCListTransfersResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(transfersResponse)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	return &pb.ListTransfersResponse{Rows: resp.V}, nil
+}
+
+// decodeGRPCGetTransferRequest decodes a GetTransfer request into the request of the TransferEndpoint
+func decodeGRPCGetTransferRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(*pb.GetTransferRequest)
+	return transferRequest{ID: req.Id}, nil
+}
+
+// encodeGRPCTransferResponse encodes the response of the TransferEndpoint as a Transfer
+func encodeGRPCTransferResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(transferResponse)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	t := resp.V
+	transfer := &pb.Transfer{
+		Id:       t.ID,
+		LegacyId: t.LegacyID,
+		From:     t.From,
+		To:       t.To,
+		Amount:   t.Amount,
+		Currency: t.Currency,
+		Time:     timestamppb.New(t.Time),
+		Status:   t.Status,
+		Reason:   t.Reason,
+	}
+	for _, c := range t.History {
+		transfer.History = append(transfer.History, &pb.TransferStatusChange{Status: c.Status, ChangedAt: timestamppb.New(c.ChangedAt), Reason: c.Reason})
+	}
+	return transfer, nil
+}
+
+// decodeGRPCSubmitTransferRequest decodes a SubmitTransfer request into the request of the submitTransferEndpoint, checked like the HTTP one
+func decodeGRPCSubmitTransferRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(*pb.SubmitTransferRequest)
+	r := submitTransferRequest{
+		FromAccount: req.From,
+		ToAccount:   req.To,
+		FromWallet:  req.FromWallet,
+		ToWallet:    req.ToWallet,
+		Currency:    req.Currency,
+		Amount:      req.Amount,
+	}
+	if err := checkSubmitTransferRequest(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// encodeGRPCSubmitTransferResponse encodes the response of the submitTransferEndpoint as a SubmitTransfer response. A failed transfer attempt is
+// recorded, so its status tells the client where to find it
+func encodeGRPCSubmitTransferResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(submitTransferResponse)
+	if resp.Err != nil {
+		p := NewProblem(context.Background(), resp.Err)
+		p.TransferID, p.LegacyTransferID = resp.ID, resp.LegacyID
+		return nil, grpcStatus(p).Err()
+	}
+	return &pb.SubmitTransferResponse{Result: resp.V, Id: resp.ID, LegacyId: resp.LegacyID, Status: resp.Status}, nil
+}
+
+// grpcError turns an error of the wallet service into a gRPC status error, errors that already are one are returned as they are
+func grpcError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return grpcStatus(NewProblem(context.Background(), err)).Err()
+}
+
+// grpcStatus builds the gRPC status of problem details: its code matches the HTTP status, its message is the detail, a google.rpc.ErrorInfo carries
+// the stable code of the error (and the ID of a failed transfer attempt) and a google.rpc.BadRequest lists the invalid fields of a validation error
+func grpcStatus(p Problem) *status.Status {
+	code, ok := grpcCodes[p.Status]
+	if !ok {
+		code = codes.Internal
+	}
+	st := status.New(code, p.Detail)
+	info := &errdetails.ErrorInfo{Reason: p.Code, Domain: grpcErrorDomain}
+	if p.TransferID != "" {
+		info.Metadata = map[string]string{"transfer_id": p.TransferID}
+		if p.LegacyTransferID != 0 {
+			info.Metadata["legacy_transfer_id"] = strconv.FormatInt(p.LegacyTransferID, 10)
+		}
+	}
+	withInfo, err := st.WithDetails(info)
+	if err != nil {
+		return st
+	}
+	if len(p.Errors) == 0 {
+		return withInfo
+	}
+	fields := &errdetails.BadRequest{}
+	for _, f := range p.Errors {
+		fields.FieldViolations = append(fields.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: f.Field, Description: f.Message})
+	}
+	if withFields, err := withInfo.WithDetails(fields); err == nil {
+		return withFields
+	}
+	return withInfo
+}
+
+// UnaryServerInterceptor counts the gRPC requests like Handler counts the HTTP ones, and answers UNAVAILABLE once draining has started
+func (d *Drainer) UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !d.enter() {
+		return nil, grpcError(ErrShuttingDown)
+	}
+	defer d.leave()
+	return handler(ctx, req)
+}
@@ -0,0 +1,132 @@
+package wservice
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vstoianovici/wservice/pb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestGRPCClient serves the gRPC API of a wallet service in memory until the test ends and returns a client connection to it
+func newTestGRPCClient(t *testing.T, svc WalletService, drainer *Drainer) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	server := NewGRPCServer(svc, drainer)
+	go server.Serve(lis)
+	conn, err := grpc.NewClient("passthrough:///wservice", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }))
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+	})
+	return conn
+}
+
+// errorInfo returns the google.rpc.ErrorInfo of a gRPC status error
+func errorInfo(t *testing.T, err error) *errdetails.ErrorInfo {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info
+		}
+	}
+	t.Fatalf("no ErrorInfo in %v", err)
+	return nil
+}
+
+func TestGRPCTransport(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		client := pb.NewWalletClient(newTestGRPCClient(t, svc, NewDrainer()))
+		ctx := context.Background()
+
+		submitted, err := client.SubmitTransfer(ctx, &pb.SubmitTransferRequest{From: "bob123", To: "alice456", Amount: "2.5"})
+		assert.Nil(t, err)
+		assert.Equal(t, "success", submitted.Result)
+		assert.Equal(t, StatusCompleted, submitted.Status)
+		transfer, err := client.GetTransfer(ctx, &pb.GetTransferRequest{Id: submitted.Id})
+		assert.Nil(t, err)
+		assert.Equal(t, "bob123", transfer.From)
+		assert.Equal(t, "alice456", transfer.To)
+		assert.Equal(t, "2.500", transfer.Amount)
+		assert.Equal(t, StatusCompleted, transfer.Status)
+		assert.NotNil(t, transfer.Time)
+		assert.NotEmpty(t, transfer.History)
+
+		accounts, err := client.ListAccounts(ctx, &pb.ListAccountsRequest{})
+		assert.Nil(t, err)
+		want, err := svc.GetTable(AccountsTable)
+		assert.Nil(t, err)
+		assert.Equal(t, want, accounts.Rows)
+		transfers, err := client.ListTransfers(ctx, &pb.ListTransfersRequest{})
+		assert.Nil(t, err)
+		want, err = svc.GetTable(TransfersTable)
+		assert.Nil(t, err)
+		assert.Equal(t, want, transfers.Rows)
+	})
+}
+
+func TestGRPCErrors(t *testing.T) {
+	svc := newMemoryService(t)
+	client := pb.NewWalletClient(newTestGRPCClient(t, svc, NewDrainer()))
+	ctx := context.Background()
+
+	// A refused transfer is recorded, its status carries the stable code of the error and where to find the failed attempt
+	_, err := client.SubmitTransfer(ctx, &pb.SubmitTransferRequest{From: "bob123", To: "alice456", Amount: "100000"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	info := errorInfo(t, err)
+	assert.Equal(t, "insufficient_funds", info.Reason)
+	assert.Equal(t, grpcErrorDomain, info.Domain)
+	failed, err := svc.GetTransfer(info.Metadata["transfer_id"])
+	assert.Nil(t, err)
+	assert.Equal(t, StatusFailed, failed.Status)
+
+	_, err = client.GetTransfer(ctx, &pb.GetTransferRequest{Id: "01D6MK0GTBZ4W3K9XH8S2JQ5VN"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "transfer_not_found", errorInfo(t, err).Reason)
+
+	// A validation error lists the invalid fields
+	_, err = client.SubmitTransfer(ctx, &pb.SubmitTransferRequest{From: "bob123", FromWallet: "w1", ToWallet: "w2", Currency: "USD", Amount: "1"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	var fields *errdetails.BadRequest
+	for _, d := range status.Convert(err).Details() {
+		if b, ok := d.(*errdetails.BadRequest); ok {
+			fields = b
+		}
+	}
+	if assert.NotNil(t, fields) {
+		assert.Equal(t, "from", fields.FieldViolations[0].Field)
+		assert.Equal(t, "must not be set together with from_wallet and to_wallet", fields.FieldViolations[0].Description)
+	}
+}
+
+func TestGRPCDrain(t *testing.T) {
+	drainer := NewDrainer()
+	client := pb.NewWalletClient(newTestGRPCClient(t, newMemoryService(t), drainer))
+	assert.Nil(t, drainer.Drain(context.Background()))
+	_, err := client.ListAccounts(context.Background(), &pb.ListAccountsRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, "service_unavailable", errorInfo(t, err).Reason)
+}
+
+func TestGRPCReflection(t *testing.T) {
+	conn := newTestGRPCClient(t, newMemoryService(t), NewDrainer())
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, stream.Send(&reflectionpb.ServerReflectionRequest{MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{}}))
+	resp, err := stream.Recv()
+	assert.Nil(t, err)
+	var services []string
+	for _, s := range resp.GetListServicesResponse().Service {
+		services = append(services, s.Name)
+	}
+	assert.Contains(t, services, "wservice.v1.Wallet")
+	assert.Nil(t, stream.CloseSend())
+}
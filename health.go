@@ -0,0 +1,201 @@
+package wservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// /healthz tells whether the process is alive and /readyz whether it can serve requests: the database is reachable, its schema is at the version the
+// binary expects and the server is not draining. The readiness checks are cached for a while so that load balancers and Docker polling the endpoints
+// do not hammer Postgres
+
+// checkTimeout bounds every readiness check
+const checkTimeout = 2 * time.Second
+
+// CheckResult is the outcome of a readiness check
+type CheckResult struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	Duration  string    `json:"duration"`
+}
+
+// HealthReport is the body of the health endpoints
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// readinessCheck is a named check with its last result
+type readinessCheck struct {
+	mu     sync.Mutex
+	name   string
+	check  func(context.Context) error
+	cached bool
+	result CheckResult
+}
+
+// Health serves the liveness and readiness endpoints
+type Health struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	checks []*readinessCheck
+}
+
+// NewHealth exported to be accessible from outside the package (from main)
+// NewHealth creates the health endpoints, the result of a readiness check is reused for ttl
+func NewHealth(ttl time.Duration) *Health {
+	return &Health{ttl: ttl}
+}
+
+// SetCacheTTL changes how long the result of a readiness check is reused
+func (h *Health) SetCacheTTL(ttl time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ttl = ttl
+}
+
+// AddCheck adds a readiness check, the service is ready when every check returns nil. The result of a cached check is reused for the cache TTL,
+// cheap checks that must be seen straight away (like draining) are not cached
+func (h *Health) AddCheck(name string, check func(context.Context) error, cached bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, &readinessCheck{name: name, check: check, cached: cached})
+}
+
+// run returns the result of a check, running it again if its last result is older than ttl. Concurrent callers wait for the same run
+func (c *readinessCheck) run(ctx context.Context, ttl time.Duration) CheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cached && !c.result.CheckedAt.IsZero() && time.Since(c.result.CheckedAt) < ttl {
+		return c.result
+	}
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	begin := time.Now()
+	// The check is not waited for past the timeout, as the driver does not always honour the context while connecting
+	done := make(chan error, 1)
+	go func() { done <- c.check(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errors.New("err: check timed out after " + checkTimeout.String())
+	}
+	c.result = CheckResult{Status: "ok", CheckedAt: begin.UTC(), Duration: time.Since(begin).String()}
+	if err != nil {
+		c.result.Status = "failing"
+		c.result.Error = err.Error()
+	}
+	return c.result
+}
+
+// Readiness runs the readiness checks (or reuses their cached results) and reports whether they all pass
+func (h *Health) Readiness(ctx context.Context) (HealthReport, bool) {
+	h.mu.Lock()
+	ttl, checks := h.ttl, h.checks
+	h.mu.Unlock()
+
+	report := HealthReport{Status: "ready", Checks: map[string]CheckResult{}}
+	ready := true
+	for _, c := range checks {
+		result := c.run(ctx, ttl)
+		if result.Status != "ok" {
+			ready = false
+			report.Status = "not_ready"
+		}
+		report.Checks[c.name] = result
+	}
+	return report, ready
+}
+
+// LivenessHandler answers 200 as long as the process serves HTTP
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK, HealthReport{Status: "ok"})
+	})
+}
+
+// ReadinessHandler answers 200 when every readiness check passes and 503 otherwise, with the result of every check
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, ready := h.Readiness(r.Context())
+		status := http.StatusOK
+		if !ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeHealth(w, status, report)
+	})
+}
+
+// writeHealth writes a health report, which must never be cached by a proxy
+func writeHealth(w http.ResponseWriter, status int, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// DatabaseCheck exported to be accessible from outside the package (from main)
+// DatabaseCheck returns a readiness check that pings the database (Postgres or SQLite) of the wallet service created by NewServiceFromConfig
+func DatabaseCheck(svc WalletService) func(context.Context) error {
+	return func(ctx context.Context) error {
+		store, _ := storeOf(svc)
+		switch s := store.(type) {
+		case sqliteStore:
+			return s.ping(ctx)
+		case sqlDBTx:
+			db, err := s.openDB()
+			if err != nil {
+				return err
+			}
+			if err := db.PingContext(ctx); err != nil {
+				var ErrPing = errors.New("err: could not reach postgres ")
+				return dbError(ErrPing, err)
+			}
+			return nil
+		}
+		var ErrNoDB = errors.New("err: only the wallet service kept in a database has a database to check")
+		return ErrNoDB
+	}
+}
+
+// MigrationsCheck exported to be accessible from outside the package (from main)
+// MigrationsCheck returns a readiness check that makes sure every migration embedded in the binary has been applied to the database
+func MigrationsCheck(svc WalletService) func(context.Context) error {
+	return func(ctx context.Context) error {
+		migrator, err := NewMigrator(svc)
+		if err != nil {
+			return err
+		}
+		migrations, err := migrator.StatusContext(ctx)
+		if err != nil {
+			return err
+		}
+		applied := 0
+		for _, m := range migrations {
+			if !m.Applied() {
+				return fmt.Errorf("err: migration %04d_%s is pending, the schema is at version %d and version %d is expected",
+					m.Version, m.Name, applied, migrations[len(migrations)-1].Version)
+			}
+			applied = m.Version
+		}
+		return nil
+	}
+}
+
+// DrainingCheck exported to be accessible from outside the package (from main)
+// DrainingCheck returns a readiness check that fails once the server has started draining, so that load balancers stop sending it requests
+func DrainingCheck(d *Drainer) func(context.Context) error {
+	return func(ctx context.Context) error {
+		if d.Draining() {
+			return ErrShuttingDown
+		}
+		return nil
+	}
+}
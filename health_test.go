@@ -0,0 +1,61 @@
+package wservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	h := NewHealth(time.Hour)
+	calls := 0
+	var dbErr error
+	h.AddCheck("database", func(ctx context.Context) error {
+		calls++
+		return dbErr
+	}, true)
+	d := NewDrainer()
+	h.AddCheck("shutdown", DrainingCheck(d), false)
+
+	response := httptest.NewRecorder()
+	h.LivenessHandler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, response.Body.String())
+
+	readiness := func() (int, HealthReport) {
+		response := httptest.NewRecorder()
+		h.ReadinessHandler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var report HealthReport
+		assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &report))
+		return response.Code, report
+	}
+	code, report := readiness()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", report.Status)
+	assert.Equal(t, "ok", report.Checks["database"].Status)
+
+	// The database check is cached, the draining check is not
+	dbErr = errors.New("err: could not reach postgres")
+	go d.Drain(context.Background())
+	for !d.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+	code, report = readiness()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "not_ready", report.Status)
+	assert.Equal(t, "ok", report.Checks["database"].Status)
+	assert.Equal(t, CheckResult{Status: "failing", Error: ErrShuttingDown.Error()}, CheckResult{Status: report.Checks["shutdown"].Status, Error: report.Checks["shutdown"].Error})
+	assert.Equal(t, 1, calls)
+
+	h.SetCacheTTL(0)
+	_, report = readiness()
+	assert.Equal(t, "failing", report.Checks["database"].Status)
+	assert.Equal(t, "err: could not reach postgres", report.Checks["database"].Error)
+	assert.Equal(t, 2, calls)
+}
@@ -50,3 +50,354 @@ func (mw instrumentingMiddleware) DoTransfer(s string, t string, v string) (outp
 	output, err = mw.next.DoTransfer(s, t, v)
 	return
 }
+
+// GetCurrencies function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) GetCurrencies() (output []string, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "getCurrencies", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetCurrencies()
+	return
+}
+
+// SetCurrencyEnabled function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) SetCurrencyEnabled(c string, e bool) (output string, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "setCurrencyEnabled", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.SetCurrencyEnabled(c, e)
+	return
+}
+
+// GetWallet function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) GetWallet(w string) (output []string, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "getWallet", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetWallet(w)
+	return
+}
+
+// SetInterestRate function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) SetInterestRate(a string, r string, d string, e string) (output string, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "setInterestRate", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.SetInterestRate(a, r, d, e)
+	return
+}
+
+// AccrueInterest function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) AccrueInterest(d string) (output string, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "accrueInterest", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.AccrueInterest(d)
+	return
+}
+
+// PostInterest function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) PostInterest(m string) (output string, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "postInterest", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.PostInterest(m)
+	return
+}
+
+// PostDueInterest function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) PostDueInterest() (output string, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "postDueInterest", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.PostDueInterest()
+	return
+}
+
+// SubmitTransfer function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) SubmitTransfer(s string, t string, v string) (output Transfer, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "submitTransfer", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.SubmitTransfer(s, t, v)
+	return
+}
+
+// DoWalletTransfer function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) DoWalletTransfer(s string, t string, c string, v string) (output Transfer, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "doWalletTransfer", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.DoWalletTransfer(s, t, c, v)
+	return
+}
+
+// GetTransfer function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) GetTransfer(id string) (output Transfer, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "getTransfer", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetTransfer(id)
+	return
+}
+
+// ReverseTransfer function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) ReverseTransfer(id string) (output Transfer, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "reverseTransfer", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.ReverseTransfer(id)
+	return
+}
+
+// CancelTransfer function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) CancelTransfer(id string) (output Transfer, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "cancelTransfer", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.CancelTransfer(id)
+	return
+}
+
+// CreateWebhook function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) CreateWebhook(u string, e []string, s string) (output Webhook, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "createWebhook", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.CreateWebhook(u, e, s)
+	return
+}
+
+// GetWebhooks function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) GetWebhooks() (output []Webhook, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "getWebhooks", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetWebhooks()
+	return
+}
+
+// GetWebhook function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) GetWebhook(id string) (output Webhook, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "getWebhook", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetWebhook(id)
+	return
+}
+
+// SetWebhookEnabled function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) SetWebhookEnabled(id string, e bool) (output Webhook, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "setWebhookEnabled", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.SetWebhookEnabled(id, e)
+	return
+}
+
+// DeleteWebhook function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) DeleteWebhook(id string) (output string, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "deleteWebhook", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.DeleteWebhook(id)
+	return
+}
+
+// GetWebhookDeliveries function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) GetWebhookDeliveries(id string) (output []WebhookDelivery, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "getWebhookDeliveries", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetWebhookDeliveries(id)
+	return
+}
+
+// ReplayWebhookDelivery function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) ReplayWebhookDelivery(id string, d string) (output WebhookDelivery, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "replayWebhookDelivery", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.ReplayWebhookDelivery(id, d)
+	return
+}
+
+// GetAccounts function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) GetAccounts(w string) (output []Account, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "getAccounts", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetAccounts(w)
+	return
+}
+
+// GetAccount function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) GetAccount(id string) (output Account, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "getAccount", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetAccount(id)
+	return
+}
+
+// GetTransfers function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) GetTransfers() (output []Transfer, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "getTransfers", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetTransfers()
+	return
+}
+
+// CreateAPIKey function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) CreateAPIKey(name string, scopes []string) (output APIKey, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "createAPIKey", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.CreateAPIKey(name, scopes)
+	return
+}
+
+// GetAPIKeys function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) GetAPIKeys() (output []APIKey, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "getAPIKeys", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetAPIKeys()
+	return
+}
+
+// RotateAPIKey function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) RotateAPIKey(id string, grace time.Duration) (output APIKey, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "rotateAPIKey", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.RotateAPIKey(id, grace)
+	return
+}
+
+// RevokeAPIKey function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) RevokeAPIKey(id string) (output APIKey, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "revokeAPIKey", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.RevokeAPIKey(id)
+	return
+}
+
+// Authenticate function is implemented for the instrumenting layer as the request traverses through the instrumenting layer down to the next layer
+func (mw instrumentingMiddleware) Authenticate(key string) (output APIKey, err error) {
+	// Incremement instrumenting counters and determine latency
+	defer func(begin time.Time) {
+		lvs := []string{"method", "authenticate", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.Authenticate(key)
+	return
+}
@@ -0,0 +1,346 @@
+package wservice
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Interest is the accrual and posting engine for savings-style accounts. Every account with a row in the InterestRates table accrues interest daily on
+// its end-of-day balance (according to its day-count convention) into the InterestAccruals table, and once a month is over the accrued interest
+// is posted to the account as a transfer from the bank's interest-expense account. Both steps are idempotent so the job can be safely re-run or restarted.
+// Like the amounts of the ledger, the rates, balances and interest are decimal strings computed with exact rational arithmetic (see parseAmount)
+
+const (
+	// dateLayout is the layout of the dates the interest engine works with
+	dateLayout = "2006-01-02"
+	// monthLayout is the layout of the months the interest engine posts
+	monthLayout = "2006-01"
+	// accrualScale is the number of decimal places the interest of a day is kept with, as many as the Amount column of the InterestAccruals table
+	accrualScale = 9
+)
+
+// dayCountConventions are the supported day-count conventions
+var dayCountConventions = []string{"ACT/365", "ACT/360", "ACT/ACT", "30/360"}
+
+// SetInterestRate is a ledger type method that configures (or reconfigures) the annual interest rate, given as a fraction ("0.015" is 1.5%), the day-count
+// convention and the interest-expense account that pays the interest of an account. A new rate only applies to the days accrued after the change
+func (l ledger) SetInterestRate(accountID string, rate string, dayCount string, expenseAccount string) (string, error) {
+	if !amountPattern.MatchString(rate) {
+		var ErrRate = newError(ErrInvalidRequest, "The interest rate must be a non-negative number")
+		return "error", ErrRate
+	}
+	if _, err := dayCountFraction(dayCount, time.Now(), time.Now()); err != nil {
+		return "error", err
+	}
+	if accountID == expenseAccount {
+		var ErrSameAcc = newError(ErrSameAccount, "the interest-expense account is the same as the interest-bearing account. ")
+		return "error", ErrSameAcc
+	}
+
+	err := l.store.Update(func(tx LedgerTx) error {
+		// Both accounts have to exist
+		for _, id := range []string{accountID, expenseAccount} {
+			if _, err := tx.Account(id); err != nil {
+				if err == errNotFound {
+					var ErrNoAccount = newError(ErrAccountNotFound, "The account or the interest-expense account does not exist")
+					return ErrNoAccount
+				}
+				return err
+			}
+		}
+		return tx.SetInterestRate(InterestRate{AccountID: accountID, AnnualRate: rate, DayCount: dayCount, ExpenseAccount: expenseAccount, StartDate: today()})
+	})
+	if err != nil {
+		return "error", err
+	}
+	return "success", nil
+}
+
+// AccrueInterest is a ledger type method that accrues the daily interest of every configured account for all the days up to (and including) the given date
+// that were not accrued yet. The date ("2006-01-02") has to be in the past, as interest is computed on end-of-day balances. An account whose interest
+// cannot be accrued stops at the day that failed, so that it is accrued again next time, while the other accounts are accrued all the same
+func (l ledger) AccrueInterest(date string) (string, error) {
+	until, err := time.Parse(dateLayout, date)
+	if err != nil {
+		var ErrDate = newError(ErrInvalidRequest, "The accrual date must have the YYYY-MM-DD format")
+		return "error", ErrDate
+	}
+	if !until.Before(today()) {
+		var ErrDate = newError(ErrInvalidRequest, "Interest can only be accrued for days that are over")
+		return "error", ErrDate
+	}
+
+	// Fetch the configured accounts together with the first day that still has to be accrued for each of them
+	type accrual struct {
+		rate InterestRate
+		from time.Time
+	}
+	var accruals []accrual
+	err = l.store.View(func(tx LedgerTx) error {
+		rates, err := tx.InterestRates()
+		if err != nil {
+			return err
+		}
+		accruals = nil
+		for _, r := range rates {
+			last, err := tx.LastAccrualDate(r.AccountID)
+			if err != nil {
+				return err
+			}
+			a := accrual{rate: r, from: r.StartDate.UTC()}
+			if next := last.UTC().AddDate(0, 0, 1); !last.IsZero() && next.After(a.from) {
+				a.from = next
+			}
+			accruals = append(accruals, a)
+		}
+		return nil
+	})
+	if err != nil {
+		return "error", err
+	}
+
+	var failures interestFailures
+	for _, a := range accruals {
+		for d := a.from; !d.After(until); d = d.AddDate(0, 0, 1) {
+			if err := l.accrueDay(a.rate, d); err != nil {
+				failures = append(failures, interestFailure{of: a.rate.AccountID, err: err})
+				break
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return "error", failures.join("Could not accrue the interest of ")
+	}
+	return "success", nil
+}
+
+// accrueDay computes and records the interest of a single account for a single day, on its end-of-day balance as rebuilt by the store
+func (l ledger) accrueDay(rate InterestRate, day time.Time) error {
+	next := day.AddDate(0, 0, 1)
+	fraction, err := dayCountFraction(rate.DayCount, day, next)
+	if err != nil {
+		return err
+	}
+	annualRate, err := parseAmount(rate.AnnualRate)
+	if err != nil {
+		return err
+	}
+	return l.store.Update(func(tx LedgerTx) error {
+		balance, err := tx.BalanceAt(rate.AccountID, next)
+		if err != nil {
+			return err
+		}
+		amount, err := parseAmount(balance)
+		if err != nil {
+			return err
+		}
+		amount.Mul(amount, annualRate).Mul(amount, fraction)
+		return tx.InsertAccrual(InterestAccrual{AccountID: rate.AccountID, Date: day, Balance: balance, AnnualRate: rate.AnnualRate, Amount: amount.FloatString(accrualScale)})
+	})
+}
+
+// PostInterest is a ledger type method that posts the interest accrued during the given month ("2006-01") by every account as a transfer from its
+// interest-expense account. The accruals are marked as posted in the same transaction as the transfer, so posting a month twice is a no-op. An account
+// whose interest cannot be posted is left to be posted again, while the other accounts are posted all the same
+func (l ledger) PostInterest(month string) (string, error) {
+	from, err := time.Parse(monthLayout, month)
+	if err != nil {
+		var ErrMonth = newError(ErrInvalidRequest, "The posting month must have the YYYY-MM format")
+		return "error", ErrMonth
+	}
+	until := from.AddDate(0, 1, 0)
+	if until.After(today()) {
+		var ErrMonth = newError(ErrInvalidRequest, "Interest can only be posted for months that are over")
+		return "error", ErrMonth
+	}
+
+	var accounts []string
+	err = l.store.View(func(tx LedgerTx) error {
+		var err error
+		accounts, err = tx.UnpostedAccounts(from, until)
+		return err
+	})
+	if err != nil {
+		return "error", err
+	}
+
+	var failures interestFailures
+	for _, accountID := range accounts {
+		if err := l.postAccountInterest(accountID, from, until); err != nil {
+			failures = append(failures, interestFailure{of: accountID, err: err})
+		}
+	}
+	if len(failures) > 0 {
+		return "error", failures.join("Could not post the interest of ")
+	}
+	return "success", nil
+}
+
+// PostDueInterest is a ledger type method that posts the interest of every month that is over and was not posted yet, from the first month with
+// unposted interest on, so that the months missed while the job was not running (or whose posting failed) are caught up. A month that cannot be
+// posted does not keep the following ones from being posted
+func (l ledger) PostDueInterest() (string, error) {
+	thisMonth := today().AddDate(0, 0, 1-today().Day())
+	var first time.Time
+	err := l.store.View(func(tx LedgerTx) error {
+		var err error
+		first, err = tx.FirstUnpostedAccrual(thisMonth)
+		return err
+	})
+	if err != nil {
+		return "error", err
+	}
+	if first.IsZero() {
+		return "success", nil
+	}
+
+	var failures interestFailures
+	for month := first.AddDate(0, 0, 1-first.Day()); month.Before(thisMonth); month = month.AddDate(0, 1, 0) {
+		if _, err := l.PostInterest(month.Format(monthLayout)); err != nil {
+			failures = append(failures, interestFailure{of: month.Format(monthLayout), err: err})
+		}
+	}
+	if len(failures) > 0 {
+		return "error", failures.join("Could not post the interest due in ")
+	}
+	return "success", nil
+}
+
+// postAccountInterest posts the unposted interest accrued by an account between from (inclusive) and until (exclusive), rounded to the minor units of the
+// account's currency, and marks the accruals as posted, all in the same transaction
+func (l ledger) postAccountInterest(accountID string, from time.Time, until time.Time) error {
+	return l.store.Update(func(tx LedgerTx) error {
+		total, err := tx.UnpostedInterest(accountID, from, until)
+		if err == errNotFound {
+			// Another instance posted this account in the meantime
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rate, err := tx.InterestRate(accountID)
+		if err != nil {
+			return err
+		}
+		account, err := tx.Account(accountID)
+		if err != nil {
+			return err
+		}
+		currency, err := tx.Currency(account.Currency)
+		if err != nil {
+			return err
+		}
+
+		interest, err := parseAmount(total)
+		if err != nil {
+			return err
+		}
+
+		// Post the rounded interest (if any) and keep the ID of the transfer that paid it
+		var transferID int64
+		if amount := roundToMinorUnits(interest, currency.MinorUnits); amount != roundToMinorUnits(new(big.Rat), currency.MinorUnits) {
+			t, err := l.transferTx(tx, rate.ExpenseAccount, accountID, amount)
+			if err != nil {
+				return err
+			}
+			transferID = t.LegacyID
+		}
+		return tx.MarkInterestPosted(accountID, from, until, transferID)
+	})
+}
+
+// RunInterestJob runs the interest engine every interval until stop is closed: it accrues the interest of every day up to yesterday and posts the interest
+// of every month that is over and was not posted yet. Since both steps are idempotent a restarted (or a concurrently running) job simply picks up
+// whatever was not done yet
+func RunInterestJob(svc WalletService, interval time.Duration, logger log.Logger, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		now := today()
+		if _, err := svc.AccrueInterest(now.AddDate(0, 0, -1).Format(dateLayout)); err != nil {
+			logger.Log("msg", "interest accrual failed", "err", err)
+		}
+		if _, err := svc.PostDueInterest(); err != nil {
+			logger.Log("msg", "interest posting failed", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// dayCountFraction returns the fraction of a year between from and to according to the day-count convention
+func dayCountFraction(convention string, from time.Time, to time.Time) (*big.Rat, error) {
+	days := int64(to.Sub(from).Hours() / 24)
+	switch convention {
+	case "ACT/365":
+		return big.NewRat(days, 365), nil
+	case "ACT/360":
+		return big.NewRat(days, 360), nil
+	case "ACT/ACT":
+		// Every day counts as a fraction of the length of the year it belongs to
+		fraction := new(big.Rat)
+		for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+			fraction.Add(fraction, big.NewRat(1, int64(daysInYear(d.Year()))))
+		}
+		return fraction, nil
+	case "30/360":
+		// 30E/360: every month counts as 30 days, the 31st being treated as the 30th
+		d1, d2 := from.Day(), to.Day()
+		if d1 == 31 {
+			d1 = 30
+		}
+		if d2 == 31 {
+			d2 = 30
+		}
+		days360 := 360*(to.Year()-from.Year()) + 30*(int(to.Month())-int(from.Month())) + d2 - d1
+		return big.NewRat(int64(days360), 360), nil
+	}
+	var ErrDayCount = newError(ErrInvalidRequest, "The day-count convention must be one of "+strings.Join(dayCountConventions, ", "))
+	return nil, ErrDayCount
+}
+
+// daysInYear returns 366 for leap years and 365 otherwise
+func daysInYear(year int) int {
+	return time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+}
+
+// roundToMinorUnits rounds an amount half away from zero to the given number of decimal places and formats it as a transfer amount
+func roundToMinorUnits(amount *big.Rat, minorUnits int) string {
+	return amount.FloatString(minorUnits)
+}
+
+// interestFailure is the failure of the interest engine for an account, or for a month
+type interestFailure struct {
+	of  string
+	err error
+}
+
+// interestFailures are the failures of the interest engine for the accounts (or the months) it could not accrue or post, reported together once
+// the others are done
+type interestFailures []interestFailure
+
+// join reports the failures as a single error following msg, of the kind of the first one when it has a kind so that a single failure keeps its status
+func (f interestFailures) join(msg string) error {
+	msgs := make([]string, len(f))
+	for i, e := range f {
+		msgs[i] = e.of + ": " + e.err.Error()
+	}
+	msg += strings.Join(msgs, "; ")
+	var serr serviceError
+	if errors.As(f[0].err, &serr) {
+		return newError(serr.kind, msg)
+	}
+	return errors.New(msg)
+}
+
+// today returns the current date (midnight UTC), the boundary of the days the interest engine works with
+func today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
@@ -0,0 +1,65 @@
+package wservice
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func date(s string) time.Time {
+	d, _ := time.Parse(dateLayout, s)
+	return d
+}
+
+func TestDayCountFraction(t *testing.T) {
+	f, err := dayCountFraction("ACT/365", date("2019-03-01"), date("2019-03-02"))
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewRat(1, 365), f)
+	f, err = dayCountFraction("ACT/360", date("2019-03-01"), date("2019-03-02"))
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewRat(1, 360), f)
+	f, err = dayCountFraction("ACT/ACT", date("2020-02-28"), date("2020-02-29"))
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewRat(1, 366), f)
+	f, err = dayCountFraction("ACT/ACT", date("2019-12-31"), date("2020-01-02"))
+	assert.Nil(t, err)
+	assert.Equal(t, new(big.Rat).Add(big.NewRat(1, 365), big.NewRat(1, 366)), f)
+	_, err = dayCountFraction("ACT/366", date("2019-03-01"), date("2019-03-02"))
+	assert.NotNil(t, err)
+}
+
+func TestDayCountFraction30360(t *testing.T) {
+	// The 31st accrues nothing and the last day of February accrues the rest of the 30 day month
+	f, _ := dayCountFraction("30/360", date("2019-01-31"), date("2019-02-01"))
+	assert.Equal(t, big.NewRat(1, 360), f)
+	f, _ = dayCountFraction("30/360", date("2019-01-30"), date("2019-01-31"))
+	assert.Equal(t, 0, f.Sign())
+	f, _ = dayCountFraction("30/360", date("2019-02-28"), date("2019-03-01"))
+	assert.Equal(t, big.NewRat(3, 360), f)
+	// Summing every day of a month always gives 30 days
+	sum := new(big.Rat)
+	for d := date("2019-02-01"); d.Before(date("2019-03-01")); d = d.AddDate(0, 0, 1) {
+		f, _ = dayCountFraction("30/360", d, d.AddDate(0, 0, 1))
+		sum.Add(sum, f)
+	}
+	assert.Equal(t, big.NewRat(30, 360), sum)
+}
+
+func TestRoundToMinorUnits(t *testing.T) {
+	assert.Equal(t, "1.24", roundToMinorUnits(big.NewRat(1235, 1000), 2))
+	assert.Equal(t, "1", roundToMinorUnits(big.NewRat(14999, 10000), 0))
+	assert.Equal(t, "0.001", roundToMinorUnits(big.NewRat(5, 10000), 3))
+	assert.Equal(t, "0.00", roundToMinorUnits(big.NewRat(4, 1000), 2))
+}
+
+func TestAccrueInterestFutureDate(t *testing.T) {
+	svc, _ := getDbConfig("./cmd/postgresql.cfg")
+	status, err := svc.AccrueInterest(today().Format(dateLayout))
+	assert.Contains(t, status, "error")
+	assert.EqualError(t, err, "Interest can only be accrued for days that are over")
+	status, err = svc.PostInterest(today().Format(monthLayout))
+	assert.Contains(t, status, "error")
+	assert.EqualError(t, err, "Interest can only be posted for months that are over")
+}
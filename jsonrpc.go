@@ -0,0 +1,299 @@
+package wservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// The JSON-RPC 2.0 endpoint of /rpc serves the go-kit endpoints of the HTTP transport to the tools that only speak JSON-RPC. Every method takes its
+// params as an object with the fields of the JSON body of its HTTP counterpart and returns the same JSON as its result, a batch runs its calls in
+// order, and the errors of the service come back with the JSON-RPC error code matching their kind and their problem details as data
+
+// The JSON-RPC version the endpoint speaks and the largest batch it runs
+const (
+	jsonrpcVersion      = "2.0"
+	jsonrpcMaxBatchSize = 100
+)
+
+// The error codes of JSON-RPC 2.0, and the server error codes the errors of the service map to
+const (
+	JSONRPCParseError      = -32700
+	JSONRPCInvalidRequest  = -32600
+	JSONRPCMethodNotFound  = -32601
+	JSONRPCInvalidParams   = -32602
+	JSONRPCInternalError   = -32603
+	JSONRPCNotFound        = -32001
+	JSONRPCConflict        = -32002
+	JSONRPCUnprocessable   = -32003
+	JSONRPCUnavailable     = -32004
+	JSONRPCUnauthenticated = -32005
+	JSONRPCForbidden       = -32006
+)
+
+// jsonrpcCodes maps the HTTP status codes of the problem details to JSON-RPC error codes, the errors of any other status are internal errors
+var jsonrpcCodes = map[int]int{
+	http.StatusBadRequest:            JSONRPCInvalidParams,
+	http.StatusRequestEntityTooLarge: JSONRPCInvalidRequest,
+	http.StatusUnauthorized:          JSONRPCUnauthenticated,
+	http.StatusForbidden:             JSONRPCForbidden,
+	http.StatusNotFound:              JSONRPCNotFound,
+	http.StatusConflict:              JSONRPCConflict,
+	http.StatusUnprocessableEntity:   JSONRPCUnprocessable,
+	http.StatusServiceUnavailable:    JSONRPCUnavailable,
+}
+
+// jsonrpcMethod is a JSON-RPC method served by a go-kit endpoint, decode turns its params (nil when there are none) into the request of the endpoint
+type jsonrpcMethod struct {
+	endpoint endpoint.Endpoint
+	decode   func(params json.RawMessage) (interface{}, error)
+}
+
+// jsonrpcRequest is a call of a JSON-RPC request, a call without an ID is a notification which gets no response
+type jsonrpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// jsonrpcResponse is the response to a call, with either its result or its error
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// JSONRPCError is the error of a failed call. The data of the errors of the service are their problem details
+type JSONRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// jsonrpcHandler serves the JSON-RPC requests of /rpc
+type jsonrpcHandler struct {
+	svc     WalletService
+	methods map[string]jsonrpcMethod
+}
+
+// NewJSONRPCHandler exported to be accessible from outside the package (from main)
+// NewJSONRPCHandler creates the JSON-RPC 2.0 handler of the wallet service. A new method of the service is exposed by adding its endpoint below
+func NewJSONRPCHandler(svc WalletService) http.Handler {
+	return &jsonrpcHandler{svc: svc, methods: map[string]jsonrpcMethod{
+		"wallet.listAccounts":   {Authorize(svc, ScopeRead)(MakeAccountsEndpoint(svc)), decodeJSONRPCListAccountsParams},
+		"wallet.listTransfers":  {Authorize(svc, ScopeRead)(MakeTransfersEndpoint(svc)), decodeJSONRPCNoParams},
+		"wallet.getTransfer":    {Authorize(svc, ScopeRead)(MakeTransferEndpoint(svc)), decodeJSONRPCTransferParams},
+		"wallet.transfer":       {Authorize(svc, ScopeTransfer)(MakeSubmitTransferEndpoint(svc)), decodeJSONRPCTransferRequestParams},
+		"wallet.listCurrencies": {Authorize(svc, ScopeRead)(MakeCurrenciesEndpoint(svc)), decodeJSONRPCNoParams},
+	}}
+}
+
+// ServeHTTP answers a single call with its response and a batch with the responses of the calls that are not notifications, or with
+// 204 No Content when there are none
+func (h *jsonrpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := populateAPIKey(httptransport.PopulateRequestContext(r.Context(), r), r)
+	// The API key is authenticated before the request is read, the scope of every call is checked by the endpoint of its method
+	if _, err := h.svc.Authenticate(httpAPIKey(r.Header)); err != nil {
+		writeJSONRPC(w, jsonrpcFailure(nil, jsonrpcServiceError(ctx, err)))
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		writeJSONRPC(w, jsonrpcFailure(nil, jsonrpcServiceError(ctx, err)))
+		return
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		if resp, ok := h.call(ctx, body); ok {
+			writeJSONRPC(w, resp)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		writeJSONRPC(w, jsonrpcFailure(nil, &JSONRPCError{Code: JSONRPCParseError, Message: "Parse error", Data: err.Error()}))
+		return
+	}
+	if len(batch) == 0 || len(batch) > jsonrpcMaxBatchSize {
+		msg := "a batch must hold between 1 and " + strconv.Itoa(jsonrpcMaxBatchSize) + " calls, got " + strconv.Itoa(len(batch))
+		writeJSONRPC(w, jsonrpcFailure(nil, &JSONRPCError{Code: JSONRPCInvalidRequest, Message: "Invalid Request", Data: msg}))
+		return
+	}
+	responses := []jsonrpcResponse{}
+	for _, call := range batch {
+		if resp, ok := h.call(ctx, call); ok {
+			responses = append(responses, resp)
+		}
+	}
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSONRPC(w, responses)
+}
+
+// call runs a single call. It returns false for a notification, whose response is not sent
+func (h *jsonrpcHandler) call(ctx context.Context, raw json.RawMessage) (jsonrpcResponse, bool) {
+	var req jsonrpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		// A call that is valid JSON but not an object is an invalid request rather than a parse error
+		var v interface{}
+		if json.Unmarshal(raw, &v) == nil {
+			return jsonrpcFailure(nil, &JSONRPCError{Code: JSONRPCInvalidRequest, Message: "Invalid Request", Data: "a call must be a JSON object"}), true
+		}
+		return jsonrpcFailure(nil, &JSONRPCError{Code: JSONRPCParseError, Message: "Parse error", Data: err.Error()}), true
+	}
+	if !validJSONRPCID(req.ID) {
+		return jsonrpcFailure(nil, &JSONRPCError{Code: JSONRPCInvalidRequest, Message: "Invalid Request", Data: "id must be a string, a number or null"}), true
+	}
+	if req.JSONRPC != jsonrpcVersion || req.Method == "" {
+		return jsonrpcFailure(req.ID, &JSONRPCError{Code: JSONRPCInvalidRequest, Message: "Invalid Request", Data: "jsonrpc must be \"2.0\" and method is required"}), true
+	}
+	resp := h.run(ctx, req)
+	return resp, req.ID != nil
+}
+
+// run runs a call through the endpoint of its method
+func (h *jsonrpcHandler) run(ctx context.Context, req jsonrpcRequest) jsonrpcResponse {
+	m, ok := h.methods[req.Method]
+	if !ok {
+		return jsonrpcFailure(req.ID, &JSONRPCError{Code: JSONRPCMethodNotFound, Message: "Method not found", Data: "no method \"" + req.Method + "\""})
+	}
+	params := req.Params
+	if string(params) == "null" {
+		params = nil
+	}
+	if params != nil && params[0] != '{' {
+		return jsonrpcFailure(req.ID, &JSONRPCError{Code: JSONRPCInvalidParams, Message: "Invalid params", Data: "params must be an object"})
+	}
+	request, err := m.decode(params)
+	if err != nil {
+		return jsonrpcFailure(req.ID, jsonrpcServiceError(ctx, err))
+	}
+	response, err := m.endpoint(ctx, request)
+	if err == nil {
+		if f, ok := response.(failer); ok {
+			err = f.Failed()
+		}
+	}
+	if err != nil {
+		p := NewProblem(ctx, err)
+		// A failed transfer attempt is recorded, so let the client know where to find it
+		if t, ok := response.(submitTransferResponse); ok {
+			p.TransferID, p.LegacyTransferID = t.ID, t.LegacyID
+		}
+		return jsonrpcFailure(req.ID, jsonrpcProblem(p))
+	}
+	result, err := json.Marshal(response)
+	if err != nil {
+		return jsonrpcFailure(req.ID, jsonrpcServiceError(ctx, err))
+	}
+	return jsonrpcResponse{JSONRPC: jsonrpcVersion, Result: result, ID: req.ID}
+}
+
+// validJSONRPCID reports whether the ID of a call is absent, a string, a number or null
+func validJSONRPCID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	var v interface{}
+	if json.Unmarshal(id, &v) != nil {
+		return false
+	}
+	switch v.(type) {
+	case nil, string, float64:
+		return true
+	}
+	return false
+}
+
+// jsonrpcFailure builds the response of a failed call, the ID is null when the call could not be read
+func jsonrpcFailure(id json.RawMessage, err *JSONRPCError) jsonrpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return jsonrpcResponse{JSONRPC: jsonrpcVersion, Error: err, ID: id}
+}
+
+// jsonrpcServiceError builds the JSON-RPC error of an error of the service out of its problem details
+func jsonrpcServiceError(ctx context.Context, err error) *JSONRPCError {
+	return jsonrpcProblem(NewProblem(ctx, err))
+}
+
+// jsonrpcProblem builds the JSON-RPC error of problem details: its code matches the HTTP status, its message is the title and its data the problem
+func jsonrpcProblem(p Problem) *JSONRPCError {
+	code, ok := jsonrpcCodes[p.Status]
+	if !ok {
+		code = JSONRPCInternalError
+	}
+	return &JSONRPCError{Code: code, Message: p.Title, Data: p}
+}
+
+// writeJSONRPC writes a response, or the responses of a batch. JSON-RPC reports errors in the body, so the HTTP status is always 200
+func writeJSONRPC(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+// decodeJSONRPCNoParams decodes the params of the methods that take none
+func decodeJSONRPCNoParams(params json.RawMessage) (interface{}, error) {
+	if params != nil {
+		var none struct{}
+		if err := decodeJSON(params, &none); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// decodeJSONRPCListAccountsParams decodes the params of wallet.listAccounts, an optional wallet narrows the result down to its balances
+func decodeJSONRPCListAccountsParams(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Wallet string `json:"wallet"`
+	}
+	if params != nil {
+		if err := decodeJSON(params, &p); err != nil {
+			return nil, err
+		}
+	}
+	return accountsRequest{S: p.Wallet}, nil
+}
+
+// decodeJSONRPCTransferParams decodes the params of wallet.getTransfer
+func decodeJSONRPCTransferParams(params json.RawMessage) (interface{}, error) {
+	var request transferRequest
+	if params != nil {
+		if err := decodeJSON(params, &request); err != nil {
+			return nil, err
+		}
+	}
+	if request.ID == "" {
+		var v validator
+		v.fail("id", "is required")
+		return nil, v.err()
+	}
+	return request, nil
+}
+
+// decodeJSONRPCTransferRequestParams decodes the params of wallet.transfer, checked like the body of /submittransfer
+func decodeJSONRPCTransferRequestParams(params json.RawMessage) (interface{}, error) {
+	var request submitTransferRequest
+	if params != nil {
+		if err := decodeJSON(params, &request); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkSubmitTransferRequest(request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
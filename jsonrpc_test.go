@@ -0,0 +1,136 @@
+package wservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// postRPC posts a JSON-RPC request to /rpc of the HTTP transport of a wallet service
+func postRPC(t *testing.T, svc WalletService, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	NewHTTPTransport(svc).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(body)))
+	return rec
+}
+
+// rpcResult is a JSON-RPC response as read by a client
+type rpcResult struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	} `json:"error"`
+	ID json.RawMessage `json:"id"`
+}
+
+func TestJSONRPC(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		rec := postRPC(t, svc, `{"jsonrpc":"2.0","method":"wallet.transfer","params":{"from":"bob123","to":"alice456","amount":"2"},"id":1}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var resp rpcResult
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, "2.0", resp.JSONRPC)
+		assert.Equal(t, "1", string(resp.ID))
+		assert.Nil(t, resp.Error)
+		var submitted submitTransferResponse
+		assert.Nil(t, json.Unmarshal(resp.Result, &submitted))
+		assert.Equal(t, "success", submitted.V)
+		assert.Equal(t, StatusCompleted, submitted.Status)
+
+		rec = postRPC(t, svc, `{"jsonrpc":"2.0","method":"wallet.listAccounts","id":"accounts"}`)
+		resp = rpcResult{}
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		var accounts accountsResponse
+		assert.Nil(t, json.Unmarshal(resp.Result, &accounts))
+		want, err := svc.GetTable(AccountsTable)
+		assert.Nil(t, err)
+		assert.Equal(t, want, accounts.V)
+
+		rec = postRPC(t, svc, `{"jsonrpc":"2.0","method":"wallet.getTransfer","params":{"id":"`+submitted.ID+`"},"id":2}`)
+		resp = rpcResult{}
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Contains(t, string(resp.Result), `"from":"bob123"`)
+	})
+}
+
+func TestJSONRPCBatch(t *testing.T) {
+	svc := newMemoryService(t)
+	// The calls of a batch run in order, notifications get no response
+	rec := postRPC(t, svc, `[
+		{"jsonrpc":"2.0","method":"wallet.transfer","params":{"from":"bob123","to":"alice456","amount":"1"}},
+		{"jsonrpc":"2.0","method":"wallet.listTransfers","id":1},
+		{"jsonrpc":"2.0","method":"wallet.transfer","params":{"from":"bob123","to":"alice456","amount":"100000"},"id":2},
+		{"jsonrpc":"2.0","method":"wallet.nope","id":3},
+		{"jsonrpc":"1.0","method":"wallet.listTransfers","id":4},
+		1
+	]`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var responses []rpcResult
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &responses))
+	if !assert.Len(t, responses, 5) {
+		return
+	}
+	assert.Nil(t, responses[0].Error)
+	var transfers transfersResponse
+	assert.Nil(t, json.Unmarshal(responses[0].Result, &transfers))
+	assert.Contains(t, strings.Join(transfers.V, "\n"), "alice456")
+
+	// The errors of the service carry their problem details, with the failed transfer attempt
+	failed := responses[1].Error
+	assert.Equal(t, "2", string(responses[1].ID))
+	assert.Equal(t, JSONRPCUnprocessable, failed.Code)
+	assert.Equal(t, "Insufficient funds", failed.Message)
+	var p Problem
+	assert.Nil(t, json.Unmarshal(failed.Data, &p))
+	assert.Equal(t, "insufficient_funds", p.Code)
+	assert.Equal(t, "/rpc", p.Instance)
+	assert.NotEmpty(t, p.TransferID)
+
+	assert.Equal(t, JSONRPCMethodNotFound, responses[2].Error.Code)
+	assert.Equal(t, JSONRPCInvalidRequest, responses[3].Error.Code)
+	assert.Equal(t, "4", string(responses[3].ID))
+	assert.Equal(t, JSONRPCInvalidRequest, responses[4].Error.Code)
+	assert.Equal(t, "null", string(responses[4].ID))
+
+	// A batch of notifications gets no response at all
+	rec = postRPC(t, svc, `[{"jsonrpc":"2.0","method":"wallet.listTransfers"}]`)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, rec.Body.String())
+}
+
+func TestJSONRPCErrors(t *testing.T) {
+	svc := newMemoryService(t)
+	for _, c := range []struct {
+		body string
+		code int
+	}{
+		{`{"jsonrpc":"2.0","method":"wallet.listTransfers","id":1`, JSONRPCParseError},
+		{`[]`, JSONRPCInvalidRequest},
+		{`{"jsonrpc":"2.0","id":1}`, JSONRPCInvalidRequest},
+		{`{"jsonrpc":"2.0","method":"wallet.listTransfers","id":{}}`, JSONRPCInvalidRequest},
+		{`{"jsonrpc":"2.0","method":"wallet.listAccounts","params":["w1"],"id":1}`, JSONRPCInvalidParams},
+		{`{"jsonrpc":"2.0","method":"wallet.listAccounts","params":{"wallets":"w1"},"id":1}`, JSONRPCInvalidParams},
+		{`{"jsonrpc":"2.0","method":"wallet.getTransfer","params":{},"id":1}`, JSONRPCInvalidParams},
+		{`{"jsonrpc":"2.0","method":"wallet.getTransfer","params":{"id":"01D6MK0GTBZ4W3K9XH8S2JQ5VN"},"id":1}`, JSONRPCNotFound},
+		{`{"jsonrpc":"2.0","method":"wallet.transfer","params":{"from":"bob123","from_wallet":"w1","to_wallet":"w2","amount":"1"},"id":1}`, JSONRPCInvalidParams},
+	} {
+		rec := postRPC(t, svc, c.body)
+		assert.Equal(t, http.StatusOK, rec.Code, c.body)
+		var resp rpcResult
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp), c.body)
+		if assert.NotNil(t, resp.Error, c.body) {
+			assert.Equal(t, c.code, resp.Error.Code, c.body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	NewHTTPTransport(svc).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rpc", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "POST", rec.Header().Get("Allow"))
+}
@@ -1,13 +1,39 @@
 package wservice
 
 import (
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 )
 
 // The logging middleware amends the wallet service with a logger
 
+// levelled returns the logger at the error level for a call that failed and at the info level otherwise, so that log_level can filter the calls
+func levelled(logger log.Logger, err error) log.Logger {
+	if err != nil {
+		return level.Error(logger)
+	}
+	return level.Info(logger)
+}
+
+// LevelFilter exported to be accessible from outside the package (from main)
+// LevelFilter returns a logger that only logs the entries at the given level (debug, info, warn or error) or above, entries without a level are always logged
+func LevelFilter(logger log.Logger, logLevel string) log.Logger {
+	switch logLevel {
+	case "debug":
+		return level.NewFilter(logger, level.AllowDebug())
+	case "warn":
+		return level.NewFilter(logger, level.AllowWarn())
+	case "error":
+		return level.NewFilter(logger, level.AllowError())
+	default:
+		return level.NewFilter(logger, level.AllowInfo())
+	}
+}
+
 // loggingMiddleware is the type of the wrapper around the core service and any other functionality layers
 type loggingMiddleware struct {
 	logger log.Logger
@@ -32,7 +58,7 @@ func (mw loggingMiddleware) GetTable(s string) (output []string, err error) {
 			}
 			return "no table"
 		}(output)
-		_ = mw.logger.Log(
+		_ = levelled(mw.logger, err).Log(
 			"method", "getTables",
 			"input", s,
 			//"output", strings.Join(output, ","),
@@ -50,7 +76,7 @@ func (mw loggingMiddleware) GetTable(s string) (output []string, err error) {
 func (mw loggingMiddleware) DoTransfer(s string, t string, v string) (output string, err error) {
 	// Log everything that the function sees in the provided format
 	defer func(begin time.Time) {
-		_ = mw.logger.Log(
+		_ = levelled(mw.logger, err).Log(
 			"method", "doTransfer",
 			"input", "From "+s+" to "+t+" amount "+v,
 			"output", output,
@@ -62,3 +88,461 @@ func (mw loggingMiddleware) DoTransfer(s string, t string, v string) (output str
 	output, err = mw.next.DoTransfer(s, t, v)
 	return
 }
+
+// GetCurrencies function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) GetCurrencies() (output []string, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "getCurrencies",
+			"output", len(output),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetCurrencies()
+	return
+}
+
+// SetCurrencyEnabled function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) SetCurrencyEnabled(c string, e bool) (output string, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "setCurrencyEnabled",
+			"input", c+" enabled "+strconv.FormatBool(e),
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.SetCurrencyEnabled(c, e)
+	return
+}
+
+// GetWallet function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) GetWallet(w string) (output []string, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "getWallet",
+			"input", w,
+			"output", len(output),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetWallet(w)
+	return
+}
+
+// SetInterestRate function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) SetInterestRate(a string, r string, d string, e string) (output string, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "setInterestRate",
+			"input", "Account "+a+" rate "+r+" "+d+" paid by "+e,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.SetInterestRate(a, r, d, e)
+	return
+}
+
+// AccrueInterest function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) AccrueInterest(d string) (output string, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "accrueInterest",
+			"input", d,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.AccrueInterest(d)
+	return
+}
+
+// PostInterest function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) PostInterest(m string) (output string, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "postInterest",
+			"input", m,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.PostInterest(m)
+	return
+}
+
+// PostDueInterest function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) PostDueInterest() (output string, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "postDueInterest",
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.PostDueInterest()
+	return
+}
+
+// SubmitTransfer function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) SubmitTransfer(s string, t string, v string) (output Transfer, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "submitTransfer",
+			"input", "From "+s+" to "+t+" amount "+v,
+			"output", output.ID+" "+output.Status,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.SubmitTransfer(s, t, v)
+	return
+}
+
+// DoWalletTransfer function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) DoWalletTransfer(s string, t string, c string, v string) (output Transfer, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "doWalletTransfer",
+			"input", "From wallet "+s+" to wallet "+t+" amount "+v+" "+c,
+			"output", output.ID+" "+output.Status,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.DoWalletTransfer(s, t, c, v)
+	return
+}
+
+// GetTransfer function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) GetTransfer(id string) (output Transfer, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "getTransfer",
+			"input", id,
+			"output", output.Status,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetTransfer(id)
+	return
+}
+
+// ReverseTransfer function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) ReverseTransfer(id string) (output Transfer, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "reverseTransfer",
+			"input", id,
+			"output", output.Status,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.ReverseTransfer(id)
+	return
+}
+
+// CancelTransfer function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) CancelTransfer(id string) (output Transfer, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "cancelTransfer",
+			"input", id,
+			"output", output.Status,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.CancelTransfer(id)
+	return
+}
+
+// CreateWebhook function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) CreateWebhook(u string, e []string, s string) (output Webhook, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "createWebhook",
+			"input", "URL "+u+" events "+strings.Join(e, ","),
+			"output", output.ID,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.CreateWebhook(u, e, s)
+	return
+}
+
+// GetWebhooks function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) GetWebhooks() (output []Webhook, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "getWebhooks",
+			"output", len(output),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetWebhooks()
+	return
+}
+
+// GetWebhook function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) GetWebhook(id string) (output Webhook, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "getWebhook",
+			"input", id,
+			"output", output.URL,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetWebhook(id)
+	return
+}
+
+// SetWebhookEnabled function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) SetWebhookEnabled(id string, e bool) (output Webhook, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "setWebhookEnabled",
+			"input", id+" enabled "+strconv.FormatBool(e),
+			"output", output.Enabled,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.SetWebhookEnabled(id, e)
+	return
+}
+
+// DeleteWebhook function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) DeleteWebhook(id string) (output string, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "deleteWebhook",
+			"input", id,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.DeleteWebhook(id)
+	return
+}
+
+// GetWebhookDeliveries function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) GetWebhookDeliveries(id string) (output []WebhookDelivery, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "getWebhookDeliveries",
+			"input", id,
+			"output", len(output),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetWebhookDeliveries(id)
+	return
+}
+
+// ReplayWebhookDelivery function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) ReplayWebhookDelivery(id string, d string) (output WebhookDelivery, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "replayWebhookDelivery",
+			"input", "Webhook "+id+" delivery "+d,
+			"output", output.Status,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.ReplayWebhookDelivery(id, d)
+	return
+}
+
+// GetAccounts function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) GetAccounts(w string) (output []Account, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "getAccounts",
+			"input", w,
+			"output", len(output),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetAccounts(w)
+	return
+}
+
+// GetAccount function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) GetAccount(id string) (output Account, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "getAccount",
+			"input", id,
+			"output", output.Balance+" "+output.Currency,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetAccount(id)
+	return
+}
+
+// GetTransfers function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) GetTransfers() (output []Transfer, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "getTransfers",
+			"input", "",
+			"output", len(output),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetTransfers()
+	return
+}
+
+// CreateAPIKey function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) CreateAPIKey(name string, scopes []string) (output APIKey, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "createAPIKey",
+			"input", "name "+name+" scopes "+strings.Join(scopes, ","),
+			"output", output.ID,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.CreateAPIKey(name, scopes)
+	return
+}
+
+// GetAPIKeys function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) GetAPIKeys() (output []APIKey, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "getAPIKeys",
+			"input", "",
+			"output", len(output),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.GetAPIKeys()
+	return
+}
+
+// RotateAPIKey function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) RotateAPIKey(id string, grace time.Duration) (output APIKey, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "rotateAPIKey",
+			"input", id+" grace "+grace.String(),
+			"output", output.ID,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.RotateAPIKey(id, grace)
+	return
+}
+
+// RevokeAPIKey function is implemented for the logging layer as the request traverses through the logging layer down to the next layer
+func (mw loggingMiddleware) RevokeAPIKey(id string) (output APIKey, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		_ = levelled(mw.logger, err).Log(
+			"method", "revokeAPIKey",
+			"input", id,
+			"output", output.ID,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.RevokeAPIKey(id)
+	return
+}
+
+// Authenticate function is implemented for the logging layer as the request traverses through the logging layer down to the next layer. Only the ID of
+// the presented key is logged, never the key itself
+func (mw loggingMiddleware) Authenticate(key string) (output APIKey, err error) {
+	// Log everything that the function sees in the provided format
+	defer func(begin time.Time) {
+		id, _ := apiKeyID(key)
+		_ = levelled(mw.logger, err).Log(
+			"method", "authenticate",
+			"input", id,
+			"output", strings.Join(output.Scopes, ","),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	// The function calls the next layer down
+	output, err = mw.next.Authenticate(key)
+	return
+}
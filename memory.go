@@ -0,0 +1,819 @@
+package wservice
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memStore keeps the ledger in memory, so that the service and its HTTP layer can be tested and demoed without a database. It starts with the currency
+// registry and the demo accounts of the seed migration and loses everything on restart. Its transactions hold a single lock, so they are serializable
+// like the ones of Postgres, and a transaction that fails is rolled back by undoing its writes in reverse order
+
+// memAccount is an account of the in-memory ledger
+type memAccount struct {
+	walletID       string
+	currency       string
+	balance        *big.Rat
+	initialBalance *big.Rat
+}
+
+// memAccrual is the interest accrued by an account on a day, and the transfer that posted it if it was posted
+type memAccrual struct {
+	InterestAccrual
+	posted         bool
+	postedTransfer int64
+}
+
+// memStore is the in-memory Store
+type memStore struct {
+	mu         sync.RWMutex
+	closed     bool
+	accounts   map[string]*memAccount
+	currencies map[string]Currency
+	// transfers and failed are ordered by legacy ID, which both take from lastID
+	transfers []Transfer
+	failed    []Transfer
+	lastID    int64
+	rates     map[string]InterestRate
+	accruals  map[string]map[string]*memAccrual
+	// events is the outbox, ordered by sequence number
+	events []Event
+	// deliveries are ordered by ID, which they take from lastDelivery
+	webhooks     map[string]Webhook
+	deliveries   []WebhookDelivery
+	lastDelivery int64
+	apiKeys      map[string]APIKey
+	// notifier announces the committed transfers to the listeners of the process
+	notifier *localNotifier
+}
+
+// newMemStore creates an in-memory ledger with the currencies and accounts of the seed migration
+func newMemStore() (*memStore, error) {
+	m := &memStore{
+		accounts:   map[string]*memAccount{},
+		currencies: map[string]Currency{},
+		rates:      map[string]InterestRate{},
+		accruals:   map[string]map[string]*memAccrual{},
+		webhooks:   map[string]Webhook{},
+		apiKeys:    map[string]APIKey{},
+		notifier:   &localNotifier{},
+	}
+	currencies, accounts, err := readSeed()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range currencies {
+		m.currencies[c.Code] = c
+	}
+	for _, a := range accounts {
+		balance, err := parseAmount(a.Balance)
+		if err != nil {
+			return nil, err
+		}
+		initialBalance, err := parseAmount(a.InitialBalance)
+		if err != nil {
+			return nil, err
+		}
+		m.accounts[a.ID] = &memAccount{walletID: a.WalletID, currency: a.Currency, balance: balance, initialBalance: initialBalance}
+	}
+	return m, nil
+}
+
+// parseAmount reads a decimal amount exactly
+func parseAmount(amount string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(amount))
+	if !ok {
+		var ErrAmount = newError(ErrInvalidAmount, "The amount "+amount+" is not a decimal number")
+		return nil, ErrAmount
+	}
+	return r, nil
+}
+
+// Update is a memStore type method that runs fn with the ledger locked, and undoes its writes if it fails
+func (m *memStore) Update(fn func(LedgerTx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrShuttingDown
+	}
+	tx := &memTx{m: m}
+	if err := fn(tx); err != nil {
+		for i := len(tx.undo) - 1; i >= 0; i-- {
+			tx.undo[i]()
+		}
+		return err
+	}
+	// The transfers are announced in the order they are committed
+	m.notifier.announce(tx.notes)
+	return nil
+}
+
+// View is a memStore type method that runs fn with the ledger locked for reading, writing is refused
+func (m *memStore) View(fn func(LedgerTx) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return ErrShuttingDown
+	}
+	return fn(&memTx{m: m, readOnly: true})
+}
+
+// Close is a memStore type method that refuses every transaction from now on
+func (m *memStore) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+// memTx is a transaction of the in-memory store, undo reverts its writes
+type memTx struct {
+	m        *memStore
+	readOnly bool
+	undo     []func()
+	// notes are the transfers to announce once the transaction is committed
+	notes []string
+}
+
+// errReadOnly is returned when a read only transaction writes
+var errReadOnly = errors.New("err: cannot write in a read only transaction")
+
+// write checks that the transaction may write and registers how to undo the write
+func (t *memTx) write(undo func()) error {
+	if t.readOnly {
+		return errReadOnly
+	}
+	t.undo = append(t.undo, undo)
+	return nil
+}
+
+// account returns an account as the ledger sees it
+func (a *memAccount) account(id string) Account {
+	return Account{ID: id, WalletID: a.walletID, Currency: a.currency, Balance: a.balance.FloatString(storeAmountScale), InitialBalance: a.initialBalance.FloatString(storeAmountScale)}
+}
+
+// Accounts returns every account ordered by ID
+func (t *memTx) Accounts() ([]Account, error) {
+	var accounts []Account
+	for id, a := range t.m.accounts {
+		accounts = append(accounts, a.account(id))
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	return accounts, nil
+}
+
+// WalletAccounts returns the accounts of a wallet ordered by currency
+func (t *memTx) WalletAccounts(walletID string) ([]Account, error) {
+	var accounts []Account
+	for id, a := range t.m.accounts {
+		if a.walletID == walletID {
+			accounts = append(accounts, a.account(id))
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Currency < accounts[j].Currency })
+	return accounts, nil
+}
+
+// Account returns a single account
+func (t *memTx) Account(id string) (Account, error) {
+	a, ok := t.m.accounts[id]
+	if !ok {
+		return Account{}, errNotFound
+	}
+	return a.account(id), nil
+}
+
+// Debit subtracts an amount from the balance of an account, refusing a negative balance like the check constraint of the Postgres ledger
+func (t *memTx) Debit(accountID string, amount string) error {
+	value, err := parseAmount(amount)
+	if err != nil {
+		return err
+	}
+	return t.addBalance(accountID, value.Neg(value))
+}
+
+// Credit adds an amount to the balance of an account
+func (t *memTx) Credit(accountID string, amount string) error {
+	value, err := parseAmount(amount)
+	if err != nil {
+		return err
+	}
+	return t.addBalance(accountID, value)
+}
+
+// addBalance adds a (possibly negative) value to the balance of an account
+func (t *memTx) addBalance(accountID string, value *big.Rat) error {
+	a, ok := t.m.accounts[accountID]
+	if !ok {
+		return errNotFound
+	}
+	balance := new(big.Rat).Add(a.balance, value)
+	if balance.Sign() < 0 {
+		return errNegativeBalance
+	}
+	old := a.balance
+	if err := t.write(func() { a.balance = old }); err != nil {
+		return err
+	}
+	a.balance = balance
+	return nil
+}
+
+// Currencies returns the whole registry ordered by code
+func (t *memTx) Currencies() ([]Currency, error) {
+	var currencies []Currency
+	for _, c := range t.m.currencies {
+		currencies = append(currencies, c)
+	}
+	sort.Slice(currencies, func(i, j int) bool { return currencies[i].Code < currencies[j].Code })
+	return currencies, nil
+}
+
+// Currency returns a single currency of the registry
+func (t *memTx) Currency(code string) (Currency, error) {
+	c, ok := t.m.currencies[code]
+	if !ok {
+		return Currency{}, errNotFound
+	}
+	return c, nil
+}
+
+// SetCurrencyEnabled enables or disables a currency of the registry
+func (t *memTx) SetCurrencyEnabled(code string, enabled bool) error {
+	c, ok := t.m.currencies[code]
+	if !ok {
+		return errNotFound
+	}
+	if err := t.write(func() { t.m.currencies[code] = c }); err != nil {
+		return err
+	}
+	updated := c
+	updated.Enabled = enabled
+	t.m.currencies[code] = updated
+	return nil
+}
+
+// Transfers returns every recorded transfer, whatever its status, ordered by legacy ID, without their history
+func (t *memTx) Transfers() ([]Transfer, error) {
+	var transfers []Transfer
+	for _, tr := range t.m.transfers {
+		tr.History = nil
+		transfers = append(transfers, tr)
+	}
+	return transfers, nil
+}
+
+// findTransfer returns the index of the transfer with the given reference
+func findTransfer(transfers []Transfer, ref transferRef) int {
+	for i, tr := range transfers {
+		if (ref.uid != "" && tr.ID == ref.uid) || (ref.uid == "" && tr.LegacyID == ref.legacyID) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Transfer returns a committed transfer with its history
+func (t *memTx) Transfer(ref transferRef) (Transfer, error) {
+	i := findTransfer(t.m.transfers, ref)
+	if i == -1 {
+		return Transfer{}, errNotFound
+	}
+	tr := t.m.transfers[i]
+	tr.History = append([]TransferStatusChange(nil), tr.History...)
+	return tr, nil
+}
+
+// FailedTransfer returns a failed transfer attempt
+func (t *memTx) FailedTransfer(ref transferRef) (Transfer, error) {
+	i := findTransfer(t.m.failed, ref)
+	if i == -1 {
+		return Transfer{}, errNotFound
+	}
+	return t.m.failed[i], nil
+}
+
+// nextID numbers a new transfer or failed transfer attempt
+func (t *memTx) nextID() (int64, error) {
+	lastID := t.m.lastID
+	if err := t.write(func() { t.m.lastID = lastID }); err != nil {
+		return 0, err
+	}
+	t.m.lastID++
+	return t.m.lastID, nil
+}
+
+// stamp returns the time of a new row, the current time unless it has one
+func stamp(at time.Time) time.Time {
+	if at.IsZero() {
+		return time.Now()
+	}
+	return at
+}
+
+// InsertTransfer records a new transfer and its first status change
+func (t *memTx) InsertTransfer(tr Transfer) (Transfer, error) {
+	amount, err := parseAmount(tr.Amount)
+	if err != nil {
+		return Transfer{}, err
+	}
+	id, err := t.nextID()
+	if err != nil {
+		return Transfer{}, err
+	}
+	tr.LegacyID, tr.Time = id, stamp(tr.Time)
+	tr.History = []TransferStatusChange{{Status: tr.Status, ChangedAt: time.Now()}}
+	n := len(t.m.transfers)
+	if err := t.write(func() { t.m.transfers = t.m.transfers[:n] }); err != nil {
+		return Transfer{}, err
+	}
+	// The amount is kept with the scale of the Postgres ledger, while the transfer is returned as submitted
+	stored := tr
+	stored.Amount = amount.FloatString(storeAmountScale)
+	t.m.transfers = append(t.m.transfers, stored)
+	tr.History = append([]TransferStatusChange(nil), tr.History...)
+	return tr, nil
+}
+
+// InsertFailedTransfer records a failed transfer attempt
+func (t *memTx) InsertFailedTransfer(tr Transfer) (Transfer, error) {
+	id, err := t.nextID()
+	if err != nil {
+		return Transfer{}, err
+	}
+	tr.LegacyID, tr.Time = id, stamp(tr.Time)
+	n := len(t.m.failed)
+	if err := t.write(func() { t.m.failed = t.m.failed[:n] }); err != nil {
+		return Transfer{}, err
+	}
+	t.m.failed = append(t.m.failed, tr)
+	return tr, nil
+}
+
+// SetTransferStatus moves a transfer to a new status and records the change in its history
+func (t *memTx) SetTransferStatus(legacyID int64, status string, reason string) error {
+	i := findTransfer(t.m.transfers, transferRef{legacyID: legacyID})
+	if i == -1 {
+		return errNotFound
+	}
+	old := t.m.transfers[i]
+	if err := t.write(func() { t.m.transfers[i] = old }); err != nil {
+		return err
+	}
+	tr := old
+	tr.Status = status
+	tr.History = append(append([]TransferStatusChange(nil), old.History...), TransferStatusChange{Status: status, ChangedAt: time.Now(), Reason: reason})
+	t.m.transfers[i] = tr
+	return nil
+}
+
+// SetInterestRate creates or changes the interest configuration of an account, a changed configuration keeps its start date
+func (t *memTx) SetInterestRate(r InterestRate) error {
+	old, exists := t.m.rates[r.AccountID]
+	if err := t.write(func() {
+		if exists {
+			t.m.rates[r.AccountID] = old
+		} else {
+			delete(t.m.rates, r.AccountID)
+		}
+	}); err != nil {
+		return err
+	}
+	if exists {
+		r.StartDate = old.StartDate
+	}
+	t.m.rates[r.AccountID] = r
+	return nil
+}
+
+// InterestRate returns the interest configuration of an account
+func (t *memTx) InterestRate(accountID string) (InterestRate, error) {
+	r, ok := t.m.rates[accountID]
+	if !ok {
+		return InterestRate{}, errNotFound
+	}
+	return r, nil
+}
+
+// InterestRates returns the interest configuration of every account ordered by account
+func (t *memTx) InterestRates() ([]InterestRate, error) {
+	var rates []InterestRate
+	for _, r := range t.m.rates {
+		rates = append(rates, r)
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].AccountID < rates[j].AccountID })
+	return rates, nil
+}
+
+// LastAccrualDate returns the last day an account accrued interest for
+func (t *memTx) LastAccrualDate(accountID string) (time.Time, error) {
+	var last time.Time
+	for _, a := range t.m.accruals[accountID] {
+		if a.Date.After(last) {
+			last = a.Date
+		}
+	}
+	return last, nil
+}
+
+// BalanceAt rebuilds the balance of an account at a time from its current balance by reverting the transfers committed since
+func (t *memTx) BalanceAt(accountID string, at time.Time) (string, error) {
+	a, ok := t.m.accounts[accountID]
+	if !ok {
+		return "", errNotFound
+	}
+	balance := new(big.Rat).Set(a.balance)
+	for _, tr := range t.m.transfers {
+		if tr.Time.Before(at) || (tr.From != accountID && tr.To != accountID) || !movedFunds(tr.Status) {
+			continue
+		}
+		amount, err := parseAmount(tr.Amount)
+		if err != nil {
+			return "", err
+		}
+		if tr.To == accountID {
+			balance.Sub(balance, amount)
+		} else {
+			balance.Add(balance, amount)
+		}
+	}
+	return balance.FloatString(storeAmountScale), nil
+}
+
+// InsertAccrual records the interest accrued by an account on a day, a day that was already accrued is left as it is
+func (t *memTx) InsertAccrual(a InterestAccrual) error {
+	day := a.Date.Format(dateLayout)
+	if _, ok := t.m.accruals[a.AccountID][day]; ok {
+		return nil
+	}
+	if err := t.write(func() { delete(t.m.accruals[a.AccountID], day) }); err != nil {
+		return err
+	}
+	if t.m.accruals[a.AccountID] == nil {
+		t.m.accruals[a.AccountID] = map[string]*memAccrual{}
+	}
+	t.m.accruals[a.AccountID][day] = &memAccrual{InterestAccrual: a}
+	return nil
+}
+
+// unposted returns the accruals of an account between from and until that were not posted yet
+func (t *memTx) unposted(accountID string, from time.Time, until time.Time) []*memAccrual {
+	var accruals []*memAccrual
+	for _, a := range t.m.accruals[accountID] {
+		if !a.posted && !a.Date.Before(from) && a.Date.Before(until) {
+			accruals = append(accruals, a)
+		}
+	}
+	return accruals
+}
+
+// UnpostedAccounts returns the accounts with interest accrued between from and until that was not posted yet, ordered by account
+func (t *memTx) UnpostedAccounts(from time.Time, until time.Time) ([]string, error) {
+	var accounts []string
+	for accountID := range t.m.accruals {
+		if len(t.unposted(accountID, from, until)) > 0 {
+			accounts = append(accounts, accountID)
+		}
+	}
+	sort.Strings(accounts)
+	return accounts, nil
+}
+
+// UnpostedInterest sums the interest accrued by an account between from and until that was not posted yet
+func (t *memTx) UnpostedInterest(accountID string, from time.Time, until time.Time) (string, error) {
+	accruals := t.unposted(accountID, from, until)
+	if len(accruals) == 0 {
+		return "", errNotFound
+	}
+	total := new(big.Rat)
+	for _, a := range accruals {
+		amount, err := parseAmount(a.Amount)
+		if err != nil {
+			return "", err
+		}
+		total.Add(total, amount)
+	}
+	return total.FloatString(accrualScale), nil
+}
+
+// FirstUnpostedAccrual returns the first day before until whose interest was accrued but not posted yet
+func (t *memTx) FirstUnpostedAccrual(until time.Time) (time.Time, error) {
+	var first time.Time
+	for accountID := range t.m.accruals {
+		for _, a := range t.unposted(accountID, time.Time{}, until) {
+			if first.IsZero() || a.Date.Before(first) {
+				first = a.Date
+			}
+		}
+	}
+	return first, nil
+}
+
+// MarkInterestPosted marks the interest accrued by an account between from and until as posted by a transfer
+func (t *memTx) MarkInterestPosted(accountID string, from time.Time, until time.Time, transferID int64) error {
+	for _, a := range t.unposted(accountID, from, until) {
+		a := a
+		if err := t.write(func() { a.posted, a.postedTransfer = false, 0 }); err != nil {
+			return err
+		}
+		a.posted, a.postedTransfer = true, transferID
+	}
+	return nil
+}
+
+// InsertEvent writes a pending event to the outbox, numbered after the last one
+func (t *memTx) InsertEvent(e Event) (Event, error) {
+	n := len(t.m.events)
+	if err := t.write(func() { t.m.events = t.m.events[:n] }); err != nil {
+		return Event{}, err
+	}
+	e.Seq, e.CreatedAt, e.Status = 1, stamp(e.CreatedAt), EventPending
+	if n > 0 {
+		e.Seq = t.m.events[n-1].Seq + 1
+	}
+	e.Payload = append(json.RawMessage(nil), e.Payload...)
+	t.m.events = append(t.m.events, e)
+	return e, nil
+}
+
+// PendingEvents returns the oldest pending events of the outbox
+func (t *memTx) PendingEvents(limit int) ([]Event, error) {
+	var events []Event
+	for _, e := range t.m.events {
+		if len(events) == limit {
+			break
+		}
+		if e.Status == EventPending {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// Events returns the events of the outbox that follow a sequence number
+func (t *memTx) Events(after int64, limit int) ([]Event, error) {
+	var events []Event
+	for _, e := range t.m.events {
+		if len(events) == limit {
+			break
+		}
+		if e.Seq > after {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// LastEventSeq returns the sequence number of the last event of the outbox
+func (t *memTx) LastEventSeq() (int64, error) {
+	if n := len(t.m.events); n > 0 {
+		return t.m.events[n-1].Seq, nil
+	}
+	return 0, nil
+}
+
+// Notify announces a transfer to the listeners of the process once the transaction is committed
+func (t *memTx) Notify(channel string, transferID string) error {
+	if err := t.write(func() {}); err != nil {
+		return err
+	}
+	t.notes = append(t.notes, transferID)
+	return nil
+}
+
+// updateEvent changes an event of the outbox
+func (t *memTx) updateEvent(seq int64, change func(e *Event)) error {
+	for i := range t.m.events {
+		if t.m.events[i].Seq != seq {
+			continue
+		}
+		old := t.m.events[i]
+		if err := t.write(func() { t.m.events[i] = old }); err != nil {
+			return err
+		}
+		change(&t.m.events[i])
+		return nil
+	}
+	return nil
+}
+
+// MarkEventPublished marks an event of the outbox as published
+func (t *memTx) MarkEventPublished(seq int64) error {
+	return t.updateEvent(seq, func(e *Event) {
+		e.Status, e.Attempts, e.PublishedAt = EventPublished, e.Attempts+1, time.Now()
+	})
+}
+
+// MarkEventFailed records a failed attempt to publish an event of the outbox
+func (t *memTx) MarkEventFailed(seq int64, reason string) error {
+	return t.updateEvent(seq, func(e *Event) {
+		e.Attempts, e.LastError = e.Attempts+1, reason
+	})
+}
+
+// copyWebhook returns a webhook that shares nothing with the stored one
+func copyWebhook(w Webhook) Webhook {
+	w.Events = append([]string(nil), w.Events...)
+	return w
+}
+
+// InsertWebhook records a new webhook
+func (t *memTx) InsertWebhook(w Webhook) (Webhook, error) {
+	if err := t.write(func() { delete(t.m.webhooks, w.ID) }); err != nil {
+		return Webhook{}, err
+	}
+	w.CreatedAt = stamp(w.CreatedAt)
+	t.m.webhooks[w.ID] = copyWebhook(w)
+	return w, nil
+}
+
+// Webhooks returns every webhook ordered by ID
+func (t *memTx) Webhooks() ([]Webhook, error) {
+	var webhooks []Webhook
+	for _, w := range t.m.webhooks {
+		webhooks = append(webhooks, copyWebhook(w))
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+	return webhooks, nil
+}
+
+// Webhook returns a single webhook
+func (t *memTx) Webhook(id string) (Webhook, error) {
+	w, ok := t.m.webhooks[id]
+	if !ok {
+		return Webhook{}, errNotFound
+	}
+	return copyWebhook(w), nil
+}
+
+// UpdateWebhook changes whether a webhook is enabled and its count of consecutive failures
+func (t *memTx) UpdateWebhook(w Webhook) error {
+	old, ok := t.m.webhooks[w.ID]
+	if !ok {
+		return errNotFound
+	}
+	if err := t.write(func() { t.m.webhooks[w.ID] = old }); err != nil {
+		return err
+	}
+	updated := old
+	updated.Enabled, updated.Failures = w.Enabled, w.Failures
+	t.m.webhooks[w.ID] = updated
+	return nil
+}
+
+// DeleteWebhook deletes a webhook with its deliveries
+func (t *memTx) DeleteWebhook(id string) error {
+	old, ok := t.m.webhooks[id]
+	if !ok {
+		return errNotFound
+	}
+	deliveries := t.m.deliveries
+	if err := t.write(func() { t.m.webhooks[id], t.m.deliveries = old, deliveries }); err != nil {
+		return err
+	}
+	delete(t.m.webhooks, id)
+	var kept []WebhookDelivery
+	for _, d := range deliveries {
+		if d.WebhookID != id {
+			kept = append(kept, d)
+		}
+	}
+	t.m.deliveries = kept
+	return nil
+}
+
+// InsertDelivery queues a pending delivery of an event to a webhook, an event already queued for the webhook is left as it is
+func (t *memTx) InsertDelivery(d WebhookDelivery) error {
+	for _, queued := range t.m.deliveries {
+		if queued.WebhookID == d.WebhookID && queued.EventSeq == d.EventSeq {
+			return nil
+		}
+	}
+	n, lastDelivery := len(t.m.deliveries), t.m.lastDelivery
+	if err := t.write(func() { t.m.deliveries, t.m.lastDelivery = t.m.deliveries[:n], lastDelivery }); err != nil {
+		return err
+	}
+	t.m.lastDelivery++
+	d.ID, d.Status, d.CreatedAt, d.NextAttempt = t.m.lastDelivery, DeliveryPending, time.Now(), stamp(d.NextAttempt)
+	d.Payload = append(json.RawMessage(nil), d.Payload...)
+	t.m.deliveries = append(t.m.deliveries, d)
+	return nil
+}
+
+// DueDeliveries returns the pending deliveries of enabled webhooks that are due, the most overdue first
+func (t *memTx) DueDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	var due []WebhookDelivery
+	for _, d := range t.m.deliveries {
+		if d.Status == DeliveryPending && !d.NextAttempt.After(now) && t.m.webhooks[d.WebhookID].Enabled {
+			due = append(due, d)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].NextAttempt.Before(due[j].NextAttempt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+// Deliveries returns the most recent deliveries of a webhook
+func (t *memTx) Deliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	for i := len(t.m.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if t.m.deliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, t.m.deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+// Delivery returns a single delivery
+func (t *memTx) Delivery(id int64) (WebhookDelivery, error) {
+	for _, d := range t.m.deliveries {
+		if d.ID == id {
+			return d, nil
+		}
+	}
+	return WebhookDelivery{}, errNotFound
+}
+
+// UpdateDelivery records the status, attempts, outcome of the last attempt and next attempt of a delivery
+func (t *memTx) UpdateDelivery(d WebhookDelivery) error {
+	for i := range t.m.deliveries {
+		if t.m.deliveries[i].ID != d.ID {
+			continue
+		}
+		old := t.m.deliveries[i]
+		if err := t.write(func() { t.m.deliveries[i] = old }); err != nil {
+			return err
+		}
+		updated := old
+		updated.Status, updated.Attempts, updated.ResponseCode, updated.LastError = d.Status, d.Attempts, d.ResponseCode, d.LastError
+		updated.NextAttempt, updated.DeliveredAt = d.NextAttempt, d.DeliveredAt
+		t.m.deliveries[i] = updated
+		return nil
+	}
+	return errNotFound
+}
+
+// copyAPIKey returns an API key that shares nothing with the stored one
+func copyAPIKey(k APIKey) APIKey {
+	k.Scopes = append([]string(nil), k.Scopes...)
+	if k.ExpiresAt != nil {
+		at := *k.ExpiresAt
+		k.ExpiresAt = &at
+	}
+	if k.RevokedAt != nil {
+		at := *k.RevokedAt
+		k.RevokedAt = &at
+	}
+	return k
+}
+
+// InsertAPIKey records a new API key
+func (t *memTx) InsertAPIKey(k APIKey) (APIKey, error) {
+	if err := t.write(func() { delete(t.m.apiKeys, k.ID) }); err != nil {
+		return APIKey{}, err
+	}
+	k.CreatedAt = stamp(k.CreatedAt)
+	t.m.apiKeys[k.ID] = copyAPIKey(k)
+	return k, nil
+}
+
+// APIKeys returns every API key ordered by ID
+func (t *memTx) APIKeys() ([]APIKey, error) {
+	var keys []APIKey
+	for _, k := range t.m.apiKeys {
+		keys = append(keys, copyAPIKey(k))
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+// APIKey returns a single API key
+func (t *memTx) APIKey(id string) (APIKey, error) {
+	k, ok := t.m.apiKeys[id]
+	if !ok {
+		return APIKey{}, errNotFound
+	}
+	return copyAPIKey(k), nil
+}
+
+// UpdateAPIKey records when an API key expires and when it was revoked
+func (t *memTx) UpdateAPIKey(k APIKey) error {
+	old, ok := t.m.apiKeys[k.ID]
+	if !ok {
+		return errNotFound
+	}
+	if err := t.write(func() { t.m.apiKeys[k.ID] = old }); err != nil {
+		return err
+	}
+	updated := copyAPIKey(old)
+	updated.ExpiresAt, updated.RevokedAt = copyAPIKey(k).ExpiresAt, copyAPIKey(k).RevokedAt
+	t.m.apiKeys[k.ID] = updated
+	return nil
+}
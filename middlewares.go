@@ -2,6 +2,7 @@ package wservice
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/go-kit/kit/endpoint"
 )
@@ -16,7 +17,7 @@ type transfersRequest struct {
 // For each method, we define response struct that is needed by the MakeTransfersEndpoint enpoint constructor (biolerplate)
 type transfersResponse struct {
 	V   []string `json:"v"`
-	Err string   `json:"err,omitempty"` // errors don't define JSON marshaling
+	Err error    `json:"-"` // errors are encoded as problem details by EncodeError
 }
 
 // For each method, we define request struct that is needed by the MakeAccountsEndpoint enpoint constructor (biolerplate)
@@ -27,54 +28,525 @@ type accountsRequest struct {
 // For each method, we define response struct that is needed by the MakeAccountsEndpoint enpoint constructor (biolerplate)
 type accountsResponse struct {
 	V   []string `json:"v"`
-	Err string   `json:"err,omitempty"` // errors don't define JSON marshaling
+	Err error    `json:"-"` // errors are encoded as problem details by EncodeError
 }
 
 // For each method, we define request struct that is needed by the MakeSubmitTransferEndpoint enpoint constructor (biolerplate)
+// Transfers can be addressed either by account (from/to) or by wallet plus currency (from_wallet/to_wallet/currency)
 type submitTransferRequest struct {
 	FromAccount string `json:"from"`
 	ToAccount   string `json:"to"`
+	FromWallet  string `json:"from_wallet,omitempty"`
+	ToWallet    string `json:"to_wallet,omitempty"`
+	Currency    string `json:"currency,omitempty"`
 	Amount      string `json:"amount"`
 }
 
 // For each method, we define response struct that is needed by the MakeSubmitTransferEndpoint enpoint constructor (biolerplate)
 type submitTransferResponse struct {
-	V   string `json:"result"`
-	Err string `json:"err,omitempty"` // errors don't define JSON marshaling
+	V        string `json:"result"`
+	ID       string `json:"id,omitempty"`
+	LegacyID int64  `json:"legacy_id,omitempty"`
+	Status   string `json:"status,omitempty"`
+	Err      error  `json:"-"` // errors are encoded as problem details by EncodeError
+}
+
+// For each method, we define request struct that is needed by the MakeTransferEndpoint and MakeReverseTransferEndpoint enpoint constructors (biolerplate)
+type transferRequest struct {
+	ID string `json:"id"`
+}
+
+// For each method, we define response struct that is needed by the MakeTransferEndpoint and MakeReverseTransferEndpoint enpoint constructors (biolerplate)
+type transferResponse struct {
+	V   *Transfer `json:"v"`
+	Err error     `json:"-"` // errors are encoded as problem details by EncodeError
 }
 
 // MakeTransfersEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method GetTable method
 func MakeTransfersEndpoint(svc WalletService) endpoint.Endpoint {
 	return func(_ context.Context, request interface{}) (interface{}, error) {
 		//req := request.(transfersRequest)
-		v, err := svc.GetTable("Transfers")
+		v, err := svc.GetTable(TransfersTable)
 		if err != nil {
-			return transfersResponse{v, err.Error()}, nil
+			return transfersResponse{v, err}, nil
 		}
-		return transfersResponse{v, ""}, nil
+		return transfersResponse{v, nil}, nil
 	}
 }
 
 // MakeAccountsEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method GetTable method
 func MakeAccountsEndpoint(svc WalletService) endpoint.Endpoint {
 	return func(_ context.Context, request interface{}) (interface{}, error) {
-		//req := request.(accountsRequest)
-		v, err := svc.GetTable("Accounts")
+		req, _ := request.(accountsRequest)
+		var v []string
+		var err error
+		// If a wallet is requested show all of its balances, otherwise show the whole Accounts table
+		if req.S != "" {
+			v, err = svc.GetWallet(req.S)
+		} else {
+			v, err = svc.GetTable(AccountsTable)
+		}
 		if err != nil {
-			return accountsResponse{v, err.Error()}, nil
+			return accountsResponse{v, err}, nil
 		}
-		return accountsResponse{v, ""}, nil
+		return accountsResponse{v, nil}, nil
 	}
 }
 
-// MakeSubmitTransferEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method GetTable method
+// MakeSubmitTransferEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the methods SubmitTransfer and DoWalletTransfer
 func MakeSubmitTransferEndpoint(svc WalletService) endpoint.Endpoint {
 	return func(_ context.Context, request interface{}) (interface{}, error) {
 		req := request.(submitTransferRequest)
-		v, err := svc.DoTransfer(req.FromAccount, req.ToAccount, req.Amount)
+		var t Transfer
+		var err error
+		if req.FromWallet != "" || req.ToWallet != "" {
+			t, err = svc.DoWalletTransfer(req.FromWallet, req.ToWallet, req.Currency, req.Amount)
+		} else {
+			t, err = svc.SubmitTransfer(req.FromAccount, req.ToAccount, req.Amount)
+		}
+		if err != nil {
+			return submitTransferResponse{"error", t.ID, t.LegacyID, t.Status, err}, nil
+		}
+		return submitTransferResponse{"success", t.ID, t.LegacyID, t.Status, nil}, nil
+	}
+}
+
+// For each method, we define response struct that is needed by the MakeCurrenciesEndpoint enpoint constructor (biolerplate)
+type currenciesResponse struct {
+	V   []string `json:"v"`
+	Err error    `json:"-"` // errors are encoded as problem details by EncodeError
+}
+
+// For each method, we define request struct that is needed by the MakeSetCurrencyEndpoint enpoint constructor (biolerplate)
+type setCurrencyRequest struct {
+	Code    string `json:"code"`
+	Enabled bool   `json:"enabled"`
+}
+
+// For each method, we define response struct that is needed by the MakeSetCurrencyEndpoint enpoint constructor (biolerplate)
+type setCurrencyResponse struct {
+	V   string `json:"result"`
+	Err error  `json:"-"` // errors are encoded as problem details by EncodeError
+}
+
+// MakeCurrenciesEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method GetCurrencies method
+func MakeCurrenciesEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		v, err := svc.GetCurrencies()
+		if err != nil {
+			return currenciesResponse{v, err}, nil
+		}
+		return currenciesResponse{v, nil}, nil
+	}
+}
+
+// MakeSetCurrencyEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method SetCurrencyEnabled method
+func MakeSetCurrencyEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(setCurrencyRequest)
+		v, err := svc.SetCurrencyEnabled(req.Code, req.Enabled)
 		if err != nil {
-			return submitTransferResponse{v, err.Error()}, nil
+			return setCurrencyResponse{v, err}, nil
 		}
-		return submitTransferResponse{v, ""}, nil
+		return setCurrencyResponse{v, nil}, nil
 	}
 }
+
+// For each method, we define request struct that is needed by the MakeSetInterestRateEndpoint enpoint constructor (biolerplate)
+type setInterestRateRequest struct {
+	Account        string `json:"account"`
+	Rate           string `json:"rate"`
+	DayCount       string `json:"day_count"`
+	ExpenseAccount string `json:"expense_account"`
+}
+
+// For each method, we define request struct that is needed by the MakeAccrueInterestEndpoint enpoint constructor (biolerplate)
+type accrueInterestRequest struct {
+	Date string `json:"date"`
+}
+
+// For each method, we define request struct that is needed by the MakePostInterestEndpoint enpoint constructor (biolerplate)
+type postInterestRequest struct {
+	Month string `json:"month"`
+}
+
+// For each method, we define response struct that is needed by the interest enpoint constructors (biolerplate)
+type interestResponse struct {
+	V   string `json:"result"`
+	Err error  `json:"-"` // errors are encoded as problem details by EncodeError
+}
+
+// MakeSetInterestRateEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method SetInterestRate method
+func MakeSetInterestRateEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(setInterestRateRequest)
+		v, err := svc.SetInterestRate(req.Account, req.Rate, req.DayCount, req.ExpenseAccount)
+		if err != nil {
+			return interestResponse{v, err}, nil
+		}
+		return interestResponse{v, nil}, nil
+	}
+}
+
+// MakeAccrueInterestEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method AccrueInterest method
+func MakeAccrueInterestEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(accrueInterestRequest)
+		v, err := svc.AccrueInterest(req.Date)
+		if err != nil {
+			return interestResponse{v, err}, nil
+		}
+		return interestResponse{v, nil}, nil
+	}
+}
+
+// MakePostInterestEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method PostInterest method
+func MakePostInterestEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(postInterestRequest)
+		v, err := svc.PostInterest(req.Month)
+		if err != nil {
+			return interestResponse{v, err}, nil
+		}
+		return interestResponse{v, nil}, nil
+	}
+}
+
+// MakeTransferEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method GetTransfer method
+func MakeTransferEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(transferRequest)
+		t, err := svc.GetTransfer(req.ID)
+		if err != nil {
+			return transferResponse{nil, err}, nil
+		}
+		return transferResponse{&t, nil}, nil
+	}
+}
+
+// MakeReverseTransferEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method ReverseTransfer method
+func MakeReverseTransferEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(transferRequest)
+		t, err := svc.ReverseTransfer(req.ID)
+		if err != nil {
+			return transferResponse{nil, err}, nil
+		}
+		return transferResponse{&t, nil}, nil
+	}
+}
+
+// For each method, we define request struct that is needed by the MakeCreateWebhookEndpoint enpoint constructor (biolerplate)
+type createWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// For each method, we define request struct that is needed by the MakeWebhookEndpoint, MakeDeleteWebhookEndpoint and MakeWebhookDeliveriesEndpoint enpoint constructors (biolerplate)
+type webhookRequest struct {
+	ID string `json:"id"`
+}
+
+// For each method, we define request struct that is needed by the MakeSetWebhookEndpoint enpoint constructor (biolerplate)
+type setWebhookRequest struct {
+	ID      string `json:"-"`
+	Enabled *bool  `json:"enabled"`
+}
+
+// For each method, we define request struct that is needed by the MakeReplayWebhookDeliveryEndpoint enpoint constructor (biolerplate)
+type replayWebhookDeliveryRequest struct {
+	ID       string `json:"id"`
+	Delivery string `json:"delivery"`
+}
+
+// For each method, we define response struct that is needed by the MakeCreateWebhookEndpoint, MakeWebhookEndpoint and MakeSetWebhookEndpoint enpoint constructors (biolerplate)
+type webhookResponse struct {
+	V   *Webhook `json:"v"`
+	Err error    `json:"-"` // errors are encoded as problem details by EncodeError
+}
+
+// For each method, we define response struct that is needed by the MakeWebhooksEndpoint enpoint constructor (biolerplate)
+type webhooksResponse struct {
+	V   []Webhook `json:"v"`
+	Err error     `json:"-"` // errors are encoded as problem details by EncodeError
+}
+
+// For each method, we define response struct that is needed by the MakeDeleteWebhookEndpoint enpoint constructor (biolerplate)
+type deleteWebhookResponse struct {
+	V   string `json:"result"`
+	Err error  `json:"-"` // errors are encoded as problem details by EncodeError
+}
+
+// For each method, we define response struct that is needed by the MakeWebhookDeliveriesEndpoint enpoint constructor (biolerplate)
+type webhookDeliveriesResponse struct {
+	V   []WebhookDelivery `json:"v"`
+	Err error             `json:"-"` // errors are encoded as problem details by EncodeError
+}
+
+// For each method, we define response struct that is needed by the MakeReplayWebhookDeliveryEndpoint enpoint constructor (biolerplate)
+type webhookDeliveryResponse struct {
+	V   *WebhookDelivery `json:"v"`
+	Err error            `json:"-"` // errors are encoded as problem details by EncodeError
+}
+
+// MakeCreateWebhookEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method CreateWebhook method
+func MakeCreateWebhookEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(createWebhookRequest)
+		w, err := svc.CreateWebhook(req.URL, req.Events, req.Secret)
+		if err != nil {
+			return webhookResponse{nil, err}, nil
+		}
+		return webhookResponse{&w, nil}, nil
+	}
+}
+
+// MakeWebhooksEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method GetWebhooks method
+func MakeWebhooksEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		v, err := svc.GetWebhooks()
+		if err != nil {
+			return webhooksResponse{v, err}, nil
+		}
+		return webhooksResponse{v, nil}, nil
+	}
+}
+
+// MakeWebhookEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method GetWebhook method
+func MakeWebhookEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(webhookRequest)
+		w, err := svc.GetWebhook(req.ID)
+		if err != nil {
+			return webhookResponse{nil, err}, nil
+		}
+		return webhookResponse{&w, nil}, nil
+	}
+}
+
+// MakeSetWebhookEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method SetWebhookEnabled method
+func MakeSetWebhookEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(setWebhookRequest)
+		w, err := svc.SetWebhookEnabled(req.ID, *req.Enabled)
+		if err != nil {
+			return webhookResponse{nil, err}, nil
+		}
+		return webhookResponse{&w, nil}, nil
+	}
+}
+
+// MakeDeleteWebhookEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method DeleteWebhook method
+func MakeDeleteWebhookEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(webhookRequest)
+		v, err := svc.DeleteWebhook(req.ID)
+		if err != nil {
+			return deleteWebhookResponse{v, err}, nil
+		}
+		return deleteWebhookResponse{v, nil}, nil
+	}
+}
+
+// MakeWebhookDeliveriesEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method GetWebhookDeliveries method
+func MakeWebhookDeliveriesEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(webhookRequest)
+		v, err := svc.GetWebhookDeliveries(req.ID)
+		if err != nil {
+			return webhookDeliveriesResponse{v, err}, nil
+		}
+		return webhookDeliveriesResponse{v, nil}, nil
+	}
+}
+
+// MakeReplayWebhookDeliveryEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method ReplayWebhookDelivery method
+func MakeReplayWebhookDeliveryEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(replayWebhookDeliveryRequest)
+		d, err := svc.ReplayWebhookDelivery(req.ID, req.Delivery)
+		if err != nil {
+			return webhookDeliveryResponse{nil, err}, nil
+		}
+		return webhookDeliveryResponse{&d, nil}, nil
+	}
+}
+
+// For each method, we define request struct that is needed by the MakeAccountEndpoint enpoint constructor (biolerplate)
+type accountRequest struct {
+	ID string `json:"id"`
+}
+
+// For each method, we define response struct that is needed by the MakeListAccountsEndpoint enpoint constructor (biolerplate)
+// The /v1 API serves its resources as they are rather than under a "v" field
+type listAccountsResponse struct {
+	Accounts []Account `json:"accounts"`
+	Err      error     `json:"-"` // errors are encoded as problem details by EncodeError
+}
+
+// For each method, we define response struct that is needed by the MakeAccountEndpoint enpoint constructor (biolerplate)
+type accountResponse struct {
+	*Account
+	Err error `json:"-"` // errors are encoded as problem details by EncodeError
+}
+
+// For each method, we define response struct that is needed by the MakeListTransfersEndpoint enpoint constructor (biolerplate)
+type listTransfersResponse struct {
+	Transfers []Transfer `json:"transfers"`
+	Err       error      `json:"-"` // errors are encoded as problem details by EncodeError
+}
+
+// For each method, we define response struct that is needed by the MakeTransferResourceEndpoint enpoint constructor (biolerplate)
+type transferResourceResponse struct {
+	*Transfer
+	Err error `json:"-"` // errors are encoded as problem details by EncodeError
+}
+
+// For each method, we define response struct that is needed by the MakeCreateTransferEndpoint enpoint constructor (biolerplate)
+// A refused transfer still carries the failed attempt, so that its ID can be reported with the error
+type createTransferResponse struct {
+	*Transfer
+	Err error `json:"-"` // errors are encoded as problem details by EncodeError
+}
+
+// StatusCode answers a created transfer with 201 Created
+func (r createTransferResponse) StatusCode() int { return http.StatusCreated }
+
+// Headers point the client to the created transfer
+func (r createTransferResponse) Headers() http.Header {
+	return http.Header{"Location": {"/v1/transfers/" + r.ID}}
+}
+
+// MakeListAccountsEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method GetAccounts method
+func MakeListAccountsEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req, _ := request.(accountsRequest)
+		v, err := svc.GetAccounts(req.S)
+		if err != nil {
+			return listAccountsResponse{v, err}, nil
+		}
+		return listAccountsResponse{v, nil}, nil
+	}
+}
+
+// MakeAccountEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method GetAccount method
+func MakeAccountEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(accountRequest)
+		a, err := svc.GetAccount(req.ID)
+		if err != nil {
+			return accountResponse{nil, err}, nil
+		}
+		return accountResponse{&a, nil}, nil
+	}
+}
+
+// MakeListTransfersEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method GetTransfers method
+func MakeListTransfersEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		v, err := svc.GetTransfers()
+		if err != nil {
+			return listTransfersResponse{v, err}, nil
+		}
+		return listTransfersResponse{v, nil}, nil
+	}
+}
+
+// MakeTransferResourceEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method GetTransfer method
+// It answers with the transfer itself, where MakeTransferEndpoint answers with the transfer under a "v" field
+func MakeTransferResourceEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(transferRequest)
+		t, err := svc.GetTransfer(req.ID)
+		if err != nil {
+			return transferResourceResponse{nil, err}, nil
+		}
+		return transferResourceResponse{&t, nil}, nil
+	}
+}
+
+// MakeCancelTransferEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the method CancelTransfer method
+// It answers with the cancelled transfer itself, like MakeTransferResourceEndpoint
+func MakeCancelTransferEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(transferRequest)
+		t, err := svc.CancelTransfer(req.ID)
+		if err != nil {
+			return transferResourceResponse{nil, err}, nil
+		}
+		return transferResourceResponse{&t, nil}, nil
+	}
+}
+
+// MakeCreateTransferEndpoint is an endpoint constructor that takes a service and constructs individual endpoints for the methods SubmitTransfer and DoWalletTransfer
+// It answers with the created transfer, where MakeSubmitTransferEndpoint answers with its result, ID and status only
+func MakeCreateTransferEndpoint(svc WalletService) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(submitTransferRequest)
+		var t Transfer
+		var err error
+		if req.FromWallet != "" || req.ToWallet != "" {
+			t, err = svc.DoWalletTransfer(req.FromWallet, req.ToWallet, req.Currency, req.Amount)
+		} else {
+			t, err = svc.SubmitTransfer(req.FromAccount, req.ToAccount, req.Amount)
+		}
+		return createTransferResponse{&t, err}, nil
+	}
+}
+
+// failer is implemented by every response so that EncodeResponse can tell a failed request apart and encode its error as problem details
+type failer interface {
+	Failed() error
+}
+
+// Failed returns the error of the request, if any
+func (r transfersResponse) Failed() error { return r.Err }
+
+// Failed returns the error of the request, if any
+func (r accountsResponse) Failed() error { return r.Err }
+
+// Failed returns the error of the request, if any
+func (r submitTransferResponse) Failed() error { return r.Err }
+
+// Failed returns the error of the request, if any
+func (r transferResponse) Failed() error { return r.Err }
+
+// Failed returns the error of the request, if any
+func (r currenciesResponse) Failed() error { return r.Err }
+
+// Failed returns the error of the request, if any
+func (r setCurrencyResponse) Failed() error { return r.Err }
+
+// Failed returns the error of the request, if any
+func (r interestResponse) Failed() error { return r.Err }
+
+// Failed returns the error of the request, if any
+func (r webhookResponse) Failed() error { return r.Err }
+
+// Failed returns the error of the request, if any
+func (r webhooksResponse) Failed() error { return r.Err }
+
+// Failed returns the error of the request, if any
+func (r deleteWebhookResponse) Failed() error { return r.Err }
+
+// Failed returns the error of the request, if any
+func (r webhookDeliveriesResponse) Failed() error { return r.Err }
+
+// Failed returns the error of the request, if any
+func (r webhookDeliveryResponse) Failed() error { return r.Err }
+
+// Failed returns the error of the request, if any
+func (r listAccountsResponse) Failed() error { return r.Err }
+
+// Failed returns the error of the request, if any
+func (r accountResponse) Failed() error { return r.Err }
+
+// Failed returns the error of the request, if any
+func (r listTransfersResponse) Failed() error { return r.Err }
+
+// Failed returns the error of the request, if any
+func (r transferResourceResponse) Failed() error { return r.Err }
+
+// Failed returns the error of the request, if any
+func (r createTransferResponse) Failed() error { return r.Err }
@@ -0,0 +1,280 @@
+package wservice
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Migrations are the versioned SQL files of the migrations directory, embedded in the binary. Every version has an up file and a down file
+// (e.g. "0001_initial_schema.up.sql" and "0001_initial_schema.down.sql") and the applied versions are recorded in the schema_migrations table.
+// The files are templates that refer to the objects of the ledger by their logical names (e.g. {{.Accounts}}), rendered with the configured names
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the Postgres advisory lock that serialises the migrations of several instances (or ledgers) starting at the same time
+const migrationLockID = 7291053
+
+// Migration is a single version of the schema
+type Migration struct {
+	Version   int
+	Name      string
+	Up        string
+	Down      string
+	AppliedAt time.Time
+}
+
+// Applied tells whether the migration has been applied to the database
+func (m Migration) Applied() bool {
+	return !m.AppliedAt.IsZero()
+}
+
+// Migrator applies and reverts the embedded migrations against the Postgres database of the wallet service
+type Migrator struct {
+	db sqlDBTx
+}
+
+// NewMigrator exported to be accessible from outside the package (from main)
+// NewMigrator takes the wallet service created by NewService, as the migrations run against the same database
+func NewMigrator(svc WalletService) (*Migrator, error) {
+	s, ok := postgresStore(svc)
+	if !ok {
+		var ErrNoDB = errors.New("err: migrations can only run against the Postgres wallet service")
+		return nil, ErrNoDB
+	}
+	return &Migrator{db: s}, nil
+}
+
+// loadMigrations reads the embedded migrations, renders them with the object names of the ledger and returns them ordered by version
+func loadMigrations(s sqlDBTx) ([]Migration, error) {
+	names := map[string]string{}
+	for logical, name := range s.schemaObjects() {
+		names[logical] = s.qualify(name)
+	}
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		i := strings.Index(base, "_")
+		if i == -1 {
+			return nil, errors.New("err: migration file " + name + " is not named <version>_<name>." + direction + ".sql")
+		}
+		version, err := strconv.Atoi(base[:i])
+		if err != nil {
+			return nil, errors.New("err: migration file " + name + " does not start with a version number")
+		}
+		content, err := migrationFiles.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(string(content))
+		if err != nil {
+			return nil, err
+		}
+		var rendered strings.Builder
+		if err := tmpl.Execute(&rendered, names); err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: base[i+1:]}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = rendered.String()
+		} else {
+			m.Down = rendered.String()
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("err: migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Status returns every embedded migration, together with the time it was applied if it was
+func (m *Migrator) Status() ([]Migration, error) {
+	return m.StatusContext(context.Background())
+}
+
+// StatusContext is Status bounded by a context, for the readiness check
+func (m *Migrator) StatusContext(ctx context.Context) ([]Migration, error) {
+	db, err := m.db.openDB()
+	// If any error, return it to parent function
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		var ErrConn = errors.New("err: could not connect to postgres ")
+		return nil, dbError(ErrConn, err)
+	}
+	defer conn.Close()
+	return m.status(ctx, conn)
+}
+
+// Up applies every pending migration in order and returns the ones it applied
+func (m *Migrator) Up() ([]Migration, error) {
+	var applied []Migration
+	err := m.locked(func(conn *sql.Conn) error {
+		migrations, err := m.status(context.Background(), conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range migrations {
+			if mig.Applied() {
+				continue
+			}
+			err := apply(conn, mig.Up, "INSERT INTO "+m.db.migrations()+" (Version, Name) VALUES ($1, $2);", mig.Version, mig.Name)
+			if err != nil {
+				return fmt.Errorf("err: migration %04d_%s failed: %v", mig.Version, mig.Name, err)
+			}
+			applied = append(applied, mig)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the given number of the most recently applied migrations and returns the ones it reverted
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	var reverted []Migration
+	err := m.locked(func(conn *sql.Conn) error {
+		migrations, err := m.status(context.Background(), conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			mig := migrations[i]
+			if !mig.Applied() {
+				continue
+			}
+			err := apply(conn, mig.Down, "DELETE FROM "+m.db.migrations()+" WHERE Version = $1;", mig.Version)
+			if err != nil {
+				return fmt.Errorf("err: reverting migration %04d_%s failed: %v", mig.Version, mig.Name, err)
+			}
+			reverted = append(reverted, mig)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// locked runs f on a single connection that holds the migration advisory lock, so only one instance migrates the database at a time
+func (m *Migrator) locked(f func(conn *sql.Conn) error) error {
+	db, err := m.db.openDB()
+	// If any error, return it to parent function
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		var ErrConn = errors.New("err: could not connect to postgres ")
+		return dbError(ErrConn, err)
+	}
+	defer conn.Close()
+
+	// Advisory locks belong to the session, so the lock is taken and released on the connection the migrations run on
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1);", migrationLockID); err != nil {
+		var ErrLock = errors.New("err: could not take the migration lock ")
+		return dbError(ErrLock, err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1);", migrationLockID)
+
+	// A ledger hosted in its own schema gets the schema created first
+	if m.db.schema != "" {
+		if _, err = conn.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS "+m.db.schema+";"); err != nil {
+			return err
+		}
+	}
+	if _, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+m.db.migrations()+" (Version int PRIMARY KEY, Name varchar(255) NOT NULL, AppliedAt timestamptz NOT NULL DEFAULT now());"); err != nil {
+		return err
+	}
+	return f(conn)
+}
+
+// status returns the embedded migrations with the time each one was applied, read from the schema_migrations table if it exists
+func (m *Migrator) status(ctx context.Context, conn *sql.Conn) ([]Migration, error) {
+	migrations, err := loadMigrations(m.db)
+	if err != nil {
+		return nil, err
+	}
+	var exists bool
+	if err := conn.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL;", m.db.migrations()).Scan(&exists); err != nil {
+		var ErrUnexpect = errors.New("err: unexpected error ")
+		return nil, dbError(ErrUnexpect, err)
+	}
+	if !exists {
+		return migrations, nil
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT Version, AppliedAt FROM "+m.db.migrations()+";")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	appliedAt := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		appliedAt[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range migrations {
+		migrations[i].AppliedAt = appliedAt[migrations[i].Version]
+	}
+	return migrations, nil
+}
+
+// apply runs a migration script and the statement that records it in the same transaction, so a migration is either fully applied or not at all
+func apply(conn *sql.Conn, script string, record string, args ...interface{}) error {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,141 @@
+package wservice
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	var s sqlDBTx
+	s.setSchemaDefaults()
+	migrations, err := loadMigrations(s)
+	assert.Nil(t, err)
+	assert.True(t, len(migrations) >= 2)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "migration versions must be sequential")
+		assert.NotEmpty(t, m.Name)
+		assert.NotEmpty(t, strings.TrimSpace(m.Up))
+		assert.NotEmpty(t, strings.TrimSpace(m.Down))
+		assert.False(t, m.Applied())
+	}
+	assert.Equal(t, "initial_schema", migrations[0].Name)
+	assert.Contains(t, migrations[0].Up, "CREATE TABLE IF NOT EXISTS Accounts (")
+	assert.Contains(t, migrations[0].Up, "CREATE SEQUENCE IF NOT EXISTS Payment_Counter;")
+
+	// The migrations of a ledger hosted in its own schema refer to its own objects only
+	s.schema, s.accountsTable = "ledger2", "Wallets"
+	migrations, err = loadMigrations(s)
+	assert.Nil(t, err)
+	assert.Contains(t, migrations[0].Up, "CREATE TABLE IF NOT EXISTS ledger2.Wallets (")
+	assert.Contains(t, migrations[0].Up, "REFERENCES ledger2.Wallets(AccountID)")
+	assert.Contains(t, migrations[0].Up, "CREATE SEQUENCE IF NOT EXISTS ledger2.Payment_Counter;")
+	assert.NotContains(t, migrations[0].Up, " Accounts")
+}
+
+func TestNewMigrator(t *testing.T) {
+	_, err := NewMigrator(ledger{store: sqlDBTx{}})
+	assert.Nil(t, err)
+	_, err = NewMigrator(NewValidating(ledger{store: sqlDBTx{}}))
+	assert.NotNil(t, err)
+	_, err = NewMigrator(ledger{store: &memStore{}})
+	assert.NotNil(t, err)
+}
+
+// baselineSchema is the schema the former docker init script created in a schema of the test database, with a transfer made by the service of that time
+const baselineSchema = `
+CREATE TABLE %[1]s.Accounts (
+    AccountID varchar(255) PRIMARY KEY,
+    Balance decimal(9,3) NOT NULL CHECK (Balance>=0),
+    Currency varchar(255) NOT NULL,
+    InitialBalance decimal(9,3) NOT NULL CHECK (Balance>=0)
+);
+
+CREATE TABLE %[1]s.Transfers (
+    TransID int NOT NULL PRIMARY KEY,
+    From_Account varchar(255) NOT NULL,
+    To_Account varchar(255)  NOT NULL,
+    Amount decimal(9,3) NOT NULL CHECK (Amount>=0),
+    Currency varchar(255) NOT NULL,
+    TTime varchar(255) NOT NULL,
+    FOREIGN KEY (From_Account) REFERENCES %[1]s.Accounts(AccountID),
+    FOREIGN KEY (To_Account) REFERENCES %[1]s.Accounts(AccountID)
+);
+
+INSERT INTO %[1]s.Accounts (AccountID, Balance, Currency, InitialBalance) VALUES
+    ('bob123', '282.35', 'USD', '302.35'),
+    ('alice456', '593.81', 'USD', '573.81'),
+    ('marcy789', '4583.90', 'EUR', '4583.90'),
+    ('lucy0123', '14583.90', 'EUR', '14583.90');
+
+CREATE SEQUENCE %[1]s.Payment_Counter;
+
+INSERT INTO %[1]s.Transfers (TransID, From_Account, To_Account, Amount, Currency, TTime)
+VALUES (nextval('%[1]s.Payment_Counter'), 'bob123', 'alice456', '20', 'USD', '2019-03-25T12:02:55Z');
+`
+
+func TestMigrateBaselineDatabase(t *testing.T) {
+	cfg, err := ReadConfigFile(DefaultConfig(), "./cmd/postgresql.cfg")
+	assert.Nil(t, err)
+	cfg, err = ApplyEnv(cfg, os.LookupEnv)
+	assert.Nil(t, err)
+	id, err := newULID(time.Now())
+	assert.Nil(t, err)
+	cfg.Database.Schema = "wservice_test_" + strings.ToLower(id)
+	cfg.Auth.Enabled = false
+	svc, err := NewServiceFromConfig(cfg)
+	assert.Nil(t, err)
+	t.Cleanup(func() { ClosePool(svc) })
+
+	// Create the database the way the former docker init script did
+	s, _ := postgresStore(svc)
+	db, err := s.openDB()
+	if err == nil {
+		_, err = db.Exec("CREATE SCHEMA " + cfg.Database.Schema + ";")
+	}
+	if err != nil {
+		t.Skip("no test database: ", err)
+	}
+	t.Cleanup(func() {
+		_, err := db.Exec("DROP SCHEMA " + cfg.Database.Schema + " CASCADE;")
+		assert.Nil(t, err)
+	})
+	_, err = db.Exec(fmt.Sprintf(baselineSchema, cfg.Database.Schema))
+	assert.Nil(t, err)
+
+	// The migrations adopt it: its accounts go into wallets and its transfer is kept as a completed one
+	m, err := NewMigrator(svc)
+	assert.Nil(t, err)
+	if _, err = m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := svc.GetWallet("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"Wallet: alice  Account: alice457  Balance = 1000.000000 EUR  Initial Balance = 1000.000000",
+		"Wallet: alice  Account: alice456  Balance = 593.810000 USD  Initial Balance = 573.810000",
+		"Success.",
+	}, rows)
+	transfers, err := svc.GetTransfers()
+	assert.Nil(t, err)
+	if assert.Len(t, transfers, 1) {
+		assert.True(t, isULID(transfers[0].ID))
+		assert.Equal(t, StatusCompleted, transfers[0].Status)
+		transfer, err := svc.GetTransfer(transfers[0].ID)
+		assert.Nil(t, err)
+		assert.Len(t, transfer.History, 1)
+	}
+
+	// The adopted ledger carries on where it stopped
+	transfer, err := svc.SubmitTransfer("bob123", "alice456", "2.35")
+	assert.Nil(t, err)
+	assert.Equal(t, StatusCompleted, transfer.Status)
+	assert.Equal(t, int64(2), transfer.LegacyID)
+	assert.Equal(t, "280.000000", balance(t, svc, "bob123"))
+	_, err = svc.DoTransfer("bob123", "marcy789", "1")
+	assert.EqualError(t, err, "Not same currency in transaction source and destination")
+}
@@ -0,0 +1,8 @@
+DROP SEQUENCE IF EXISTS {{.Sequence}};
+DROP TABLE IF EXISTS {{.InterestAccruals}};
+DROP TABLE IF EXISTS {{.InterestRates}};
+DROP TABLE IF EXISTS {{.FailedTransfers}};
+DROP TABLE IF EXISTS {{.TransferStatusChanges}};
+DROP TABLE IF EXISTS {{.Transfers}};
+DROP TABLE IF EXISTS {{.Accounts}};
+DROP TABLE IF EXISTS {{.Currencies}};
@@ -0,0 +1,97 @@
+-- The initial schema of the wallet service. It uses IF NOT EXISTS so that it also adopts databases created by the former docker init script, whose
+-- tables are then brought up to this schema (see the end of this migration)
+
+CREATE TABLE IF NOT EXISTS {{.Currencies}} (
+    Code char(3) PRIMARY KEY CHECK (Code ~ '^[A-Z]{3}$'),
+    NumericCode char(3) NOT NULL UNIQUE CHECK (NumericCode ~ '^[0-9]{3}$'),
+    MinorUnits smallint NOT NULL CHECK (MinorUnits BETWEEN 0 AND 3),
+    Name varchar(255) NOT NULL,
+    Enabled boolean NOT NULL DEFAULT true
+);
+
+CREATE TABLE IF NOT EXISTS {{.Accounts}} (
+    AccountID varchar(255) PRIMARY KEY,
+    Balance decimal(9,3) NOT NULL CHECK (Balance>=0),
+    Currency char(3) NOT NULL REFERENCES {{.Currencies}}(Code),
+    InitialBalance decimal(9,3) NOT NULL CHECK (Balance>=0),
+    WalletID varchar(255) NOT NULL,
+    UNIQUE (WalletID, Currency)
+);
+
+CREATE TABLE IF NOT EXISTS {{.Transfers}} (
+    TransID int NOT NULL PRIMARY KEY,
+    From_Account varchar(255) NOT NULL,
+    To_Account varchar(255)  NOT NULL,
+    Amount decimal(9,3) NOT NULL CHECK (Amount>=0),
+    Currency char(3) NOT NULL REFERENCES {{.Currencies}}(Code),
+    TTime varchar(255) NOT NULL,
+    Status varchar(16) NOT NULL DEFAULT 'completed' CHECK (Status IN ('pending', 'completed', 'failed', 'reversed', 'cancelled')),
+    FOREIGN KEY (From_Account) REFERENCES {{.Accounts}}(AccountID),
+    FOREIGN KEY (To_Account) REFERENCES {{.Accounts}}(AccountID)
+);
+
+CREATE TABLE IF NOT EXISTS {{.TransferStatusChanges}} (
+    TransID int NOT NULL REFERENCES {{.Transfers}}(TransID),
+    Status varchar(16) NOT NULL,
+    ChangedAt timestamptz NOT NULL DEFAULT now(),
+    Reason text NOT NULL DEFAULT ''
+);
+
+-- Failed attempts share the transfer IDs but live apart as they may reference unknown accounts, currencies or amounts
+CREATE TABLE IF NOT EXISTS {{.FailedTransfers}} (
+    TransID int NOT NULL PRIMARY KEY,
+    From_Account varchar(255) NOT NULL,
+    To_Account varchar(255) NOT NULL,
+    Amount varchar(255) NOT NULL,
+    Reason text NOT NULL,
+    FailedAt timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS {{.InterestRates}} (
+    AccountID varchar(255) PRIMARY KEY REFERENCES {{.Accounts}}(AccountID),
+    AnnualRate decimal(9,6) NOT NULL CHECK (AnnualRate>=0),
+    DayCount varchar(16) NOT NULL CHECK (DayCount IN ('ACT/365', 'ACT/360', 'ACT/ACT', '30/360')),
+    ExpenseAccount varchar(255) NOT NULL REFERENCES {{.Accounts}}(AccountID),
+    StartDate date NOT NULL DEFAULT CURRENT_DATE
+);
+
+CREATE TABLE IF NOT EXISTS {{.InterestAccruals}} (
+    AccountID varchar(255) NOT NULL REFERENCES {{.Accounts}}(AccountID),
+    AccrualDate date NOT NULL,
+    Balance decimal(9,3) NOT NULL,
+    AnnualRate decimal(9,6) NOT NULL,
+    Amount decimal(18,9) NOT NULL,
+    PostedTransfer int,
+    Posted boolean NOT NULL DEFAULT false,
+    PRIMARY KEY (AccountID, AccrualDate)
+);
+
+CREATE SEQUENCE IF NOT EXISTS {{.Sequence}};
+
+-- The former docker init script created the tables of the accounts and transfers without wallets, transfer statuses or a currency registry. Every adopted
+-- account goes into a wallet named after its ID without the trailing digits (bob123 into bob) unless another account of its currency would go there
+-- too, in which case its wallet is its ID. The currencies reference the registry seeded by the next migration, which validates them once it is there
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_attribute WHERE attrelid = '{{.Accounts}}'::regclass AND attname = 'walletid' AND NOT attisdropped) THEN
+        ALTER TABLE {{.Accounts}} ADD COLUMN WalletID varchar(255);
+        UPDATE {{.Accounts}} a SET WalletID = COALESCE(NULLIF(regexp_replace(a.AccountID, '[0-9]+$', ''), ''), a.AccountID)
+            WHERE NOT EXISTS (SELECT 1 FROM {{.Accounts}} b WHERE b.AccountID <> a.AccountID AND b.Currency = a.Currency
+                AND regexp_replace(b.AccountID, '[0-9]+$', '') = regexp_replace(a.AccountID, '[0-9]+$', ''));
+        UPDATE {{.Accounts}} SET WalletID = AccountID WHERE WalletID IS NULL;
+        ALTER TABLE {{.Accounts}} ALTER COLUMN WalletID SET NOT NULL;
+        ALTER TABLE {{.Accounts}} ADD UNIQUE (WalletID, Currency);
+        ALTER TABLE {{.Accounts}} ALTER COLUMN Currency TYPE char(3);
+        ALTER TABLE {{.Accounts}} ADD FOREIGN KEY (Currency) REFERENCES {{.Currencies}}(Code) NOT VALID;
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM pg_attribute WHERE attrelid = '{{.Transfers}}'::regclass AND attname = 'status' AND NOT attisdropped) THEN
+        ALTER TABLE {{.Transfers}} ALTER COLUMN Currency TYPE char(3);
+        ALTER TABLE {{.Transfers}} ADD FOREIGN KEY (Currency) REFERENCES {{.Currencies}}(Code) NOT VALID;
+        ALTER TABLE {{.Transfers}} ADD COLUMN Status varchar(16) NOT NULL DEFAULT 'completed'
+            CHECK (Status IN ('pending', 'completed', 'failed', 'reversed', 'cancelled'));
+        -- The adopted transfers were completed when they were made
+        INSERT INTO {{.TransferStatusChanges}} (TransID, Status, ChangedAt) SELECT TransID, 'completed', TTime::timestamptz FROM {{.Transfers}};
+    END IF;
+END
+$$;
@@ -0,0 +1,2 @@
+DELETE FROM {{.Accounts}} WHERE AccountID IN ('bob123', 'alice456', 'marcy789', 'lucy0123', 'alice457', 'bankinterestusd', 'bankinteresteur');
+DELETE FROM {{.Currencies}};
@@ -0,0 +1,5 @@
+ALTER TABLE {{.FailedTransfers}} DROP COLUMN UID;
+ALTER TABLE {{.Transfers}} DROP COLUMN UID;
+
+ALTER TABLE {{.Transfers}} ALTER COLUMN TTime DROP DEFAULT;
+ALTER TABLE {{.Transfers}} ALTER COLUMN TTime TYPE varchar(255) USING to_char(TTime AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"');
@@ -0,0 +1,32 @@
+-- Transfers are timestamped by the database clock and identified by ULIDs in the API, the numeric TransID is kept for legacy clients
+
+ALTER TABLE {{.Transfers}} ALTER COLUMN TTime TYPE timestamptz USING TTime::timestamptz;
+ALTER TABLE {{.Transfers}} ALTER COLUMN TTime SET DEFAULT now();
+
+-- ulid builds a ULID from the time of an existing row (48-bit millisecond timestamp followed by 80 random bits, in Crockford base32)
+CREATE FUNCTION pg_temp.ulid(ts timestamptz) RETURNS char(26) AS $$
+DECLARE
+    alphabet text := '0123456789ABCDEFGHJKMNPQRSTVWXYZ';
+    ms bigint := floor(extract(epoch FROM ts) * 1000);
+    id text := '';
+BEGIN
+    FOR i IN 1..10 LOOP
+        id := substr(alphabet, (ms % 32)::int + 1, 1) || id;
+        ms := ms / 32;
+    END LOOP;
+    FOR i IN 1..16 LOOP
+        id := id || substr(alphabet, floor(random() * 32)::int + 1, 1);
+    END LOOP;
+    RETURN id;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE {{.Transfers}} ADD COLUMN UID char(26);
+UPDATE {{.Transfers}} SET UID = pg_temp.ulid(TTime);
+ALTER TABLE {{.Transfers}} ALTER COLUMN UID SET NOT NULL;
+ALTER TABLE {{.Transfers}} ADD UNIQUE (UID);
+
+ALTER TABLE {{.FailedTransfers}} ADD COLUMN UID char(26);
+UPDATE {{.FailedTransfers}} SET UID = pg_temp.ulid(FailedAt);
+ALTER TABLE {{.FailedTransfers}} ALTER COLUMN UID SET NOT NULL;
+ALTER TABLE {{.FailedTransfers}} ADD UNIQUE (UID);
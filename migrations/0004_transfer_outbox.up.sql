@@ -0,0 +1,14 @@
+-- The events of the committed transfers are written to the outbox in the same transaction as the transfers, and stay pending until the relay has published them
+
+CREATE TABLE {{.Outbox}} (
+    Seq bigserial PRIMARY KEY,
+    EventType varchar(64) NOT NULL,
+    Payload jsonb NOT NULL,
+    CreatedAt timestamptz NOT NULL DEFAULT now(),
+    Status varchar(16) NOT NULL DEFAULT 'pending' CHECK (Status IN ('pending', 'published')),
+    Attempts int NOT NULL DEFAULT 0,
+    LastError text NOT NULL DEFAULT '',
+    PublishedAt timestamptz
+);
+
+CREATE INDEX ON {{.Outbox}} (Seq) WHERE Status = 'pending';
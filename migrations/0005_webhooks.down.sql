@@ -0,0 +1,2 @@
+DROP TABLE {{.WebhookDeliveries}};
+DROP TABLE {{.Webhooks}};
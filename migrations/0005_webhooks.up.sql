@@ -0,0 +1,29 @@
+-- Webhooks subscribe a URL to some event types of the outbox, every relayed event is queued as a delivery per subscribed webhook until its receiver accepts it
+
+CREATE TABLE {{.Webhooks}} (
+    ID char(26) PRIMARY KEY,
+    URL text NOT NULL,
+    Events text NOT NULL,
+    Secret text NOT NULL,
+    Enabled boolean NOT NULL DEFAULT true,
+    Failures int NOT NULL DEFAULT 0,
+    CreatedAt timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE {{.WebhookDeliveries}} (
+    ID bigserial PRIMARY KEY,
+    WebhookID char(26) NOT NULL REFERENCES {{.Webhooks}}(ID) ON DELETE CASCADE,
+    EventSeq bigint NOT NULL,
+    EventType varchar(64) NOT NULL,
+    Payload jsonb NOT NULL,
+    Status varchar(16) NOT NULL DEFAULT 'pending' CHECK (Status IN ('pending', 'delivered', 'failed')),
+    Attempts int NOT NULL DEFAULT 0,
+    ResponseCode int NOT NULL DEFAULT 0,
+    LastError text NOT NULL DEFAULT '',
+    NextAttempt timestamptz NOT NULL DEFAULT now(),
+    DeliveredAt timestamptz,
+    CreatedAt timestamptz NOT NULL DEFAULT now(),
+    UNIQUE (WebhookID, EventSeq)
+);
+
+CREATE INDEX ON {{.WebhookDeliveries}} (NextAttempt) WHERE Status = 'pending';
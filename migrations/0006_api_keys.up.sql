@@ -0,0 +1,11 @@
+-- API keys authenticate the clients of the API, only the SHA-256 hash of their secret is kept
+
+CREATE TABLE {{.APIKeys}} (
+    ID char(26) PRIMARY KEY,
+    Name text NOT NULL,
+    Scopes text NOT NULL,
+    Hash char(64) NOT NULL UNIQUE,
+    CreatedAt timestamptz NOT NULL DEFAULT now(),
+    ExpiresAt timestamptz,
+    RevokedAt timestamptz
+);
@@ -0,0 +1,206 @@
+package wservice
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/lib/pq"
+)
+
+// Every committed transfer is announced with its ID, so that every instance of the service learns about the transfers of the others as they commit.
+// With Postgres the announcement is a NOTIFY on the configured channel, sent in the transaction of the transfer so that Postgres only delivers it once
+// the transfer is committed, and every instance LISTENs on a connection of its own that is re-established whenever it is lost. The ledgers of a single
+// node (SQLite or in memory) announce their transfers in process once they are committed. Either way the ChangeListener of the instance dispatches
+// the announcements to its subscribers, such as the live feed, the outbox relay or a cache
+
+// changeQueueSize is the number of announcements of the ledger of a single node that wait for the listener before it resyncs its subscribers instead
+const changeQueueSize = 256
+
+// notificationsPing is how often the listener checks that its Postgres connection is alive, a dead one being re-established
+const notificationsPing = 90 * time.Second
+
+// Notification is an announcement dispatched by the ChangeListener. TransferID is the transfer that was committed, unless Resync is set: announcements
+// may have been lost (the connection of the listener was re-established, or its subscribers fell behind), so the subscribers should catch up on their own
+type Notification struct {
+	TransferID string
+	Resync     bool
+}
+
+// localNotifier announces the transfers committed by the ledger of a single node to the listeners of the process
+type localNotifier struct {
+	mu        sync.Mutex
+	listeners []func(Notification)
+}
+
+// listen registers a listener of the announcements
+func (n *localNotifier) listen(fn func(Notification)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.listeners = append(n.listeners, fn)
+}
+
+// announce announces the transfers of a committed transaction
+func (n *localNotifier) announce(transferIDs []string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, id := range transferIDs {
+		for _, fn := range n.listeners {
+			fn(Notification{TransferID: id})
+		}
+	}
+}
+
+// ChangeListener receives the announcements of the committed transfers, from Postgres or from the ledger of the process, and dispatches them to its subscribers
+type ChangeListener struct {
+	cfg NotificationsConfig
+	// pg is the connection string of the Postgres database to listen to and secrets the secrets to redact from its errors, local is set for the other ledgers
+	pg      string
+	secrets []string
+	local   chan Notification
+
+	mu          sync.Mutex
+	subscribers map[int]func(Notification)
+	next        int
+	// overflow is set when an announcement of the ledger was dropped because the local queue was full
+	overflow bool
+}
+
+// NewChangeListener exported to be accessible from outside the package (from main)
+// NewChangeListener creates the listener of the announcements of the wallet service created by NewServiceFromConfig, RunChangeListener runs it
+func NewChangeListener(svc WalletService, cfg NotificationsConfig) (*ChangeListener, error) {
+	store, ok := storeOf(svc)
+	if !ok {
+		var ErrNoStore = errors.New("err: only the wallet service created by NewServiceFromConfig announces its transfers")
+		return nil, ErrNoStore
+	}
+	c := &ChangeListener{cfg: cfg, subscribers: make(map[int]func(Notification))}
+	switch s := store.(type) {
+	case sqlDBTx:
+		c.pg, c.secrets = s.connectionString(), s.secrets()
+	case *memStore:
+		c.local = make(chan Notification, changeQueueSize)
+		s.notifier.listen(c.enqueue)
+	case sqliteStore:
+		c.local = make(chan Notification, changeQueueSize)
+		s.notifier.listen(c.enqueue)
+	}
+	return c, nil
+}
+
+// enqueue queues an announcement of the ledger of the process without ever blocking its transaction, a full queue making the subscribers resync
+func (c *ChangeListener) enqueue(n Notification) {
+	select {
+	case c.local <- n:
+	default:
+		c.mu.Lock()
+		c.overflow = true
+		c.mu.Unlock()
+	}
+}
+
+// Subscribe registers fn to be called with every notification. It is called by the goroutine of the listener, one notification at a time, so it
+// should return quickly (e.g. by waking up a job of its own). It returns a function that unsubscribes it
+func (c *ChangeListener) Subscribe(fn func(Notification)) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.next
+	c.next++
+	c.subscribers[id] = fn
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.subscribers, id)
+	}
+}
+
+// dispatch calls every subscriber with a notification
+func (c *ChangeListener) dispatch(n Notification) {
+	c.mu.Lock()
+	subscribers := make([]func(Notification), 0, len(c.subscribers))
+	for _, fn := range c.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	c.mu.Unlock()
+	for _, fn := range subscribers {
+		fn(n)
+	}
+}
+
+// RunChangeListener exported to be accessible from outside the package (from main)
+// RunChangeListener dispatches the announcements to the subscribers until stop is closed. With Postgres it listens on a dedicated connection, which
+// is re-established after the configured reconnect interval whenever it is lost, and resyncs the subscribers once it is back
+func RunChangeListener(c *ChangeListener, logger log.Logger, stop <-chan struct{}) {
+	if c.local != nil {
+		c.runLocal(stop)
+		return
+	}
+	listener := pq.NewListener(c.pg, time.Duration(c.cfg.MinReconnect), time.Duration(c.cfg.MaxReconnect), func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventConnected:
+			logger.Log("msg", "listening for notifications", "channel", c.cfg.Channel)
+		case pq.ListenerEventDisconnected:
+			logger.Log("msg", "lost the notification connection, reconnecting", "err", redactError(err, c.secrets))
+		case pq.ListenerEventReconnected:
+			logger.Log("msg", "notification connection re-established, resyncing", "channel", c.cfg.Channel)
+		case pq.ListenerEventConnectionAttemptFailed:
+			logger.Log("msg", "could not connect to listen for notifications", "err", redactError(err, c.secrets))
+		}
+	})
+	// Listen waits for the first connection, which is retried in the background, so it must not hold up the shutdown
+	go func() {
+		if err := listener.Listen(c.cfg.Channel); err != nil {
+			select {
+			case <-stop:
+			default:
+				logger.Log("msg", "could not listen for notifications", "channel", c.cfg.Channel, "err", redactError(err, c.secrets))
+			}
+		}
+	}()
+	ping := time.NewTicker(notificationsPing)
+	defer ping.Stop()
+	for {
+		select {
+		case n, ok := <-listener.Notify:
+			if !ok {
+				return
+			}
+			// The listener sends nil once it reconnected, the notifications sent in the meantime are lost
+			if n == nil {
+				c.dispatch(Notification{Resync: true})
+			} else {
+				c.dispatch(Notification{TransferID: n.Extra})
+			}
+		case <-ping.C:
+			go listener.Ping()
+		case <-stop:
+			listener.Close()
+			return
+		}
+	}
+}
+
+// runLocal dispatches the announcements of the ledger of the process until stop is closed
+func (c *ChangeListener) runLocal(stop <-chan struct{}) {
+	for {
+		select {
+		case n := <-c.local:
+			c.dispatchLocal(n)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// dispatchLocal dispatches an announcement of the ledger of the process, then resyncs the subscribers if any had to be dropped
+func (c *ChangeListener) dispatchLocal(n Notification) {
+	c.dispatch(n)
+	c.mu.Lock()
+	overflow := c.overflow
+	c.overflow = false
+	c.mu.Unlock()
+	if overflow {
+		c.dispatch(Notification{Resync: true})
+	}
+}
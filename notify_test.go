@@ -0,0 +1,131 @@
+package wservice
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// runTestListener runs the listener of the announcements of a wallet service until the test ends, forwarding its notifications to the returned channel
+func runTestListener(t *testing.T, svc WalletService) (*ChangeListener, <-chan Notification) {
+	c, err := NewChangeListener(svc, DefaultConfig().Notifications)
+	assert.Nil(t, err)
+	notes := make(chan Notification, changeQueueSize)
+	c.Subscribe(func(n Notification) { notes <- n })
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		RunChangeListener(c, log.NewNopLogger(), stop)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+	// The Postgres listener connects in the background, and what is announced before it listens is lost
+	if s, ok := postgresStore(svc); ok {
+		awaitListening(t, s, notes)
+	}
+	return c, notes
+}
+
+// awaitListening announces probes on the channel of the notifications until the listener dispatches one, then drops the probes still in flight
+func awaitListening(t *testing.T, s sqlDBTx, notes <-chan Notification) {
+	db, err := s.openDB()
+	assert.Nil(t, err)
+	const probe = "probe"
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		_, err = db.Exec("SELECT pg_notify($1, $2);", DefaultConfig().Notifications.Channel, probe)
+		assert.Nil(t, err)
+		select {
+		case n := <-notes:
+			if n.TransferID != probe {
+				continue
+			}
+			for {
+				select {
+				case <-notes:
+				case <-time.After(200 * time.Millisecond):
+					return
+				}
+			}
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	t.Fatal("the listener never started listening")
+}
+
+// nextNotification returns the next notification dispatched by a listener
+func nextNotification(t *testing.T, notes <-chan Notification) Notification {
+	select {
+	case n := <-notes:
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification was dispatched")
+		return Notification{}
+	}
+}
+
+func TestChangeListener(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		c, notes := runTestListener(t, svc)
+		other := make(chan Notification, 10)
+		unsubscribe := c.Subscribe(func(n Notification) { other <- n })
+
+		transfer, err := svc.SubmitTransfer("bob123", "alice456", "1")
+		assert.Nil(t, err)
+		assert.Equal(t, Notification{TransferID: transfer.ID}, nextNotification(t, notes))
+		assert.Equal(t, Notification{TransferID: transfer.ID}, nextNotification(t, other))
+
+		// A refused transfer is not announced, and an unsubscribed function is no longer called
+		_, err = svc.SubmitTransfer("bob123", "alice456", "100000")
+		assert.NotNil(t, err)
+		unsubscribe()
+		transfer, err = svc.SubmitTransfer("marcy789", "lucy0123", "1")
+		assert.Nil(t, err)
+		assert.Equal(t, Notification{TransferID: transfer.ID}, nextNotification(t, notes))
+		assert.Len(t, other, 0)
+	})
+}
+
+func TestChangeListenerOverflow(t *testing.T) {
+	svc := newMemoryService(t)
+	c, err := NewChangeListener(svc, DefaultConfig().Notifications)
+	assert.Nil(t, err)
+	// The announcements that do not fit in the queue are dropped, the subscribers are then told to resync
+	for i := 0; i <= changeQueueSize; i++ {
+		_, err = svc.DoTransfer("bob123", "alice456", "0.01")
+		assert.Nil(t, err)
+	}
+	var resyncs, transfers int
+	c.Subscribe(func(n Notification) {
+		if n.Resync {
+			resyncs++
+		} else {
+			transfers++
+		}
+	})
+	for len(c.local) > 0 {
+		c.dispatchLocal(<-c.local)
+	}
+	assert.Equal(t, changeQueueSize, transfers)
+	assert.Equal(t, 1, resyncs)
+}
+
+func TestNotificationsDisabled(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Storage = StorageMemory
+	cfg.Auth.Enabled = false
+	cfg.Notifications.Enabled = false
+	svc, err := NewServiceFromConfig(cfg)
+	assert.Nil(t, err)
+	_, notes := runTestListener(t, svc)
+	_, err = svc.DoTransfer("bob123", "alice456", "1")
+	assert.Nil(t, err)
+	select {
+	case n := <-notes:
+		t.Fatalf("the transfer was announced with the notifications disabled: %+v", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,197 @@
+package wservice
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	legacyrouter "github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/gorilla/mux"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// The OpenAPI document of openapi.yaml describes every route of the HTTP API. It is built into the binary, served at /openapi.json and browsable
+// with the Swagger UI page of /docs, and the requests of the HTTP endpoints are validated against it once their API key is checked (see
+// newHandler). The validation checks the shape of a request, its types and its required fields, while the validating middleware keeps
+// checking the rules of the service, such as the format of an amount
+
+//go:embed openapi.yaml
+var openAPIDocument []byte
+
+// swaggerUI holds the Swagger UI page and the scripts and styles of its distribution
+//
+//go:embed swaggerui
+var swaggerUI embed.FS
+
+// openAPI is the OpenAPI document of the API, with the router finding the operation of a request in it
+var openAPI, openAPIRouter = mustLoadOpenAPI()
+
+// unsupportedProperty extracts the name of an unknown field out of the reason of its schema error
+var unsupportedProperty = regexp.MustCompile(`^property "(.*)" is unsupported$`)
+
+// mustLoadOpenAPI loads and checks the OpenAPI document built into the binary, which cannot fail once the tests pass
+func mustLoadOpenAPI() (*openapi3.T, routers.Router) {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPIDocument)
+	if err != nil {
+		panic("err: could not load openapi.yaml: " + err.Error())
+	}
+	router, err := legacyrouter.NewRouter(doc)
+	if err != nil {
+		panic("err: invalid openapi.yaml: " + err.Error())
+	}
+	return doc, router
+}
+
+// openAPIHandler serves the OpenAPI document as JSON
+func openAPIHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(openAPI)
+	})
+}
+
+// swaggerUIHandler serves the Swagger UI page of /docs and the assets of /docs/{asset}
+func swaggerUIHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := mux.Vars(req)["asset"]
+		if name == "" {
+			name = "index.html"
+		}
+		data, err := swaggerUI.ReadFile(path.Join("swaggerui", path.Clean("/"+name)))
+		if err != nil {
+			ctx := httptransport.PopulateRequestContext(req.Context(), req)
+			EncodeError(ctx, newError(ErrNotFound, "404 page not found"), w)
+			return
+		}
+		http.ServeContent(w, req, name, time.Time{}, bytes.NewReader(data))
+	})
+}
+
+// validateRequests validates the requests of an endpoint against their operation of the OpenAPI document before passing them on. The requests of the
+// verbs the document does not describe are passed on as they are so that the handler answers them
+func validateRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		route, pathParams, err := openAPIRouter.FindRoute(req)
+		if err != nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if err := validateRequest(req, route, pathParams); err != nil {
+			ctx := httptransport.PopulateRequestContext(req.Context(), req)
+			EncodeError(ctx, err, w)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// validateRequest validates a request against its operation. Its body is read within the request body limit and put back for the handler
+func validateRequest(req *http.Request, route *routers.Route, pathParams map[string]string) error {
+	// The API reads every body as JSON whatever its Content-Type (curl -d sends form data), so the body is validated as JSON
+	validated := req.Clone(req.Context())
+	if route.Operation.RequestBody != nil {
+		body, err := readBody(req)
+		if err != nil {
+			return err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		validated.Body = ioutil.NopCloser(bytes.NewReader(body))
+		validated.Header.Set("Content-Type", "application/json")
+	}
+	err := openapi3filter.ValidateRequest(context.Background(), &openapi3filter.RequestValidationInput{
+		Request:    validated,
+		PathParams: pathParams,
+		Route:      route,
+		Options: &openapi3filter.Options{
+			MultiError:         true,
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		},
+	})
+	if err == nil {
+		return nil
+	}
+	var v validator
+	if !openAPIFieldErrors(err, &v) {
+		return newError(ErrInvalidRequest, "err: "+err.Error())
+	}
+	sort.SliceStable(v.fields, func(i, j int) bool { return v.fields[i].Field < v.fields[j].Field })
+	return v.err()
+}
+
+// openAPIFieldErrors records the invalid fields the errors of the validation of a request are about. It returns false when an error is about the
+// request as a whole, such as a body that is missing or not JSON
+func openAPIFieldErrors(err error, v *validator) bool {
+	if me, ok := err.(openapi3.MultiError); ok {
+		for _, err := range me {
+			if !openAPIFieldErrors(err, v) {
+				return false
+			}
+		}
+		return true
+	}
+	rerr, ok := err.(*openapi3filter.RequestError)
+	if !ok {
+		return false
+	}
+	if rerr.Parameter != nil && errors.Is(rerr.Err, openapi3filter.ErrInvalidRequired) {
+		v.fail(rerr.Parameter.Name, "is required")
+		return true
+	}
+	schemaErrors, ok := rerr.Err.(openapi3.MultiError)
+	if !ok {
+		schemaErrors = openapi3.MultiError{rerr.Err}
+	}
+	for _, err := range schemaErrors {
+		serr, ok := err.(*openapi3.SchemaError)
+		if !ok {
+			return false
+		}
+		field := strings.Join(serr.JSONPointer(), ".")
+		if rerr.Parameter != nil {
+			field = rerr.Parameter.Name
+		}
+		message := schemaErrorMessage(serr)
+		if m := unsupportedProperty.FindStringSubmatch(serr.Reason); m != nil {
+			field, message = strings.TrimPrefix(field+"."+m[1], "."), "is not a known field"
+		}
+		// A body of the wrong type altogether is not about any of its fields
+		if field == "" {
+			return false
+		}
+		v.fail(field, message)
+	}
+	return true
+}
+
+// schemaErrorMessage phrases a schema error like the failures of the validating middleware
+func schemaErrorMessage(err *openapi3.SchemaError) string {
+	switch err.SchemaField {
+	case "required":
+		return "is required"
+	case "type":
+		switch err.Schema.Type {
+		case "array", "integer", "object":
+			return "must be an " + err.Schema.Type
+		default:
+			return "must be a " + err.Schema.Type
+		}
+	}
+	reason := err.Reason
+	if reason != "" {
+		reason = strings.ToLower(reason[:1]) + reason[1:]
+	}
+	return reason
+}
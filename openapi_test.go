@@ -0,0 +1,101 @@
+package wservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPIRoutes(t *testing.T) {
+	// Every route of the HTTP transport, and every verb it matches, must be described by the OpenAPI document
+	err := newRouter(newMemoryService(t)).Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		item := openAPI.Paths.Find(path)
+		if !assert.NotNil(t, item, "%s is missing from openapi.yaml", path) {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// The route answers any verb itself
+			return nil
+		}
+		for _, method := range methods {
+			assert.NotNil(t, item.GetOperation(method), "%s %s is missing from openapi.yaml", method, path)
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestOpenAPIValidation(t *testing.T) {
+	svc := newMemoryService(t)
+	for _, c := range []struct {
+		method, target, body string
+		fields               []FieldError
+	}{
+		{http.MethodPost, "/v1/transfers", `{"from":"bob123","to":"alice456","amount":2}`, []FieldError{{"amount", "must be a string"}}},
+		{http.MethodPost, "/submittransfer", `{"from":"bob123","to":"alice456"}`, []FieldError{{"amount", "is required"}}},
+		{http.MethodPost, "/submittransfer", `{"from":"bob123","to":"alice456","amount":"2","memo":"rent"}`, []FieldError{{"memo", "is not a known field"}}},
+		{http.MethodPost, "/webhooks", `{"url":"https://hooks.example.com","events":"transfer.completed","secret":7}`, []FieldError{{"events", "must be an array"}, {"secret", "must be a string"}}},
+		{http.MethodPost, "/webhooks", `{"url":"https://hooks.example.com","events":[1]}`, []FieldError{{"events.0", "must be a string"}}},
+		{http.MethodPatch, "/webhooks/01D6MK0GTBZ4W3K9XH8S2JQ5VN", `{"enabled":"yes"}`, []FieldError{{"enabled", "must be a boolean"}}},
+	} {
+		rec := serveHTTP(svc, c.method, c.target, c.body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, c.body)
+		var p Problem
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &p), c.body)
+		assert.Equal(t, "validation_failed", p.Code, c.body)
+		assert.Equal(t, c.target, p.Instance, c.body)
+		assert.Equal(t, c.fields, p.Errors, c.body)
+	}
+
+	// A body that is missing, not JSON or not an object is not about any field
+	for _, body := range []string{"", `{"from":"bob123"`, `[]`} {
+		rec := serveHTTP(svc, http.MethodPost, "/v1/transfers", body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+		assert.Contains(t, rec.Body.String(), `"code":"invalid_request"`, body)
+	}
+	rec := serveHTTP(svc, http.MethodPost, "/v1/transfers", `{"from":"`+strings.Repeat("a", maxRequestBodySize)+`"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	// Valid requests reach their handler with their body, whatever their Content-Type
+	rec = serveHTTP(svc, http.MethodPost, "/submittransfer", `{"from":"bob123","to":"alice456","amount":"2"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"result":"success"`)
+	// The verbs and routes the document does not describe are answered by the router
+	rec = serveHTTP(svc, http.MethodDelete, "/v1/transfers", `{"amount":2}`)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	rec = serveHTTP(svc, http.MethodGet, "/nowhere", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	svc := newMemoryService(t)
+	rec := serveHTTP(svc, http.MethodGet, "/openapi.json", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json; charset=utf-8", rec.Header().Get("Content-Type"))
+	var doc struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+	assert.Contains(t, doc.Paths, "/v1/transfers")
+
+	rec = serveHTTP(svc, http.MethodGet, "/docs", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, rec.Body.String(), `url: "/openapi.json"`)
+	rec = serveHTTP(svc, http.MethodGet, "/docs/swagger-ui-bundle.js", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "javascript")
+	rec = serveHTTP(svc, http.MethodGet, "/docs/swagger-ui.js", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,264 @@
+package wservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Every committed transfer writes an event to the outbox of the ledger in the same transaction as the transfer itself, so an event exists if and only if
+// the transfer was committed. The outbox relay then publishes the pending events in order through an EventPublisher and marks each one published once
+// the sink has accepted it. A failed event stays pending and is retried on the next run, so every event is delivered at least once: consumers must
+// expect duplicates and can drop them by sequence number
+
+// The types of the events written to the outbox
+const (
+	// EventTransferCompleted is written for every committed transfer (including reversals and interest payments), its payload is a TransferEvent
+	EventTransferCompleted = "transfer.completed"
+	// EventTransferReversed is written when a transfer is reversed, its payload is the reversed transfer
+	EventTransferReversed = "transfer.reversed"
+)
+
+// The delivery statuses of an event of the outbox
+const (
+	EventPending   = "pending"
+	EventPublished = "published"
+)
+
+// The sinks the outbox relay can publish the events to, none leaving them in the outbox
+const (
+	SinkNone   = "none"
+	SinkStdout = "stdout"
+	SinkFile   = "file"
+	SinkHTTP   = "http"
+)
+
+// outboxSinks are the values of the outbox.sink setting
+var outboxSinks = []string{SinkNone, SinkStdout, SinkFile, SinkHTTP}
+
+// Event is an event of the outbox. Only its sequence number, type, payload and creation time are published, the rest is its delivery state
+type Event struct {
+	Seq         int64           `json:"seq"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Status      string          `json:"-"`
+	Attempts    int             `json:"-"`
+	LastError   string          `json:"-"`
+	PublishedAt time.Time       `json:"-"`
+}
+
+// TransferEvent is the payload of a transfer.completed event: the transfer (without its history) together with the balances its accounts were left with
+type TransferEvent struct {
+	Transfer
+	Balances []AccountBalance `json:"balances"`
+}
+
+// AccountBalance is the balance of an account right after a transfer
+type AccountBalance struct {
+	AccountID string `json:"account_id"`
+	WalletID  string `json:"wallet_id"`
+	Currency  string `json:"currency"`
+	Balance   string `json:"balance"`
+}
+
+// emit writes an event to the outbox within the transaction of the change it reports, stamped like the transfers
+func (l ledger) emit(tx LedgerTx, eventType string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = tx.InsertEvent(Event{Type: eventType, Payload: body, CreatedAt: l.clockTime()})
+	return err
+}
+
+// EventPublisher publishes the events of the outbox to a sink. Publish returns once the sink has accepted the event, an error leaves it pending
+type EventPublisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// WriterPublisher publishes the events as JSON lines to a writer (the standard output or a file)
+type WriterPublisher struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterPublisher exported to be accessible from outside the package (from main)
+// NewWriterPublisher creates a publisher that writes the events as JSON lines to w
+func NewWriterPublisher(w io.Writer) *WriterPublisher {
+	return &WriterPublisher{w: w}
+}
+
+// Publish writes the event as a single JSON line
+func (p *WriterPublisher) Publish(ctx context.Context, e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.w.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the writer if it can be closed (a file)
+func (p *WriterPublisher) Close() error {
+	if c, ok := p.w.(io.Closer); ok && p.w != os.Stdout {
+		return c.Close()
+	}
+	return nil
+}
+
+// HTTPPublisher publishes the events by POSTing them as JSON to a URL, any answer but a 2xx being a failure
+type HTTPPublisher struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPPublisher exported to be accessible from outside the package (from main)
+// NewHTTPPublisher creates a publisher that POSTs the events to url, each request failing after timeout
+func NewHTTPPublisher(url string, timeout time.Duration) *HTTPPublisher {
+	return &HTTPPublisher{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// Publish POSTs the event, with its sequence number in the Idempotency-Key header so the receiver can drop the duplicates
+func (p *HTTPPublisher) Publish(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", strconv.FormatInt(e.Seq, 10))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("err: the event sink answered " + resp.Status)
+	}
+	return nil
+}
+
+// NewEventPublisher exported to be accessible from outside the package (from main)
+// NewEventPublisher creates the publisher of the configured sink, nil for none
+func NewEventPublisher(cfg OutboxConfig) (EventPublisher, error) {
+	switch cfg.Sink {
+	case SinkStdout:
+		return NewWriterPublisher(os.Stdout), nil
+	case SinkFile:
+		f, err := os.OpenFile(cfg.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			var ErrOpen = errors.New("err: could not open the outbox file " + cfg.Path + " ")
+			return nil, errors.New(ErrOpen.Error() + err.Error())
+		}
+		return NewWriterPublisher(f), nil
+	case SinkHTTP:
+		return NewHTTPPublisher(cfg.URL, time.Duration(cfg.Timeout)), nil
+	}
+	return nil, nil
+}
+
+// multiPublisher publishes the events to several publishers in turn
+type multiPublisher []EventPublisher
+
+// NewMultiPublisher exported to be accessible from outside the package (from main)
+// NewMultiPublisher creates a publisher that publishes every event to each of the publishers in turn. An event is only published once every publisher
+// has accepted it, so a failure makes the publishers that had already accepted it get it again on the next attempt
+func NewMultiPublisher(publishers ...EventPublisher) EventPublisher {
+	return multiPublisher(publishers)
+}
+
+// Publish publishes the event to each of the publishers, stopping at the first one that fails
+func (m multiPublisher) Publish(ctx context.Context, e Event) error {
+	for _, p := range m {
+		if err := p.Publish(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RelayEvents exported to be accessible from outside the package (from main)
+// RelayEvents publishes the pending events of the outbox of the wallet service created by NewServiceFromConfig in order, up to batchSize of them. It stops at
+// the first event the publisher fails, which is retried on the next call, so that the events are never published out of order. It returns how many were published
+func RelayEvents(ctx context.Context, svc WalletService, publisher EventPublisher, batchSize int) (int, error) {
+	store, ok := storeOf(svc)
+	if !ok {
+		var ErrNoStore = errors.New("err: only the wallet service created by NewServiceFromConfig has an outbox")
+		return 0, ErrNoStore
+	}
+	var pending []Event
+	err := store.View(func(tx LedgerTx) error {
+		var err error
+		pending, err = tx.PendingEvents(batchSize)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	// The events are published outside of any transaction, so a slow sink never holds up the transfers
+	for i, e := range pending {
+		if pubErr := publisher.Publish(ctx, e); pubErr != nil {
+			err = store.Update(func(tx LedgerTx) error { return tx.MarkEventFailed(e.Seq, pubErr.Error()) })
+			if err != nil {
+				return i, err
+			}
+			var ErrPublish = errors.New("err: could not publish event " + strconv.FormatInt(e.Seq, 10) + " ")
+			return i, errors.New(ErrPublish.Error() + pubErr.Error())
+		}
+		// If marking the event fails it is published again on the next call, hence at least once
+		if err = store.Update(func(tx LedgerTx) error { return tx.MarkEventPublished(e.Seq) }); err != nil {
+			return i, err
+		}
+	}
+	return len(pending), nil
+}
+
+// RunOutboxRelay exported to be accessible from outside the package (from main)
+// RunOutboxRelay relays the pending events of the outbox every interval, and whenever wake (nil for never) signals new events, until stop is closed.
+// A full batch is followed by the next one right away
+func RunOutboxRelay(svc WalletService, publisher EventPublisher, interval time.Duration, batchSize int, logger log.Logger, wake <-chan struct{}, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := RelayEvents(ctx, svc, publisher, batchSize)
+		if err != nil {
+			logger.Log("msg", "outbox relay failed", "published", n, "err", err)
+		}
+		if err == nil && n == batchSize {
+			select {
+			case <-stop:
+				return
+			default:
+				continue
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-wake:
+		case <-stop:
+			return
+		}
+	}
+}
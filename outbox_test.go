@@ -0,0 +1,217 @@
+package wservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// outboxEvents returns every event of the outbox of a wallet service
+func outboxEvents(t *testing.T, svc WalletService) []Event {
+	store, _ := storeOf(svc)
+	var events []Event
+	assert.Nil(t, store.View(func(tx LedgerTx) error {
+		var err error
+		events, err = tx.Events(0, 100)
+		return err
+	}))
+	return events
+}
+
+// publisherFunc turns a function into an EventPublisher
+type publisherFunc func(ctx context.Context, e Event) error
+
+func (f publisherFunc) Publish(ctx context.Context, e Event) error {
+	return f(ctx, e)
+}
+
+func TestOutboxEvents(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		transfer, err := svc.SubmitTransfer("bob123", "alice456", "2.35")
+		assert.Nil(t, err)
+		// Refused transfers and rolled back transactions leave no event
+		_, err = svc.SubmitTransfer("bob123", "alice456", "300000")
+		assert.NotNil(t, err)
+		store, _ := storeOf(svc)
+		errAbort := errors.New("abort")
+		assert.Equal(t, errAbort, store.Update(func(tx LedgerTx) error {
+			_, err := tx.InsertEvent(Event{Type: EventTransferCompleted, Payload: json.RawMessage(`{}`)})
+			assert.Nil(t, err)
+			return errAbort
+		}))
+		_, err = svc.ReverseTransfer(transfer.ID)
+		assert.Nil(t, err)
+
+		events := outboxEvents(t, svc)
+		assert.Len(t, events, 3)
+		for i, e := range events {
+			assert.Equal(t, EventPending, e.Status)
+			assert.False(t, e.CreatedAt.IsZero())
+			if i > 0 {
+				assert.True(t, e.Seq > events[i-1].Seq)
+			}
+		}
+		var completed, reversal, reversed Transfer
+		assert.Nil(t, json.Unmarshal(events[0].Payload, &completed))
+		assert.Nil(t, json.Unmarshal(events[1].Payload, &reversal))
+		assert.Nil(t, json.Unmarshal(events[2].Payload, &reversed))
+		assert.Equal(t, EventTransferCompleted, events[0].Type)
+		assert.Equal(t, transfer.ID, completed.ID)
+		assert.Equal(t, "bob123", completed.From)
+		assert.Equal(t, StatusCompleted, completed.Status)
+		assert.Nil(t, completed.History)
+		assert.Equal(t, EventTransferCompleted, events[1].Type)
+		assert.Equal(t, "alice456", reversal.From)
+		assert.Equal(t, EventTransferReversed, events[2].Type)
+		assert.Equal(t, transfer.ID, reversed.ID)
+		assert.Equal(t, StatusReversed, reversed.Status)
+		assert.Equal(t, "reversed by transfer "+reversal.ID, reversed.Reason)
+	})
+}
+
+func TestOutboxRelay(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		for i := 0; i < 3; i++ {
+			_, err := svc.DoTransfer("bob123", "alice456", "1")
+			assert.Nil(t, err)
+		}
+		var out bytes.Buffer
+		n, err := RelayEvents(context.Background(), svc, NewWriterPublisher(&out), 2)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, n)
+		n, err = RelayEvents(context.Background(), svc, NewWriterPublisher(&out), 2)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+		n, err = RelayEvents(context.Background(), svc, NewWriterPublisher(&out), 2)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		assert.Len(t, lines, 3)
+		var published Event
+		assert.Nil(t, json.Unmarshal([]byte(lines[2]), &published))
+		events := outboxEvents(t, svc)
+		assert.Equal(t, events[2].Seq, published.Seq)
+		assert.Equal(t, EventTransferCompleted, published.Type)
+		assert.Contains(t, string(published.Payload), `"from":"bob123"`)
+		assert.NotContains(t, lines[2], "attempts")
+		for _, e := range events {
+			assert.Equal(t, EventPublished, e.Status)
+			assert.Equal(t, 1, e.Attempts)
+			assert.False(t, e.PublishedAt.IsZero())
+		}
+	})
+}
+
+func TestOutboxRelayRetry(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		for i := 0; i < 2; i++ {
+			_, err := svc.DoTransfer("bob123", "alice456", "1")
+			assert.Nil(t, err)
+		}
+		// The sink is down: the first event stays pending with the failure recorded and the second one is not tried, to keep the order
+		var seen []int64
+		failing := publisherFunc(func(ctx context.Context, e Event) error {
+			seen = append(seen, e.Seq)
+			return errors.New("sink unavailable")
+		})
+		n, err := RelayEvents(context.Background(), svc, failing, 10)
+		assert.Equal(t, 0, n)
+		assert.Contains(t, err.Error(), "sink unavailable")
+		assert.Len(t, seen, 1)
+		events := outboxEvents(t, svc)
+		assert.Equal(t, EventPending, events[0].Status)
+		assert.Equal(t, 1, events[0].Attempts)
+		assert.Equal(t, "sink unavailable", events[0].LastError)
+		assert.Equal(t, 0, events[1].Attempts)
+
+		// Once the sink is back both are published, the first one on its second attempt
+		n, err = RelayEvents(context.Background(), svc, publisherFunc(func(ctx context.Context, e Event) error { return nil }), 10)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, n)
+		events = outboxEvents(t, svc)
+		assert.Equal(t, EventPublished, events[0].Status)
+		assert.Equal(t, 2, events[0].Attempts)
+	})
+}
+
+func TestRunOutboxRelay(t *testing.T) {
+	svc := newMemoryService(t)
+	published := make(chan Event, 10)
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		RunOutboxRelay(svc, publisherFunc(func(ctx context.Context, e Event) error {
+			published <- e
+			return nil
+		}), 10*time.Millisecond, 100, log.NewNopLogger(), nil, stop)
+		close(done)
+	}()
+	_, err := svc.DoTransfer("bob123", "alice456", "1")
+	assert.Nil(t, err)
+	select {
+	case e := <-published:
+		assert.Equal(t, EventTransferCompleted, e.Type)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event was not relayed")
+	}
+	close(stop)
+	<-done
+}
+
+func TestRunOutboxRelayWake(t *testing.T) {
+	svc := newMemoryService(t)
+	published := make(chan Event, 10)
+	wake, stop, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		RunOutboxRelay(svc, publisherFunc(func(ctx context.Context, e Event) error {
+			published <- e
+			return nil
+		}), time.Hour, 100, log.NewNopLogger(), wake, stop)
+		close(done)
+	}()
+	// The relay runs once on start and then waits for its interval, unless it is woken up
+	_, err := svc.DoTransfer("bob123", "alice456", "1")
+	assert.Nil(t, err)
+	wake <- struct{}{}
+	select {
+	case e := <-published:
+		assert.Equal(t, EventTransferCompleted, e.Type)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event was not relayed once the relay was woken up")
+	}
+	close(stop)
+	<-done
+}
+
+func TestHTTPPublisher(t *testing.T) {
+	status := http.StatusNoContent
+	var received []*http.Request
+	var bodies []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received, bodies = append(received, r), append(bodies, string(body))
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	p := NewHTTPPublisher(receiver.URL, time.Second)
+	e := Event{Seq: 7, Type: EventTransferCompleted, Payload: json.RawMessage(`{"id":"01ARZ3NDEKTSV4RRFFQ69G5FAV"}`), CreatedAt: time.Now()}
+	assert.Nil(t, p.Publish(context.Background(), e))
+	assert.Equal(t, http.MethodPost, received[0].Method)
+	assert.Equal(t, "application/json", received[0].Header.Get("Content-Type"))
+	assert.Equal(t, "7", received[0].Header.Get("Idempotency-Key"))
+	assert.Contains(t, bodies[0], `"type":"transfer.completed","payload":{"id":"01ARZ3NDEKTSV4RRFFQ69G5FAV"}`)
+
+	status = http.StatusServiceUnavailable
+	assert.EqualError(t, p.Publish(context.Background(), e), "err: the event sink answered 503 Service Unavailable")
+}
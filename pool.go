@@ -0,0 +1,116 @@
+package wservice
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// The wallet service shares one pool of database connections between all its requests instead of connecting for every request. The pool is opened on
+// first use and sized by the database settings of the configuration, which can be changed while it is in use
+
+// poolSettings are the sizing settings of the connection pool, as configured in DatabaseConfig
+type poolSettings struct {
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+}
+
+// connPool is the connection pool of a wallet service, shared by all the copies of its sqlDBTx
+type connPool struct {
+	mu       sync.Mutex
+	db       *sqlx.DB
+	settings poolSettings
+	closed   bool
+}
+
+// get returns the pool, opening it with open the first time
+func (p *connPool) get(open func() (*sqlx.DB, error)) (*sqlx.DB, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrShuttingDown
+	}
+	if p.db != nil {
+		return p.db, nil
+	}
+	db, err := open()
+	if err != nil {
+		return nil, err
+	}
+	p.db = db
+	p.apply()
+	return p.db, nil
+}
+
+// configure changes the sizing settings of the pool, the connections in use are not interrupted
+func (p *connPool) configure(settings poolSettings) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.settings = settings
+	p.apply()
+}
+
+// close closes the pool for good, it waits for the queries that have started to finish
+func (p *connPool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	if p.db == nil {
+		return nil
+	}
+	err := p.db.Close()
+	p.db = nil
+	return err
+}
+
+// apply sets the sizing settings on the open pool, if any. The caller holds the lock
+func (p *connPool) apply() {
+	if p.db == nil {
+		return
+	}
+	p.db.SetMaxOpenConns(p.settings.maxOpenConns)
+	p.db.SetMaxIdleConns(p.settings.maxIdleConns)
+	p.db.SetConnMaxLifetime(p.settings.connMaxLifetime)
+}
+
+// openDB returns the connection pool of the wallet service, opening it on first use
+func (s sqlDBTx) openDB() (*sqlx.DB, error) {
+	if s.pool == nil {
+		var ErrNoPool = errors.New("err: the wallet service was not created from a configuration")
+		return nil, ErrNoPool
+	}
+	return s.pool.get(s.newDB)
+}
+
+// ConfigurePool exported to be accessible from outside the package (from main)
+// ConfigurePool applies the pool sizing settings of a new configuration to the wallet service created by NewServiceFromConfig
+func ConfigurePool(svc WalletService, db DatabaseConfig) error {
+	store, _ := storeOf(svc)
+	switch s := store.(type) {
+	case sqlDBTx:
+		if s.pool != nil {
+			s.pool.configure(db.poolSettings())
+			return nil
+		}
+	case sqliteStore:
+		// Only the read only connections can be resized, the writes always go through a single connection
+		s.reader.configure(db.poolSettings())
+		return nil
+	}
+	var ErrNoDB = errors.New("err: the connection pool can only be configured for the wallet service kept in a database")
+	return ErrNoDB
+}
+
+// ClosePool exported to be accessible from outside the package (from main)
+// ClosePool closes the store of the wallet service created by NewServiceFromConfig on shutdown (the connection pool of Postgres), after which every call fails with ErrShuttingDown
+func ClosePool(svc WalletService) error {
+	store, ok := storeOf(svc)
+	if !ok {
+		var ErrNoStore = errors.New("err: only the wallet service created by NewServiceFromConfig has a store to close")
+		return ErrNoStore
+	}
+	return store.Close()
+}
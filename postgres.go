@@ -0,0 +1,773 @@
+package wservice
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// sqlDBTx keeps the ledger in Postgres. Every write transaction is serializable and locks the Accounts table so that several instances of the server
+// can run transfers against the same database, and is retried from the start when Postgres aborts it because of a concurrent one
+
+// errRetryTx signals that a transaction ran into a serialization conflict with a concurrent one and has to be retried from the start
+var errRetryTx = errors.New("err: could not serialize access, retrying transaction")
+
+// isSerializationFailure reports whether the error is Postgres aborting a transaction because of a concurrent serializable transaction
+func isSerializationFailure(err error) bool {
+	return strings.Contains(err.Error(), "could not serialize access due to")
+}
+
+// pgError classifies the error of a statement for the ledger: no rows is errNotFound, a serialization failure retries the transaction and anything else is unexpected
+func pgError(err error) error {
+	if err == sql.ErrNoRows {
+		return errNotFound
+	}
+	if isSerializationFailure(err) {
+		log.Println(err, "...continuing...")
+		return errRetryTx
+	}
+	var ErrUnexp = errors.New("err: Unexpected error occurred")
+	return dbError(ErrUnexp, err)
+}
+
+// Update is a sqlDBTx type method that runs fn in a serializable transaction, with the Accounts table locked, until it is committed
+func (s sqlDBTx) Update(fn func(LedgerTx) error) error {
+	// Based on the information contained on a sqlDBTx struct created with the "NewServiceFromConfig" function a connection is taken from the pool
+	db, err := s.openDB()
+	// If any error, return it to parent function
+	if err != nil {
+		return err
+	}
+
+	// While the transaction we are about to execute is not committed we will retry it until successful
+	var isCommitted = false
+	for ok := true; ok; ok = !isCommitted {
+		// Start a transaction against the Postgres db
+		// If at anypoint between the "begin" and "commit" there is any kind of issue all changes to the db will be reverted
+		tx, err := db.Begin()
+		if err != nil {
+			var ErrStartTx = errors.New("err: error beginning transaction in postgres")
+			cErr := newError(ErrUnavailable, ErrStartTx.Error()+err.Error())
+			return cErr
+		}
+
+		// Set the transaction ISOLATION LEVEL to "Serializable" to allow for multiple instances of the server to run transactions against the same Postgres db
+		if _, err = tx.Exec(`set transaction isolation level serializable`); err != nil {
+			tx.Rollback()
+			return err
+		}
+		// Set a table lock so we exclude any type of conflicts that could generate data corruption, if an error occurs we retry the transaction
+		if _, err = tx.Exec("LOCK TABLE " + s.accounts() + " IN SHARE ROW EXCLUSIVE MODE;"); err != nil {
+			tx.Rollback()
+			log.Println(err, "...continuing...")
+			continue
+		}
+
+		err = fn(pgTx{s: s, tx: tx})
+		if err == errRetryTx {
+			tx.Rollback()
+			continue
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		// If we've gotten this far without any errors we can commit our transaction and break out of the transaction loop
+		if err = tx.Commit(); err != nil {
+			if isSerializationFailure(err) {
+				continue
+			}
+			return err
+		}
+		isCommitted = true
+	}
+	return nil
+}
+
+// View is a sqlDBTx type method that runs fn in a read only transaction that reads a single snapshot of the database
+func (s sqlDBTx) View(fn func(LedgerTx) error) error {
+	db, err := s.openDB()
+	// If any error, return it to parent function
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		var ErrStartTx = errors.New("err: error beginning transaction in postgres")
+		return newError(ErrUnavailable, ErrStartTx.Error()+err.Error())
+	}
+	defer tx.Rollback()
+	// Reading from a repeatable read snapshot never conflicts with the write transactions, so there is nothing to retry
+	if _, err = tx.Exec(`set transaction isolation level repeatable read read only`); err != nil {
+		return err
+	}
+	if err = fn(pgTx{s: s, tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close is a sqlDBTx type method that closes the connection pool for good
+func (s sqlDBTx) Close() error {
+	if s.pool == nil {
+		var ErrNoPool = errors.New("err: the wallet service was not created from a configuration")
+		return ErrNoPool
+	}
+	return s.pool.close()
+}
+
+// pgTx is a transaction of the Postgres store
+type pgTx struct {
+	s  sqlDBTx
+	tx *sql.Tx
+}
+
+// Accounts fetches every account ordered by ID
+func (t pgTx) Accounts() ([]Account, error) {
+	return t.accounts("SELECT AccountID, WalletID, Currency, Balance, InitialBalance FROM " + t.s.accounts() + " ORDER BY AccountID;")
+}
+
+// WalletAccounts fetches the accounts of a wallet ordered by currency
+func (t pgTx) WalletAccounts(walletID string) ([]Account, error) {
+	return t.accounts("SELECT AccountID, WalletID, Currency, Balance, InitialBalance FROM "+t.s.accounts()+" WHERE WalletID = $1 ORDER BY Currency;", walletID)
+}
+
+// accounts runs a query of accounts
+func (t pgTx) accounts(query string, args ...interface{}) ([]Account, error) {
+	rows, err := t.tx.Query(query, args...)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	defer rows.Close()
+	var accounts []Account
+	for rows.Next() {
+		var a Account
+		if err := rows.Scan(&a.ID, &a.WalletID, &a.Currency, &a.Balance, &a.InitialBalance); err != nil {
+			return nil, pgError(err)
+		}
+		accounts = append(accounts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgError(err)
+	}
+	return accounts, nil
+}
+
+// Account fetches a single account
+func (t pgTx) Account(id string) (Account, error) {
+	var a Account
+	err := t.tx.QueryRow("SELECT AccountID, WalletID, Currency, Balance, InitialBalance FROM "+t.s.accounts()+" WHERE AccountID = $1;", id).
+		Scan(&a.ID, &a.WalletID, &a.Currency, &a.Balance, &a.InitialBalance)
+	if err != nil {
+		return Account{}, pgError(err)
+	}
+	return a, nil
+}
+
+// Debit subtracts an amount from the balance of an account, the check constraint of the Accounts table refuses a negative balance
+func (t pgTx) Debit(accountID string, amount string) error {
+	_, err := t.tx.Exec("UPDATE "+t.s.accounts()+" SET Balance = Balance - $1::numeric WHERE AccountID = $2;", amount, accountID)
+	if err != nil && strings.Contains(err.Error(), "violates check constraint") {
+		return errNegativeBalance
+	}
+	if err != nil {
+		return pgError(err)
+	}
+	return nil
+}
+
+// Credit adds an amount to the balance of an account
+func (t pgTx) Credit(accountID string, amount string) error {
+	if _, err := t.tx.Exec("UPDATE "+t.s.accounts()+" SET Balance = Balance + $1::numeric WHERE AccountID = $2;", amount, accountID); err != nil {
+		return pgError(err)
+	}
+	return nil
+}
+
+// Currencies fetches the whole currency registry ordered by code
+func (t pgTx) Currencies() ([]Currency, error) {
+	rows, err := t.tx.Query("SELECT Code, NumericCode, MinorUnits, Name, Enabled FROM " + t.s.currencies() + " ORDER BY Code;")
+	if err != nil {
+		return nil, pgError(err)
+	}
+	defer rows.Close()
+	var currencies []Currency
+	for rows.Next() {
+		var c Currency
+		if err := rows.Scan(&c.Code, &c.NumericCode, &c.MinorUnits, &c.Name, &c.Enabled); err != nil {
+			return nil, pgError(err)
+		}
+		currencies = append(currencies, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgError(err)
+	}
+	return currencies, nil
+}
+
+// Currency fetches a single currency of the registry
+func (t pgTx) Currency(code string) (Currency, error) {
+	var c Currency
+	err := t.tx.QueryRow("SELECT Code, NumericCode, MinorUnits, Name, Enabled FROM "+t.s.currencies()+" WHERE Code = $1;", code).
+		Scan(&c.Code, &c.NumericCode, &c.MinorUnits, &c.Name, &c.Enabled)
+	if err != nil {
+		return Currency{}, pgError(err)
+	}
+	return c, nil
+}
+
+// SetCurrencyEnabled enables or disables a currency of the registry
+func (t pgTx) SetCurrencyEnabled(code string, enabled bool) error {
+	res, err := t.tx.Exec("UPDATE "+t.s.currencies()+" SET Enabled = $1 WHERE Code = $2;", enabled, code)
+	if err != nil {
+		return pgError(err)
+	}
+	// If no row was updated the currency code is not part of the registry
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+// Transfers fetches every recorded transfer, whatever its status, ordered by legacy ID
+func (t pgTx) Transfers() ([]Transfer, error) {
+	rows, err := t.tx.Query("SELECT TransID, UID, From_Account, To_Account, Amount, Currency, TTime, Status FROM " + t.s.transfers() + " ORDER BY TransID;")
+	if err != nil {
+		return nil, pgError(err)
+	}
+	defer rows.Close()
+	var transfers []Transfer
+	for rows.Next() {
+		var tr Transfer
+		if err := rows.Scan(&tr.LegacyID, &tr.ID, &tr.From, &tr.To, &tr.Amount, &tr.Currency, &tr.Time, &tr.Status); err != nil {
+			return nil, pgError(err)
+		}
+		transfers = append(transfers, tr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgError(err)
+	}
+	return transfers, nil
+}
+
+// refColumn returns the column and value to look a transfer up by
+func refColumn(ref transferRef) (string, interface{}) {
+	if ref.uid != "" {
+		return "UID", ref.uid
+	}
+	return "TransID", ref.legacyID
+}
+
+// Transfer fetches a committed transfer with its status history
+func (t pgTx) Transfer(ref transferRef) (Transfer, error) {
+	column, key := refColumn(ref)
+	var tr Transfer
+	txString := "SELECT TransID, UID, From_Account, To_Account, Amount, Currency, TTime, Status FROM " + t.s.transfers() + " WHERE " + column + " = $1;"
+	err := t.tx.QueryRow(txString, key).Scan(&tr.LegacyID, &tr.ID, &tr.From, &tr.To, &tr.Amount, &tr.Currency, &tr.Time, &tr.Status)
+	if err != nil {
+		return Transfer{}, pgError(err)
+	}
+
+	rows, err := t.tx.Query("SELECT Status, ChangedAt, Reason FROM "+t.s.transferStatusChanges()+" WHERE TransID = $1 ORDER BY ChangedAt;", tr.LegacyID)
+	if err != nil {
+		return Transfer{}, pgError(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c TransferStatusChange
+		if err := rows.Scan(&c.Status, &c.ChangedAt, &c.Reason); err != nil {
+			return Transfer{}, pgError(err)
+		}
+		tr.History = append(tr.History, c)
+	}
+	if err := rows.Err(); err != nil {
+		return Transfer{}, pgError(err)
+	}
+	return tr, nil
+}
+
+// FailedTransfer fetches a failed transfer attempt
+func (t pgTx) FailedTransfer(ref transferRef) (Transfer, error) {
+	column, key := refColumn(ref)
+	var tr Transfer
+	txString := "SELECT TransID, UID, From_Account, To_Account, Amount, Reason, FailedAt FROM " + t.s.failedTransfers() + " WHERE " + column + " = $1;"
+	err := t.tx.QueryRow(txString, key).Scan(&tr.LegacyID, &tr.ID, &tr.From, &tr.To, &tr.Amount, &tr.Reason, &tr.Time)
+	if err != nil {
+		return Transfer{}, pgError(err)
+	}
+	return tr, nil
+}
+
+// pgTime returns the time to stamp a new row with, or nil to let the database clock stamp it
+func pgTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// InsertTransfer records a new transfer numbered by the transfer ID sequence, and its first status change
+func (t pgTx) InsertTransfer(tr Transfer) (Transfer, error) {
+	txString := "INSERT INTO " + t.s.transfers() + " (TransID, UID, From_Account, To_Account, Amount, Currency, TTime, Status) VALUES (" + t.s.nextTransferID() +
+		", $1, $2, $3, $4, $5, COALESCE($6::timestamptz, now()), $7) RETURNING TransID, TTime;"
+	err := t.tx.QueryRow(txString, tr.ID, tr.From, tr.To, tr.Amount, tr.Currency, pgTime(tr.Time), tr.Status).Scan(&tr.LegacyID, &tr.Time)
+	if err != nil {
+		return Transfer{}, pgError(err)
+	}
+	if _, err = t.tx.Exec("INSERT INTO "+t.s.transferStatusChanges()+" (TransID, Status) VALUES ($1, $2);", tr.LegacyID, tr.Status); err != nil {
+		return Transfer{}, pgError(err)
+	}
+	return tr, nil
+}
+
+// InsertFailedTransfer records a failed transfer attempt, numbered by the same sequence as the transfers
+func (t pgTx) InsertFailedTransfer(tr Transfer) (Transfer, error) {
+	txString := "INSERT INTO " + t.s.failedTransfers() + " (TransID, UID, From_Account, To_Account, Amount, Reason, FailedAt) VALUES (" + t.s.nextTransferID() +
+		", $1, $2, $3, $4, $5, COALESCE($6::timestamptz, now())) RETURNING TransID, FailedAt;"
+	if err := t.tx.QueryRow(txString, tr.ID, tr.From, tr.To, tr.Amount, tr.Reason, pgTime(tr.Time)).Scan(&tr.LegacyID, &tr.Time); err != nil {
+		return Transfer{}, pgError(err)
+	}
+	return tr, nil
+}
+
+// SetTransferStatus moves a transfer to a new status and records the change in its history
+func (t pgTx) SetTransferStatus(legacyID int64, status string, reason string) error {
+	_, err := t.tx.Exec("UPDATE "+t.s.transfers()+" SET Status = $1 WHERE TransID = $2;", status, legacyID)
+	if err == nil {
+		_, err = t.tx.Exec("INSERT INTO "+t.s.transferStatusChanges()+" (TransID, Status, Reason) VALUES ($1, $2, $3);", legacyID, status, reason)
+	}
+	if err != nil {
+		return pgError(err)
+	}
+	return nil
+}
+
+// SetInterestRate creates or changes the interest configuration of an account, the start date of a new configuration is the current date of the database
+func (t pgTx) SetInterestRate(r InterestRate) error {
+	txString := "INSERT INTO " + t.s.interestRates() + " (AccountID, AnnualRate, DayCount, ExpenseAccount) VALUES ($1, $2, $3, $4) " +
+		"ON CONFLICT (AccountID) DO UPDATE SET AnnualRate = EXCLUDED.AnnualRate, DayCount = EXCLUDED.DayCount, ExpenseAccount = EXCLUDED.ExpenseAccount;"
+	if _, err := t.tx.Exec(txString, r.AccountID, r.AnnualRate, r.DayCount, r.ExpenseAccount); err != nil {
+		return pgError(err)
+	}
+	return nil
+}
+
+// InterestRate fetches the interest configuration of an account
+func (t pgTx) InterestRate(accountID string) (InterestRate, error) {
+	var r InterestRate
+	err := t.tx.QueryRow("SELECT AccountID, AnnualRate, DayCount, ExpenseAccount, StartDate FROM "+t.s.interestRates()+" WHERE AccountID = $1;", accountID).
+		Scan(&r.AccountID, &r.AnnualRate, &r.DayCount, &r.ExpenseAccount, &r.StartDate)
+	if err != nil {
+		return InterestRate{}, pgError(err)
+	}
+	return r, nil
+}
+
+// InterestRates fetches the interest configuration of every account
+func (t pgTx) InterestRates() ([]InterestRate, error) {
+	rows, err := t.tx.Query("SELECT AccountID, AnnualRate, DayCount, ExpenseAccount, StartDate FROM " + t.s.interestRates() + " ORDER BY AccountID;")
+	if err != nil {
+		return nil, pgError(err)
+	}
+	defer rows.Close()
+	var rates []InterestRate
+	for rows.Next() {
+		var r InterestRate
+		if err := rows.Scan(&r.AccountID, &r.AnnualRate, &r.DayCount, &r.ExpenseAccount, &r.StartDate); err != nil {
+			return nil, pgError(err)
+		}
+		rates = append(rates, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgError(err)
+	}
+	return rates, nil
+}
+
+// LastAccrualDate fetches the last day an account accrued interest for
+func (t pgTx) LastAccrualDate(accountID string) (time.Time, error) {
+	var last sql.NullTime
+	if err := t.tx.QueryRow("SELECT MAX(AccrualDate) FROM "+t.s.interestAccruals()+" WHERE AccountID = $1;", accountID).Scan(&last); err != nil {
+		return time.Time{}, pgError(err)
+	}
+	return last.Time, nil
+}
+
+// BalanceAt rebuilds the balance of an account at a time from its current balance by reverting the transfers committed since
+func (t pgTx) BalanceAt(accountID string, at time.Time) (string, error) {
+	var balance string
+	txString := "SELECT Balance - COALESCE((SELECT SUM(CASE WHEN To_Account = $1 THEN Amount ELSE -Amount END) FROM " + t.s.transfers() +
+		" WHERE (From_Account = $1 OR To_Account = $1) AND TTime >= $2 AND Status IN ('completed', 'reversed')), 0) FROM " + t.s.accounts() + " WHERE AccountID = $1;"
+	if err := t.tx.QueryRow(txString, accountID, at).Scan(&balance); err != nil {
+		return "", pgError(err)
+	}
+	return balance, nil
+}
+
+// InsertAccrual records the interest accrued by an account on a day, a day that was already accrued is left as it is
+func (t pgTx) InsertAccrual(a InterestAccrual) error {
+	txString := "INSERT INTO " + t.s.interestAccruals() + " (AccountID, AccrualDate, Balance, AnnualRate, Amount) VALUES ($1, $2, $3, $4, $5) " +
+		"ON CONFLICT (AccountID, AccrualDate) DO NOTHING;"
+	if _, err := t.tx.Exec(txString, a.AccountID, a.Date.Format(dateLayout), a.Balance, a.AnnualRate, a.Amount); err != nil {
+		return pgError(err)
+	}
+	return nil
+}
+
+// UnpostedAccounts fetches the accounts with interest accrued between from and until that was not posted yet
+func (t pgTx) UnpostedAccounts(from time.Time, until time.Time) ([]string, error) {
+	rows, err := t.tx.Query("SELECT DISTINCT AccountID FROM "+t.s.interestAccruals()+" WHERE NOT Posted AND AccrualDate >= $1 AND AccrualDate < $2 ORDER BY AccountID;", from, until)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	defer rows.Close()
+	var accounts []string
+	for rows.Next() {
+		var accountID string
+		if err := rows.Scan(&accountID); err != nil {
+			return nil, pgError(err)
+		}
+		accounts = append(accounts, accountID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgError(err)
+	}
+	return accounts, nil
+}
+
+// UnpostedInterest sums the interest accrued by an account between from and until that was not posted yet
+func (t pgTx) UnpostedInterest(accountID string, from time.Time, until time.Time) (string, error) {
+	var days int
+	var total string
+	txString := "SELECT COUNT(*), COALESCE(SUM(Amount), 0) FROM " + t.s.interestAccruals() + " WHERE AccountID = $1 AND NOT Posted AND AccrualDate >= $2 AND AccrualDate < $3;"
+	if err := t.tx.QueryRow(txString, accountID, from, until).Scan(&days, &total); err != nil {
+		return "", pgError(err)
+	}
+	if days == 0 {
+		return "", errNotFound
+	}
+	return total, nil
+}
+
+// FirstUnpostedAccrual fetches the first day before until whose interest was accrued but not posted yet
+func (t pgTx) FirstUnpostedAccrual(until time.Time) (time.Time, error) {
+	var first sql.NullTime
+	if err := t.tx.QueryRow("SELECT MIN(AccrualDate) FROM "+t.s.interestAccruals()+" WHERE NOT Posted AND AccrualDate < $1;", until).Scan(&first); err != nil {
+		return time.Time{}, pgError(err)
+	}
+	return first.Time, nil
+}
+
+// MarkInterestPosted marks the interest accrued by an account between from and until as posted by a transfer
+func (t pgTx) MarkInterestPosted(accountID string, from time.Time, until time.Time, transferID int64) error {
+	postedTransfer := sql.NullInt64{Int64: transferID, Valid: transferID != 0}
+	txString := "UPDATE " + t.s.interestAccruals() + " SET Posted = true, PostedTransfer = $1 WHERE AccountID = $2 AND NOT Posted AND AccrualDate >= $3 AND AccrualDate < $4;"
+	if _, err := t.tx.Exec(txString, postedTransfer, accountID, from, until); err != nil {
+		return pgError(err)
+	}
+	return nil
+}
+
+// eventColumns are the columns of an event of the outbox as events reads them
+const eventColumns = "Seq, EventType, Payload, CreatedAt, Status, Attempts, LastError, PublishedAt"
+
+// InsertEvent writes a pending event to the outbox, numbered by its serial column
+func (t pgTx) InsertEvent(e Event) (Event, error) {
+	txString := "INSERT INTO " + t.s.outbox() + " (EventType, Payload, CreatedAt) VALUES ($1, $2::jsonb, COALESCE($3::timestamptz, now())) RETURNING Seq, CreatedAt;"
+	if err := t.tx.QueryRow(txString, e.Type, string(e.Payload), pgTime(e.CreatedAt)).Scan(&e.Seq, &e.CreatedAt); err != nil {
+		return Event{}, pgError(err)
+	}
+	e.Status = EventPending
+	return e, nil
+}
+
+// PendingEvents fetches the oldest pending events of the outbox
+func (t pgTx) PendingEvents(limit int) ([]Event, error) {
+	return t.events("SELECT "+eventColumns+" FROM "+t.s.outbox()+" WHERE Status = $1 ORDER BY Seq LIMIT $2;", EventPending, limit)
+}
+
+// Events fetches the events of the outbox that follow a sequence number
+func (t pgTx) Events(after int64, limit int) ([]Event, error) {
+	return t.events("SELECT "+eventColumns+" FROM "+t.s.outbox()+" WHERE Seq > $1 ORDER BY Seq LIMIT $2;", after, limit)
+}
+
+// LastEventSeq fetches the sequence number of the last event of the outbox
+func (t pgTx) LastEventSeq() (int64, error) {
+	var seq int64
+	if err := t.tx.QueryRow("SELECT COALESCE(MAX(Seq), 0) FROM " + t.s.outbox() + ";").Scan(&seq); err != nil {
+		return 0, pgError(err)
+	}
+	return seq, nil
+}
+
+// Notify sends a NOTIFY with the ID of a transfer on a channel, which Postgres only delivers once the transaction is committed
+func (t pgTx) Notify(channel string, transferID string) error {
+	if _, err := t.tx.Exec("SELECT pg_notify($1, $2);", channel, transferID); err != nil {
+		return pgError(err)
+	}
+	return nil
+}
+
+// events runs a query of events
+func (t pgTx) events(query string, args ...interface{}) ([]Event, error) {
+	rows, err := t.tx.Query(query, args...)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	defer rows.Close()
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var payload []byte
+		var publishedAt sql.NullTime
+		if err := rows.Scan(&e.Seq, &e.Type, &payload, &e.CreatedAt, &e.Status, &e.Attempts, &e.LastError, &publishedAt); err != nil {
+			return nil, pgError(err)
+		}
+		e.Payload, e.PublishedAt = payload, publishedAt.Time
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgError(err)
+	}
+	return events, nil
+}
+
+// MarkEventPublished marks an event of the outbox as published
+func (t pgTx) MarkEventPublished(seq int64) error {
+	txString := "UPDATE " + t.s.outbox() + " SET Status = $1, Attempts = Attempts + 1, PublishedAt = now() WHERE Seq = $2;"
+	if _, err := t.tx.Exec(txString, EventPublished, seq); err != nil {
+		return pgError(err)
+	}
+	return nil
+}
+
+// MarkEventFailed records a failed attempt to publish an event of the outbox
+func (t pgTx) MarkEventFailed(seq int64, reason string) error {
+	if _, err := t.tx.Exec("UPDATE "+t.s.outbox()+" SET Attempts = Attempts + 1, LastError = $1 WHERE Seq = $2;", reason, seq); err != nil {
+		return pgError(err)
+	}
+	return nil
+}
+
+// webhookColumns are the columns of a webhook as webhooks reads them
+const webhookColumns = "ID, URL, Events, Secret, Enabled, Failures, CreatedAt"
+
+// InsertWebhook records a new webhook, its event types being kept as a comma separated list
+func (t pgTx) InsertWebhook(w Webhook) (Webhook, error) {
+	txString := "INSERT INTO " + t.s.webhooks() + " (ID, URL, Events, Secret, Enabled, CreatedAt) VALUES ($1, $2, $3, $4, $5, COALESCE($6::timestamptz, now())) RETURNING CreatedAt;"
+	if err := t.tx.QueryRow(txString, w.ID, w.URL, strings.Join(w.Events, ","), w.Secret, w.Enabled, pgTime(w.CreatedAt)).Scan(&w.CreatedAt); err != nil {
+		return Webhook{}, pgError(err)
+	}
+	return w, nil
+}
+
+// Webhooks fetches every webhook ordered by ID
+func (t pgTx) Webhooks() ([]Webhook, error) {
+	return t.webhooks("SELECT " + webhookColumns + " FROM " + t.s.webhooks() + " ORDER BY ID;")
+}
+
+// Webhook fetches a single webhook
+func (t pgTx) Webhook(id string) (Webhook, error) {
+	webhooks, err := t.webhooks("SELECT "+webhookColumns+" FROM "+t.s.webhooks()+" WHERE ID = $1;", id)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if len(webhooks) == 0 {
+		return Webhook{}, errNotFound
+	}
+	return webhooks[0], nil
+}
+
+// webhooks runs a query of webhooks
+func (t pgTx) webhooks(query string, args ...interface{}) ([]Webhook, error) {
+	rows, err := t.tx.Query(query, args...)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	defer rows.Close()
+	var webhooks []Webhook
+	for rows.Next() {
+		var w Webhook
+		var events string
+		if err := rows.Scan(&w.ID, &w.URL, &events, &w.Secret, &w.Enabled, &w.Failures, &w.CreatedAt); err != nil {
+			return nil, pgError(err)
+		}
+		w.Events = strings.Split(events, ",")
+		webhooks = append(webhooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgError(err)
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook changes whether a webhook is enabled and its count of consecutive failures
+func (t pgTx) UpdateWebhook(w Webhook) error {
+	return t.exec("UPDATE "+t.s.webhooks()+" SET Enabled = $1, Failures = $2 WHERE ID = $3;", w.Enabled, w.Failures, w.ID)
+}
+
+// DeleteWebhook deletes a webhook, its deliveries going with it through the cascading foreign key
+func (t pgTx) DeleteWebhook(id string) error {
+	return t.exec("DELETE FROM "+t.s.webhooks()+" WHERE ID = $1;", id)
+}
+
+// exec runs a statement that changes a single row, errNotFound when there is no such row
+func (t pgTx) exec(query string, args ...interface{}) error {
+	res, err := t.tx.Exec(query, args...)
+	if err != nil {
+		return pgError(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return pgError(err)
+	} else if n == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+// deliveryColumns are the columns of a webhook delivery as deliveries reads them
+const deliveryColumns = "ID, WebhookID, EventSeq, EventType, Payload, Status, Attempts, ResponseCode, LastError, NextAttempt, DeliveredAt, CreatedAt"
+
+// InsertDelivery queues a pending delivery of an event to a webhook, the unique constraint on the webhook and event leaving an event already queued as it is
+func (t pgTx) InsertDelivery(d WebhookDelivery) error {
+	txString := "INSERT INTO " + t.s.webhookDeliveries() + " (WebhookID, EventSeq, EventType, Payload, NextAttempt) VALUES ($1, $2, $3, $4::jsonb, COALESCE($5::timestamptz, now())) " +
+		"ON CONFLICT (WebhookID, EventSeq) DO NOTHING;"
+	if _, err := t.tx.Exec(txString, d.WebhookID, d.EventSeq, d.EventType, string(d.Payload), pgTime(d.NextAttempt)); err != nil {
+		return pgError(err)
+	}
+	return nil
+}
+
+// DueDeliveries fetches the pending deliveries of enabled webhooks that are due, the most overdue first
+func (t pgTx) DueDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	txString := "SELECT d." + strings.Replace(deliveryColumns, ", ", ", d.", -1) + " FROM " + t.s.webhookDeliveries() + " d JOIN " + t.s.webhooks() + " w ON w.ID = d.WebhookID " +
+		"WHERE d.Status = $1 AND d.NextAttempt <= $2 AND w.Enabled ORDER BY d.NextAttempt, d.ID LIMIT $3;"
+	return t.deliveries(txString, DeliveryPending, now, limit)
+}
+
+// Deliveries fetches the most recent deliveries of a webhook
+func (t pgTx) Deliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	return t.deliveries("SELECT "+deliveryColumns+" FROM "+t.s.webhookDeliveries()+" WHERE WebhookID = $1 ORDER BY ID DESC LIMIT $2;", webhookID, limit)
+}
+
+// Delivery fetches a single delivery
+func (t pgTx) Delivery(id int64) (WebhookDelivery, error) {
+	deliveries, err := t.deliveries("SELECT "+deliveryColumns+" FROM "+t.s.webhookDeliveries()+" WHERE ID = $1;", id)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	if len(deliveries) == 0 {
+		return WebhookDelivery{}, errNotFound
+	}
+	return deliveries[0], nil
+}
+
+// deliveries runs a query of webhook deliveries
+func (t pgTx) deliveries(query string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := t.tx.Query(query, args...)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	defer rows.Close()
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		var payload []byte
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventSeq, &d.EventType, &payload, &d.Status, &d.Attempts, &d.ResponseCode, &d.LastError, &d.NextAttempt, &deliveredAt, &d.CreatedAt); err != nil {
+			return nil, pgError(err)
+		}
+		d.Payload = payload
+		if deliveredAt.Valid {
+			d.DeliveredAt = &deliveredAt.Time
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgError(err)
+	}
+	return deliveries, nil
+}
+
+// UpdateDelivery records the status, attempts, outcome of the last attempt and next attempt of a delivery
+func (t pgTx) UpdateDelivery(d WebhookDelivery) error {
+	var deliveredAt sql.NullTime
+	if d.DeliveredAt != nil {
+		deliveredAt = sql.NullTime{Time: *d.DeliveredAt, Valid: true}
+	}
+	txString := "UPDATE " + t.s.webhookDeliveries() + " SET Status = $1, Attempts = $2, ResponseCode = $3, LastError = $4, NextAttempt = $5, DeliveredAt = $6 WHERE ID = $7;"
+	return t.exec(txString, d.Status, d.Attempts, d.ResponseCode, d.LastError, d.NextAttempt, deliveredAt, d.ID)
+}
+
+// apiKeyColumns are the columns of an API key as apiKeys reads them
+const apiKeyColumns = "ID, Name, Scopes, Hash, CreatedAt, ExpiresAt, RevokedAt"
+
+// InsertAPIKey records a new API key with the hash of its secret, its scopes being kept as a comma separated list
+func (t pgTx) InsertAPIKey(k APIKey) (APIKey, error) {
+	txString := "INSERT INTO " + t.s.apiKeys() + " (ID, Name, Scopes, Hash, CreatedAt) VALUES ($1, $2, $3, $4, COALESCE($5::timestamptz, now())) RETURNING CreatedAt;"
+	if err := t.tx.QueryRow(txString, k.ID, k.Name, strings.Join(k.Scopes, ","), k.Hash, pgTime(k.CreatedAt)).Scan(&k.CreatedAt); err != nil {
+		return APIKey{}, pgError(err)
+	}
+	return k, nil
+}
+
+// APIKeys fetches every API key ordered by ID
+func (t pgTx) APIKeys() ([]APIKey, error) {
+	return t.apiKeys("SELECT " + apiKeyColumns + " FROM " + t.s.apiKeys() + " ORDER BY ID;")
+}
+
+// APIKey fetches a single API key
+func (t pgTx) APIKey(id string) (APIKey, error) {
+	keys, err := t.apiKeys("SELECT "+apiKeyColumns+" FROM "+t.s.apiKeys()+" WHERE ID = $1;", id)
+	if err != nil {
+		return APIKey{}, err
+	}
+	if len(keys) == 0 {
+		return APIKey{}, errNotFound
+	}
+	return keys[0], nil
+}
+
+// apiKeys runs a query of API keys
+func (t pgTx) apiKeys(query string, args ...interface{}) ([]APIKey, error) {
+	rows, err := t.tx.Query(query, args...)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	defer rows.Close()
+	var keys []APIKey
+	for rows.Next() {
+		var k APIKey
+		var scopes string
+		var expiresAt, revokedAt sql.NullTime
+		if err := rows.Scan(&k.ID, &k.Name, &scopes, &k.Hash, &k.CreatedAt, &expiresAt, &revokedAt); err != nil {
+			return nil, pgError(err)
+		}
+		k.Scopes = strings.Split(scopes, ",")
+		if expiresAt.Valid {
+			k.ExpiresAt = &expiresAt.Time
+		}
+		if revokedAt.Valid {
+			k.RevokedAt = &revokedAt.Time
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgError(err)
+	}
+	return keys, nil
+}
+
+// UpdateAPIKey records when an API key expires and when it was revoked
+func (t pgTx) UpdateAPIKey(k APIKey) error {
+	var expiresAt, revokedAt sql.NullTime
+	if k.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: *k.ExpiresAt, Valid: true}
+	}
+	if k.RevokedAt != nil {
+		revokedAt = sql.NullTime{Time: *k.RevokedAt, Valid: true}
+	}
+	return t.exec("UPDATE "+t.s.apiKeys()+" SET ExpiresAt = $1, RevokedAt = $2 WHERE ID = $3;", expiresAt, revokedAt, k.ID)
+}
@@ -0,0 +1,193 @@
+package wservice
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// The configuration can be reloaded while the service runs, on SIGHUP or when the configuration file changes. The new configuration is validated first and
+// refused as a whole if it is invalid. Otherwise the settings below are applied to the running service without dropping any request, and the other
+// changes (the port, the database connection or the names of the tables) are only logged, as they take a restart to apply
+
+// reloadableSettings are the settings that can change while the service runs
+var reloadableSettings = map[string]bool{
+	"log_level":                  true,
+	"interest_interval":          true,
+	"max_request_body":           true,
+	"watch_interval":             true,
+	"drain_timeout":              true,
+	"readiness_cache":            true,
+	"database.max_open_conns":    true,
+	"database.max_idle_conns":    true,
+	"database.conn_max_lifetime": true,
+}
+
+// ConfigChange is a setting that differs between two configurations, with both values (secrets masked) and whether it is applied without a restart
+type ConfigChange struct {
+	Setting    string
+	Old        string
+	New        string
+	Reloadable bool
+}
+
+// DiffConfig returns the settings that differ between two configurations, in the order of the configuration
+func DiffConfig(old Config, new Config) []ConfigChange {
+	var changes []ConfigChange
+	diffSettings(reflect.ValueOf(old), reflect.ValueOf(new), reflect.ValueOf(old.Redacted()), reflect.ValueOf(new.Redacted()), "", &changes)
+	return changes
+}
+
+// diffSettings walks two configuration structs side by side, the values are compared as they are and shown redacted
+func diffSettings(old reflect.Value, new reflect.Value, oldShown reflect.Value, newShown reflect.Value, path string, changes *[]ConfigChange) {
+	t := old.Type()
+	for i := 0; i < t.NumField(); i++ {
+		setting := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if path != "" {
+			setting = path + "." + setting
+		}
+		if old.Field(i).Kind() == reflect.Struct {
+			diffSettings(old.Field(i), new.Field(i), oldShown.Field(i), newShown.Field(i), setting, changes)
+			continue
+		}
+		if old.Field(i).Interface() == new.Field(i).Interface() {
+			continue
+		}
+		*changes = append(*changes, ConfigChange{
+			Setting:    setting,
+			Old:        settingString(oldShown.Field(i)),
+			New:        settingString(newShown.Field(i)),
+			Reloadable: reloadableSettings[setting],
+		})
+	}
+}
+
+// settingString formats a setting the way it is written in the configuration
+func settingString(v reflect.Value) string {
+	if d, ok := v.Interface().(Duration); ok {
+		return time.Duration(d).String()
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// applyReloadable returns the current configuration with the reloadable settings of the new one
+func applyReloadable(current Config, next Config) Config {
+	current.LogLevel = next.LogLevel
+	current.InterestInterval = next.InterestInterval
+	current.MaxRequestBody = next.MaxRequestBody
+	current.WatchInterval = next.WatchInterval
+	current.DrainTimeout = next.DrainTimeout
+	current.ReadinessCache = next.ReadinessCache
+	current.Database.MaxOpenConns = next.Database.MaxOpenConns
+	current.Database.MaxIdleConns = next.Database.MaxIdleConns
+	current.Database.ConnMaxLifetime = next.Database.ConnMaxLifetime
+	return current
+}
+
+// Reloader holds the configuration the service runs with and reloads it, handing the reloadable settings to the parts of the service registered with OnReload
+type Reloader struct {
+	mu      sync.Mutex
+	current Config
+	load    func() (Config, error)
+	hooks   []func(Config)
+	logger  log.Logger
+}
+
+// NewReloader exported to be accessible from outside the package (from main)
+// NewReloader takes the configuration the service started with and the function that builds the configuration again (e.g. ConfigLoader.Load)
+func NewReloader(cfg Config, load func() (Config, error), logger log.Logger) *Reloader {
+	return &Reloader{current: cfg, load: load, logger: logger}
+}
+
+// Config returns the configuration the service currently runs with
+func (r *Reloader) Config() Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// OnReload registers a function that applies the configuration to a part of the service, it is called after every successful reload
+func (r *Reloader) OnReload(apply func(Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, apply)
+}
+
+// Reload builds the configuration again and applies its reloadable settings. An invalid configuration is refused and the current one is kept
+func (r *Reloader) Reload() error {
+	next, err := r.load()
+	if err == nil {
+		err = next.Validate()
+	}
+	if err != nil {
+		level.Error(r.logger).Log("msg", "configuration reload refused, keeping the current configuration", "err", err)
+		return err
+	}
+
+	// Reloads are serialised, so every hook sees the configurations in the order they were loaded
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changes := DiffConfig(r.current, next)
+	if len(changes) == 0 {
+		level.Info(r.logger).Log("msg", "configuration reloaded, nothing changed")
+		return nil
+	}
+	for _, c := range changes {
+		if c.Reloadable {
+			level.Info(r.logger).Log("msg", "setting changed", "setting", c.Setting, "old", c.Old, "new", c.New)
+		} else {
+			level.Warn(r.logger).Log("msg", "setting changed, it takes a restart to apply", "setting", c.Setting, "old", c.Old, "new", c.New)
+		}
+	}
+	r.current = applyReloadable(r.current, next)
+	for _, apply := range r.hooks {
+		apply(r.current)
+	}
+	return nil
+}
+
+// Watch reloads the configuration every time a signal is received, and when the modification time of the configuration file changes if the watch_interval
+// setting is positive, until stop is closed
+func (r *Reloader) Watch(signals <-chan os.Signal, fileName string, stop <-chan struct{}) {
+	lastModified := modTime(fileName)
+	// The file is checked once a second and only reloaded once every watch_interval at most, so a changed watch_interval applies straight away
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var lastCheck time.Time
+	for {
+		select {
+		case sig := <-signals:
+			level.Info(r.logger).Log("msg", "reloading configuration", "signal", sig)
+			r.Reload()
+			lastModified = modTime(fileName)
+		case now := <-ticker.C:
+			interval := time.Duration(r.Config().WatchInterval)
+			if interval <= 0 || now.Sub(lastCheck) < interval {
+				continue
+			}
+			lastCheck = now
+			if m := modTime(fileName); !m.Equal(lastModified) {
+				lastModified = m
+				level.Info(r.logger).Log("msg", "reloading configuration", "file", fileName)
+				r.Reload()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// modTime returns the modification time of a file, or the zero time if it does not exist
+func modTime(fileName string) time.Time {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
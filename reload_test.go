@@ -0,0 +1,66 @@
+package wservice
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffConfig(t *testing.T) {
+	old := DefaultConfig()
+	old.Database.Password = "secret"
+	new := old
+	new.LogLevel = "debug"
+	new.Port = 9090
+	new.Database.Password = "new secret"
+	new.Database.ConnMaxLifetime = Duration(time.Minute)
+	assert.Equal(t, []ConfigChange{
+		{Setting: "port", Old: "8080", New: "9090"},
+		{Setting: "log_level", Old: "info", New: "debug", Reloadable: true},
+		{Setting: "database.password", Old: secretMask, New: secretMask},
+		{Setting: "database.conn_max_lifetime", Old: "0s", New: "1m0s", Reloadable: true},
+	}, DiffConfig(old, new))
+	assert.Empty(t, DiffConfig(old, old))
+}
+
+func TestReload(t *testing.T) {
+	next := DefaultConfig()
+	var loadErr error
+	var out bytes.Buffer
+	r := NewReloader(DefaultConfig(), func() (Config, error) { return next, loadErr }, log.NewLogfmtLogger(&out))
+	var applied []Config
+	r.OnReload(func(cfg Config) { applied = append(applied, cfg) })
+
+	// Only the reloadable settings are applied, the other changes are logged
+	next.MaxRequestBody = 1024
+	next.Database.Host = "elsewhere"
+	assert.Nil(t, r.Reload())
+	assert.Len(t, applied, 1)
+	assert.Equal(t, 1024, r.Config().MaxRequestBody)
+	assert.Equal(t, "127.0.0.1", r.Config().Database.Host)
+	assert.Contains(t, out.String(), "level=info msg=\"setting changed\" setting=max_request_body old=65536 new=1024")
+	assert.Contains(t, out.String(), "level=warn msg=\"setting changed, it takes a restart to apply\" setting=database.host old=127.0.0.1 new=elsewhere")
+
+	// An invalid configuration, or one that cannot be loaded, is refused as a whole
+	next.MaxRequestBody = 2048
+	next.LogLevel = "verbose"
+	assert.NotNil(t, r.Reload())
+	loadErr = errors.New("err: config file is not valid YAML")
+	assert.NotNil(t, r.Reload())
+	assert.Len(t, applied, 1)
+	assert.Equal(t, 1024, r.Config().MaxRequestBody)
+	assert.Equal(t, "info", r.Config().LogLevel)
+}
+
+func TestLevelFilter(t *testing.T) {
+	var out bytes.Buffer
+	logger := LevelFilter(log.NewLogfmtLogger(&out), "error")
+	levelled(logger, nil).Log("msg", "hidden")
+	levelled(logger, errors.New("boom")).Log("msg", "shown")
+	logger.Log("msg", "no level")
+	assert.Equal(t, "level=error msg=shown\nmsg=\"no level\"\n", out.String())
+}
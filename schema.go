@@ -0,0 +1,151 @@
+package wservice
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Schema names the Postgres objects a ledger lives in (schema, tables and the transfer ID sequence). Every statement of the service and every migration
+// goes through the configured names, so that several ledgers can share one Postgres database under different schemas or table names
+
+// AccountsTable and TransfersTable are the logical names GetTable is called with, whatever the configured table names are
+const (
+	AccountsTable  = "Accounts"
+	TransfersTable = "Transfers"
+)
+
+// The default names of the objects of a ledger, any of them can be overridden in the configuration file
+const (
+	// defaultCurrenciesTable holds the ISO 4217 currency registry (code, numeric code, minor units, name and enabled flag)
+	defaultCurrenciesTable = "Currencies"
+	// defaultTransferStatusChangesTable holds the history of status changes of every transfer
+	defaultTransferStatusChangesTable = "TransferStatusChanges"
+	// defaultFailedTransfersTable holds the transfer attempts that failed and why
+	defaultFailedTransfersTable = "FailedTransfers"
+	// defaultInterestRatesTable holds the per-account interest configuration (annual rate as a fraction, day-count convention and interest-expense account)
+	defaultInterestRatesTable = "InterestRates"
+	// defaultInterestAccrualsTable holds one row of accrued interest per account and day, and whether it was already posted
+	defaultInterestAccrualsTable = "InterestAccruals"
+	// defaultOutboxTable holds the events of the committed transfers until the outbox relay has published them (see outbox.go)
+	defaultOutboxTable = "Outbox"
+	// defaultWebhooksTable holds the webhook subscriptions and defaultWebhookDeliveriesTable the deliveries of the events to them (see webhooks.go)
+	defaultWebhooksTable          = "Webhooks"
+	defaultWebhookDeliveriesTable = "WebhookDeliveries"
+	// defaultAPIKeysTable holds the API keys of the clients with the hashes of their secrets (see apikeys.go)
+	defaultAPIKeysTable = "APIKeys"
+	// defaultMigrationsTable records the applied schema migrations
+	defaultMigrationsTable = "schema_migrations"
+	// defaultSequence numbers the transfers (and failed transfer attempts) for legacy clients
+	defaultSequence = "Payment_Counter"
+)
+
+// identifierPattern is the format of the configured schema, table and sequence names. They are interpolated in the SQL statements, so nothing else is accepted
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+// setSchemaDefaults names every object that was not configured after its default
+func (s *sqlDBTx) setSchemaDefaults() {
+	defaults := []struct {
+		name *string
+		def  string
+	}{
+		{&s.accountsTable, AccountsTable},
+		{&s.transfersTable, TransfersTable},
+		{&s.currenciesTable, defaultCurrenciesTable},
+		{&s.transferStatusChangesTable, defaultTransferStatusChangesTable},
+		{&s.failedTransfersTable, defaultFailedTransfersTable},
+		{&s.interestRatesTable, defaultInterestRatesTable},
+		{&s.interestAccrualsTable, defaultInterestAccrualsTable},
+		{&s.outboxTable, defaultOutboxTable},
+		{&s.webhooksTable, defaultWebhooksTable},
+		{&s.webhookDeliveriesTable, defaultWebhookDeliveriesTable},
+		{&s.apiKeysTable, defaultAPIKeysTable},
+		{&s.migrationsTable, defaultMigrationsTable},
+		{&s.sequence, defaultSequence},
+	}
+	for _, d := range defaults {
+		if *d.name == "" {
+			*d.name = d.def
+		}
+	}
+}
+
+// schemaObjects returns the configured (unqualified) name of every object of the ledger, keyed by the name the migrations refer to it with
+func (s sqlDBTx) schemaObjects() map[string]string {
+	return map[string]string{
+		"Accounts":              s.accountsTable,
+		"Transfers":             s.transfersTable,
+		"Currencies":            s.currenciesTable,
+		"TransferStatusChanges": s.transferStatusChangesTable,
+		"FailedTransfers":       s.failedTransfersTable,
+		"InterestRates":         s.interestRatesTable,
+		"InterestAccruals":      s.interestAccrualsTable,
+		"Outbox":                s.outboxTable,
+		"Webhooks":              s.webhooksTable,
+		"WebhookDeliveries":     s.webhookDeliveriesTable,
+		"APIKeys":               s.apiKeysTable,
+		"Sequence":              s.sequence,
+	}
+}
+
+// qualify returns the name of an object of the ledger qualified with the configured schema, if any
+func (s sqlDBTx) qualify(name string) string {
+	if s.schema == "" {
+		return name
+	}
+	return s.schema + "." + name
+}
+
+// accounts, transfers and the methods below return the schema-qualified names to use in SQL statements
+func (s sqlDBTx) accounts() string              { return s.qualify(s.accountsTable) }
+func (s sqlDBTx) transfers() string             { return s.qualify(s.transfersTable) }
+func (s sqlDBTx) currencies() string            { return s.qualify(s.currenciesTable) }
+func (s sqlDBTx) transferStatusChanges() string { return s.qualify(s.transferStatusChangesTable) }
+func (s sqlDBTx) failedTransfers() string       { return s.qualify(s.failedTransfersTable) }
+func (s sqlDBTx) interestRates() string         { return s.qualify(s.interestRatesTable) }
+func (s sqlDBTx) interestAccruals() string      { return s.qualify(s.interestAccrualsTable) }
+func (s sqlDBTx) outbox() string                { return s.qualify(s.outboxTable) }
+func (s sqlDBTx) webhooks() string              { return s.qualify(s.webhooksTable) }
+func (s sqlDBTx) webhookDeliveries() string     { return s.qualify(s.webhookDeliveriesTable) }
+func (s sqlDBTx) apiKeys() string               { return s.qualify(s.apiKeysTable) }
+func (s sqlDBTx) migrations() string            { return s.qualify(s.migrationsTable) }
+func (s sqlDBTx) nextTransferID() string        { return "nextval('" + s.qualify(s.sequence) + "')" }
+
+// verifySchema checks that every configured object of the ledger exists in the database, so a misconfigured ledger fails at startup rather than on its first request
+func (s sqlDBTx) verifySchema() error {
+	db, err := s.openDB()
+	// If any error, return it to parent function
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, name := range s.schemaObjects() {
+		var exists bool
+		if err := db.QueryRow("SELECT to_regclass($1) IS NOT NULL;", s.qualify(name)).Scan(&exists); err != nil {
+			var ErrUnexpect = errors.New("err: unexpected error ")
+			return dbError(ErrUnexpect, err)
+		}
+		if !exists {
+			missing = append(missing, s.qualify(name))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		var ErrMissing = errors.New("err: the configured objects " + strings.Join(missing, ", ") + " do not exist, run \"wService migrate up\" or fix the configuration")
+		return ErrMissing
+	}
+	return nil
+}
+
+// VerifySchema exported to be accessible from outside the package (from main)
+// VerifySchema checks that the tables and sequence configured for the wallet service created by NewService exist in its database
+func VerifySchema(svc WalletService) error {
+	s, ok := postgresStore(svc)
+	if !ok {
+		var ErrNoDB = errors.New("err: the schema can only be verified for the Postgres wallet service")
+		return ErrNoDB
+	}
+	return s.verifySchema()
+}
@@ -0,0 +1,50 @@
+package wservice
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaConfig(t *testing.T) {
+	svc, err := getDbConfig("./cmd/postgresql.cfg")
+	assert.Nil(t, err)
+	s, _ := postgresStore(svc)
+	assert.Equal(t, "Accounts", s.accounts())
+	assert.Equal(t, "Currencies", s.currencies())
+	assert.Equal(t, "nextval('Payment_Counter')", s.nextTransferID())
+
+	svc, err = getDbConfig("./cmd/test/postgresql_schema.cfg")
+	assert.Nil(t, err)
+	s, _ = postgresStore(svc)
+	assert.Equal(t, "ledger2.Accounts", s.accounts())
+	assert.Equal(t, "ledger2.Transfers", s.transfers())
+	assert.Equal(t, "ledger2.InterestAccruals", s.interestAccruals())
+	assert.Equal(t, "ledger2.schema_migrations", s.migrations())
+	assert.Equal(t, "nextval('ledger2.Ledger2_Counter')", s.nextTransferID())
+}
+
+func TestSchemaConfigInvalid(t *testing.T) {
+	base, err := ioutil.ReadFile("./cmd/postgresql.cfg")
+	assert.Nil(t, err)
+	for _, extra := range []string{",\nschema : ledger2;DROP TABLE Accounts", ",\nunknownTable : Foo", ",\nschema : a,\nschema : b"} {
+		f, err := ioutil.TempFile("", "postgresql*.cfg")
+		assert.Nil(t, err)
+		f.Write(append(append([]byte{}, base...), extra...))
+		f.Close()
+		_, err = getDbConfig(f.Name())
+		assert.NotNil(t, err, extra)
+		os.Remove(f.Name())
+	}
+}
+
+func TestGetTableUnknown(t *testing.T) {
+	svc, _ := getDbConfig("./cmd/postgresql.cfg")
+	v, err := svc.GetTable("someOtherTable")
+	assert.Nil(t, v)
+	assert.True(t, errors.Is(err, ErrInvalidRequest))
+	assert.EqualError(t, err, "err: unknown table \"someOtherTable\", expected \"Accounts\" or \"Transfers\"")
+}
@@ -0,0 +1,214 @@
+package wservice
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// The database password does not have to be written in the configuration file: it can be read from a file (database.password_file, e.g. a Docker or
+// Kubernetes secret mounted in the container), from the WSERVICE_DATABASE_PASSWORD or PGPASSWORD environment variables, or from a pgpass file
+// (database.passfile, or PGPASSFILE and ~/.pgpass which the driver reads by itself when no password is configured). Whatever its source, the password
+// never leaves the service: the connection errors that could carry the connection string are redacted before they reach the logs or the API
+
+// resolveSecrets reads the secrets the database configuration points to and returns the configuration with the password filled in
+func resolveSecrets(db DatabaseConfig) (DatabaseConfig, error) {
+	// A password file takes precedence over a password, so a secret mounted in a container wins over the password of a default configuration file
+	if db.PasswordFile != "" {
+		password, err := readSecretFile(db.PasswordFile)
+		if err != nil {
+			return db, errors.New("err: invalid configuration: database.password_file: " + err.Error())
+		}
+		db.Password = password
+	}
+	if db.PassFile != "" && db.Password == "" {
+		password, err := lookupPgpass(db.PassFile, db.Host, strconv.Itoa(db.Port), db.Name, db.User)
+		if err != nil {
+			return db, errors.New("err: invalid configuration: database.passfile: " + err.Error())
+		}
+		db.Password = password
+	}
+	return db, nil
+}
+
+// readSecretFile reads a secret from a file, without the line break editors and "echo" leave at its end
+func readSecretFile(fileName string) (string, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return "", errors.New("cannot read " + fileName)
+	}
+	secret := strings.TrimRight(string(content), "\r\n")
+	if secret == "" {
+		return "", errors.New(fileName + " is empty")
+	}
+	return secret, nil
+}
+
+// lookupPgpass returns the password of the first line of a pgpass file (hostname:port:database:username:password, where any of the first four
+// fields can be "*") that matches the connection. Like psql, it refuses a file that other users can read
+func lookupPgpass(fileName string, host string, port string, dbName string, user string) (string, error) {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return "", errors.New("cannot read " + fileName)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return "", errors.New(fileName + " must not be accessible by group or others (chmod 0600)")
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", errors.New("cannot read " + fileName)
+	}
+	defer file.Close()
+
+	want := []string{host, port, dbName, user}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := splitPgpassLine(line)
+		if len(fields) != 5 {
+			continue
+		}
+		matches := true
+		for i, w := range want {
+			if fields[i] != "*" && fields[i] != w && !(i == 0 && fields[i] == "localhost" && (w == "127.0.0.1" || w == "::1")) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return fields[4], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", errors.New("cannot read " + fileName)
+	}
+	return "", errors.New("no entry of " + fileName + " matches " + host + ":" + port + ":" + dbName + ":" + user)
+}
+
+// splitPgpassLine splits a pgpass line on the ":" that are not escaped with "\"
+func splitPgpassLine(line string) []string {
+	var fields []string
+	var field strings.Builder
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			field.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == ':':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteRune(c)
+		}
+	}
+	return append(fields, field.String())
+}
+
+// secrets returns the secrets of the connection: the password, whether configured on its own or inside the DSN
+func (s sqlDBTx) secrets() []string {
+	var secrets []string
+	if s.sqlPassword != "" {
+		secrets = append(secrets, s.sqlPassword)
+	}
+	if password := urlPassword(s.dsn); password != "" {
+		secrets = append(secrets, password)
+		if unescaped, err := url.PathUnescape(password); err == nil && unescaped != password {
+			secrets = append(secrets, unescaped)
+		}
+	}
+	for _, m := range dsnPasswordPattern.FindAllStringSubmatch(s.dsn, -1) {
+		password := m[2]
+		if strings.HasPrefix(password, "'") {
+			password = strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(strings.Trim(password, "'"))
+		}
+		if password != "" {
+			secrets = append(secrets, password, m[2])
+		}
+	}
+	return secrets
+}
+
+// urlPassword returns the password of a postgres:// URL as written, it does not parse the URL so that the password of a malformed URL is found too
+func urlPassword(dsn string) string {
+	i := strings.Index(dsn, "://")
+	if i == -1 {
+		return ""
+	}
+	rest := dsn[i+3:]
+	if j := strings.IndexAny(rest, "/?#"); j != -1 {
+		rest = rest[:j]
+	}
+	at := strings.LastIndex(rest, "@")
+	if at == -1 {
+		return ""
+	}
+	userinfo := rest[:at]
+	if k := strings.Index(userinfo, ":"); k != -1 {
+		return userinfo[k+1:]
+	}
+	return ""
+}
+
+// redactedError is an error whose message had its secrets masked, it still unwraps to the original error so its kind (e.g. a network error) is kept
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e redactedError) Error() string { return e.msg }
+func (e redactedError) Unwrap() error { return e.err }
+
+// redactError masks every secret found in the message of an error
+func redactError(err error, secrets []string) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	for _, secret := range secrets {
+		msg = strings.Replace(msg, secret, secretMask, -1)
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return redactedError{msg: msg, err: err}
+}
+
+// redactingConnector opens Postgres connections and redacts the errors of the driver, which can quote the connection string it failed to use
+type redactingConnector struct {
+	driver.Connector
+	secrets []string
+}
+
+// Connect opens a connection, redacting its error if any
+func (c redactingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, redactError(err, c.secrets)
+	}
+	return conn, nil
+}
+
+// newDB defines the DB connection string based on the information contained on a sqlDBTx struct and opens a connection pool with it
+func (s sqlDBTx) newDB() (*sqlx.DB, error) {
+	connector, err := pq.NewConnector(s.connectionString())
+	if err != nil {
+		return nil, redactError(err, s.secrets())
+	}
+	return sqlx.NewDb(sql.OpenDB(redactingConnector{Connector: connector, secrets: s.secrets()}), s.sqlDriver), nil
+}
@@ -1,18 +1,12 @@
 package wservice
 
 import (
-	"bufio"
-	"database/sql"
 	"errors"
-	"flag"
 	"fmt"
 	"log"
-	"os"
 	"strconv"
-	"strings"
 	"time"
 
-	"github.com/jmoiron/sqlx"
 	// importing as blank for side-effects puposes only (init)
 	_ "github.com/lib/pq"
 )
@@ -25,15 +19,63 @@ import (
 // all the entries in the specific table and a error (nil if the method ran successfully)
 // DoTransfer is the method that actually implements the wallet's fund transfer functionality from one account to another. It takes 3 input strings (the source account,
 // the destination account and the transferred amount) and returns a status string (like "successful") and an error.
+// GetCurrencies returns the currency registry (ISO 4217 code, numeric code, minor units and whether the currency is enabled) and SetCurrencyEnabled
+// takes a currency code and a flag and enables or disables transfers in that currency, returning a status string and an error.
+// GetWallet takes a wallet ID and returns all the per-currency balances grouped under that wallet.
+// SetInterestRate takes an account, an annual rate, a day-count convention and an interest-expense account and configures the interest of that account,
+// AccrueInterest takes a date and accrues the daily interest of every configured account up to that date, PostInterest takes a month and posts
+// the interest accrued during that month as transfers from the interest-expense accounts and PostDueInterest posts the interest of every month that
+// is over and was not posted yet. All four return a status string and an error.
+// SubmitTransfer does what DoTransfer does but returns the resulting Transfer (with its ID and status, failed attempts included), DoWalletTransfer takes 4 input
+// strings (the source wallet, the destination wallet, the currency and the transferred amount) and moves funds between the wallets' balances in that currency,
+// GetTransfer takes a transfer ID and returns that transfer with its status history, ReverseTransfer takes the ID of a completed transfer and moves its funds back
+// and CancelTransfer takes the ID of a pending transfer and cancels it.
+// CreateWebhook takes a URL, the event types to subscribe it to and an optional secret and returns the new webhook with its secret, GetWebhooks, GetWebhook,
+// SetWebhookEnabled and DeleteWebhook list, fetch, enable or disable and delete webhooks by ID, GetWebhookDeliveries takes a webhook ID and returns its most
+// recent deliveries and ReplayWebhookDelivery takes a webhook ID and a delivery ID and sends that delivery again.
+// GetAccounts takes an optional wallet ID and returns every account (or the accounts of that wallet), GetAccount takes an account ID and returns that
+// account and GetTransfers returns every recorded transfer, all three as the resources of the /v1 API.
+// CreateAPIKey takes a name and some scopes and returns the new API key with its secret, GetAPIKeys lists every API key, RotateAPIKey takes an API key ID
+// and a grace period and returns a new key replacing that one, RevokeAPIKey takes an API key ID and revokes that key and Authenticate takes the key a
+// client presented and returns that API key if it is valid.
 type WalletService interface {
 	GetTable(string) ([]string, error)
 	DoTransfer(string, string, string) (string, error)
+	GetCurrencies() ([]string, error)
+	SetCurrencyEnabled(string, bool) (string, error)
+	GetWallet(string) ([]string, error)
+	DoWalletTransfer(string, string, string, string) (Transfer, error)
+	SetInterestRate(string, string, string, string) (string, error)
+	AccrueInterest(string) (string, error)
+	PostInterest(string) (string, error)
+	PostDueInterest() (string, error)
+	SubmitTransfer(string, string, string) (Transfer, error)
+	GetTransfer(string) (Transfer, error)
+	ReverseTransfer(string) (Transfer, error)
+	CancelTransfer(string) (Transfer, error)
+	CreateWebhook(string, []string, string) (Webhook, error)
+	GetWebhooks() ([]Webhook, error)
+	GetWebhook(string) (Webhook, error)
+	SetWebhookEnabled(string, bool) (Webhook, error)
+	DeleteWebhook(string) (string, error)
+	GetWebhookDeliveries(string) ([]WebhookDelivery, error)
+	ReplayWebhookDelivery(string, string) (WebhookDelivery, error)
+	GetAccounts(string) ([]Account, error)
+	GetAccount(string) (Account, error)
+	GetTransfers() ([]Transfer, error)
+	CreateAPIKey(string, []string) (APIKey, error)
+	GetAPIKeys() ([]APIKey, error)
+	RotateAPIKey(string, time.Duration) (APIKey, error)
+	RevokeAPIKey(string) (APIKey, error)
+	Authenticate(string) (APIKey, error)
 }
 
 // sqlDBTx is a type that defines the necessary information to establish a Postgres
-// database connection and what tables to access (structure of the DB)
+// database connection and what tables to access (structure of the DB). It is the Postgres Store of the ledger (see postgres.go)
 type sqlDBTx struct {
-	sqlDriver      string
+	sqlDriver string
+	// dsn, when set, is the full connection string (a postgres:// URL or key=value pairs) and takes the place of the settings below
+	dsn            string
 	sqlHost        string
 	sqlPort        string
 	sqlUser        string
@@ -42,377 +84,350 @@ type sqlDBTx struct {
 	sslmode        string
 	accountsTable  string
 	transfersTable string
+	// The optional names of the other objects of the ledger (see schema.go)
+	schema                     string
+	currenciesTable            string
+	transferStatusChangesTable string
+	failedTransfersTable       string
+	interestRatesTable         string
+	interestAccrualsTable      string
+	outboxTable                string
+	webhooksTable              string
+	webhookDeliveriesTable     string
+	apiKeysTable               string
+	migrationsTable            string
+	sequence                   string
+	// pool is the connection pool shared by all the copies of the service (see pool.go)
+	pool *connPool
 }
 
-func parseArgs() (string, int) {
-	var fileName string
-	// Parse the postrgres configuration file name and path. if not deifned the default is "postgresql.cfg" from /cmd
-	flag.StringVar(&fileName, "file", "./postgresql.cfg", "Path of postgresql config file to be parsed.")
-	var portNumber int
-	// Parse the port number that the server uses to listen and serve. If none is defined the default is 8080
-	flag.IntVar(&portNumber, "port", 8080, "Port on which the server will listen and serve.")
-	flag.Parse()
-	return fileName, portNumber
-}
-
+// getDbConfig reads a configuration file (YAML, JSON or the legacy format) on top of the defaults and creates the wallet service it describes
 func getDbConfig(fileName string) (WalletService, error) {
-	// Open Postgres configuration file
-	file, err := os.Open(fileName)
-	// If there is an error return a suggestive error message
+	cfg, err := ReadConfigFile(DefaultConfig(), fileName)
 	if err != nil {
-		sError := "There was a problem opening file " + fileName + " "
-		var ErrReadFile = errors.New(sError)
-		cErr := errors.New(ErrReadFile.Error() + err.Error())
-		var d = sqlDBTx{}
-		return d, cErr
-	}
-	// Defering the file closure to make sure it will eventually be closed
-	defer file.Close()
-	// declaring a sqlDBTx stuct that will hold the Postgres connection info
-	var configStruct sqlDBTx
-
-	// Read file and split each line on the " : " separator and then split the string to the right of
-	// the separtor by another spearator (",") and keep the string to the left of separator
-	cSlice := []string{}
-	scanner := bufio.NewScanner(file)
-	counter := 0
-	for scanner.Scan() {
-		item := scanner.Text()
-		if strings.Index(item, " : ") == -1 {
-			// Check if there is actually a " : " delimiter on the line, if not then there is a formating issue
-			var d = sqlDBTx{}
-			var ErrFormat = errors.New("err: postgres config file error, no ' : ' delimiter found")
-			return d, ErrFormat
-		}
-		if strings.LastIndex(item, " : ") != strings.Index(item, " : ") {
-			// Check if there are more than one " : " delimiter on the line, if so then there is a formating issue
-			var d = sqlDBTx{}
-			var ErrFormat = errors.New("err: postgres config file error, too many ' : ' delimiters found")
-			return d, ErrFormat
-		}
-		s := strings.Split(item, " : ")
-		v := strings.Split(s[1], ",")
-		cSlice = append(cSlice, v[0])
-		counter++
-	}
-	// If there are more or less than 9 lines in the config file, then there is a fomatting issue
-	if counter != 9 {
-		var d = sqlDBTx{}
-		var ErrFormat = errors.New("err: postgres config file error, the number of lines in the config file is not the expected one (9)")
-		return d, ErrFormat
-	}
-	// In case of error return the message to the outer function
-	if err := scanner.Err(); err != nil {
-		var d = sqlDBTx{}
+		var d = ledger{}
 		return d, err
 	}
-	// Assign the gathered values to the configStruct struct of type sqlDBTx
-	configStruct = sqlDBTx{
-		sqlDriver:      cSlice[0],
-		sqlHost:        cSlice[1],
-		sqlPort:        cSlice[2],
-		sqlUser:        cSlice[3],
-		sqlPassword:    cSlice[4],
-		sqlDbName:      cSlice[5],
-		sslmode:        cSlice[6],
-		accountsTable:  cSlice[7],
-		transfersTable: cSlice[8],
-	}
-	return configStruct, nil
-
+	return NewServiceFromConfig(cfg)
 }
 
 // NewService exported to be accessible from outside the package (from main)
-// NewService is necessary because we need the ability to create a sqlDBTx stuct from outside the package (like from main)
+// NewService is necessary because we need the ability to create the wallet service from outside the package (like from main)
 func NewService() (WalletService, int, error) {
 
-	// Call function to parse cli arguments
-	fileName, portNumber := parseArgs()
-	configStruct, err := getDbConfig(fileName)
+	// Load the configuration from the cli arguments, the configuration file and the environment
+	cfg, err := LoadConfig()
+	if err != nil {
+		var d = ledger{}
+		return d, 0, err
+	}
+	configStruct, err := NewServiceFromConfig(cfg)
 
-	// Return the sqlDBTx struct that holds the Postgres db configuration parameters and the Listen and Serve port number
-	return configStruct, portNumber, err
+	// Return the wallet service on top of the configured store and the Listen and Serve port number
+	return configStruct, cfg.Port, err
 }
 
-// GetTable is a sqlDBTx type method and its purpose is to fetch the information contained in one of the 2 tables
-// of the DB (one that keeps track of transfers and one that keeps track of the information in the wallet accounts)
+// GetTable is a ledger type method and its purpose is to fetch the information contained in one of the 2 tables
+// of the ledger (one that keeps track of transfers and one that keeps track of the information in the wallet accounts)
 // GetTable is also one of core functionalities of the Wallet service and has its own go-kit endpoint
-func (s sqlDBTx) GetTable(t string) ([]string, error) {
-	// Based on the information contained on a sqlDBTx struct created with the "NewService" function a DB connection string is defined and a connection is opened
-	connectionString := "host=" + s.sqlHost + " port=" + s.sqlPort + " user=" + s.sqlUser + " password=" + s.sqlPassword + " dbname=" + s.sqlDbName + " sslmode=" + s.sslmode
-	db, err := sqlx.Open(s.sqlDriver, connectionString)
-	// If any error, return it to parent function
-	if err != nil {
-		return nil, err
+func (l ledger) GetTable(t string) ([]string, error) {
+	// Resolve the logical table name (or the configured one) to the table to read, any other name is refused rather than read as the transfers table
+	var isAccounts bool
+	switch t {
+	case AccountsTable, l.accountsTable:
+		isAccounts = true
+	case TransfersTable, l.transfersTable:
+		isAccounts = false
+	default:
+		var ErrTable = newError(ErrInvalidRequest, "err: unknown table \""+t+"\", expected \""+AccountsTable+"\" or \""+TransfersTable+"\"")
+		return nil, ErrTable
 	}
-	// Make sure we actually close the connction once we're done
-	defer db.Close()
 
-	// While the transaction we are about to execute is not committed we will retry it until successful
-	var isCommitted = false
+	// Read the table from a consistent snapshot of the ledger
 	var results []string
-	for ok := true; ok; ok = !isCommitted {
-		// Start a transaction against the Postgres db
-		// If at anypoint between the "begin" and "commit" there is any kind of issue all changes to the db will be reverted
-		tx, err := db.Begin()
-		// If we get an error return a descriptive message and roll back the transaction in the "defer" section
-		if err != nil {
-			var ErrStartTx = errors.New("err: error beginning transaction in postgres")
-			cErr := errors.New(ErrStartTx.Error() + err.Error())
-			return nil, cErr
-		}
-		defer tx.Rollback()
-
-		// Set the transaction ISOLATION LEVEL to "Serializable" to allow for multiple instances of the server to run transactions against the same Postgres db
-		_, err = tx.Exec(`set transaction isolation level serializable`)
-		//_, err = tx.Exec(`set transaction isolation level repeatable read`) // <=== SET ISOLATION LEVEL
-		// If an error occurs return it to the parent function
-		if err != nil {
-			return nil, err
-		}
-
-		// Set a table lock so we exclude any type of conflicts that could generate data corruption
-		_, err = tx.Exec("LOCK TABLE Accounts IN SHARE ROW EXCLUSIVE MODE;") // <=== Lock table
-		// If an error occurs we retry the transaction
-		if err != nil {
-			//log.Println(err, "...continuing...")
-			continue
-		}
-
-		var txString string
-		if t == s.accountsTable {
-			// If we are trying to access the table that keeps information about accounts run the following query
-			txString = "SELECT * FROM " + s.accountsTable + " ORDER BY AccountID;"
-		} else {
-			// If, instead we are trying to access the table that keeps information about fund transfers run the following query
-			txString = "SELECT * FROM " + s.transfersTable + ";"
-		}
-		// Get the query result
-		rows, err := tx.Query(txString)
-		// If there is an error when sending the query
-		if err != nil {
-			// If the error message is indicative of a db collision retry the transaction in a new iteration
-			if strings.Contains(err.Error(), "could not serialize access due to") {
-				continue
-			}
-			// If the error is that the table has now rows
-			if err == sql.ErrNoRows {
-				// And if the table has information about accounts
-				if t == s.accountsTable {
-					// Return an appropriate error to the outer function
-					var ErrAcc = errors.New("err: there are no defined accounts")
-					cErr := errors.New(ErrAcc.Error() + err.Error())
-					return nil, cErr
-				}
-				// Or, if the table has information about fund transfers return an appropriate error to the outer function
-				var ErrAcc = errors.New("err: there are no defined transfers")
-				cErr := errors.New(ErrAcc.Error() + err.Error())
-				return nil, cErr
+	err := l.store.View(func(tx LedgerTx) error {
+		results = nil
+		if isAccounts {
+			// If we are trying to access the table that keeps information about accounts list the account ID, balance, currency and the initial balance
+			accounts, err := tx.Accounts()
+			if err != nil {
+				return err
 			}
-			// If we got a different error return
-			var ErrUnexp = errors.New("err: Unexpected error occurred")
-			cErr := errors.New(ErrUnexp.Error() + err.Error())
-			return nil, cErr
-		}
-		// For each row returned in the query results
-		for rows.Next() {
-			if t == s.accountsTable {
-				// If the table has information about accounts get the account ID, balance, currency and the initial balance in a slice of strings
-				var accountID string
-				var balance float64
-				var currency string
-				var initialBalance float64
-
-				if err := rows.Scan(&accountID, &balance, &currency, &initialBalance); err != nil {
-					log.Fatal(err)
-				}
-				sBalance := fmt.Sprintf("%f", balance)
-				sIBalance := fmt.Sprintf("%f", initialBalance)
-				rString := "Account: " + accountID + "  Balance = " + sBalance + " " + currency + "  Initial Balance = " + sIBalance
-				results = append(results, rString)
-
-			} else {
-				// If the table has information about func transfers get the payment ID, source account, destination account, currency and timestamp in a slice of strings
-				var paymentID int
-				var fromAccount string
-				var toAccount string
-				var amount float64
-				var currency string
-				var time string
-
-				if err := rows.Scan(&paymentID, &fromAccount, &toAccount, &amount, &currency, &time); err != nil {
-					log.Fatal(err)
-				}
-				sPayment := fmt.Sprintf("%d", paymentID)
-				sAmount := fmt.Sprintf("%f", amount)
-				rString := "Transfer #" + sPayment + "  from: " + fromAccount + "  to:  " + toAccount + " in the amount of " + sAmount + " " + currency + " at " + time
+			for _, a := range accounts {
+				rString := "Account: " + a.ID + "  Balance = " + formatAmount(a.Balance) + " " + a.Currency + "  Initial Balance = " + formatAmount(a.InitialBalance)
 				results = append(results, rString)
 			}
+			return nil
+		}
+		// If, instead we are trying to access the table that keeps information about fund transfers list the payment ID, source account,
+		// destination account, currency, timestamp and status
+		transfers, err := tx.Transfers()
+		if err != nil {
+			return err
 		}
-		// If we've gotten this far without any errors we can commit our transaction, break out of the transaction loop as the transaction was successful
-		// and return the slice with all the information parsed from the query's result and a nil error to the parent function
-		tx.Commit()
-		isCommitted = true
+		for _, tr := range transfers {
+			sPayment := fmt.Sprintf("%d", tr.LegacyID)
+			rString := "Transfer #" + sPayment + " (" + tr.ID + ")  from: " + tr.From + "  to:  " + tr.To + " in the amount of " + formatAmount(tr.Amount) + " " + tr.Currency + " at " + tr.Time.UTC().Format(time.RFC3339) + " (" + tr.Status + ")"
+			results = append(results, rString)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	if t == s.transfersTable && len(results) == 0 {
+	if !isAccounts && len(results) == 0 {
 		results = append(results, "No submitted transfers yet, this is not an error.")
 	}
 	results = append(results, "Success.")
 	return results, nil
 }
 
-// DoTransfer is a sqlDBTx type method that is responsible for the actual fund transfer transaction from one account to another
+// formatAmount formats a decimal amount of the ledger the way the tables are listed
+func formatAmount(amount string) string {
+	f, _ := strconv.ParseFloat(amount, 64)
+	return fmt.Sprintf("%f", f)
+}
+
+// DoTransfer is a ledger type method that is responsible for the actual fund transfer transaction from one account to another
 // DoTransfer takes in 3 arguments: the source account, the destination account and the transferred amount and returns a confirmation string and an empty error
 // GetTable is also one of core functionalities of the Wallet service and has its own go-kit endpoint
-func (s sqlDBTx) DoTransfer(fromAccount string, toAccount string, transferAmount string) (string, error) {
-	// Based on the information contained on a sqlDBTx struct created with the "NewService" function a DB connection string is defined and a connection is opened
-	connectionString := "host=" + s.sqlHost + " port=" + s.sqlPort + " user=" + s.sqlUser + " password=" + s.sqlPassword + " dbname=" + s.sqlDbName + " sslmode=" + s.sslmode
-	db, err := sqlx.Open(s.sqlDriver, connectionString)
-	// If any error, return it to parent function
-	if err != nil {
-		log.Println("err", err)
+func (l ledger) DoTransfer(fromAccount string, toAccount string, transferAmount string) (string, error) {
+	if _, err := l.SubmitTransfer(fromAccount, toAccount, transferAmount); err != nil {
 		return "error", err
 	}
+	return "success", nil
+}
 
-	// check if the source account and destination account are the same and return an error before any transactions happen as we do not support transactions of this type
-	if fromAccount == toAccount {
-		var ErrSameAcc = errors.New("the source account is the same as the destination account. ")
-		//log.Println("err", ErrSameAcc)
-		return "error", ErrSameAcc
-	}
-	// Make sure we actually close the connction once we're done
-	defer db.Close()
+// SubmitTransfer is a ledger type method that runs the fund transfer transaction from one account to another and returns the resulting transfer with its ID and status.
+// A transfer that is refused is recorded as a failed attempt together with its reason, and the failed transfer is returned along with the error
+func (l ledger) SubmitTransfer(fromAccount string, toAccount string, transferAmount string) (Transfer, error) {
+	return l.submitTransfer(transferAmount, func(LedgerTx) (string, string, error) {
+		return fromAccount, toAccount, nil
+	})
+}
 
-	// While the transaction we are about to execute is not committed we will retry it until successful
-	var isCommitted = false
-	for ok := true; ok; ok = !isCommitted {
-		// Start a transaction against the Postgres db
-		tx, err := db.Begin()
-		// If we get an error return a descriptive message and roll back the transaction in the "defer" section, then restart transaction
-		// If at anypoint between the "begin" and "commit" there is an issue all changes to the db will be reverted
-		if err != nil {
-			var ErrStartTx = errors.New("err: error beginning transaction in postgres")
-			cErr := errors.New(ErrStartTx.Error() + err.Error())
-			return "error", cErr
+// submitTransfer records a pending transfer between the source and destination accounts that accounts resolves inside the same transaction, once the
+// transfer passed its checks, then completes it. A transfer whose accounts cannot be resolved fails without being recorded, as it has none to record
+func (l ledger) submitTransfer(transferAmount string, accounts func(tx LedgerTx) (string, string, error)) (Transfer, error) {
+	// The store retries the transaction until it commits, or until the transfer is refused
+	var fromAccount, toAccount string
+	var transfer Transfer
+	var unresolved, refused error
+	err := l.store.Update(func(tx LedgerTx) error {
+		var err error
+		unresolved, refused = nil, nil
+		if fromAccount, toAccount, unresolved = accounts(tx); unresolved != nil {
+			return unresolved
 		}
-		defer tx.Rollback()
-
-		// Set the transaction ISOLATION LEVEL to "Serializable" to allow for multiple instances of the server to run transactions against the same Postgres db
-		_, err = tx.Exec(`set transaction isolation level serializable`)
-		//_, err = tx.Exec(`set transaction isolation level repeatable read`) // <=== SET ISOLATION LEVEL
-		// If an error occurs return it to the parent function and restart the transaction
-		if err != nil {
-			return "error", err
+		// check if the source account and destination account are the same and return an error before any funds move as we do not support transactions of this type
+		if fromAccount == toAccount {
+			err = newError(ErrSameAccount, "the source account is the same as the destination account. ")
+		} else {
+			var source Account
+			if source, err = l.checkTransfer(tx, fromAccount, toAccount, transferAmount); err == nil {
+				transfer, err = l.insertTransfer(tx, source, fromAccount, toAccount, transferAmount, StatusPending)
+			}
 		}
+		refused = err
+		return err
+	})
+	switch {
+	case err == nil:
+		return l.completeTransfer(transfer)
+	case err == unresolved:
+		return Transfer{Status: StatusFailed}, err
+	// A transaction that could not even start (the store is unreachable or closed) is not an attempt worth recording
+	case err != refused:
+		log.Println("err", err)
+		return Transfer{Status: StatusFailed}, err
+	}
+	return l.recordFailedTransfer(fromAccount, toAccount, transferAmount, err), err
+}
 
-		// // Set a table lock so we exclude any type of conflicts that could generate data corruption
-		_, err = tx.Exec("LOCK TABLE Accounts IN SHARE ROW EXCLUSIVE MODE;") // <=== Lock table
-		// If an error occurs we retry the transaction
+// completeTransfer moves the funds of a pending transfer and marks it as completed, unless it was cancelled in the meantime. A transfer whose funds
+// cannot be moved is marked as failed together with the reason, and returned along with the error
+func (l ledger) completeTransfer(t Transfer) (Transfer, error) {
+	var cancelled, refused error
+	err := l.store.Update(func(tx LedgerTx) error {
+		cancelled, refused = nil, nil
+		current, err := tx.Transfer(transferRef{legacyID: t.LegacyID})
 		if err != nil {
-			log.Println(err, "...continuing...")
-			continue
+			return err
 		}
-
-		// Fetch the balance and source account currency
-		var sBalance string
-		var sCurrency string
-		txString := "SELECT Balance , Currency FROM " + s.accountsTable + " WHERE AccountID ='" + fromAccount + "';"
-		err = tx.QueryRow(txString).Scan(&sBalance, &sCurrency)
-		// Return error messages if the query finds that the indicated source account does not return any results
-		if err != nil {
-			if err == sql.ErrNoRows {
-				var ErrNoSource = errors.New("The source account does not exist")
-				return "error", ErrNoSource
-			}
-			// Otherwise return a relevant error message
-			var ErrUnexpect = errors.New("err: unexpected error")
-			cErr := errors.New(ErrUnexpect.Error() + err.Error())
-			return "error", cErr
+		if current.Status != StatusPending {
+			cancelled = newError(ErrInvalidTransferStatus, "The transfer was "+current.Status+" before its funds were moved")
+			return cancelled
 		}
-		// If there is an issue with reading the balance return an appropriate error
-		fBalance, err := strconv.ParseFloat(sBalance, 64)
-		if err != nil {
-			var ErrParse = errors.New("Error parsing blance")
-			return "error", ErrParse
+		// The balances may have changed since the transfer was recorded, so it is checked again before the funds move
+		source, err := l.checkTransfer(tx, t.From, t.To, t.Amount)
+		if err == nil {
+			if err = tx.SetTransferStatus(t.LegacyID, StatusCompleted, ""); err == nil {
+				t.Status = StatusCompleted
+				err = l.moveFunds(tx, source, t)
+			}
 		}
+		refused = err
+		return err
+	})
+	switch {
+	case err == nil:
+		return l.GetTransfer(t.ID)
+	case err == cancelled:
+		t.Status = StatusCancelled
+		return t, err
+	// The transfer stays pending when the store cannot be reached, and can still be cancelled
+	case err != refused:
+		log.Println("err", err)
+		return t, err
+	}
+	return l.failTransfer(t, err), err
+}
 
-		// If there is an issue with determining the transferred amout return an appropriate error
-		fAmount, err := strconv.ParseFloat(transferAmount, 64)
-		if err != nil {
-			var ErrParse = errors.New("err: error beginning transaction in postgres")
-			cErr := errors.New(ErrParse.Error() + err.Error())
-			return "error", cErr
+// failTransfer marks a pending transfer whose funds could not be moved as failed together with the reason, and returns it as a failed transfer.
+// Marking it is best effort: if it fails the transfer is only logged and stays pending
+func (l ledger) failTransfer(t Transfer, reason error) Transfer {
+	err := l.store.Update(func(tx LedgerTx) error {
+		current, err := tx.Transfer(transferRef{legacyID: t.LegacyID})
+		if err != nil || current.Status != StatusPending {
+			return err
 		}
-		// If the balance is insuficcient to allow the indicated amount transfer return an appropriate message
-		if fBalance < fAmount {
-			var ErrBalance = errors.New("Balance insuficient for transaction")
-			return "error", ErrBalance
-		}
-		// Fetch currency of the destination account
-		var dCurrency string
-		txString = "SELECT Currency FROM " + s.accountsTable + " WHERE AccountID ='" + toAccount + "';"
-		err = tx.QueryRow(txString).Scan(&dCurrency)
-		// if there is an error while fetching the currency retun an appropriate error
-		if err != nil {
-			if err == sql.ErrNoRows {
-				var ErrNoSource = errors.New("The destination account does not exist")
-				return "error", ErrNoSource
-			}
-			return "error", err
+		return tx.SetTransferStatus(t.LegacyID, StatusFailed, reason.Error())
+	})
+	if err != nil {
+		log.Println("err: could not mark the transfer as failed", err)
+		return t
+	}
+	t.Status, t.Reason = StatusFailed, reason.Error()
+	return t
+}
+
+// transferTx moves the funds from one account to another inside an already started transaction of the store and records the transfer, returning the new
+// (completed) transfer. It leaves the commit to the caller so that other writes (like marking interest accruals as posted) can be part of the same transaction
+func (l ledger) transferTx(tx LedgerTx, fromAccount string, toAccount string, transferAmount string) (Transfer, error) {
+	source, err := l.checkTransfer(tx, fromAccount, toAccount, transferAmount)
+	if err != nil {
+		return Transfer{}, err
+	}
+	t, err := l.insertTransfer(tx, source, fromAccount, toAccount, transferAmount, StatusCompleted)
+	if err != nil {
+		return Transfer{}, err
+	}
+	if err = l.moveFunds(tx, source, t); err != nil {
+		return Transfer{}, err
+	}
+	return t, nil
+}
+
+// insertTransfer records a checked transfer from the source account to another with the given status inside an already started transaction of the store,
+// returning the new transfer
+func (l ledger) insertTransfer(tx LedgerTx, source Account, fromAccount string, toAccount string, transferAmount string, status string) (Transfer, error) {
+	// The transfer is identified by a ULID in the API and keeps the numeric ID of the transfer ID sequence for legacy clients
+	transferUID, err := newULID(l.now())
+	if err != nil {
+		return Transfer{}, err
+	}
+	// Record the transfer and its first status change, timestamped by the clock of the store unless a server clock is injected
+	t := Transfer{ID: transferUID, From: fromAccount, To: toAccount, Amount: transferAmount, Currency: source.Currency, Time: l.clockTime(), Status: status}
+	return tx.InsertTransfer(t)
+}
+
+// checkTransfer makes sure that the funds of a transfer from one account to another can move, and returns the source account
+func (l ledger) checkTransfer(tx LedgerTx, fromAccount string, toAccount string, transferAmount string) (Account, error) {
+	// Fetch the balance and source account currency
+	source, err := tx.Account(fromAccount)
+	// Return error messages if the indicated source account does not exist
+	if err != nil {
+		if err == errNotFound {
+			var ErrNoSource = newError(ErrAccountNotFound, "The source account does not exist")
+			return Account{}, ErrNoSource
 		}
+		return Account{}, err
+	}
+	// If there is an issue with reading the balance return an appropriate error
+	fBalance, err := strconv.ParseFloat(source.Balance, 64)
+	if err != nil {
+		var ErrParse = errors.New("Error parsing blance")
+		return Account{}, ErrParse
+	}
 
-		// If the source account currency is not the same as the destination account currency, then the transfer is not allowed
-		if dCurrency != sCurrency {
-			var ErrMissmatch = errors.New("Not same currency in transaction source and destination")
-			return "error", ErrMissmatch
+	// Funds only move from the source to the destination account, by an amount in plain decimal notation whichever transport it came from
+	if err = checkAmount(transferAmount); err != nil {
+		return Account{}, err
+	}
+	// If there is an issue with determining the transferred amout return an appropriate error
+	fAmount, err := strconv.ParseFloat(transferAmount, 64)
+	if err != nil {
+		var ErrParse = errors.New("err: error beginning transaction in postgres")
+		cErr := newError(ErrInvalidAmount, ErrParse.Error()+err.Error())
+		return Account{}, cErr
+	}
+	// Make sure the currency is enabled in the registry and that the amount does not carry more decimals than the currency allows
+	if err = checkCurrency(tx, source.Currency, transferAmount); err != nil {
+		return Account{}, err
+	}
+	// If the balance is insuficcient to allow the indicated amount transfer return an appropriate message
+	if fBalance < fAmount {
+		var ErrBalance = newError(ErrInsufficientFunds, "Balance insuficient for transaction")
+		return Account{}, ErrBalance
+	}
+	// Fetch currency of the destination account
+	destination, err := tx.Account(toAccount)
+	if err != nil {
+		if err == errNotFound {
+			var ErrNoSource = newError(ErrAccountNotFound, "The destination account does not exist")
+			return Account{}, ErrNoSource
 		}
+		return Account{}, err
+	}
 
-		// Make query to implement in the Account table the subtraction of the transfer amount from the source account
-		txString = "UPDATE " + s.accountsTable + " SET balance = balance - " + transferAmount + " WHERE accountid = '" + fromAccount + "';"
-		_, err = tx.Exec(txString)
-		// In case of failures return appropriate error messages
-		if err != nil {
-			if strings.Contains(err.Error(), "could not serialize access due to") {
-				log.Println(err, "...continuing...")
-				continue
-			} else {
-				if strings.Contains(err.Error(), "new row for relation \"accounts\" violates check constraint") {
-					var ErrParse = errors.New("err: Please check available balance before making transactions. ")
-					return "error", ErrParse
-				}
-				return "error", err
-			}
+	// If the source account currency is not the same as the destination account currency, then the transfer is not allowed
+	if destination.Currency != source.Currency {
+		var ErrMissmatch = newError(ErrCurrencyMismatch, "Not same currency in transaction source and destination")
+		return Account{}, ErrMissmatch
+	}
+	return source, nil
+}
+
+// moveFunds moves the funds of a completed transfer from its source account, as it was before they moved, to its destination account and reports the
+// transfer inside an already started transaction of the store
+func (l ledger) moveFunds(tx LedgerTx, source Account, t Transfer) error {
+	// Subtract the transfer amount from the source account and add it to the destination account
+	if err := tx.Debit(t.From, t.Amount); err != nil {
+		if err == errNegativeBalance {
+			var ErrParse = newError(ErrInsufficientFunds, "err: Please check available balance before making transactions. ")
+			return ErrParse
 		}
-		// Make query to implement in the Account table the addition of the transfer amount to the destination account
-		txString = "UPDATE " + s.accountsTable + " SET balance = balance + " + transferAmount + " WHERE accountid= '" + toAccount + "';"
-		_, err = tx.Exec(txString)
-		// In case of failures if the error message is indicative of a db collision retry the transaction in a new iteration
+		return err
+	}
+	if err := tx.Credit(t.To, t.Amount); err != nil {
+		return err
+	}
+	// Report the transfer (without its history) and the balances it left through the outbox, in the same transaction so the event exists if and only if
+	// the transfer is committed
+	event := TransferEvent{Transfer: t}
+	event.History = nil
+	for _, id := range []string{t.From, t.To} {
+		account, err := tx.Account(id)
 		if err != nil {
-			if strings.Contains(err.Error(), "could not serialize access due to") {
-				continue
-			}
-			// otherwise return the error message to the outer function
-			return "error", err
+			return err
 		}
-		t0 := time.Now().Format(time.RFC3339)
-		// Insert into the table responsible for tracking transactions the information about this particular transfer:
-		// Transaction ID, Source account, Destination Account, Amount transferred, Currency of amount transferred and Timestamp of transaction
-		txString = "INSERT INTO " + s.transfersTable + " (transid, From_Account, To_Account, Amount, Currency, TTime) VALUES( nextval('Payment_counter'), '" + fromAccount + "', '" + toAccount + "', '" + transferAmount + "', '" + sCurrency + "', '" + t0 + "' );"
-		_, err = tx.Exec(txString)
-
+		balance, err := parseAmount(account.Balance)
 		if err != nil {
-			// In case of a db write conflict retry the transaction in a new iteration
-			if strings.Contains(err.Error(), "could not serialize access due to") {
-				continue
-			} else {
-				// otherwise return the error to the outer function
-				return "error", err
-			}
+			return err
 		}
-		// If we've gotten this far without any errors we can commit our transaction, break out of the transaction loop as the transaction was successful
-		// and return an appropriate status mesage with a nil error
-		tx.Commit()
-		isCommitted = true
+		event.Balances = append(event.Balances, AccountBalance{AccountID: account.ID, WalletID: account.WalletID, Currency: account.Currency, Balance: balance.FloatString(storeAmountScale)})
 	}
-	return "success", nil
+	if err := l.emit(tx, EventTransferCompleted, event); err != nil {
+		return err
+	}
+	if err := l.emitLowBalance(tx, source, t.Amount, t.ID); err != nil {
+		return err
+	}
+	// Announce the transfer to every instance of the service, which only happens once it is committed
+	if l.notifyChannel != "" {
+		if err := tx.Notify(l.notifyChannel, t.ID); err != nil {
+			return err
+		}
+	}
+	return nil
 }
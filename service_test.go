@@ -2,8 +2,10 @@ package wservice
 
 import (
 	"os"
+	"strconv"
 	"sync"
 	"testing"
+	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
@@ -135,3 +137,104 @@ func TestDoTransferConcurentRevertChanges(t *testing.T) {
 	wg.Wait()
 
 }
+
+func TestAmountScale(t *testing.T) {
+	assert.Equal(t, 0, amountScale("30"))
+	assert.Equal(t, 0, amountScale("30.000"))
+	assert.Equal(t, 1, amountScale("30.50"))
+	assert.Equal(t, 2, amountScale(" 30.05 "))
+	assert.Equal(t, 3, amountScale("0.005"))
+}
+
+func TestDoTransferWrongScale(t *testing.T) {
+	svc, _ := getDbConfig("./cmd/postgresql.cfg")
+	status, err := svc.DoTransfer("alice456", "bob123", "0.005")
+	assert.Contains(t, status, "error")
+	assert.EqualError(t, err, "The amount has more decimal places than currency USD allows (2)")
+}
+
+func TestSetCurrencyEnabled(t *testing.T) {
+	svc, _ := getDbConfig("./cmd/postgresql.cfg")
+	status, err := svc.SetCurrencyEnabled("USD", false)
+	assert.Contains(t, status, "success")
+	assert.Nil(t, err)
+	status, err = svc.DoTransfer("alice456", "bob123", "30")
+	assert.Contains(t, status, "error")
+	assert.EqualError(t, err, "The currency USD is disabled")
+	status, err = svc.SetCurrencyEnabled("USD", true)
+	assert.Contains(t, status, "success")
+	assert.Nil(t, err)
+	status, err = svc.SetCurrencyEnabled("XYZ", true)
+	assert.Contains(t, status, "error")
+	assert.EqualError(t, err, "The currency XYZ does not exist")
+}
+
+func TestGetWallet(t *testing.T) {
+	svc, _ := getDbConfig("./cmd/postgresql.cfg")
+	vSlice, err := svc.GetWallet("alice")
+	assert.Nil(t, err)
+	assert.Contains(t, vSlice, "Success.")
+	assert.Len(t, vSlice, 3)
+	_, err = svc.GetWallet("amockwallet")
+	assert.EqualError(t, err, "The wallet amockwallet does not exist")
+}
+
+func TestDoWalletTransfer(t *testing.T) {
+	svc, _ := getDbConfig("./cmd/postgresql.cfg")
+	transfer, err := svc.DoWalletTransfer("bob", "alice", "USD", "10")
+	assert.Equal(t, StatusCompleted, transfer.Status)
+	assert.Nil(t, err)
+	transfer, err = svc.DoWalletTransfer("alice", "bob", "USD", "10")
+	assert.Equal(t, StatusCompleted, transfer.Status)
+	assert.Nil(t, err)
+	transfer, err = svc.DoWalletTransfer("alice", "bob", "EUR", "10")
+	assert.Equal(t, StatusFailed, transfer.Status)
+	assert.EqualError(t, err, "The wallet bob has no EUR balance")
+}
+
+func TestTransferLifecycle(t *testing.T) {
+	svc, _ := getDbConfig("./cmd/postgresql.cfg")
+	transfer, err := svc.SubmitTransfer("bob123", "alice456", "5")
+	assert.Nil(t, err)
+	assert.Equal(t, StatusCompleted, transfer.Status)
+	id := transfer.ID
+	assert.True(t, isULID(id))
+	transfer, err = svc.GetTransfer(id)
+	assert.Nil(t, err)
+	assert.Equal(t, "bob123", transfer.From)
+	assert.Len(t, transfer.History, 2)
+	// Legacy clients can still look the transfer up by its numeric ID
+	legacy, err := svc.GetTransfer(strconv.FormatInt(transfer.LegacyID, 10))
+	assert.Nil(t, err)
+	assert.Equal(t, id, legacy.ID)
+	transfer, err = svc.ReverseTransfer(id)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusReversed, transfer.Status)
+	assert.Len(t, transfer.History, 3)
+	_, err = svc.ReverseTransfer(id)
+	assert.EqualError(t, err, "Only completed transfers can be reversed, the transfer is reversed")
+}
+
+func TestFailedTransferRecorded(t *testing.T) {
+	svc, _ := getDbConfig("./cmd/postgresql.cfg")
+	transfer, err := svc.SubmitTransfer("alice456", "bob123", "300000")
+	assert.EqualError(t, err, "Balance insuficient for transaction")
+	assert.Equal(t, StatusFailed, transfer.Status)
+	transfer, err = svc.GetTransfer(transfer.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusFailed, transfer.Status)
+	assert.Equal(t, "Balance insuficient for transaction", transfer.Reason)
+}
+
+func TestTransferServerClock(t *testing.T) {
+	svc, _ := getDbConfig("./cmd/postgresql.cfg")
+	s := svc.(ledger)
+	at := time.Date(2019, 3, 22, 20, 40, 18, 0, time.UTC)
+	s.clock = func() time.Time { return at }
+	transfer, err := s.SubmitTransfer("bob123", "alice456", "1")
+	assert.Nil(t, err)
+	assert.True(t, at.Equal(transfer.Time))
+	transfer, err = s.SubmitTransfer("alice456", "bob123", "1")
+	assert.Nil(t, err)
+	assert.True(t, at.Equal(transfer.Time))
+}
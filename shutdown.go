@@ -0,0 +1,96 @@
+package wservice
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// On shutdown the server drains: the requests already in flight (a DoTransfer in the middle of its transaction, for instance) are given the
+// configured drain timeout to complete while any new request is answered 503 Service Unavailable, so that clients and load balancers retry elsewhere
+
+// ErrShuttingDown is the error new requests get while the server drains
+var ErrShuttingDown = newError(ErrUnavailable, "err: the service is shutting down")
+
+// Drainer tracks the requests in flight and turns new requests away once draining has started
+type Drainer struct {
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+	idle     chan struct{}
+}
+
+// NewDrainer exported to be accessible from outside the package (from main)
+// NewDrainer creates a Drainer, Handler wraps the HTTP transport with it
+func NewDrainer() *Drainer {
+	return &Drainer{idle: make(chan struct{})}
+}
+
+// Handler counts the requests that next serves, and answers 503 with problem details once draining has started
+func (d *Drainer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.enter() {
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "1")
+			writeProblem(w, NewProblem(r.Context(), ErrShuttingDown))
+			return
+		}
+		defer d.leave()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// enter records a new request in flight, unless draining has started
+func (d *Drainer) enter() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inFlight++
+	return true
+}
+
+// leave records the end of a request, the last one to leave while draining lets Drain return
+func (d *Drainer) leave() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight--
+	if d.draining && d.inFlight == 0 {
+		close(d.idle)
+	}
+}
+
+// InFlight returns the number of requests being served
+func (d *Drainer) InFlight() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inFlight
+}
+
+// Draining reports whether draining has started
+func (d *Drainer) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// Drain turns new requests away and waits until the requests in flight are done, or until ctx is done in which case it returns an error
+// telling how many requests were still in flight
+func (d *Drainer) Drain(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.draining {
+		d.draining = true
+		if d.inFlight == 0 {
+			close(d.idle)
+		}
+	}
+	d.mu.Unlock()
+	select {
+	case <-d.idle:
+		return nil
+	case <-ctx.Done():
+		return newError(ErrUnavailable, "err: drain timeout, "+strconv.Itoa(d.InFlight())+" requests still in flight: "+ctx.Err().Error())
+	}
+}
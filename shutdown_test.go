@@ -0,0 +1,64 @@
+package wservice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainer(t *testing.T) {
+	d := NewDrainer()
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := d.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// A request in flight when the drain starts completes normally
+	inFlight := httptest.NewRecorder()
+	go handler.ServeHTTP(inFlight, httptest.NewRequest(http.MethodPost, "/submittransfer", nil))
+	<-started
+	assert.Equal(t, 1, d.InFlight())
+	drained := make(chan error)
+	go func() { drained <- d.Drain(context.Background()) }()
+	for !d.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A new request is turned away
+	rejected := httptest.NewRecorder()
+	handler.ServeHTTP(rejected, httptest.NewRequest(http.MethodGet, "/accounts", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rejected.Code)
+	assert.Equal(t, "application/problem+json", rejected.Header().Get("Content-Type"))
+	assert.Equal(t, "close", rejected.Header().Get("Connection"))
+
+	close(release)
+	assert.Nil(t, <-drained)
+	assert.Equal(t, http.StatusOK, inFlight.Code)
+	assert.Equal(t, 0, d.InFlight())
+}
+
+func TestDrainerTimeout(t *testing.T) {
+	d := NewDrainer()
+	assert.True(t, d.enter())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := d.Drain(ctx)
+	assert.True(t, errors.Is(err, ErrUnavailable))
+	assert.Contains(t, err.Error(), "1 requests still in flight")
+	d.leave()
+}
+
+func TestClosePool(t *testing.T) {
+	svc, _ := getDbConfig("./cmd/postgresql.cfg")
+	assert.Nil(t, ClosePool(svc))
+	_, err := svc.GetCurrencies()
+	assert.Equal(t, ErrShuttingDown, err)
+	assert.NotNil(t, ClosePool(NewValidating(svc)))
+}
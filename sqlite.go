@@ -0,0 +1,1176 @@
+package wservice
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	// Register the SQLite driver (cgo)
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteStore keeps the ledger in a single SQLite file, for single-node deployments that do not want to run Postgres. The file is in WAL mode so that
+// reads never wait for the writer. Writes are serialized by a pool of a single connection whose transactions start with BEGIN IMMEDIATE, which takes
+// the write lock of the file up front instead of locking a table and retrying on conflicts, while reads run on a separate read only pool. The schema
+// follows the one of the Postgres migrations and is created, with the currencies and demo accounts of the seed migration, when the file is first opened
+
+// sqliteTimeLayout is the layout times are stored with, in UTC. Its fixed width keeps the text columns in chronological order
+const sqliteTimeLayout = "2006-01-02 15:04:05.000000000"
+
+// sqliteBusyTimeout is how long, in milliseconds, a connection waits for the lock of the file held by another process before failing
+const sqliteBusyTimeout = 5000
+
+// sqliteSchema creates the ledger, with the configured table names. Balances and amounts are integers of thousandths (the scale of the decimal(9,3)
+// columns of Postgres), times are text in sqliteTimeLayout and dates text in dateLayout
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS {{.Currencies}} (
+    Code TEXT PRIMARY KEY CHECK (length(Code) = 3),
+    NumericCode TEXT NOT NULL UNIQUE CHECK (length(NumericCode) = 3),
+    MinorUnits INTEGER NOT NULL CHECK (MinorUnits BETWEEN 0 AND 3),
+    Name TEXT NOT NULL,
+    Enabled INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS {{.Accounts}} (
+    AccountID TEXT PRIMARY KEY,
+    Balance INTEGER NOT NULL CHECK (Balance>=0),
+    Currency TEXT NOT NULL REFERENCES {{.Currencies}}(Code),
+    InitialBalance INTEGER NOT NULL CHECK (InitialBalance>=0),
+    WalletID TEXT NOT NULL,
+    UNIQUE (WalletID, Currency)
+);
+
+CREATE TABLE IF NOT EXISTS {{.Transfers}} (
+    TransID INTEGER NOT NULL PRIMARY KEY,
+    UID TEXT NOT NULL UNIQUE,
+    From_Account TEXT NOT NULL REFERENCES {{.Accounts}}(AccountID),
+    To_Account TEXT NOT NULL REFERENCES {{.Accounts}}(AccountID),
+    Amount INTEGER NOT NULL CHECK (Amount>=0),
+    Currency TEXT NOT NULL REFERENCES {{.Currencies}}(Code),
+    TTime TEXT NOT NULL,
+    Status TEXT NOT NULL DEFAULT 'completed' CHECK (Status IN ('pending', 'completed', 'failed', 'reversed', 'cancelled'))
+);
+
+CREATE TABLE IF NOT EXISTS {{.TransferStatusChanges}} (
+    TransID INTEGER NOT NULL REFERENCES {{.Transfers}}(TransID),
+    Status TEXT NOT NULL,
+    ChangedAt TEXT NOT NULL,
+    Reason TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS {{.FailedTransfers}} (
+    TransID INTEGER NOT NULL PRIMARY KEY,
+    UID TEXT NOT NULL UNIQUE,
+    From_Account TEXT NOT NULL,
+    To_Account TEXT NOT NULL,
+    Amount TEXT NOT NULL,
+    Reason TEXT NOT NULL,
+    FailedAt TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS {{.InterestRates}} (
+    AccountID TEXT PRIMARY KEY REFERENCES {{.Accounts}}(AccountID),
+    AnnualRate TEXT NOT NULL CHECK (AnnualRate>=0),
+    DayCount TEXT NOT NULL CHECK (DayCount IN ('ACT/365', 'ACT/360', 'ACT/ACT', '30/360')),
+    ExpenseAccount TEXT NOT NULL REFERENCES {{.Accounts}}(AccountID),
+    StartDate TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS {{.InterestAccruals}} (
+    AccountID TEXT NOT NULL REFERENCES {{.Accounts}}(AccountID),
+    AccrualDate TEXT NOT NULL,
+    Balance TEXT NOT NULL,
+    AnnualRate TEXT NOT NULL,
+    Amount TEXT NOT NULL,
+    PostedTransfer INTEGER,
+    Posted INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (AccountID, AccrualDate)
+);
+
+CREATE TABLE IF NOT EXISTS {{.Outbox}} (
+    Seq INTEGER PRIMARY KEY AUTOINCREMENT,
+    EventType TEXT NOT NULL,
+    Payload TEXT NOT NULL,
+    CreatedAt TEXT NOT NULL,
+    Status TEXT NOT NULL DEFAULT 'pending' CHECK (Status IN ('pending', 'published')),
+    Attempts INTEGER NOT NULL DEFAULT 0,
+    LastError TEXT NOT NULL DEFAULT '',
+    PublishedAt TEXT
+);
+
+CREATE INDEX IF NOT EXISTS {{.Outbox}}_Pending ON {{.Outbox}} (Status, Seq);
+
+CREATE TABLE IF NOT EXISTS {{.Webhooks}} (
+    ID TEXT PRIMARY KEY,
+    URL TEXT NOT NULL,
+    Events TEXT NOT NULL,
+    Secret TEXT NOT NULL,
+    Enabled INTEGER NOT NULL DEFAULT 1,
+    Failures INTEGER NOT NULL DEFAULT 0,
+    CreatedAt TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS {{.WebhookDeliveries}} (
+    ID INTEGER PRIMARY KEY AUTOINCREMENT,
+    WebhookID TEXT NOT NULL REFERENCES {{.Webhooks}}(ID) ON DELETE CASCADE,
+    EventSeq INTEGER NOT NULL,
+    EventType TEXT NOT NULL,
+    Payload TEXT NOT NULL,
+    Status TEXT NOT NULL DEFAULT 'pending' CHECK (Status IN ('pending', 'delivered', 'failed')),
+    Attempts INTEGER NOT NULL DEFAULT 0,
+    ResponseCode INTEGER NOT NULL DEFAULT 0,
+    LastError TEXT NOT NULL DEFAULT '',
+    NextAttempt TEXT NOT NULL,
+    DeliveredAt TEXT,
+    CreatedAt TEXT NOT NULL,
+    UNIQUE (WebhookID, EventSeq)
+);
+
+CREATE INDEX IF NOT EXISTS {{.WebhookDeliveries}}_Due ON {{.WebhookDeliveries}} (Status, NextAttempt);
+
+CREATE TABLE IF NOT EXISTS {{.APIKeys}} (
+    ID TEXT PRIMARY KEY,
+    Name TEXT NOT NULL,
+    Scopes TEXT NOT NULL,
+    Hash TEXT NOT NULL UNIQUE,
+    CreatedAt TEXT NOT NULL,
+    ExpiresAt TEXT,
+    RevokedAt TEXT
+);
+
+-- SQLite has no sequences, the single row of this table is the last transfer ID handed out
+CREATE TABLE IF NOT EXISTS {{.Sequence}} (
+    LastID INTEGER NOT NULL
+);
+`
+
+// sqliteStore is the SQLite Store, its copies share the same pools
+type sqliteStore struct {
+	// path is the database file
+	path   string
+	tables TablesConfig
+	// sequence is the table numbering the transfers
+	sequence string
+	// writer is the pool of the single connection that writes, reader the pool of the read only connections (see pool.go)
+	writer *connPool
+	reader *connPool
+	// notifier announces the committed transfers to the listeners of the process
+	notifier *localNotifier
+}
+
+// newSQLiteStore creates the SQLite store of a configuration, the file is opened (and created if needed) on first use
+func newSQLiteStore(db DatabaseConfig) sqliteStore {
+	return sqliteStore{
+		path:     db.Name,
+		tables:   db.Tables,
+		sequence: db.Sequence,
+		writer:   &connPool{settings: poolSettings{maxOpenConns: 1, maxIdleConns: 1}},
+		reader:   &connPool{settings: db.poolSettings()},
+		notifier: &localNotifier{},
+	}
+}
+
+// dsn returns the connection string of the database file with the given options
+func (s sqliteStore) dsn(options string) string {
+	return "file:" + (&url.URL{Path: s.path}).EscapedPath() + "?" + options
+}
+
+// openWriter opens the writing connection and makes sure the ledger exists in the file
+func (s sqliteStore) openWriter() (*sqlx.DB, error) {
+	db, err := sqlx.Open(DriverSQLite, s.dsn("_journal_mode=WAL&_busy_timeout="+strconv.Itoa(sqliteBusyTimeout)+"&_foreign_keys=1&_txlock=immediate"))
+	if err != nil {
+		return nil, err
+	}
+	if err = s.createLedger(db); err != nil {
+		db.Close()
+		var ErrCreate = errors.New("err: could not create the ledger in " + s.path + " ")
+		return nil, dbError(ErrCreate, err)
+	}
+	return db, nil
+}
+
+// openReader opens the read only connections, once the writer has created the file
+func (s sqliteStore) openReader() (*sqlx.DB, error) {
+	if _, err := s.writer.get(s.openWriter); err != nil {
+		return nil, err
+	}
+	return sqlx.Open(DriverSQLite, s.dsn("mode=ro&_busy_timeout="+strconv.Itoa(sqliteBusyTimeout)+"&_foreign_keys=1"))
+}
+
+// createLedger creates the tables of the ledger that do not exist yet, and seeds a new ledger with the currencies and accounts of the seed migration
+func (s sqliteStore) createLedger(db *sqlx.DB) error {
+	var schema bytes.Buffer
+	if err := template.Must(template.New("schema").Parse(sqliteSchema)).Execute(&schema, s.objects()); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(schema.String()); err != nil {
+		return err
+	}
+	var sequences int
+	if err = tx.QueryRow("SELECT COUNT(*) FROM " + s.sequence + ";").Scan(&sequences); err != nil {
+		return err
+	}
+	// The sequence row is created along with the seed data, so a ledger that has it was already seeded
+	if sequences > 0 {
+		return tx.Commit()
+	}
+	if _, err = tx.Exec("INSERT INTO " + s.sequence + " (LastID) VALUES (0);"); err != nil {
+		return err
+	}
+	currencies, accounts, err := readSeed()
+	if err != nil {
+		return err
+	}
+	for _, c := range currencies {
+		if _, err = tx.Exec("INSERT INTO "+s.tables.Currencies+" (Code, NumericCode, MinorUnits, Name) VALUES (?1, ?2, ?3, ?4);", c.Code, c.NumericCode, c.MinorUnits, c.Name); err != nil {
+			return err
+		}
+	}
+	for _, a := range accounts {
+		balance, err := sqliteAmount(a.Balance)
+		if err != nil {
+			return err
+		}
+		initialBalance, err := sqliteAmount(a.InitialBalance)
+		if err != nil {
+			return err
+		}
+		txString := "INSERT INTO " + s.tables.Accounts + " (AccountID, Balance, Currency, InitialBalance, WalletID) VALUES (?1, ?2, ?3, ?4, ?5);"
+		if _, err = tx.Exec(txString, a.ID, balance, a.Currency, initialBalance, a.WalletID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// objects returns the configured name of every table of the ledger, keyed by the name the schema refers to it with
+func (s sqliteStore) objects() map[string]string {
+	return map[string]string{
+		"Accounts":              s.tables.Accounts,
+		"Transfers":             s.tables.Transfers,
+		"Currencies":            s.tables.Currencies,
+		"TransferStatusChanges": s.tables.TransferStatusChanges,
+		"FailedTransfers":       s.tables.FailedTransfers,
+		"InterestRates":         s.tables.InterestRates,
+		"InterestAccruals":      s.tables.InterestAccruals,
+		"Outbox":                s.tables.Outbox,
+		"Webhooks":              s.tables.Webhooks,
+		"WebhookDeliveries":     s.tables.WebhookDeliveries,
+		"APIKeys":               s.tables.APIKeys,
+		"Sequence":              s.sequence,
+	}
+}
+
+// sqliteError classifies the error of a statement for the ledger: no rows is errNotFound and anything else is unexpected. There is nothing to retry,
+// the writer holds the lock of the file for the whole transaction
+func sqliteError(err error) error {
+	if err == sql.ErrNoRows {
+		return errNotFound
+	}
+	var ErrUnexp = errors.New("err: Unexpected error occurred")
+	return dbError(ErrUnexp, err)
+}
+
+// Update is a sqliteStore type method that runs fn in a transaction of the single writing connection, which holds the write lock of the file until it ends
+func (s sqliteStore) Update(fn func(LedgerTx) error) error {
+	db, err := s.writer.get(s.openWriter)
+	if err != nil {
+		return err
+	}
+	// The driver begins the transaction with BEGIN IMMEDIATE, so a transaction of another process makes it wait (up to the busy timeout) rather than fail later
+	tx, err := db.Begin()
+	if err != nil {
+		var ErrStartTx = errors.New("err: error beginning transaction in sqlite")
+		return newError(ErrUnavailable, ErrStartTx.Error()+err.Error())
+	}
+	var notes []string
+	if err = fn(sqliteTx{s: s, tx: tx, notes: &notes}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	s.notifier.announce(notes)
+	return nil
+}
+
+// View is a sqliteStore type method that runs fn in a read only transaction, which reads a single snapshot of the file without waiting for the writer
+func (s sqliteStore) View(fn func(LedgerTx) error) error {
+	db, err := s.reader.get(s.openReader)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		var ErrStartTx = errors.New("err: error beginning transaction in sqlite")
+		return newError(ErrUnavailable, ErrStartTx.Error()+err.Error())
+	}
+	defer tx.Rollback()
+	if err = fn(sqliteTx{s: s, tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close is a sqliteStore type method that closes both pools for good, which checkpoints the WAL into the database file
+func (s sqliteStore) Close() error {
+	err := s.reader.close()
+	if wErr := s.writer.close(); wErr != nil {
+		return wErr
+	}
+	return err
+}
+
+// ping checks that the database file can be read
+func (s sqliteStore) ping(ctx context.Context) error {
+	db, err := s.reader.get(s.openReader)
+	if err != nil {
+		return err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		var ErrPing = errors.New("err: could not open the sqlite database ")
+		return dbError(ErrPing, err)
+	}
+	return nil
+}
+
+// sqliteAmount converts a decimal amount to the integer number of thousandths the SQLite ledger keeps it as
+func sqliteAmount(amount string) (int64, error) {
+	value, err := parseAmount(amount)
+	if err != nil {
+		return 0, err
+	}
+	value.Mul(value, big.NewRat(1000, 1))
+	if !value.IsInt() || !value.Num().IsInt64() {
+		var ErrAmount = newError(ErrInvalidAmount, "The amount "+amount+" cannot be kept with "+strconv.Itoa(storeAmountScale)+" decimal places")
+		return 0, ErrAmount
+	}
+	return value.Num().Int64(), nil
+}
+
+// sqliteDecimal converts an integer number of thousandths back to a decimal amount
+func sqliteDecimal(thousandths int64) string {
+	return big.NewRat(thousandths, 1000).FloatString(storeAmountScale)
+}
+
+// sqliteTime formats a time to be stored, the current time if it is zero
+func sqliteTime(t time.Time) string {
+	return stamp(t).UTC().Format(sqliteTimeLayout)
+}
+
+// parseSQLiteTime reads a stored time
+func parseSQLiteTime(value string) (time.Time, error) {
+	t, err := time.Parse(sqliteTimeLayout, value)
+	if err != nil {
+		return time.Time{}, sqliteError(err)
+	}
+	return t, nil
+}
+
+// parseSQLiteDate reads a stored date
+func parseSQLiteDate(value string) (time.Time, error) {
+	t, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return time.Time{}, sqliteError(err)
+	}
+	return t, nil
+}
+
+// sqliteTx is a transaction of the SQLite store
+type sqliteTx struct {
+	s  sqliteStore
+	tx *sql.Tx
+	// notes are the transfers to announce once the transaction is committed, nil in a read only transaction
+	notes *[]string
+}
+
+// accountColumns are the columns of an account as scanAccount reads them
+const accountColumns = "AccountID, WalletID, Currency, Balance, InitialBalance"
+
+// scanAccount reads an account out of a row
+func scanAccount(scan func(...interface{}) error) (Account, error) {
+	var a Account
+	var balance, initialBalance int64
+	if err := scan(&a.ID, &a.WalletID, &a.Currency, &balance, &initialBalance); err != nil {
+		return Account{}, sqliteError(err)
+	}
+	a.Balance, a.InitialBalance = sqliteDecimal(balance), sqliteDecimal(initialBalance)
+	return a, nil
+}
+
+// Accounts fetches every account ordered by ID
+func (t sqliteTx) Accounts() ([]Account, error) {
+	return t.accounts("SELECT " + accountColumns + " FROM " + t.s.tables.Accounts + " ORDER BY AccountID;")
+}
+
+// WalletAccounts fetches the accounts of a wallet ordered by currency
+func (t sqliteTx) WalletAccounts(walletID string) ([]Account, error) {
+	return t.accounts("SELECT "+accountColumns+" FROM "+t.s.tables.Accounts+" WHERE WalletID = ?1 ORDER BY Currency;", walletID)
+}
+
+// accounts runs a query of accounts
+func (t sqliteTx) accounts(query string, args ...interface{}) ([]Account, error) {
+	rows, err := t.tx.Query(query, args...)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	var accounts []Account
+	for rows.Next() {
+		a, err := scanAccount(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+	return accounts, nil
+}
+
+// Account fetches a single account
+func (t sqliteTx) Account(id string) (Account, error) {
+	return scanAccount(t.tx.QueryRow("SELECT "+accountColumns+" FROM "+t.s.tables.Accounts+" WHERE AccountID = ?1;", id).Scan)
+}
+
+// Debit subtracts an amount from the balance of an account, the check constraint of the Accounts table refuses a negative balance
+func (t sqliteTx) Debit(accountID string, amount string) error {
+	value, err := sqliteAmount(amount)
+	if err != nil {
+		return err
+	}
+	_, err = t.tx.Exec("UPDATE "+t.s.tables.Accounts+" SET Balance = Balance - ?1 WHERE AccountID = ?2;", value, accountID)
+	if err != nil && strings.Contains(err.Error(), "CHECK constraint failed") {
+		return errNegativeBalance
+	}
+	if err != nil {
+		return sqliteError(err)
+	}
+	return nil
+}
+
+// Credit adds an amount to the balance of an account
+func (t sqliteTx) Credit(accountID string, amount string) error {
+	value, err := sqliteAmount(amount)
+	if err != nil {
+		return err
+	}
+	if _, err = t.tx.Exec("UPDATE "+t.s.tables.Accounts+" SET Balance = Balance + ?1 WHERE AccountID = ?2;", value, accountID); err != nil {
+		return sqliteError(err)
+	}
+	return nil
+}
+
+// Currencies fetches the whole currency registry ordered by code
+func (t sqliteTx) Currencies() ([]Currency, error) {
+	rows, err := t.tx.Query("SELECT Code, NumericCode, MinorUnits, Name, Enabled FROM " + t.s.tables.Currencies + " ORDER BY Code;")
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	var currencies []Currency
+	for rows.Next() {
+		var c Currency
+		if err := rows.Scan(&c.Code, &c.NumericCode, &c.MinorUnits, &c.Name, &c.Enabled); err != nil {
+			return nil, sqliteError(err)
+		}
+		currencies = append(currencies, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+	return currencies, nil
+}
+
+// Currency fetches a single currency of the registry
+func (t sqliteTx) Currency(code string) (Currency, error) {
+	var c Currency
+	err := t.tx.QueryRow("SELECT Code, NumericCode, MinorUnits, Name, Enabled FROM "+t.s.tables.Currencies+" WHERE Code = ?1;", code).
+		Scan(&c.Code, &c.NumericCode, &c.MinorUnits, &c.Name, &c.Enabled)
+	if err != nil {
+		return Currency{}, sqliteError(err)
+	}
+	return c, nil
+}
+
+// SetCurrencyEnabled enables or disables a currency of the registry
+func (t sqliteTx) SetCurrencyEnabled(code string, enabled bool) error {
+	res, err := t.tx.Exec("UPDATE "+t.s.tables.Currencies+" SET Enabled = ?1 WHERE Code = ?2;", enabled, code)
+	if err != nil {
+		return sqliteError(err)
+	}
+	// If no row was updated the currency code is not part of the registry
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+// transferColumns are the columns of a transfer as scanTransfer reads them
+const transferColumns = "TransID, UID, From_Account, To_Account, Amount, Currency, TTime, Status"
+
+// scanTransfer reads a transfer out of a row
+func scanTransfer(scan func(...interface{}) error) (Transfer, error) {
+	var tr Transfer
+	var amount int64
+	var at string
+	if err := scan(&tr.LegacyID, &tr.ID, &tr.From, &tr.To, &amount, &tr.Currency, &at, &tr.Status); err != nil {
+		return Transfer{}, sqliteError(err)
+	}
+	tr.Amount = sqliteDecimal(amount)
+	var err error
+	tr.Time, err = parseSQLiteTime(at)
+	return tr, err
+}
+
+// Transfers fetches every recorded transfer, whatever its status, ordered by legacy ID
+func (t sqliteTx) Transfers() ([]Transfer, error) {
+	rows, err := t.tx.Query("SELECT " + transferColumns + " FROM " + t.s.tables.Transfers + " ORDER BY TransID;")
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	var transfers []Transfer
+	for rows.Next() {
+		tr, err := scanTransfer(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, tr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+	return transfers, nil
+}
+
+// Transfer fetches a committed transfer with its status history
+func (t sqliteTx) Transfer(ref transferRef) (Transfer, error) {
+	column, key := refColumn(ref)
+	tr, err := scanTransfer(t.tx.QueryRow("SELECT "+transferColumns+" FROM "+t.s.tables.Transfers+" WHERE "+column+" = ?1;", key).Scan)
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	rows, err := t.tx.Query("SELECT Status, ChangedAt, Reason FROM "+t.s.tables.TransferStatusChanges+" WHERE TransID = ?1 ORDER BY ChangedAt, rowid;", tr.LegacyID)
+	if err != nil {
+		return Transfer{}, sqliteError(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c TransferStatusChange
+		var changedAt string
+		if err := rows.Scan(&c.Status, &changedAt, &c.Reason); err != nil {
+			return Transfer{}, sqliteError(err)
+		}
+		if c.ChangedAt, err = parseSQLiteTime(changedAt); err != nil {
+			return Transfer{}, err
+		}
+		tr.History = append(tr.History, c)
+	}
+	if err := rows.Err(); err != nil {
+		return Transfer{}, sqliteError(err)
+	}
+	return tr, nil
+}
+
+// FailedTransfer fetches a failed transfer attempt
+func (t sqliteTx) FailedTransfer(ref transferRef) (Transfer, error) {
+	column, key := refColumn(ref)
+	var tr Transfer
+	var failedAt string
+	txString := "SELECT TransID, UID, From_Account, To_Account, Amount, Reason, FailedAt FROM " + t.s.tables.FailedTransfers + " WHERE " + column + " = ?1;"
+	if err := t.tx.QueryRow(txString, key).Scan(&tr.LegacyID, &tr.ID, &tr.From, &tr.To, &tr.Amount, &tr.Reason, &failedAt); err != nil {
+		return Transfer{}, sqliteError(err)
+	}
+	var err error
+	tr.Time, err = parseSQLiteTime(failedAt)
+	return tr, err
+}
+
+// nextTransferID numbers a new transfer or failed transfer attempt out of the sequence table
+func (t sqliteTx) nextTransferID() (int64, error) {
+	var id int64
+	if err := t.tx.QueryRow("UPDATE " + t.s.sequence + " SET LastID = LastID + 1 RETURNING LastID;").Scan(&id); err != nil {
+		return 0, sqliteError(err)
+	}
+	return id, nil
+}
+
+// InsertTransfer records a new transfer numbered by the sequence table, and its first status change
+func (t sqliteTx) InsertTransfer(tr Transfer) (Transfer, error) {
+	amount, err := sqliteAmount(tr.Amount)
+	if err != nil {
+		return Transfer{}, err
+	}
+	if tr.LegacyID, err = t.nextTransferID(); err != nil {
+		return Transfer{}, err
+	}
+	at := sqliteTime(tr.Time)
+	txString := "INSERT INTO " + t.s.tables.Transfers + " (" + transferColumns + ") VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8);"
+	if _, err = t.tx.Exec(txString, tr.LegacyID, tr.ID, tr.From, tr.To, amount, tr.Currency, at, tr.Status); err != nil {
+		return Transfer{}, sqliteError(err)
+	}
+	if _, err = t.tx.Exec("INSERT INTO "+t.s.tables.TransferStatusChanges+" (TransID, Status, ChangedAt) VALUES (?1, ?2, ?3);", tr.LegacyID, tr.Status, sqliteTime(time.Time{})); err != nil {
+		return Transfer{}, sqliteError(err)
+	}
+	tr.Time, err = parseSQLiteTime(at)
+	return tr, err
+}
+
+// InsertFailedTransfer records a failed transfer attempt, numbered by the same sequence table as the transfers
+func (t sqliteTx) InsertFailedTransfer(tr Transfer) (Transfer, error) {
+	var err error
+	if tr.LegacyID, err = t.nextTransferID(); err != nil {
+		return Transfer{}, err
+	}
+	at := sqliteTime(tr.Time)
+	txString := "INSERT INTO " + t.s.tables.FailedTransfers + " (TransID, UID, From_Account, To_Account, Amount, Reason, FailedAt) VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7);"
+	if _, err = t.tx.Exec(txString, tr.LegacyID, tr.ID, tr.From, tr.To, tr.Amount, tr.Reason, at); err != nil {
+		return Transfer{}, sqliteError(err)
+	}
+	tr.Time, err = parseSQLiteTime(at)
+	return tr, err
+}
+
+// SetTransferStatus moves a transfer to a new status and records the change in its history
+func (t sqliteTx) SetTransferStatus(legacyID int64, status string, reason string) error {
+	_, err := t.tx.Exec("UPDATE "+t.s.tables.Transfers+" SET Status = ?1 WHERE TransID = ?2;", status, legacyID)
+	if err == nil {
+		txString := "INSERT INTO " + t.s.tables.TransferStatusChanges + " (TransID, Status, ChangedAt, Reason) VALUES (?1, ?2, ?3, ?4);"
+		_, err = t.tx.Exec(txString, legacyID, status, sqliteTime(time.Time{}), reason)
+	}
+	if err != nil {
+		return sqliteError(err)
+	}
+	return nil
+}
+
+// SetInterestRate creates or changes the interest configuration of an account, a new configuration starts on its start date (today unless it has one)
+func (t sqliteTx) SetInterestRate(r InterestRate) error {
+	if r.StartDate.IsZero() {
+		r.StartDate = today()
+	}
+	txString := "INSERT INTO " + t.s.tables.InterestRates + " (AccountID, AnnualRate, DayCount, ExpenseAccount, StartDate) VALUES (?1, ?2, ?3, ?4, ?5) " +
+		"ON CONFLICT (AccountID) DO UPDATE SET AnnualRate = excluded.AnnualRate, DayCount = excluded.DayCount, ExpenseAccount = excluded.ExpenseAccount;"
+	if _, err := t.tx.Exec(txString, r.AccountID, r.AnnualRate, r.DayCount, r.ExpenseAccount, r.StartDate.Format(dateLayout)); err != nil {
+		return sqliteError(err)
+	}
+	return nil
+}
+
+// interestRateColumns are the columns of an interest configuration as scanInterestRate reads them
+const interestRateColumns = "AccountID, AnnualRate, DayCount, ExpenseAccount, StartDate"
+
+// scanInterestRate reads an interest configuration out of a row
+func scanInterestRate(scan func(...interface{}) error) (InterestRate, error) {
+	var r InterestRate
+	var startDate string
+	if err := scan(&r.AccountID, &r.AnnualRate, &r.DayCount, &r.ExpenseAccount, &startDate); err != nil {
+		return InterestRate{}, sqliteError(err)
+	}
+	var err error
+	r.StartDate, err = parseSQLiteDate(startDate)
+	return r, err
+}
+
+// InterestRate fetches the interest configuration of an account
+func (t sqliteTx) InterestRate(accountID string) (InterestRate, error) {
+	return scanInterestRate(t.tx.QueryRow("SELECT "+interestRateColumns+" FROM "+t.s.tables.InterestRates+" WHERE AccountID = ?1;", accountID).Scan)
+}
+
+// InterestRates fetches the interest configuration of every account
+func (t sqliteTx) InterestRates() ([]InterestRate, error) {
+	rows, err := t.tx.Query("SELECT " + interestRateColumns + " FROM " + t.s.tables.InterestRates + " ORDER BY AccountID;")
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	var rates []InterestRate
+	for rows.Next() {
+		r, err := scanInterestRate(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+	return rates, nil
+}
+
+// LastAccrualDate fetches the last day an account accrued interest for
+func (t sqliteTx) LastAccrualDate(accountID string) (time.Time, error) {
+	var last sql.NullString
+	if err := t.tx.QueryRow("SELECT MAX(AccrualDate) FROM "+t.s.tables.InterestAccruals+" WHERE AccountID = ?1;", accountID).Scan(&last); err != nil {
+		return time.Time{}, sqliteError(err)
+	}
+	if !last.Valid {
+		return time.Time{}, nil
+	}
+	return parseSQLiteDate(last.String)
+}
+
+// BalanceAt rebuilds the balance of an account at a time from its current balance by reverting the transfers committed since
+func (t sqliteTx) BalanceAt(accountID string, at time.Time) (string, error) {
+	var balance int64
+	txString := "SELECT Balance - COALESCE((SELECT SUM(CASE WHEN To_Account = ?1 THEN Amount ELSE -Amount END) FROM " + t.s.tables.Transfers +
+		" WHERE (From_Account = ?1 OR To_Account = ?1) AND TTime >= ?2 AND Status IN ('completed', 'reversed')), 0) FROM " + t.s.tables.Accounts + " WHERE AccountID = ?1;"
+	if err := t.tx.QueryRow(txString, accountID, at.UTC().Format(sqliteTimeLayout)).Scan(&balance); err != nil {
+		return "", sqliteError(err)
+	}
+	return sqliteDecimal(balance), nil
+}
+
+// InsertAccrual records the interest accrued by an account on a day, a day that was already accrued is left as it is
+func (t sqliteTx) InsertAccrual(a InterestAccrual) error {
+	txString := "INSERT INTO " + t.s.tables.InterestAccruals + " (AccountID, AccrualDate, Balance, AnnualRate, Amount) VALUES (?1, ?2, ?3, ?4, ?5) " +
+		"ON CONFLICT (AccountID, AccrualDate) DO NOTHING;"
+	if _, err := t.tx.Exec(txString, a.AccountID, a.Date.Format(dateLayout), a.Balance, a.AnnualRate, a.Amount); err != nil {
+		return sqliteError(err)
+	}
+	return nil
+}
+
+// UnpostedAccounts fetches the accounts with interest accrued between from and until that was not posted yet
+func (t sqliteTx) UnpostedAccounts(from time.Time, until time.Time) ([]string, error) {
+	txString := "SELECT DISTINCT AccountID FROM " + t.s.tables.InterestAccruals + " WHERE NOT Posted AND AccrualDate >= ?1 AND AccrualDate < ?2 ORDER BY AccountID;"
+	rows, err := t.tx.Query(txString, from.Format(dateLayout), until.Format(dateLayout))
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	var accounts []string
+	for rows.Next() {
+		var accountID string
+		if err := rows.Scan(&accountID); err != nil {
+			return nil, sqliteError(err)
+		}
+		accounts = append(accounts, accountID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+	return accounts, nil
+}
+
+// UnpostedInterest sums the interest accrued by an account between from and until that was not posted yet. SQLite sums numbers as floats, so the
+// amounts are summed here
+func (t sqliteTx) UnpostedInterest(accountID string, from time.Time, until time.Time) (string, error) {
+	txString := "SELECT Amount FROM " + t.s.tables.InterestAccruals + " WHERE AccountID = ?1 AND NOT Posted AND AccrualDate >= ?2 AND AccrualDate < ?3;"
+	rows, err := t.tx.Query(txString, accountID, from.Format(dateLayout), until.Format(dateLayout))
+	if err != nil {
+		return "", sqliteError(err)
+	}
+	defer rows.Close()
+	var days int
+	total := new(big.Rat)
+	for rows.Next() {
+		var amount string
+		if err := rows.Scan(&amount); err != nil {
+			return "", sqliteError(err)
+		}
+		value, err := parseAmount(amount)
+		if err != nil {
+			return "", err
+		}
+		total.Add(total, value)
+		days++
+	}
+	if err := rows.Err(); err != nil {
+		return "", sqliteError(err)
+	}
+	if days == 0 {
+		return "", errNotFound
+	}
+	return total.FloatString(accrualScale), nil
+}
+
+// FirstUnpostedAccrual fetches the first day before until whose interest was accrued but not posted yet
+func (t sqliteTx) FirstUnpostedAccrual(until time.Time) (time.Time, error) {
+	var first sql.NullString
+	if err := t.tx.QueryRow("SELECT MIN(AccrualDate) FROM "+t.s.tables.InterestAccruals+" WHERE NOT Posted AND AccrualDate < ?1;", until.Format(dateLayout)).Scan(&first); err != nil {
+		return time.Time{}, sqliteError(err)
+	}
+	if !first.Valid {
+		return time.Time{}, nil
+	}
+	return parseSQLiteDate(first.String)
+}
+
+// MarkInterestPosted marks the interest accrued by an account between from and until as posted by a transfer
+func (t sqliteTx) MarkInterestPosted(accountID string, from time.Time, until time.Time, transferID int64) error {
+	postedTransfer := sql.NullInt64{Int64: transferID, Valid: transferID != 0}
+	txString := "UPDATE " + t.s.tables.InterestAccruals + " SET Posted = 1, PostedTransfer = ?1 WHERE AccountID = ?2 AND NOT Posted AND AccrualDate >= ?3 AND AccrualDate < ?4;"
+	if _, err := t.tx.Exec(txString, postedTransfer, accountID, from.Format(dateLayout), until.Format(dateLayout)); err != nil {
+		return sqliteError(err)
+	}
+	return nil
+}
+
+// sqliteEventColumns are the columns of an event of the outbox as events reads them
+const sqliteEventColumns = "Seq, EventType, Payload, CreatedAt, Status, Attempts, LastError, PublishedAt"
+
+// InsertEvent writes a pending event to the outbox, numbered by its autoincrement column
+func (t sqliteTx) InsertEvent(e Event) (Event, error) {
+	at := sqliteTime(e.CreatedAt)
+	res, err := t.tx.Exec("INSERT INTO "+t.s.tables.Outbox+" (EventType, Payload, CreatedAt) VALUES (?1, ?2, ?3);", e.Type, string(e.Payload), at)
+	if err != nil {
+		return Event{}, sqliteError(err)
+	}
+	if e.Seq, err = res.LastInsertId(); err != nil {
+		return Event{}, sqliteError(err)
+	}
+	e.Status = EventPending
+	e.CreatedAt, err = parseSQLiteTime(at)
+	return e, err
+}
+
+// PendingEvents fetches the oldest pending events of the outbox
+func (t sqliteTx) PendingEvents(limit int) ([]Event, error) {
+	return t.events("SELECT "+sqliteEventColumns+" FROM "+t.s.tables.Outbox+" WHERE Status = ?1 ORDER BY Seq LIMIT ?2;", EventPending, limit)
+}
+
+// Events fetches the events of the outbox that follow a sequence number
+func (t sqliteTx) Events(after int64, limit int) ([]Event, error) {
+	return t.events("SELECT "+sqliteEventColumns+" FROM "+t.s.tables.Outbox+" WHERE Seq > ?1 ORDER BY Seq LIMIT ?2;", after, limit)
+}
+
+// LastEventSeq fetches the sequence number of the last event of the outbox
+func (t sqliteTx) LastEventSeq() (int64, error) {
+	var seq int64
+	if err := t.tx.QueryRow("SELECT COALESCE(MAX(Seq), 0) FROM " + t.s.tables.Outbox + ";").Scan(&seq); err != nil {
+		return 0, sqliteError(err)
+	}
+	return seq, nil
+}
+
+// Notify announces a transfer to the listeners of the process once the transaction is committed
+func (t sqliteTx) Notify(channel string, transferID string) error {
+	if t.notes == nil {
+		return errReadOnly
+	}
+	*t.notes = append(*t.notes, transferID)
+	return nil
+}
+
+// events runs a query of events
+func (t sqliteTx) events(query string, args ...interface{}) ([]Event, error) {
+	rows, err := t.tx.Query(query, args...)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var payload, createdAt string
+		var publishedAt sql.NullString
+		if err := rows.Scan(&e.Seq, &e.Type, &payload, &createdAt, &e.Status, &e.Attempts, &e.LastError, &publishedAt); err != nil {
+			return nil, sqliteError(err)
+		}
+		e.Payload = []byte(payload)
+		if e.CreatedAt, err = parseSQLiteTime(createdAt); err != nil {
+			return nil, err
+		}
+		if publishedAt.Valid {
+			if e.PublishedAt, err = parseSQLiteTime(publishedAt.String); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+	return events, nil
+}
+
+// MarkEventPublished marks an event of the outbox as published
+func (t sqliteTx) MarkEventPublished(seq int64) error {
+	txString := "UPDATE " + t.s.tables.Outbox + " SET Status = ?1, Attempts = Attempts + 1, PublishedAt = ?2 WHERE Seq = ?3;"
+	if _, err := t.tx.Exec(txString, EventPublished, sqliteTime(time.Time{}), seq); err != nil {
+		return sqliteError(err)
+	}
+	return nil
+}
+
+// MarkEventFailed records a failed attempt to publish an event of the outbox
+func (t sqliteTx) MarkEventFailed(seq int64, reason string) error {
+	if _, err := t.tx.Exec("UPDATE "+t.s.tables.Outbox+" SET Attempts = Attempts + 1, LastError = ?1 WHERE Seq = ?2;", reason, seq); err != nil {
+		return sqliteError(err)
+	}
+	return nil
+}
+
+// sqliteWebhookColumns are the columns of a webhook as webhooks reads them
+const sqliteWebhookColumns = "ID, URL, Events, Secret, Enabled, Failures, CreatedAt"
+
+// InsertWebhook records a new webhook, its event types being kept as a comma separated list
+func (t sqliteTx) InsertWebhook(w Webhook) (Webhook, error) {
+	at := sqliteTime(w.CreatedAt)
+	txString := "INSERT INTO " + t.s.tables.Webhooks + " (ID, URL, Events, Secret, Enabled, CreatedAt) VALUES (?1, ?2, ?3, ?4, ?5, ?6);"
+	if _, err := t.tx.Exec(txString, w.ID, w.URL, strings.Join(w.Events, ","), w.Secret, w.Enabled, at); err != nil {
+		return Webhook{}, sqliteError(err)
+	}
+	var err error
+	w.CreatedAt, err = parseSQLiteTime(at)
+	return w, err
+}
+
+// Webhooks fetches every webhook ordered by ID
+func (t sqliteTx) Webhooks() ([]Webhook, error) {
+	return t.webhooks("SELECT " + sqliteWebhookColumns + " FROM " + t.s.tables.Webhooks + " ORDER BY ID;")
+}
+
+// Webhook fetches a single webhook
+func (t sqliteTx) Webhook(id string) (Webhook, error) {
+	webhooks, err := t.webhooks("SELECT "+sqliteWebhookColumns+" FROM "+t.s.tables.Webhooks+" WHERE ID = ?1;", id)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if len(webhooks) == 0 {
+		return Webhook{}, errNotFound
+	}
+	return webhooks[0], nil
+}
+
+// webhooks runs a query of webhooks
+func (t sqliteTx) webhooks(query string, args ...interface{}) ([]Webhook, error) {
+	rows, err := t.tx.Query(query, args...)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	var webhooks []Webhook
+	for rows.Next() {
+		var w Webhook
+		var events, createdAt string
+		if err := rows.Scan(&w.ID, &w.URL, &events, &w.Secret, &w.Enabled, &w.Failures, &createdAt); err != nil {
+			return nil, sqliteError(err)
+		}
+		w.Events = strings.Split(events, ",")
+		if w.CreatedAt, err = parseSQLiteTime(createdAt); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook changes whether a webhook is enabled and its count of consecutive failures
+func (t sqliteTx) UpdateWebhook(w Webhook) error {
+	return t.exec("UPDATE "+t.s.tables.Webhooks+" SET Enabled = ?1, Failures = ?2 WHERE ID = ?3;", w.Enabled, w.Failures, w.ID)
+}
+
+// DeleteWebhook deletes a webhook, its deliveries going with it through the cascading foreign key
+func (t sqliteTx) DeleteWebhook(id string) error {
+	return t.exec("DELETE FROM "+t.s.tables.Webhooks+" WHERE ID = ?1;", id)
+}
+
+// exec runs a statement that changes a single row, errNotFound when there is no such row
+func (t sqliteTx) exec(query string, args ...interface{}) error {
+	res, err := t.tx.Exec(query, args...)
+	if err != nil {
+		return sqliteError(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return sqliteError(err)
+	} else if n == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+// sqliteDeliveryColumns are the columns of a webhook delivery as deliveries reads them
+const sqliteDeliveryColumns = "ID, WebhookID, EventSeq, EventType, Payload, Status, Attempts, ResponseCode, LastError, NextAttempt, DeliveredAt, CreatedAt"
+
+// InsertDelivery queues a pending delivery of an event to a webhook, the unique constraint on the webhook and event leaving an event already queued as it is
+func (t sqliteTx) InsertDelivery(d WebhookDelivery) error {
+	txString := "INSERT INTO " + t.s.tables.WebhookDeliveries + " (WebhookID, EventSeq, EventType, Payload, NextAttempt, CreatedAt) VALUES (?1, ?2, ?3, ?4, ?5, ?6) " +
+		"ON CONFLICT (WebhookID, EventSeq) DO NOTHING;"
+	if _, err := t.tx.Exec(txString, d.WebhookID, d.EventSeq, d.EventType, string(d.Payload), sqliteTime(d.NextAttempt), sqliteTime(time.Time{})); err != nil {
+		return sqliteError(err)
+	}
+	return nil
+}
+
+// DueDeliveries fetches the pending deliveries of enabled webhooks that are due, the most overdue first
+func (t sqliteTx) DueDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	txString := "SELECT d." + strings.Replace(sqliteDeliveryColumns, ", ", ", d.", -1) + " FROM " + t.s.tables.WebhookDeliveries + " d JOIN " + t.s.tables.Webhooks + " w ON w.ID = d.WebhookID " +
+		"WHERE d.Status = ?1 AND d.NextAttempt <= ?2 AND w.Enabled ORDER BY d.NextAttempt, d.ID LIMIT ?3;"
+	return t.deliveries(txString, DeliveryPending, sqliteTime(now), limit)
+}
+
+// Deliveries fetches the most recent deliveries of a webhook
+func (t sqliteTx) Deliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	return t.deliveries("SELECT "+sqliteDeliveryColumns+" FROM "+t.s.tables.WebhookDeliveries+" WHERE WebhookID = ?1 ORDER BY ID DESC LIMIT ?2;", webhookID, limit)
+}
+
+// Delivery fetches a single delivery
+func (t sqliteTx) Delivery(id int64) (WebhookDelivery, error) {
+	deliveries, err := t.deliveries("SELECT "+sqliteDeliveryColumns+" FROM "+t.s.tables.WebhookDeliveries+" WHERE ID = ?1;", id)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	if len(deliveries) == 0 {
+		return WebhookDelivery{}, errNotFound
+	}
+	return deliveries[0], nil
+}
+
+// deliveries runs a query of webhook deliveries
+func (t sqliteTx) deliveries(query string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := t.tx.Query(query, args...)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		var payload, nextAttempt, createdAt string
+		var deliveredAt sql.NullString
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventSeq, &d.EventType, &payload, &d.Status, &d.Attempts, &d.ResponseCode, &d.LastError, &nextAttempt, &deliveredAt, &createdAt); err != nil {
+			return nil, sqliteError(err)
+		}
+		d.Payload = []byte(payload)
+		if d.NextAttempt, err = parseSQLiteTime(nextAttempt); err != nil {
+			return nil, err
+		}
+		if d.CreatedAt, err = parseSQLiteTime(createdAt); err != nil {
+			return nil, err
+		}
+		if deliveredAt.Valid {
+			at, err := parseSQLiteTime(deliveredAt.String)
+			if err != nil {
+				return nil, err
+			}
+			d.DeliveredAt = &at
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+	return deliveries, nil
+}
+
+// UpdateDelivery records the status, attempts, outcome of the last attempt and next attempt of a delivery
+func (t sqliteTx) UpdateDelivery(d WebhookDelivery) error {
+	var deliveredAt sql.NullString
+	if d.DeliveredAt != nil {
+		deliveredAt = sql.NullString{String: sqliteTime(*d.DeliveredAt), Valid: true}
+	}
+	txString := "UPDATE " + t.s.tables.WebhookDeliveries + " SET Status = ?1, Attempts = ?2, ResponseCode = ?3, LastError = ?4, NextAttempt = ?5, DeliveredAt = ?6 WHERE ID = ?7;"
+	return t.exec(txString, d.Status, d.Attempts, d.ResponseCode, d.LastError, sqliteTime(d.NextAttempt), deliveredAt, d.ID)
+}
+
+// sqliteAPIKeyColumns are the columns of an API key as apiKeys reads them
+const sqliteAPIKeyColumns = "ID, Name, Scopes, Hash, CreatedAt, ExpiresAt, RevokedAt"
+
+// InsertAPIKey records a new API key with the hash of its secret, its scopes being kept as a comma separated list
+func (t sqliteTx) InsertAPIKey(k APIKey) (APIKey, error) {
+	at := sqliteTime(k.CreatedAt)
+	txString := "INSERT INTO " + t.s.tables.APIKeys + " (ID, Name, Scopes, Hash, CreatedAt) VALUES (?1, ?2, ?3, ?4, ?5);"
+	if _, err := t.tx.Exec(txString, k.ID, k.Name, strings.Join(k.Scopes, ","), k.Hash, at); err != nil {
+		return APIKey{}, sqliteError(err)
+	}
+	var err error
+	k.CreatedAt, err = parseSQLiteTime(at)
+	return k, err
+}
+
+// APIKeys fetches every API key ordered by ID
+func (t sqliteTx) APIKeys() ([]APIKey, error) {
+	return t.apiKeys("SELECT " + sqliteAPIKeyColumns + " FROM " + t.s.tables.APIKeys + " ORDER BY ID;")
+}
+
+// APIKey fetches a single API key
+func (t sqliteTx) APIKey(id string) (APIKey, error) {
+	keys, err := t.apiKeys("SELECT "+sqliteAPIKeyColumns+" FROM "+t.s.tables.APIKeys+" WHERE ID = ?1;", id)
+	if err != nil {
+		return APIKey{}, err
+	}
+	if len(keys) == 0 {
+		return APIKey{}, errNotFound
+	}
+	return keys[0], nil
+}
+
+// apiKeys runs a query of API keys
+func (t sqliteTx) apiKeys(query string, args ...interface{}) ([]APIKey, error) {
+	rows, err := t.tx.Query(query, args...)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	var keys []APIKey
+	for rows.Next() {
+		var k APIKey
+		var scopes, createdAt string
+		var expiresAt, revokedAt sql.NullString
+		if err := rows.Scan(&k.ID, &k.Name, &scopes, &k.Hash, &createdAt, &expiresAt, &revokedAt); err != nil {
+			return nil, sqliteError(err)
+		}
+		k.Scopes = strings.Split(scopes, ",")
+		if k.CreatedAt, err = parseSQLiteTime(createdAt); err != nil {
+			return nil, err
+		}
+		if k.ExpiresAt, err = parseSQLiteNullTime(expiresAt); err != nil {
+			return nil, err
+		}
+		if k.RevokedAt, err = parseSQLiteNullTime(revokedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+	return keys, nil
+}
+
+// UpdateAPIKey records when an API key expires and when it was revoked
+func (t sqliteTx) UpdateAPIKey(k APIKey) error {
+	return t.exec("UPDATE "+t.s.tables.APIKeys+" SET ExpiresAt = ?1, RevokedAt = ?2 WHERE ID = ?3;", sqliteNullTime(k.ExpiresAt), sqliteNullTime(k.RevokedAt), k.ID)
+}
+
+// sqliteNullTime formats an optional time to be stored, NULL when there is none
+func sqliteNullTime(t *time.Time) sql.NullString {
+	if t == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: sqliteTime(*t), Valid: true}
+}
+
+// parseSQLiteNullTime reads an optional stored time, nil when it is NULL
+func parseSQLiteNullTime(value sql.NullString) (*time.Time, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+	t, err := parseSQLiteTime(value.String)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
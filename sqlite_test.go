@@ -0,0 +1,55 @@
+package wservice
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteReopen(t *testing.T) {
+	cfg := sqliteConfig(t)
+	svc, err := NewServiceFromConfig(cfg)
+	assert.Nil(t, err)
+	transfer, err := svc.SubmitTransfer("bob123", "alice456", "2.35")
+	assert.Nil(t, err)
+	assert.Nil(t, ClosePool(svc))
+
+	// The ledger is kept in the file and is not seeded again
+	svc, err = NewServiceFromConfig(cfg)
+	assert.Nil(t, err)
+	defer ClosePool(svc)
+	assert.Equal(t, "300.000000", balance(t, svc, "bob123"))
+	reopened, err := svc.GetTransfer(transfer.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "2.350", reopened.Amount)
+	assert.True(t, reopened.Time.Equal(transfer.Time))
+	next, err := svc.SubmitTransfer("bob123", "alice456", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, transfer.LegacyID+1, next.LegacyID)
+}
+
+func TestSQLiteSharedFile(t *testing.T) {
+	// Two services on the same file stand for two processes, the busy timeout makes the writer of one wait for the other
+	cfg := sqliteConfig(t)
+	first, err := NewServiceFromConfig(cfg)
+	assert.Nil(t, err)
+	defer ClosePool(first)
+	second, err := NewServiceFromConfig(cfg)
+	assert.Nil(t, err)
+	defer ClosePool(second)
+	var wg sync.WaitGroup
+	for _, svc := range []WalletService{first, second} {
+		wg.Add(1)
+		go func(svc WalletService) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				_, err := svc.DoTransfer("bob123", "alice456", "1")
+				assert.Nil(t, err)
+			}
+		}(svc)
+	}
+	wg.Wait()
+	assert.Equal(t, "282.350000", balance(t, first, "bob123"))
+	assert.Equal(t, "593.810000", balance(t, second, "alice456"))
+}
@@ -0,0 +1,260 @@
+package wservice
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The business rules of the wallet service (which transfers are allowed, how interest is accrued and posted, ...) live in ledger, which keeps
+// the ledger through a Store: Postgres (sqlDBTx, see postgres.go) in production, SQLite (sqliteStore, see sqlite.go) for single-node deployments, or
+// memory (memStore, see memory.go) for tests and demos. Stores only read and write, every check on the data is made by the ledger so every backend behaves the same
+
+// The storage backends the storage setting accepts, the database one being Postgres or SQLite depending on database.driver
+const (
+	StorageDatabase = "database"
+	StorageMemory   = "memory"
+)
+
+// storageBackends are the values of the storage setting
+var storageBackends = []string{StorageDatabase, StorageMemory}
+
+// The drivers database.driver accepts
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite3"
+)
+
+// databaseDrivers are the values of the database.driver setting
+var databaseDrivers = []string{DriverPostgres, DriverSQLite}
+
+// seedMigration is the migration the stores without migrations (memory and SQLite) take their currencies and accounts from
+const seedMigration = "migrations/0002_seed_data.up.sql"
+
+// storeAmountScale is the number of decimal places balances and amounts are kept with, the scale of the decimal columns of the Postgres ledger
+const storeAmountScale = 3
+
+// The rows of the seed migration, currencies as (code, numeric code, minor units, name) and accounts as (ID, balance, currency, initial balance, wallet ID)
+var (
+	seedCurrencyPattern = regexp.MustCompile(`\('([A-Z]{3})', '([0-9]{3})', ([0-3]), '((?:[^']|'')*)'\)`)
+	seedAccountPattern  = regexp.MustCompile(`\('([^']+)', '([0-9.]+)', '([A-Z]{3})', '([0-9.]+)', '([^']+)'\)`)
+)
+
+// readSeed reads the currency registry and the demo accounts out of the seed migration
+func readSeed() ([]Currency, []Account, error) {
+	seed, err := migrationFiles.ReadFile(seedMigration)
+	if err != nil {
+		return nil, nil, err
+	}
+	var currencies []Currency
+	for _, row := range seedCurrencyPattern.FindAllStringSubmatch(string(seed), -1) {
+		minorUnits, _ := strconv.Atoi(row[3])
+		currencies = append(currencies, Currency{Code: row[1], NumericCode: row[2], MinorUnits: minorUnits, Name: strings.Replace(row[4], "''", "'", -1), Enabled: true})
+	}
+	var accounts []Account
+	for _, row := range seedAccountPattern.FindAllStringSubmatch(string(seed), -1) {
+		accounts = append(accounts, Account{ID: row[1], Balance: row[2], Currency: row[3], InitialBalance: row[4], WalletID: row[5]})
+	}
+	if len(currencies) == 0 || len(accounts) == 0 {
+		var ErrSeed = errors.New("err: the seed migration " + seedMigration + " has no currencies or accounts")
+		return nil, nil, ErrSeed
+	}
+	return currencies, accounts, nil
+}
+
+// errNotFound is returned by a store when the account, currency, transfer or interest configuration looked up does not exist
+var errNotFound = errors.New("err: not found")
+
+// errNegativeBalance is returned by a store when a debit would leave the account with a negative balance
+var errNegativeBalance = errors.New("err: the balance cannot be negative")
+
+// Account is an account of the ledger, its balances are decimal strings
+type Account struct {
+	ID             string `json:"id"`
+	WalletID       string `json:"wallet_id"`
+	Currency       string `json:"currency"`
+	Balance        string `json:"balance"`
+	InitialBalance string `json:"initial_balance"`
+}
+
+// Currency is a currency of the ISO 4217 registry
+type Currency struct {
+	Code        string
+	NumericCode string
+	MinorUnits  int
+	Name        string
+	Enabled     bool
+}
+
+// InterestRate is the interest configuration of an account, StartDate being the first day its interest is accrued for. Like the amounts of the
+// ledger, the rate is a decimal string so that the interest is computed exactly
+type InterestRate struct {
+	AccountID      string
+	AnnualRate     string
+	DayCount       string
+	ExpenseAccount string
+	StartDate      time.Time
+}
+
+// InterestAccrual is the interest accrued by an account on a single day, with its amount kept to accrualScale decimal places
+type InterestAccrual struct {
+	AccountID  string
+	Date       time.Time
+	Balance    string
+	AnnualRate string
+	Amount     string
+}
+
+// transferRef identifies a transfer either by its ULID or by its legacy numeric ID (see transferKey)
+type transferRef struct {
+	uid      string
+	legacyID int64
+}
+
+// Store keeps the ledger. Update runs fn in a serializable transaction that is committed when fn returns nil and rolled back otherwise. When it
+// conflicts with a concurrent transaction fn is run again from the start, so it must not have side effects outside of the transaction. View runs fn
+// on a consistent snapshot of the ledger. Close releases the store, after which both fail with ErrShuttingDown
+type Store interface {
+	Update(fn func(LedgerTx) error) error
+	View(fn func(LedgerTx) error) error
+	Close() error
+}
+
+// LedgerTx reads and writes the ledger inside a transaction of a Store. Lookups return errNotFound when there is nothing to find
+type LedgerTx interface {
+	// Accounts returns every account ordered by ID, WalletAccounts the accounts of a wallet ordered by currency
+	Accounts() ([]Account, error)
+	WalletAccounts(walletID string) ([]Account, error)
+	Account(id string) (Account, error)
+	// Debit and Credit subtract and add an amount to the balance of an account, Debit fails with errNegativeBalance rather than overdraw it
+	Debit(accountID string, amount string) error
+	Credit(accountID string, amount string) error
+
+	// Currencies returns the whole registry ordered by code
+	Currencies() ([]Currency, error)
+	Currency(code string) (Currency, error)
+	SetCurrencyEnabled(code string, enabled bool) error
+
+	// Transfers returns every recorded transfer, whatever its status, ordered by legacy ID, without their history
+	Transfers() ([]Transfer, error)
+	// Transfer returns a committed transfer with its history and FailedTransfer a failed attempt
+	Transfer(ref transferRef) (Transfer, error)
+	FailedTransfer(ref transferRef) (Transfer, error)
+	// InsertTransfer records a new transfer and its first status change, numbering it and stamping it with the clock of the store unless it has a time.
+	// It returns the transfer as recorded
+	InsertTransfer(t Transfer) (Transfer, error)
+	InsertFailedTransfer(t Transfer) (Transfer, error)
+	// SetTransferStatus moves a transfer to a new status and records the change in its history
+	SetTransferStatus(legacyID int64, status string, reason string) error
+
+	// SetInterestRate creates or changes the interest configuration of an account, a new configuration starts today while a changed one keeps its start date
+	SetInterestRate(r InterestRate) error
+	InterestRate(accountID string) (InterestRate, error)
+	InterestRates() ([]InterestRate, error)
+	// LastAccrualDate returns the last day an account accrued interest for, or the zero time if it never did
+	LastAccrualDate(accountID string) (time.Time, error)
+	// BalanceAt returns the balance of an account at the given time, that is its current balance without the transfers committed since whose funds
+	// moved (the completed and reversed ones)
+	BalanceAt(accountID string, at time.Time) (string, error)
+	// InsertAccrual records the interest accrued by an account on a day, unless that day was already accrued
+	InsertAccrual(a InterestAccrual) error
+	// UnpostedAccounts returns the accounts with interest accrued between from (inclusive) and until (exclusive) that was not posted yet, and
+	// UnpostedInterest the total of that interest for one account (errNotFound when there is none)
+	UnpostedAccounts(from time.Time, until time.Time) ([]string, error)
+	UnpostedInterest(accountID string, from time.Time, until time.Time) (string, error)
+	// FirstUnpostedAccrual returns the first day before until whose interest was accrued but not posted yet, or the zero time if there is none
+	FirstUnpostedAccrual(until time.Time) (time.Time, error)
+	// MarkInterestPosted marks the interest of an account between from and until as posted by the given transfer, 0 when nothing was paid
+	MarkInterestPosted(accountID string, from time.Time, until time.Time, transferID int64) error
+
+	// InsertEvent writes a pending event to the outbox, numbering it and stamping it with the clock of the store unless it has a time. It returns the event as written
+	InsertEvent(e Event) (Event, error)
+	// PendingEvents returns up to limit pending events ordered by sequence number, Events up to limit events of any status after a sequence number
+	PendingEvents(limit int) ([]Event, error)
+	Events(after int64, limit int) ([]Event, error)
+	// LastEventSeq returns the sequence number of the last event of the outbox, 0 when it is empty
+	LastEventSeq() (int64, error)
+	// Notify announces a transfer on a notification channel once the transaction is committed, and never if it is rolled back (see notify.go)
+	Notify(channel string, transferID string) error
+	// MarkEventPublished marks an event as published and MarkEventFailed records a failed attempt to publish it, which leaves it pending
+	MarkEventPublished(seq int64) error
+	MarkEventFailed(seq int64, reason string) error
+
+	// InsertWebhook records a new webhook, stamping it with the clock of the store unless it has a time. Webhooks returns every webhook ordered by ID
+	InsertWebhook(w Webhook) (Webhook, error)
+	Webhooks() ([]Webhook, error)
+	Webhook(id string) (Webhook, error)
+	// UpdateWebhook changes whether a webhook is enabled and its count of consecutive failures, DeleteWebhook deletes it with its deliveries
+	UpdateWebhook(w Webhook) error
+	DeleteWebhook(id string) error
+	// InsertDelivery queues a pending delivery of an event to a webhook, unless that event was already queued for that webhook
+	InsertDelivery(d WebhookDelivery) error
+	// DueDeliveries returns up to limit pending deliveries of enabled webhooks whose next attempt is due at now, the most overdue first
+	DueDeliveries(now time.Time, limit int) ([]WebhookDelivery, error)
+	// Deliveries returns up to limit deliveries of a webhook, the most recent first
+	Deliveries(webhookID string, limit int) ([]WebhookDelivery, error)
+	Delivery(id int64) (WebhookDelivery, error)
+	// UpdateDelivery records the status, attempts, outcome of the last attempt and next attempt of a delivery
+	UpdateDelivery(d WebhookDelivery) error
+
+	// InsertAPIKey records a new API key with the hash of its secret, stamping it with the clock of the store unless it has a time. APIKeys returns every
+	// API key ordered by ID
+	InsertAPIKey(k APIKey) (APIKey, error)
+	APIKeys() ([]APIKey, error)
+	APIKey(id string) (APIKey, error)
+	// UpdateAPIKey records when an API key expires and when it was revoked
+	UpdateAPIKey(k APIKey) error
+}
+
+// ledger is the wallet service: the business rules of the ledger on top of the store that keeps it
+type ledger struct {
+	store Store
+	// accountsTable and transfersTable are the configured table names GetTable also accepts
+	accountsTable  string
+	transfersTable string
+	// lowBalance is the balance below which a transfer reports an account.low_balance event, none when empty
+	lowBalance string
+	// notifyChannel is the channel every committed transfer is announced on, none when empty
+	notifyChannel string
+	// apiKeysRequired is whether the requests must carry an API key, Authenticate lets any request through when it is not
+	apiKeysRequired bool
+	// clock, when set, stamps new transfers with a server clock instead of the clock of the store (used by tests)
+	clock func() time.Time
+}
+
+// now returns the current time of the injected server clock, or of the local clock if there is none
+func (l ledger) now() time.Time {
+	if l.clock != nil {
+		return l.clock()
+	}
+	return time.Now()
+}
+
+// clockTime returns the time of the injected server clock to stamp a new transfer with, or the zero time to let the store stamp it
+func (l ledger) clockTime() time.Time {
+	if l.clock != nil {
+		return l.clock()
+	}
+	return time.Time{}
+}
+
+// storeOf returns the store of the wallet service created by NewServiceFromConfig
+func storeOf(svc WalletService) (Store, bool) {
+	l, ok := svc.(ledger)
+	if !ok || l.store == nil {
+		return nil, false
+	}
+	return l.store, true
+}
+
+// postgresStore returns the Postgres store of the wallet service created by NewServiceFromConfig, if it keeps the ledger in Postgres
+func postgresStore(svc WalletService) (sqlDBTx, bool) {
+	store, ok := storeOf(svc)
+	if !ok {
+		return sqlDBTx{}, false
+	}
+	s, ok := store.(sqlDBTx)
+	return s, ok
+}
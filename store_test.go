@@ -0,0 +1,497 @@
+package wservice
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The behavioural tests of the ledger run against every store, each test on a new ledger seeded with the demo accounts. The Postgres ledger of a test
+// is migrated in a schema of its own of the test database, the one of cmd/postgresql.cfg unless the WSERVICE_DATABASE_* variables say otherwise, and
+// its tests are skipped when that database cannot be reached
+
+// testStores are the stores the behavioural tests run against
+var testStores = []struct {
+	name       string
+	newService func(t *testing.T) WalletService
+}{
+	{"memory", newMemoryService},
+	{"sqlite", newSQLiteService},
+	{"postgres", newPostgresService},
+}
+
+// forEachStore runs a test against a new ledger of every store
+func forEachStore(t *testing.T, test func(t *testing.T, svc WalletService)) {
+	for _, store := range testStores {
+		store := store
+		t.Run(store.name, func(t *testing.T) { test(t, store.newService(t)) })
+	}
+}
+
+// newMemoryService creates a wallet service on top of a new in-memory ledger. Its requests need no API key, the authentication being tested on its own
+// (see auth_test.go)
+func newMemoryService(t *testing.T) WalletService {
+	cfg := DefaultConfig()
+	cfg.Storage = StorageMemory
+	cfg.Auth.Enabled = false
+	svc, err := NewServiceFromConfig(cfg)
+	assert.Nil(t, err)
+	return svc
+}
+
+// sqliteConfig returns the configuration of a SQLite ledger kept in a new temporary directory, whose requests need no API key
+func sqliteConfig(t *testing.T) Config {
+	dir, err := ioutil.TempDir("", "wservice")
+	assert.Nil(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	cfg := DefaultConfig()
+	cfg.Database.Driver = DriverSQLite
+	cfg.Database.Name = filepath.Join(dir, "ledger.db")
+	cfg.Auth.Enabled = false
+	return cfg
+}
+
+// newSQLiteService creates a wallet service on top of a new SQLite ledger
+func newSQLiteService(t *testing.T) WalletService {
+	svc, err := NewServiceFromConfig(sqliteConfig(t))
+	assert.Nil(t, err)
+	t.Cleanup(func() { ClosePool(svc) })
+	return svc
+}
+
+// newPostgresService creates a wallet service on top of a new Postgres ledger, migrated in a schema that is dropped when the test ends
+func newPostgresService(t *testing.T) WalletService {
+	cfg, err := ReadConfigFile(DefaultConfig(), "./cmd/postgresql.cfg")
+	assert.Nil(t, err)
+	cfg, err = ApplyEnv(cfg, os.LookupEnv)
+	assert.Nil(t, err)
+	id, err := newULID(time.Now())
+	assert.Nil(t, err)
+	cfg.Database.Schema = "wservice_test_" + strings.ToLower(id)
+	cfg.Auth.Enabled = false
+	svc, err := NewServiceFromConfig(cfg)
+	assert.Nil(t, err)
+	t.Cleanup(func() { ClosePool(svc) })
+
+	m, err := NewMigrator(svc)
+	assert.Nil(t, err)
+	if _, err = m.Up(); errors.Is(err, ErrUnavailable) {
+		t.Skip("no test database: ", err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s, _ := postgresStore(svc)
+		db, err := s.openDB()
+		assert.Nil(t, err)
+		_, err = db.Exec("DROP SCHEMA " + cfg.Database.Schema + " CASCADE;")
+		assert.Nil(t, err)
+	})
+	return svc
+}
+
+// backdateInterest moves the start date of the interest configuration of an account, which the service always starts today
+func backdateInterest(t *testing.T, svc WalletService, accountID string, start time.Time) {
+	store, _ := storeOf(svc)
+	switch s := store.(type) {
+	case *memStore:
+		s.mu.Lock()
+		r := s.rates[accountID]
+		r.StartDate = start
+		s.rates[accountID] = r
+		s.mu.Unlock()
+	case sqliteStore:
+		db, err := s.writer.get(s.openWriter)
+		assert.Nil(t, err)
+		_, err = db.Exec("UPDATE "+s.tables.InterestRates+" SET StartDate = ?1 WHERE AccountID = ?2;", start.Format(dateLayout), accountID)
+		assert.Nil(t, err)
+	case sqlDBTx:
+		db, err := s.openDB()
+		assert.Nil(t, err)
+		_, err = db.Exec("UPDATE "+s.interestRates()+" SET StartDate = $1 WHERE AccountID = $2;", start.Format(dateLayout), accountID)
+		assert.Nil(t, err)
+	default:
+		t.Fatalf("cannot backdate the interest of a %T", store)
+	}
+}
+
+// balance returns the balance of an account of a wallet service as GetTable lists it
+func balance(t *testing.T, svc WalletService, accountID string) string {
+	rows, err := svc.GetTable(AccountsTable)
+	assert.Nil(t, err)
+	for _, row := range rows {
+		if strings.HasPrefix(row, "Account: "+accountID+" ") {
+			return strings.Fields(row)[4]
+		}
+	}
+	return ""
+}
+
+func TestStoreDoTransfer(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		status, err := svc.DoTransfer("bob123", "alice456", "30")
+		assert.Equal(t, "success", status)
+		assert.Nil(t, err)
+		assert.Equal(t, "272.350000", balance(t, svc, "bob123"))
+		assert.Equal(t, "603.810000", balance(t, svc, "alice456"))
+
+		cases := []struct {
+			from, to, amount string
+			err              string
+		}{
+			{"alice456", "amockaccount123", "30", "The destination account does not exist"},
+			{"amockaccount123", "alice456", "30", "The source account does not exist"},
+			{"alice456", "alice456", "30", "the source account is the same as the destination account. "},
+			{"alice456", "bob123", "300000", "Balance insuficient for transaction"},
+			{"alice456", "marcy789", "30", "Not same currency in transaction source and destination"},
+			{"alice456", "bob123", "0.005", "The amount has more decimal places than currency USD allows (2)"},
+			{"alice456", "bob123", "-5", "The amount cannot be negative"},
+			{"alice456", "bob123", "1e-5", "The amount must be a positive decimal number (e.g. \"30\" or \"12.50\")"},
+			{"alice456", "bob123", "NaN", "The amount must be a positive decimal number (e.g. \"30\" or \"12.50\")"},
+			{"alice456", "bob123", "Inf", "The amount must be a positive decimal number (e.g. \"30\" or \"12.50\")"},
+			{"alice456", "bob123", "0", "The amount must be greater than zero"},
+			{"alice456", "bob123", "0.00", "The amount must be greater than zero"},
+		}
+		for _, c := range cases {
+			status, err = svc.DoTransfer(c.from, c.to, c.amount)
+			assert.Equal(t, "error", status)
+			assert.EqualError(t, err, c.err)
+		}
+		// None of the refused transfers moved any funds
+		assert.Equal(t, "603.810000", balance(t, svc, "alice456"))
+	})
+}
+
+func TestStoreDoTransferConcurrent(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 5; j++ {
+					_, err := svc.DoTransfer("bob123", "alice456", "1.01")
+					assert.Nil(t, err)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 5; j++ {
+					_, err := svc.DoTransfer("alice456", "bob123", "0.01")
+					assert.Nil(t, err)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, "202.350000", balance(t, svc, "bob123"))
+		assert.Equal(t, "673.810000", balance(t, svc, "alice456"))
+		rows, err := svc.GetTable(TransfersTable)
+		assert.Nil(t, err)
+		assert.Len(t, rows, 201)
+	})
+}
+
+func TestStoreRollback(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		store, _ := storeOf(svc)
+		errAbort := errors.New("abort")
+		err := store.Update(func(tx LedgerTx) error {
+			assert.Nil(t, tx.Debit("bob123", "2.35"))
+			assert.Nil(t, tx.Credit("alice456", "2.35"))
+			_, err := tx.InsertTransfer(Transfer{ID: "01ARZ3NDEKTSV4RRFFQ69G5FAV", From: "bob123", To: "alice456", Amount: "2.35", Currency: "USD", Status: StatusCompleted})
+			assert.Nil(t, err)
+			assert.Nil(t, tx.SetCurrencyEnabled("USD", false))
+			return errAbort
+		})
+		assert.Equal(t, errAbort, err)
+		assert.Equal(t, "302.350000", balance(t, svc, "bob123"))
+		assert.Equal(t, "573.810000", balance(t, svc, "alice456"))
+		rows, _ := svc.GetTable(TransfersTable)
+		assert.Equal(t, []string{"No submitted transfers yet, this is not an error.", "Success."}, rows)
+		currencies, _ := svc.GetCurrencies()
+		assert.Contains(t, currencies, "Currency: USD (840)  Minor units = 2  Name = US Dollar  Enabled = true")
+		// Reads cannot write
+		err = store.View(func(tx LedgerTx) error { return tx.Credit("bob123", "1") })
+		assert.NotNil(t, err)
+	})
+}
+
+func TestStoreTransferLifecycle(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		transfer, err := svc.SubmitTransfer("bob123", "alice456", "5")
+		assert.Nil(t, err)
+		assert.Equal(t, StatusCompleted, transfer.Status)
+		assert.True(t, isULID(transfer.ID))
+		id := transfer.ID
+		transfer, err = svc.GetTransfer(id)
+		assert.Nil(t, err)
+		assert.Equal(t, "bob123", transfer.From)
+		// The transfer was pending until its funds moved
+		if assert.Len(t, transfer.History, 2) {
+			assert.Equal(t, StatusPending, transfer.History[0].Status)
+			assert.Equal(t, StatusCompleted, transfer.History[1].Status)
+		}
+		legacy, err := svc.GetTransfer(strconv.FormatInt(transfer.LegacyID, 10))
+		assert.Nil(t, err)
+		assert.Equal(t, id, legacy.ID)
+		_, err = svc.CancelTransfer(id)
+		assert.EqualError(t, err, "Only pending transfers can be cancelled, the transfer is completed")
+		transfer, err = svc.ReverseTransfer(id)
+		assert.Nil(t, err)
+		assert.Equal(t, StatusReversed, transfer.Status)
+		assert.Len(t, transfer.History, 3)
+		_, err = svc.ReverseTransfer(id)
+		assert.EqualError(t, err, "Only completed transfers can be reversed, the transfer is reversed")
+		assert.Equal(t, "302.350000", balance(t, svc, "bob123"))
+
+		// A refused transfer is recorded as a failed attempt
+		transfer, err = svc.SubmitTransfer("alice456", "bob123", "300000")
+		assert.EqualError(t, err, "Balance insuficient for transaction")
+		assert.Equal(t, int64(3), transfer.LegacyID)
+		transfer, err = svc.GetTransfer(transfer.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, StatusFailed, transfer.Status)
+		assert.Equal(t, "Balance insuficient for transaction", transfer.Reason)
+		_, err = svc.CancelTransfer(transfer.ID)
+		assert.EqualError(t, err, "Only pending transfers can be cancelled, the transfer is failed")
+		_, err = svc.GetTransfer("01ARZ3NDEKTSV4RRFFQ69G5FAV")
+		assert.True(t, errors.Is(err, ErrTransferNotFound))
+		_, err = svc.CancelTransfer("01ARZ3NDEKTSV4RRFFQ69G5FAV")
+		assert.True(t, errors.Is(err, ErrTransferNotFound))
+	})
+}
+
+// pendingTransfer records a pending transfer without checking it, as submitTransfer does before the funds of the transfer move
+func pendingTransfer(t *testing.T, svc WalletService, from string, to string, amount string) Transfer {
+	l := svc.(ledger)
+	var transfer Transfer
+	err := l.store.Update(func(tx LedgerTx) error {
+		source, err := tx.Account(from)
+		if err != nil {
+			return err
+		}
+		transfer, err = l.insertTransfer(tx, source, from, to, amount, StatusPending)
+		return err
+	})
+	assert.Nil(t, err)
+	return transfer
+}
+
+func TestStoreCancelTransfer(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		l := svc.(ledger)
+
+		// A pending transfer can be cancelled, and its funds then never move
+		pending := pendingTransfer(t, svc, "bob123", "alice456", "5")
+		assert.Equal(t, StatusPending, pending.Status)
+		cancelled, err := svc.CancelTransfer(pending.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, StatusCancelled, cancelled.Status)
+		assert.Equal(t, "cancelled on request", cancelled.Reason)
+		assert.Len(t, cancelled.History, 2)
+		_, err = svc.CancelTransfer(pending.ID)
+		assert.EqualError(t, err, "Only pending transfers can be cancelled, the transfer is cancelled")
+		_, err = l.completeTransfer(pending)
+		assert.True(t, errors.Is(err, ErrInvalidTransferStatus))
+		assert.Equal(t, "302.350000", balance(t, svc, "bob123"))
+		at, err := svc.GetTransfer(pending.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, StatusCancelled, at.Status)
+
+		// A pending transfer whose funds cannot move anymore fails with the reason why
+		pending = pendingTransfer(t, svc, "bob123", "alice456", "1000")
+		failed, err := l.completeTransfer(pending)
+		assert.EqualError(t, err, "Balance insuficient for transaction")
+		assert.Equal(t, StatusFailed, failed.Status)
+		failed, err = svc.GetTransfer(pending.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, StatusFailed, failed.Status)
+		assert.Equal(t, "Balance insuficient for transaction", failed.Reason)
+		assert.Len(t, failed.History, 2)
+		assert.Equal(t, "302.350000", balance(t, svc, "bob123"))
+
+		// Only the transfers whose funds moved count in the past balances
+		transfer, err := svc.SubmitTransfer("bob123", "alice456", "2")
+		assert.Nil(t, err)
+		assert.Equal(t, StatusCompleted, transfer.Status)
+		err = l.store.View(func(tx LedgerTx) error {
+			past, err := tx.BalanceAt("bob123", pending.Time.Add(-time.Hour))
+			assert.Equal(t, "302.350", past)
+			return err
+		})
+		assert.Nil(t, err)
+	})
+}
+
+func TestStoreCurrenciesAndWallets(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		status, err := svc.SetCurrencyEnabled("USD", false)
+		assert.Equal(t, "success", status)
+		assert.Nil(t, err)
+		_, err = svc.DoTransfer("alice456", "bob123", "30")
+		assert.EqualError(t, err, "The currency USD is disabled")
+		_, err = svc.SetCurrencyEnabled("USD", true)
+		assert.Nil(t, err)
+		_, err = svc.SetCurrencyEnabled("XYZ", true)
+		assert.EqualError(t, err, "The currency XYZ does not exist")
+		currencies, err := svc.GetCurrencies()
+		assert.Nil(t, err)
+		assert.Contains(t, currencies, "Currency: TOP (776)  Minor units = 2  Name = Pa'anga  Enabled = true")
+
+		rows, err := svc.GetWallet("alice")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"Wallet: alice  Account: alice457  Balance = 1000.000000 EUR  Initial Balance = 1000.000000",
+			"Wallet: alice  Account: alice456  Balance = 573.810000 USD  Initial Balance = 573.810000",
+			"Success.",
+		}, rows)
+		_, err = svc.GetWallet("amockwallet")
+		assert.EqualError(t, err, "The wallet amockwallet does not exist")
+		transfer, err := svc.DoWalletTransfer("bob", "alice", "USD", "10")
+		assert.Nil(t, err)
+		assert.Equal(t, "alice456", transfer.To)
+		transfer, err = svc.DoWalletTransfer("alice", "bob", "EUR", "10")
+		assert.Equal(t, StatusFailed, transfer.Status)
+		assert.EqualError(t, err, "The wallet bob has no EUR balance")
+		_, err = svc.DoWalletTransfer("alice", "alice", "USD", "10")
+		assert.EqualError(t, err, "the source account is the same as the destination account. ")
+
+		// The wallets are resolved to their accounts in the transaction that records the transfer, not in one of their own: the other transaction
+		// moves its funds and the transfer is then fetched with its history
+		l := svc.(ledger)
+		counter := &txCountingStore{Store: l.store}
+		l.store = counter
+		_, err = l.DoWalletTransfer("bob", "alice", "USD", "10")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, counter.updates)
+		assert.Equal(t, 1, counter.views)
+	})
+}
+
+// txCountingStore counts the transactions run on a store
+type txCountingStore struct {
+	Store
+	updates, views int
+}
+
+func (s *txCountingStore) Update(fn func(LedgerTx) error) error {
+	s.updates++
+	return s.Store.Update(fn)
+}
+
+func (s *txCountingStore) View(fn func(LedgerTx) error) error {
+	s.views++
+	return s.Store.View(fn)
+}
+
+func TestStoreInterest(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		status, err := svc.SetInterestRate("bob123", "0.0365", "ACT/365", "bankinterestusd")
+		assert.Equal(t, "success", status)
+		assert.Nil(t, err)
+		_, err = svc.SetInterestRate("bob123", "0.0365", "ACT/365", "amockaccount123")
+		assert.EqualError(t, err, "The account or the interest-expense account does not exist")
+
+		// Start the accruals at the beginning of last month, the interest of every day is 0.01% of the balance
+		month := today().AddDate(0, -1, 1-today().Day())
+		backdateInterest(t, svc, "bob123", month)
+		_, err = svc.AccrueInterest(month.AddDate(0, 1, -1).Format(dateLayout))
+		assert.Nil(t, err)
+		// Accruing again is a no-op
+		_, err = svc.AccrueInterest(month.AddDate(0, 1, -1).Format(dateLayout))
+		assert.Nil(t, err)
+
+		days := month.AddDate(0, 1, 0).Sub(month).Hours() / 24
+		_, err = svc.PostInterest(month.Format(monthLayout))
+		assert.Nil(t, err)
+		_, err = svc.PostInterest(month.Format(monthLayout))
+		assert.Nil(t, err)
+		// The interest of every day is exact, so the posted interest is the rounded interest of the month
+		interest := new(big.Rat).Mul(big.NewRat(30235, 100*10000), big.NewRat(int64(days), 1))
+		expected, _ := new(big.Rat).SetString(roundToMinorUnits(interest, 2))
+		actual, _ := new(big.Rat).SetString(balance(t, svc, "bob123"))
+		assert.Equal(t, new(big.Rat).Add(big.NewRat(30235, 100), expected).FloatString(storeAmountScale), actual.FloatString(storeAmountScale))
+		rows, _ := svc.GetTable(TransfersTable)
+		assert.Len(t, rows, 2)
+		assert.Contains(t, rows[0], "from: bankinterestusd  to:  bob123")
+	})
+}
+
+func TestStoreInterestCatchUp(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		// alice456 is paid by an account of another currency, so her interest cannot be posted
+		_, err := svc.SetInterestRate("bob123", "0.0365", "ACT/365", "bankinterestusd")
+		assert.Nil(t, err)
+		_, err = svc.SetInterestRate("alice456", "0.0365", "ACT/365", "bankinteresteur")
+		assert.Nil(t, err)
+		start := today().AddDate(0, -2, 1-today().Day())
+		backdateInterest(t, svc, "bob123", start)
+		backdateInterest(t, svc, "alice456", start)
+		_, err = svc.AccrueInterest(today().AddDate(0, 0, -1).Format(dateLayout))
+		assert.Nil(t, err)
+
+		// Both months that are over are posted for bob123, and the failure of alice456 is reported for each of them
+		for i := 0; i < 2; i++ {
+			status, err := svc.PostDueInterest()
+			assert.Equal(t, "error", status)
+			if assert.NotNil(t, err) {
+				assert.True(t, errors.Is(err, ErrCurrencyMismatch))
+				for _, month := range []time.Time{start, start.AddDate(0, 1, 0)} {
+					assert.Contains(t, err.Error(), month.Format(monthLayout)+": Could not post the interest of alice456: Not same currency in transaction source and destination")
+				}
+			}
+			rows, _ := svc.GetTable(TransfersTable)
+			assert.Len(t, rows, 3)
+		}
+	})
+}
+
+func TestStoreHTTP(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		handler := NewHTTPTransport(NewValidating(svc))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/submittransfer", strings.NewReader(`{"from":"bob123","to":"alice456","amount":"2.35"}`)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var submitted submitTransferResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&submitted))
+		assert.Equal(t, StatusCompleted, submitted.Status)
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transfers/"+submitted.ID, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"from":"bob123"`)
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/accounts", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Account: bob123  Balance = 300.000000 USD")
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/submittransfer", strings.NewReader(`{"from":"alice456","to":"bob123","amount":"300000"}`)))
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"code":"insufficient_funds"`)
+	})
+}
+
+func TestStoreClose(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		assert.Nil(t, ClosePool(svc))
+		_, err := svc.GetCurrencies()
+		assert.Equal(t, ErrShuttingDown, err)
+		_, err = NewMigrator(svc)
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,448 @@
+package wservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/websocket"
+)
+
+// The live feed of /transfers/stream tails the outbox of the ledger and fans its events out to the streams of the instance, as Server-Sent Events or
+// over a WebSocket. The outbox is shared by every instance of the service and each instance tails it on its own, so every stream gets every committed
+// transfer whichever instance made it. Events are identified by their sequence number in the outbox: a client that reconnects with the last one it got
+// (the Last-Event-ID header of SSE or the last_event_id parameter) first gets the events it missed, read back from the outbox, then the live ones
+
+// streamGapWait is how long the tail waits for a missing sequence number before skipping it. Postgres numbers the events of the outbox before their
+// transactions commit, so an event can show up before an earlier one that is still being committed, or that was rolled back and never will
+const streamGapWait = 5 * time.Second
+
+// streamWriteTimeout bounds every write to a stream, so a client that stopped reading does not hold its handler forever
+const streamWriteTimeout = 10 * time.Second
+
+// streamUpgrader upgrades the WebSocket streams, only from pages served by the same host as the default origin check goes
+var streamUpgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 4096}
+
+// TransferStream is the live feed of an instance: it tails the outbox and queues every event for the streams that subscribed to it
+type TransferStream struct {
+	store Store
+	cfg   StreamConfig
+	// now is the clock the gaps of the outbox are timed with (replaced by tests)
+	now func() time.Time
+
+	mu sync.Mutex
+	// head is the sequence number of the last event fanned out, gapSince when the tail first waited for the event after it
+	head        int64
+	gapSince    time.Time
+	subscribers map[*streamSubscriber]struct{}
+	closed      bool
+	// wake makes the feed tail the outbox right away
+	wake chan struct{}
+}
+
+// streamSubscriber is a stream being served, the events it subscribed to are queued on events until its handler writes them
+type streamSubscriber struct {
+	// accounts are the accounts the stream is restricted to, nil for every account
+	accounts map[string]bool
+	events   chan Event
+	// lagged is set when the stream was dropped for falling more than the buffer behind
+	lagged bool
+}
+
+// wants reports whether an event of the given accounts is one the stream subscribed to
+func (sub *streamSubscriber) wants(accounts []string) bool {
+	if sub.accounts == nil {
+		return true
+	}
+	for _, id := range accounts {
+		if sub.accounts[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// eventAccounts returns the accounts an event is about: the source and destination of a transfer, or the account of a balance event
+func eventAccounts(e Event) []string {
+	var p struct {
+		From      string `json:"from"`
+		To        string `json:"to"`
+		AccountID string `json:"account_id"`
+	}
+	if json.Unmarshal(e.Payload, &p) != nil {
+		return nil
+	}
+	var accounts []string
+	for _, id := range []string{p.From, p.To, p.AccountID} {
+		if id != "" {
+			accounts = append(accounts, id)
+		}
+	}
+	return accounts
+}
+
+// NewTransferStream exported to be accessible from outside the package (from main)
+// NewTransferStream creates the live feed of the wallet service created by NewServiceFromConfig, starting after the last event of its outbox
+func NewTransferStream(svc WalletService, cfg StreamConfig) (*TransferStream, error) {
+	store, ok := storeOf(svc)
+	if !ok {
+		var ErrNoStore = errors.New("err: only the wallet service created by NewServiceFromConfig has a live feed")
+		return nil, ErrNoStore
+	}
+	var head int64
+	err := store.View(func(tx LedgerTx) error {
+		var err error
+		head, err = tx.LastEventSeq()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TransferStream{store: store, cfg: cfg, now: time.Now, head: head, subscribers: make(map[*streamSubscriber]struct{}), wake: make(chan struct{}, 1)}, nil
+}
+
+// Wake makes the feed tail the outbox right away rather than at its next interval (e.g. when a transfer was announced), without ever blocking
+func (s *TransferStream) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Tail reads the events committed since the last call, up to a batch of them, and queues them for the streams that subscribed to them. A stream
+// whose queue is full is dropped. It returns how many events were read
+func (s *TransferStream) Tail() (int, error) {
+	s.mu.Lock()
+	after := s.head
+	s.mu.Unlock()
+	var events []Event
+	err := s.store.View(func(tx LedgerTx) error {
+		var err error
+		events, err = tx.Events(after, s.cfg.BatchSize)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range events {
+		if e.Seq != s.head+1 {
+			if s.gapSince.IsZero() {
+				s.gapSince = now
+			}
+			if now.Sub(s.gapSince) < streamGapWait {
+				return i, nil
+			}
+		}
+		s.gapSince, s.head = time.Time{}, e.Seq
+		accounts := eventAccounts(e)
+		for sub := range s.subscribers {
+			if !sub.wants(accounts) {
+				continue
+			}
+			select {
+			case sub.events <- e:
+			default:
+				s.drop(sub, true)
+			}
+		}
+	}
+	return len(events), nil
+}
+
+// subscribe registers a stream for the events of the given accounts (of every account when there are none). It also returns the sequence number
+// of the last event fanned out, up to which a resuming stream reads the events it missed back from the outbox
+func (s *TransferStream) subscribe(accounts []string) (*streamSubscriber, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		var ErrClosed = newError(ErrUnavailable, "err: the live feed is shutting down, reconnect to resume")
+		return nil, 0, ErrClosed
+	}
+	if s.cfg.MaxSubscribers > 0 && len(s.subscribers) >= s.cfg.MaxSubscribers {
+		var ErrFull = newError(ErrUnavailable, "err: the live feed is serving too many streams, retry later")
+		return nil, 0, ErrFull
+	}
+	sub := &streamSubscriber{events: make(chan Event, s.cfg.Buffer)}
+	if len(accounts) > 0 {
+		sub.accounts = make(map[string]bool)
+		for _, id := range accounts {
+			sub.accounts[id] = true
+		}
+	}
+	s.subscribers[sub] = struct{}{}
+	return sub, s.head, nil
+}
+
+// unsubscribe removes a stream that ended
+func (s *TransferStream) unsubscribe(sub *streamSubscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drop(sub, false)
+}
+
+// drop removes a stream and closes its queue, which ends it. The caller holds the lock
+func (s *TransferStream) drop(sub *streamSubscriber, lagged bool) {
+	if _, ok := s.subscribers[sub]; !ok {
+		return
+	}
+	delete(s.subscribers, sub)
+	sub.lagged = lagged
+	close(sub.events)
+}
+
+// Subscribers returns how many streams are being served
+func (s *TransferStream) Subscribers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subscribers)
+}
+
+// Close ends every stream and refuses new ones. The clients reconnect (to another instance during a shutdown) and resume from their last event ID
+func (s *TransferStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for sub := range s.subscribers {
+		s.drop(sub, false)
+	}
+}
+
+// RunTransferStream exported to be accessible from outside the package (from main)
+// RunTransferStream tails the outbox every interval, and whenever it is woken up, until stop is closed, then ends every stream. A full batch is followed
+// by the next one right away
+func RunTransferStream(s *TransferStream, logger log.Logger, stop <-chan struct{}) {
+	defer s.Close()
+	ticker := time.NewTicker(time.Duration(s.cfg.Interval))
+	defer ticker.Stop()
+	for {
+		n, err := s.Tail()
+		if err != nil {
+			logger.Log("msg", "live feed could not tail the outbox", "err", err)
+		}
+		if err == nil && n == s.cfg.BatchSize {
+			select {
+			case <-stop:
+				return
+			default:
+				continue
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// ServeHTTP serves a stream of /transfers/stream, over a WebSocket when the request asks for an upgrade and as Server-Sent Events otherwise.
+// The account parameter (repeated or comma separated) restricts the stream to the events of some accounts
+func (s *TransferStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := httptransport.PopulateRequestContext(r.Context(), r)
+	if r.Method != http.MethodGet {
+		methodNotAllowedHandler("/transfers/stream", http.MethodGet).ServeHTTP(w, r)
+		return
+	}
+	accounts, last, err := decodeStreamRequest(r)
+	if err != nil {
+		EncodeError(ctx, err, w)
+		return
+	}
+	ws := websocket.IsWebSocketUpgrade(r)
+	if _, ok := w.(http.Flusher); !ok && !ws {
+		var ErrFlush = errors.New("err: the server cannot stream responses")
+		EncodeError(ctx, ErrFlush, w)
+		return
+	}
+	sub, head, err := s.subscribe(accounts)
+	if err != nil {
+		EncodeError(ctx, err, w)
+		return
+	}
+	defer s.unsubscribe(sub)
+	if ws {
+		s.serveWebSocket(w, r, sub, head, last)
+	} else {
+		s.serveEvents(w, r, sub, head, last)
+	}
+}
+
+// decodeStreamRequest reads the accounts a stream is restricted to and the last event its client got, -1 for a new stream
+func decodeStreamRequest(r *http.Request) ([]string, int64, error) {
+	var v validator
+	query := r.URL.Query()
+	var accounts []string
+	for _, value := range query["account"] {
+		for _, id := range strings.Split(value, ",") {
+			v.id("account", id)
+			accounts = append(accounts, id)
+		}
+	}
+	last := int64(-1)
+	raw := r.Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = query.Get("last_event_id")
+	}
+	if raw != "" {
+		seq, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || seq < 0 {
+			v.fail("last_event_id", "must be the ID of an event of the stream (e.g. \"42\"), got \""+raw+"\"")
+		}
+		last = seq
+	}
+	return accounts, last, v.err()
+}
+
+// errStreamLagged ends a stream that fell more than its buffer behind
+var errStreamLagged = errors.New("err: the stream fell behind, reconnect with the last event ID to resume")
+
+// pump sends a stream the events it missed after last, read back from the outbox up to head, then the live ones as they are fanned out. It sends a
+// keep-alive every heartbeat and returns once the stream ends: its client went away, it was dropped or the feed closed
+func (s *TransferStream) pump(ctx context.Context, sub *streamSubscriber, head int64, last int64, send func(Event) error, keepAlive func() error) error {
+	if last < 0 {
+		last = head
+	}
+	for last < head {
+		var missed []Event
+		err := s.store.View(func(tx LedgerTx) error {
+			var err error
+			missed, err = tx.Events(last, s.cfg.BatchSize)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(missed) == 0 {
+			break
+		}
+		for _, e := range missed {
+			if e.Seq > head {
+				break
+			}
+			last = e.Seq
+			if !sub.wants(eventAccounts(e)) {
+				continue
+			}
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+		if missed[len(missed)-1].Seq > head {
+			break
+		}
+	}
+	ticker := time.NewTicker(time.Duration(s.cfg.Heartbeat))
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-sub.events:
+			if !ok {
+				if sub.lagged {
+					return errStreamLagged
+				}
+				return nil
+			}
+			// A client that resumed from an event this instance did not fan out yet already has it
+			if e.Seq <= last {
+				continue
+			}
+			last = e.Seq
+			if err := send(e); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := keepAlive(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// serveEvents serves a stream as Server-Sent Events: every event is sent with its sequence number as ID and its type as event name, and a comment
+// line keeps the connection alive
+func (s *TransferStream) serveEvents(w http.ResponseWriter, r *http.Request, sub *streamSubscriber, head int64, last int64) {
+	flusher := w.(http.Flusher)
+	rc := http.NewResponseController(w)
+	defer rc.SetWriteDeadline(time.Time{})
+	write := func(msg string) error {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := io.WriteString(w, msg); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Keep proxies such as nginx from buffering the stream
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	// Have the client reconnect after a second when the stream ends
+	if write("retry: 1000\n\n") != nil {
+		return
+	}
+	send := func(e Event) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return write("id: " + strconv.FormatInt(e.Seq, 10) + "\nevent: " + e.Type + "\ndata: " + string(data) + "\n\n")
+	}
+	s.pump(r.Context(), sub, head, last, send, func() error { return write(": keep-alive\n\n") })
+}
+
+// serveWebSocket serves a stream over a WebSocket: every event is sent as a JSON text message and a ping keeps the connection alive. The stream is
+// closed with "going away" when the feed shuts down and "try again later" when it fell behind, the client resuming from the seq of its last event
+func (s *TransferStream) serveWebSocket(w http.ResponseWriter, r *http.Request, sub *streamSubscriber, head int64, last int64) {
+	// A failed upgrade has already been answered
+	conn, err := streamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	// The client has nothing to send but control frames: reading them answers its pings and notices when it goes away
+	conn.SetReadLimit(4096)
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	send := func(e Event) error {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteJSON(e)
+	}
+	keepAlive := func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout))
+	}
+	err = s.pump(ctx, sub, head, last, send, keepAlive)
+	if ctx.Err() != nil {
+		return
+	}
+	code, reason := websocket.CloseGoingAway, "the live feed is shutting down"
+	if err == errStreamLagged {
+		code, reason = websocket.CloseTryAgainLater, "the stream fell behind"
+	} else if err != nil {
+		code, reason = websocket.CloseInternalServerErr, "the stream failed"
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(streamWriteTimeout))
+}
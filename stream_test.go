@@ -0,0 +1,298 @@
+package wservice
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestStream creates the live feed of a wallet service with the default settings, changed by change if not nil
+func newTestStream(t *testing.T, svc WalletService, change func(cfg *StreamConfig)) *TransferStream {
+	cfg := DefaultConfig().Stream
+	if change != nil {
+		change(&cfg)
+	}
+	s, err := NewTransferStream(svc, cfg)
+	assert.Nil(t, err)
+	return s
+}
+
+// sseMessage is a message of a Server-Sent Events stream
+type sseMessage struct {
+	id, event, data string
+}
+
+// readSSE reads the next event of a Server-Sent Events stream, skipping the comments and the retry field
+func readSSE(t *testing.T, r *bufio.Reader) sseMessage {
+	var m sseMessage
+	for {
+		line, err := r.ReadString('\n')
+		if !assert.Nil(t, err) {
+			return m
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && m.event != "":
+			return m
+		case strings.HasPrefix(line, "id: "):
+			m.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			m.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			m.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestTransferStreamTail(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		// The feed starts after the events already in the outbox
+		_, err := svc.DoTransfer("bob123", "alice456", "1")
+		assert.Nil(t, err)
+		s := newTestStream(t, svc, nil)
+		all, head, err := s.subscribe(nil)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), head)
+		bob, _, err := s.subscribe([]string{"alice456", "bob123"})
+		assert.Nil(t, err)
+		marcy, _, err := s.subscribe([]string{"marcy789"})
+		assert.Nil(t, err)
+		assert.Equal(t, 3, s.Subscribers())
+
+		transfer, err := svc.SubmitTransfer("bob123", "alice456", "2.35")
+		assert.Nil(t, err)
+		n, err := s.Tail()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+		assert.Len(t, all.events, 1)
+		assert.Len(t, marcy.events, 0)
+		e := <-bob.events
+		assert.Equal(t, int64(2), e.Seq)
+		assert.Equal(t, EventTransferCompleted, e.Type)
+		var payload TransferEvent
+		assert.Nil(t, json.Unmarshal(e.Payload, &payload))
+		assert.Equal(t, transfer.ID, payload.ID)
+		assert.Equal(t, []AccountBalance{
+			{AccountID: "bob123", WalletID: payload.Balances[0].WalletID, Currency: "USD", Balance: "299.000"},
+			{AccountID: "alice456", WalletID: payload.Balances[1].WalletID, Currency: "USD", Balance: "577.160"},
+		}, payload.Balances)
+
+		n, err = s.Tail()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+		s.unsubscribe(marcy)
+		_, ok := <-marcy.events
+		assert.False(t, ok)
+		s.Close()
+		assert.Equal(t, 0, s.Subscribers())
+		_, ok = <-bob.events
+		assert.False(t, ok)
+		_, _, err = s.subscribe(nil)
+		assert.True(t, errors.Is(err, ErrUnavailable))
+	})
+}
+
+func TestTransferStreamGap(t *testing.T) {
+	svc := newMemoryService(t)
+	s := newTestStream(t, svc, nil)
+	clock := time.Now()
+	s.now = func() time.Time { return clock }
+	sub, _, err := s.subscribe(nil)
+	assert.Nil(t, err)
+	// The feed waits for the event it expects next, as it may still be committing, and skips it once it is long overdue
+	s.head = -1
+	_, err = svc.DoTransfer("bob123", "alice456", "1")
+	assert.Nil(t, err)
+	n, err := s.Tail()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	clock = clock.Add(streamGapWait / 2)
+	n, err = s.Tail()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Len(t, sub.events, 0)
+	clock = clock.Add(streamGapWait / 2)
+	n, err = s.Tail()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Len(t, sub.events, 1)
+	assert.Equal(t, int64(1), s.head)
+}
+
+func TestTransferStreamLagged(t *testing.T) {
+	svc := newMemoryService(t)
+	s := newTestStream(t, svc, func(cfg *StreamConfig) { cfg.Buffer = 1 })
+	sub, _, err := s.subscribe(nil)
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = svc.DoTransfer("bob123", "alice456", "1")
+		assert.Nil(t, err)
+	}
+	n, err := s.Tail()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	// The event that fit in the buffer is still delivered, then the stream ends
+	_, ok := <-sub.events
+	assert.True(t, ok)
+	_, ok = <-sub.events
+	assert.False(t, ok)
+	assert.True(t, sub.lagged)
+	assert.Equal(t, 0, s.Subscribers())
+}
+
+func TestTransferStreamEvents(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		first, err := svc.SubmitTransfer("bob123", "alice456", "1")
+		assert.Nil(t, err)
+		_, err = svc.DoTransfer("marcy789", "lucy0123", "1")
+		assert.Nil(t, err)
+		s := newTestStream(t, svc, func(cfg *StreamConfig) { cfg.Heartbeat = Duration(time.Hour) })
+		server := httptest.NewServer(s)
+		defer server.Close()
+		defer s.Close()
+
+		// Resuming from the start replays the events of bob123 already in the outbox, then streams the new ones
+		req, err := http.NewRequest(http.MethodGet, server.URL+"?account=bob123", nil)
+		assert.Nil(t, err)
+		req.Header.Set("Last-Event-ID", "0")
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		r := bufio.NewReader(resp.Body)
+		m := readSSE(t, r)
+		assert.Equal(t, "1", m.id)
+		assert.Equal(t, EventTransferCompleted, m.event)
+		var e Event
+		assert.Nil(t, json.Unmarshal([]byte(m.data), &e))
+		assert.Equal(t, int64(1), e.Seq)
+		assert.Contains(t, string(e.Payload), `"id":"`+first.ID+`"`)
+
+		_, err = svc.DoTransfer("marcy789", "lucy0123", "1")
+		assert.Nil(t, err)
+		reversed, err := svc.ReverseTransfer(first.ID)
+		assert.Nil(t, err)
+		_, err = s.Tail()
+		assert.Nil(t, err)
+		m = readSSE(t, r)
+		assert.Equal(t, "4", m.id)
+		assert.Equal(t, EventTransferCompleted, m.event)
+		m = readSSE(t, r)
+		assert.Equal(t, "5", m.id)
+		assert.Equal(t, EventTransferReversed, m.event)
+		assert.Contains(t, m.data, `"id":"`+reversed.ID+`"`)
+
+		// Closing the feed ends the stream
+		s.Close()
+		_, err = r.ReadString('\n')
+		for err == nil {
+			_, err = r.ReadString('\n')
+		}
+	})
+}
+
+func TestTransferStreamWebSocket(t *testing.T) {
+	svc := newMemoryService(t)
+	_, err := svc.DoTransfer("bob123", "alice456", "1")
+	assert.Nil(t, err)
+	s := newTestStream(t, svc, nil)
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?last_event_id=0&account=alice456,lucy0123", nil)
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	var e Event
+	assert.Nil(t, conn.ReadJSON(&e))
+	assert.Equal(t, int64(1), e.Seq)
+	assert.Equal(t, EventTransferCompleted, e.Type)
+
+	_, err = svc.DoTransfer("marcy789", "lucy0123", "1")
+	assert.Nil(t, err)
+	_, err = s.Tail()
+	assert.Nil(t, err)
+	assert.Nil(t, conn.ReadJSON(&e))
+	assert.Equal(t, int64(2), e.Seq)
+	assert.Contains(t, string(e.Payload), `"to":"lucy0123"`)
+
+	// The feed going away closes the stream with a code telling the client to reconnect
+	s.Close()
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway))
+}
+
+func TestTransferStreamRequests(t *testing.T) {
+	svc := newMemoryService(t)
+	s := newTestStream(t, svc, func(cfg *StreamConfig) { cfg.MaxSubscribers = 1 })
+	serve := func(method string, target string, header http.Header) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodPost, "/transfers/stream", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET", rec.Header().Get("Allow"))
+
+	rec = serve(http.MethodGet, "/transfers/stream?account=bob123,&last_event_id=last", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	var p Problem
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&p))
+	assert.Equal(t, []FieldError{
+		{Field: "account", Message: "is required"},
+		{Field: "last_event_id", Message: "must be the ID of an event of the stream (e.g. \"42\"), got \"last\""},
+	}, p.Errors)
+	rec = serve(http.MethodGet, "/transfers/stream", http.Header{"Last-Event-Id": {"-1"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Once the feed serves as many streams as it may, the next ones are turned away
+	_, _, err := s.subscribe(nil)
+	assert.Nil(t, err)
+	rec = serve(http.MethodGet, "/transfers/stream", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "too many streams")
+	s.Close()
+	rec = serve(http.MethodGet, "/transfers/stream", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "shutting down")
+}
+
+func TestRunTransferStream(t *testing.T) {
+	svc := newMemoryService(t)
+	s := newTestStream(t, svc, func(cfg *StreamConfig) { cfg.Interval = Duration(10 * time.Millisecond) })
+	sub, _, err := s.subscribe(nil)
+	assert.Nil(t, err)
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		RunTransferStream(s, log.NewNopLogger(), stop)
+		close(done)
+	}()
+	transfer, err := svc.SubmitTransfer("bob123", "alice456", "1")
+	assert.Nil(t, err)
+	select {
+	case e := <-sub.events:
+		assert.Contains(t, string(e.Payload), transfer.ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the transfer was not streamed")
+	}
+	close(stop)
+	<-done
+	_, ok := <-sub.events
+	assert.False(t, ok)
+	assert.Equal(t, 0, s.Subscribers())
+}
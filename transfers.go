@@ -0,0 +1,213 @@
+package wservice
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transfers carry a status that follows their lifecycle: a submitted transfer that passes its checks is recorded as pending, then it is completed once
+// its funds are moved or failed if they cannot be, and it can be cancelled as long as it is pending. A completed transfer can later be reversed. Attempts
+// refused before they could be recorded are kept apart as failed transfers, together with the reason why
+
+// The statuses a transfer can be in
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusReversed  = "reversed"
+	StatusCancelled = "cancelled"
+)
+
+// movedFunds tells whether the funds of a transfer in the given status moved, which they did for completed transfers and for the reversed ones
+// (whose reversal moved them back)
+func movedFunds(status string) bool {
+	return status == StatusCompleted || status == StatusReversed
+}
+
+// Transfer is a fund transfer from one account to another together with its current status and status history. ID is the ULID of the transfer
+// while LegacyID is the numeric ID it had before ULIDs were introduced, which is still kept for legacy clients
+type Transfer struct {
+	ID       string                 `json:"id"`
+	LegacyID int64                  `json:"legacy_id,omitempty"`
+	From     string                 `json:"from"`
+	To       string                 `json:"to"`
+	Amount   string                 `json:"amount"`
+	Currency string                 `json:"currency,omitempty"`
+	Time     time.Time              `json:"time"`
+	Status   string                 `json:"status"`
+	Reason   string                 `json:"reason,omitempty"`
+	History  []TransferStatusChange `json:"history,omitempty"`
+}
+
+// TransferStatusChange is a status a transfer went into, when and why
+type TransferStatusChange struct {
+	Status    string    `json:"status"`
+	ChangedAt time.Time `json:"changed_at"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// GetTransfers is a ledger type method that fetches every recorded transfer, whatever its status, ordered by its legacy ID, without their status history
+func (l ledger) GetTransfers() ([]Transfer, error) {
+	var transfers []Transfer
+	err := l.store.View(func(tx LedgerTx) error {
+		var err error
+		transfers, err = tx.Transfers()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if transfers == nil {
+		transfers = []Transfer{}
+	}
+	return transfers, nil
+}
+
+// GetTransfer is a ledger type method that fetches a single transfer (or failed transfer attempt) by its ID, with its status history
+func (l ledger) GetTransfer(id string) (Transfer, error) {
+	ref, err := transferKey(id)
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	var t Transfer
+	err = l.store.View(func(tx LedgerTx) error {
+		var err error
+		t, err = tx.Transfer(ref)
+		if err == nil && (t.Status == StatusFailed || t.Status == StatusCancelled) && len(t.History) > 0 {
+			// A transfer that did not complete carries the reason of its last status change
+			t.Reason = t.History[len(t.History)-1].Reason
+		}
+		if err != errNotFound {
+			return err
+		}
+		// The transfer might have been a failed attempt
+		t, err = tx.FailedTransfer(ref)
+		if err == errNotFound {
+			var ErrNoTransfer = newError(ErrTransferNotFound, "The transfer does not exist")
+			return ErrNoTransfer
+		}
+		if err != nil {
+			return err
+		}
+		t.Status = StatusFailed
+		t.History = []TransferStatusChange{{Status: StatusFailed, ChangedAt: t.Time, Reason: t.Reason}}
+		return nil
+	})
+	if err != nil {
+		return Transfer{}, err
+	}
+	return t, nil
+}
+
+// ReverseTransfer is a ledger type method that moves the funds of a completed transfer back with a new (completed) transfer and marks the original one as reversed,
+// both in the same transaction. It returns the original, now reversed, transfer
+func (l ledger) ReverseTransfer(id string) (Transfer, error) {
+	ref, err := transferKey(id)
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	err = l.store.Update(func(tx LedgerTx) error {
+		t, err := tx.Transfer(ref)
+		if err == errNotFound {
+			var ErrNoTransfer = newError(ErrTransferNotFound, "The transfer does not exist")
+			return ErrNoTransfer
+		}
+		if err != nil {
+			return err
+		}
+		if t.Status != StatusCompleted {
+			var ErrStatus = newError(ErrInvalidTransferStatus, "Only completed transfers can be reversed, the transfer is "+t.Status)
+			return ErrStatus
+		}
+
+		// Move the funds back, then record the status change of the original transfer
+		reversal, err := l.transferTx(tx, t.To, t.From, t.Amount)
+		if err != nil {
+			return err
+		}
+		reason := "reversed by transfer " + reversal.ID
+		if err = tx.SetTransferStatus(t.LegacyID, StatusReversed, reason); err != nil {
+			return err
+		}
+		t.Status, t.Reason, t.History = StatusReversed, reason, nil
+		return l.emit(tx, EventTransferReversed, t)
+	})
+	if err != nil {
+		return Transfer{}, err
+	}
+	return l.GetTransfer(id)
+}
+
+// CancelTransfer is a ledger type method that cancels a pending transfer, whose funds then never move. It returns the cancelled transfer
+func (l ledger) CancelTransfer(id string) (Transfer, error) {
+	ref, err := transferKey(id)
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	err = l.store.Update(func(tx LedgerTx) error {
+		t, err := tx.Transfer(ref)
+		if err == errNotFound {
+			// A failed attempt exists but was never pending
+			if _, err = tx.FailedTransfer(ref); err == nil {
+				t.Status = StatusFailed
+			}
+		}
+		if err == errNotFound {
+			var ErrNoTransfer = newError(ErrTransferNotFound, "The transfer does not exist")
+			return ErrNoTransfer
+		}
+		if err != nil {
+			return err
+		}
+		if t.Status != StatusPending {
+			var ErrStatus = newError(ErrInvalidTransferStatus, "Only pending transfers can be cancelled, the transfer is "+t.Status)
+			return ErrStatus
+		}
+		return tx.SetTransferStatus(t.LegacyID, StatusCancelled, "cancelled on request")
+	})
+	if err != nil {
+		return Transfer{}, err
+	}
+	return l.GetTransfer(id)
+}
+
+// recordFailedTransfer keeps a transfer attempt that was refused together with the reason, and returns it as a failed transfer.
+// Recording is best effort: if it fails the attempt is only logged and the returned transfer has no legacy ID
+func (l ledger) recordFailedTransfer(fromAccount string, toAccount string, transferAmount string, reason error) Transfer {
+	t := Transfer{From: fromAccount, To: toAccount, Amount: transferAmount, Status: StatusFailed, Reason: reason.Error(), Time: l.now()}
+	var err error
+	if t.ID, err = newULID(t.Time); err != nil {
+		log.Println("err: could not record failed transfer", err)
+		return t
+	}
+	attempt := t
+	attempt.Time = l.clockTime()
+	err = l.store.Update(func(tx LedgerTx) error {
+		recorded, err := tx.InsertFailedTransfer(attempt)
+		if err == nil {
+			t.LegacyID, t.Time = recorded.LegacyID, recorded.Time
+		}
+		return err
+	})
+	if err != nil {
+		log.Println("err: could not record failed transfer", err)
+	}
+	return t
+}
+
+// transferKey returns the reference to look a transfer up by: a number is the legacy numeric ID and anything else must be a ULID
+func transferKey(id string) (transferRef, error) {
+	if legacyID, err := strconv.ParseInt(id, 10, 64); err == nil {
+		return transferRef{legacyID: legacyID}, nil
+	}
+	if uid := strings.ToUpper(id); isULID(uid) {
+		return transferRef{uid: uid}, nil
+	}
+	var ErrID = newError(ErrInvalidRequest, "The transfer ID must be a ULID or a legacy numeric ID")
+	return transferRef{}, ErrID
+}
@@ -1,45 +1,112 @@
 package wservice
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
 
 	"github.com/gorilla/mux"
 
+	"github.com/go-kit/kit/endpoint"
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// Transports exposes the wallet service API to the network via JSON over HTTP. As a future extension gRPC could also be introduced for inter-service communications.
+// Transports exposes the wallet service API to the network via JSON over HTTP, the resource-oriented /v1 API of v1.go next to the legacy routes. The gRPC transport of grpc.go serves the same endpoints to the other services.
 
-// NewHTTPTransport creates a new JSON over HTTP transport
+// NewHTTPTransport creates a new JSON over HTTP transport, validating the requests against the OpenAPI document of openapi.yaml
 func NewHTTPTransport(svc WalletService) http.Handler {
+	return newRouter(svc)
+}
+
+// newHandler creates the handler of an endpoint. It checks that the API key of a request is granted the scope first, then validates the request
+// against the OpenAPI document before decoding it, so that a caller without a valid key learns nothing of the requests the endpoint takes
+func newHandler(svc WalletService, scope string, e endpoint.Endpoint, dec httptransport.DecodeRequestFunc, options []httptransport.ServerOption) http.Handler {
+	return authorizeRequests(svc, scope, false, validateRequests(httptransport.NewServer(e, dec, EncodeResponse, options...)))
+}
+
+// newRouter routes the requests of every route of the HTTP transport to its handler
+func newRouter(svc WalletService) *mux.Router {
+	// Every handler encodes its errors as problem details and makes the request path available to the error encoder
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(EncodeError),
+		httptransport.ServerBefore(httptransport.PopulateRequestContext),
+	}
 	// define a way to service a request for the TransfersEndpoint
-	transfersHandler := httptransport.NewServer(
-		MakeTransfersEndpoint(svc),
-		DecodeTransfersRequest,
-		EncodeResponse,
-	)
+	transfersHandler := newHandler(svc, ScopeRead, MakeTransfersEndpoint(svc), DecodeTransfersRequest, options)
 	// define a way to service a request for the AccountsEndpoint
-	accountsHandler := httptransport.NewServer(
-		MakeAccountsEndpoint(svc),
-		DecodeAccountsRequest,
-		EncodeResponse,
-	)
+	accountsHandler := newHandler(svc, ScopeRead, MakeAccountsEndpoint(svc), DecodeAccountsRequest, options)
 	// define a way to service a request for the submitTransferEndpoint
-	submitTransferHandler := httptransport.NewServer(
-		MakeSubmitTransferEndpoint(svc),
-		DecodeSubmitTransferRequest,
-		EncodeResponse,
-	)
+	submitTransferHandler := newHandler(svc, ScopeTransfer, MakeSubmitTransferEndpoint(svc), DecodeSubmitTransferRequest, options)
+	// define a way to service a request for the CurrenciesEndpoint
+	currenciesHandler := newHandler(svc, ScopeRead, MakeCurrenciesEndpoint(svc), DecodeCurrenciesRequest, options)
+	// define a way to service a request for the SetCurrencyEndpoint
+	setCurrencyHandler := newHandler(svc, ScopeAdmin, MakeSetCurrencyEndpoint(svc), DecodeSetCurrencyRequest, options)
+	// define a way to service a request for the interest endpoints
+	setInterestRateHandler := newHandler(svc, ScopeAdmin, MakeSetInterestRateEndpoint(svc), DecodeSetInterestRateRequest, options)
+	accrueInterestHandler := newHandler(svc, ScopeAdmin, MakeAccrueInterestEndpoint(svc), DecodeAccrueInterestRequest, options)
+	postInterestHandler := newHandler(svc, ScopeAdmin, MakePostInterestEndpoint(svc), DecodePostInterestRequest, options)
+	// define a way to service a request for the TransferEndpoint and the ReverseTransferEndpoint
+	transferHandler := newHandler(svc, ScopeRead, MakeTransferEndpoint(svc), DecodeTransferRequest, options)
+	reverseTransferHandler := newHandler(svc, ScopeAdmin, MakeReverseTransferEndpoint(svc), DecodeReverseTransferRequest, options)
+	// define a way to service a request for the webhook endpoints
+	webhooksHandler := newHandler(svc, ScopeAdmin, MakeWebhooksEndpoint(svc), DecodeWebhooksRequest, options)
+	createWebhookHandler := newHandler(svc, ScopeAdmin, MakeCreateWebhookEndpoint(svc), DecodeCreateWebhookRequest, options)
+	webhookHandler := newHandler(svc, ScopeAdmin, MakeWebhookEndpoint(svc), DecodeWebhookRequest, options)
+	setWebhookHandler := newHandler(svc, ScopeAdmin, MakeSetWebhookEndpoint(svc), DecodeSetWebhookRequest, options)
+	deleteWebhookHandler := newHandler(svc, ScopeAdmin, MakeDeleteWebhookEndpoint(svc), DecodeWebhookRequest, options)
+	webhookDeliveriesHandler := newHandler(svc, ScopeAdmin, MakeWebhookDeliveriesEndpoint(svc), DecodeWebhookRequest, options)
+	replayWebhookDeliveryHandler := newHandler(svc, ScopeAdmin, MakeReplayWebhookDeliveryEndpoint(svc), DecodeReplayWebhookDeliveryRequest, options)
 	// Define a new router that will handle API endpoints for each of the previously defined handlers and for metrics
 	r := mux.NewRouter()
-	r.Handle("/transfers", transfersHandler)
-	r.Handle("/accounts", accountsHandler)
-	r.Handle("/submittransfer", submitTransferHandler)
-	r.Handle("/metrics", promhttp.Handler())
+	// The legacy routes that the /v1 API succeeds keep working, marked as deprecated with a link to their successor
+	r.Handle("/transfers", deprecated("/v1/transfers", transfersHandler))
+	r.Handle("/transfers/{id}", deprecated("/v1/transfers/{id}", transferHandler))
+	r.Handle("/accounts", deprecated("/v1/accounts", accountsHandler))
+	r.Handle("/submittransfer", deprecated("/v1/transfers", submitTransferHandler))
+	r.Handle("/currencies", currenciesHandler)
+	r.Handle("/admin/currencies", setCurrencyHandler)
+	r.Handle("/admin/interest/rates", setInterestRateHandler)
+	r.Handle("/admin/interest/accrue", accrueInterestHandler)
+	r.Handle("/admin/interest/post", postInterestHandler)
+	r.Handle("/admin/transfers/reverse", reverseTransferHandler)
+	// The webhook endpoints serve several verbs on the same path, the verbs they do not serve are answered by the last route of each path
+	r.Handle("/webhooks", webhooksHandler).Methods(http.MethodGet)
+	r.Handle("/webhooks", createWebhookHandler).Methods(http.MethodPost)
+	r.Handle("/webhooks", methodNotAllowedHandler("/webhooks", http.MethodGet, http.MethodPost))
+	r.Handle("/webhooks/{id}", webhookHandler).Methods(http.MethodGet)
+	r.Handle("/webhooks/{id}", setWebhookHandler).Methods(http.MethodPatch)
+	r.Handle("/webhooks/{id}", deleteWebhookHandler).Methods(http.MethodDelete)
+	r.Handle("/webhooks/{id}", methodNotAllowedHandler("/webhooks/{id}", http.MethodGet, http.MethodPatch, http.MethodDelete))
+	r.Handle("/webhooks/{id}/deliveries", webhookDeliveriesHandler).Methods(http.MethodGet)
+	r.Handle("/webhooks/{id}/deliveries", methodNotAllowedHandler("/webhooks/{id}/deliveries", http.MethodGet))
+	r.Handle("/webhooks/{id}/deliveries/{delivery}/replay", replayWebhookDeliveryHandler).Methods(http.MethodPost)
+	r.Handle("/webhooks/{id}/deliveries/{delivery}/replay", methodNotAllowedHandler("/webhooks/{id}/deliveries/{delivery}/replay", http.MethodPost))
+	// The /v1 API serves the accounts and the transfers as resources
+	addV1Routes(r, svc, options)
+	// The JSON-RPC 2.0 endpoint serves the same endpoints to the tools that only speak JSON-RPC
+	r.Handle("/rpc", NewJSONRPCHandler(svc)).Methods(http.MethodPost)
+	r.Handle("/rpc", methodNotAllowedHandler("/rpc", http.MethodPost))
+	r.Handle("/metrics", AuthorizeHandler(svc, ScopeAdmin, promhttp.Handler()))
+	// The OpenAPI document describes every route, and can be browsed with Swagger UI
+	r.Handle("/openapi.json", openAPIHandler()).Methods(http.MethodGet)
+	r.Handle("/openapi.json", methodNotAllowedHandler("/openapi.json", http.MethodGet))
+	r.Handle("/docs", swaggerUIHandler()).Methods(http.MethodGet)
+	r.Handle("/docs", methodNotAllowedHandler("/docs", http.MethodGet))
+	r.Handle("/docs/{asset}", swaggerUIHandler()).Methods(http.MethodGet)
+	r.Handle("/docs/{asset}", methodNotAllowedHandler("/docs/{asset}", http.MethodGet))
+	// Unknown routes get problem details as well
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := httptransport.PopulateRequestContext(req.Context(), req)
+		EncodeError(ctx, newError(ErrNotFound, "404 page not found"), w)
+	})
 	// Return the router
 	return r
 }
@@ -49,34 +116,347 @@ func DecodeTransfersRequest(_ context.Context, r *http.Request) (interface{}, er
 	if r.Method == http.MethodGet {
 		return nil, nil
 	}
-	var ErrVerb = errors.New("err: Verb can only be \"GET\" for endpoint \"/transfers\"")
+	var ErrVerb = newError(ErrMethodNotAllowed, "err: Verb can only be \"GET\" for endpoint \"/transfers\"")
 	return nil, ErrVerb
 }
 
 // DecodeAccountsRequest exported to be accessible from outside the package (from main)
 func DecodeAccountsRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	if r.Method == http.MethodGet {
-		return nil, nil
+		// An optional "wallet" query parameter narrows the result down to the balances of a single wallet
+		return accountsRequest{S: r.URL.Query().Get("wallet")}, nil
 	}
-	var ErrVerb = errors.New("err: Verb can only be \"GET\" for endpoint \"/accounts\"")
+	var ErrVerb = newError(ErrMethodNotAllowed, "err: Verb can only be \"GET\" for endpoint \"/accounts\"")
 	return nil, ErrVerb
 }
 
 // DecodeSubmitTransferRequest exported to be accessible from outside the package (from main)
 func DecodeSubmitTransferRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	if r.Method != http.MethodPost {
-		var ErrVerb = errors.New("err: Verb can only be \"POST\" for endpoint \"/submittransfer\"")
+		var ErrVerb = newError(ErrMethodNotAllowed, "err: Verb can only be \"POST\" for endpoint \"/submittransfer\"")
 		return nil, ErrVerb
 	}
 
 	var request submitTransferRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	if err := checkSubmitTransferRequest(request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// checkSubmitTransferRequest makes sure a transfer is addressed either by account or by wallet plus currency, never by a mix of both
+func checkSubmitTransferRequest(request submitTransferRequest) error {
+	var v validator
+	if request.FromWallet != "" || request.ToWallet != "" {
+		if request.FromAccount != "" {
+			v.fail("from", "must not be set together with from_wallet and to_wallet")
+		}
+		if request.ToAccount != "" {
+			v.fail("to", "must not be set together with from_wallet and to_wallet")
+		}
+	} else if request.Currency != "" {
+		v.fail("currency", "must only be set together with from_wallet and to_wallet")
+	}
+	return v.err()
+}
+
+// DecodeCurrenciesRequest exported to be accessible from outside the package (from main)
+func DecodeCurrenciesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method == http.MethodGet {
+		return nil, nil
+	}
+	var ErrVerb = newError(ErrMethodNotAllowed, "err: Verb can only be \"GET\" for endpoint \"/currencies\"")
+	return nil, ErrVerb
+}
+
+// DecodeSetCurrencyRequest exported to be accessible from outside the package (from main)
+func DecodeSetCurrencyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		var ErrVerb = newError(ErrMethodNotAllowed, "err: Verb can only be \"POST\" for endpoint \"/admin/currencies\"")
+		return nil, ErrVerb
+	}
+
+	var request setCurrencyRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// DecodeSetInterestRateRequest exported to be accessible from outside the package (from main)
+func DecodeSetInterestRateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		var ErrVerb = newError(ErrMethodNotAllowed, "err: Verb can only be \"POST\" for endpoint \"/admin/interest/rates\"")
+		return nil, ErrVerb
+	}
+
+	var request setInterestRateRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// DecodeAccrueInterestRequest exported to be accessible from outside the package (from main)
+func DecodeAccrueInterestRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		var ErrVerb = newError(ErrMethodNotAllowed, "err: Verb can only be \"POST\" for endpoint \"/admin/interest/accrue\"")
+		return nil, ErrVerb
+	}
+
+	var request accrueInterestRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// DecodePostInterestRequest exported to be accessible from outside the package (from main)
+func DecodePostInterestRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		var ErrVerb = newError(ErrMethodNotAllowed, "err: Verb can only be \"POST\" for endpoint \"/admin/interest/post\"")
+		return nil, ErrVerb
+	}
+
+	var request postInterestRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// DecodeTransferRequest exported to be accessible from outside the package (from main)
+func DecodeTransferRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method == http.MethodGet {
+		return transferRequest{ID: mux.Vars(r)["id"]}, nil
+	}
+	var ErrVerb = newError(ErrMethodNotAllowed, "err: Verb can only be \"GET\" for endpoint \"/transfers/{id}\"")
+	return nil, ErrVerb
+}
+
+// DecodeReverseTransferRequest exported to be accessible from outside the package (from main)
+func DecodeReverseTransferRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		var ErrVerb = newError(ErrMethodNotAllowed, "err: Verb can only be \"POST\" for endpoint \"/admin/transfers/reverse\"")
+		return nil, ErrVerb
+	}
+
+	var request transferRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// methodNotAllowedHandler answers the verbs a path is not served with, listing the ones it is
+func methodNotAllowedHandler(path string, verbs ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := httptransport.PopulateRequestContext(req.Context(), req)
+		w.Header().Set("Allow", strings.Join(verbs, ", "))
+		var ErrVerb = newError(ErrMethodNotAllowed, "err: Verb can only be \""+strings.Join(verbs, "\" or \"")+"\" for endpoint \""+path+"\"")
+		EncodeError(ctx, ErrVerb, w)
+	})
+}
+
+// DecodeWebhooksRequest exported to be accessible from outside the package (from main)
+func DecodeWebhooksRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return nil, nil
+}
+
+// DecodeCreateWebhookRequest exported to be accessible from outside the package (from main)
+func DecodeCreateWebhookRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request createWebhookRequest
+	if err := decodeJSONBody(r, &request); err != nil {
 		return nil, err
 	}
 	return request, nil
 }
 
+// DecodeWebhookRequest exported to be accessible from outside the package (from main)
+// It serves every endpoint that only takes the webhook ID of the path
+func DecodeWebhookRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return webhookRequest{ID: mux.Vars(r)["id"]}, nil
+}
+
+// DecodeSetWebhookRequest exported to be accessible from outside the package (from main)
+func DecodeSetWebhookRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request setWebhookRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	// Enabled is the only setting of a webhook that can change, so it is required
+	if request.Enabled == nil {
+		var v validator
+		v.fail("enabled", "is required")
+		return nil, v.err()
+	}
+	request.ID = mux.Vars(r)["id"]
+	return request, nil
+}
+
+// DecodeReplayWebhookDeliveryRequest exported to be accessible from outside the package (from main)
+func DecodeReplayWebhookDeliveryRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	return replayWebhookDeliveryRequest{ID: vars["id"], Delivery: vars["delivery"]}, nil
+}
+
+// maxRequestBodySize is the default largest request body, in bytes, that the API accepts
+const maxRequestBodySize = 64 << 10
+
+// requestBodyLimit is the largest request body the API currently accepts, it follows the max_request_body setting when the configuration is reloaded
+var requestBodyLimit int64 = maxRequestBodySize
+
+// SetMaxRequestBodySize exported to be accessible from outside the package (from main)
+// SetMaxRequestBodySize changes the largest request body, in bytes, that the API accepts
+func SetMaxRequestBodySize(n int) {
+	atomic.StoreInt64(&requestBodyLimit, int64(n))
+}
+
+// readBody reads the body of a request, refusing bodies larger than the request body limit
+func readBody(r *http.Request) ([]byte, error) {
+	limit := atomic.LoadInt64(&requestBodyLimit)
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, newError(ErrInvalidRequest, "err: could not read request body: "+err.Error())
+	}
+	if int64(len(body)) > limit {
+		return nil, newError(ErrRequestTooLarge, "err: the request body must not be larger than "+strconv.FormatInt(limit, 10)+" bytes")
+	}
+	return body, nil
+}
+
+// decodeJSONBody decodes the JSON body of a request into v. Bodies larger than the request body limit, unknown fields and trailing data are refused
+func decodeJSONBody(r *http.Request, v interface{}) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	return decodeJSON(body, v)
+}
+
+// decodeJSON decodes a JSON document into v, refusing unknown fields and trailing data
+func decodeJSON(body []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.More() {
+		err = errors.New("unexpected data after the JSON object")
+	}
+	if err != nil {
+		return newError(ErrInvalidRequest, "err: malformed JSON request body: "+err.Error())
+	}
+	return nil
+}
+
 // EncodeResponse exported to be accessible from outside the package (from main)
-func EncodeResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+// If the response carries a service error it is encoded as problem details by EncodeError instead
+func EncodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if f, ok := response.(failer); ok && f.Failed() != nil {
+		p := NewProblem(ctx, f.Failed())
+		// A failed transfer attempt is recorded, so let the client know where to find it
+		if t, ok := response.(submitTransferResponse); ok {
+			p.TransferID, p.LegacyTransferID = t.ID, t.LegacyID
+		}
+		if t, ok := response.(createTransferResponse); ok && t.Transfer != nil {
+			p.TransferID, p.LegacyTransferID = t.ID, t.LegacyID
+		}
+		return writeProblem(w, p)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	// A response can set headers and a status code of its own, like a created resource does with Location and 201 Created
+	if h, ok := response.(httptransport.Headerer); ok {
+		for k, values := range h.Headers() {
+			for _, v := range values {
+				w.Header().Add(k, v)
+			}
+		}
+	}
+	if sc, ok := response.(httptransport.StatusCoder); ok {
+		w.WriteHeader(sc.StatusCode())
+	}
 	return json.NewEncoder(w).Encode(response)
 }
+
+// Problem is an RFC 7807 problem details body. Code is a stable machine-readable identifier of the kind of error, while Detail is the descriptive message
+type Problem struct {
+	Type             string       `json:"type"`
+	Title            string       `json:"title"`
+	Status           int          `json:"status"`
+	Detail           string       `json:"detail,omitempty"`
+	Instance         string       `json:"instance,omitempty"`
+	Code             string       `json:"code"`
+	TransferID       string       `json:"transfer_id,omitempty"`
+	LegacyTransferID int64        `json:"legacy_transfer_id,omitempty"`
+	Errors           []FieldError `json:"errors,omitempty"`
+}
+
+// problemKinds maps every kind of service error to its HTTP status code, stable code and title
+var problemKinds = []struct {
+	kind   error
+	status int
+	code   string
+	title  string
+}{
+	{ErrInvalidRequest, http.StatusBadRequest, "invalid_request", "Invalid request"},
+	{ErrValidation, http.StatusBadRequest, "validation_failed", "Validation failed"},
+	{ErrRequestTooLarge, http.StatusRequestEntityTooLarge, "request_too_large", "Request body too large"},
+	{ErrInvalidAmount, http.StatusBadRequest, "invalid_amount", "Invalid amount"},
+	{ErrUnauthenticated, http.StatusUnauthorized, "unauthenticated", "Unauthenticated"},
+	{ErrForbidden, http.StatusForbidden, "forbidden", "Forbidden"},
+	{ErrNotFound, http.StatusNotFound, "not_found", "Not found"},
+	{ErrAccountNotFound, http.StatusNotFound, "account_not_found", "Account not found"},
+	{ErrWalletNotFound, http.StatusNotFound, "wallet_not_found", "Wallet not found"},
+	{ErrTransferNotFound, http.StatusNotFound, "transfer_not_found", "Transfer not found"},
+	{ErrCurrencyNotFound, http.StatusNotFound, "currency_not_found", "Currency not found"},
+	{ErrWebhookNotFound, http.StatusNotFound, "webhook_not_found", "Webhook not found"},
+	{ErrDeliveryNotFound, http.StatusNotFound, "delivery_not_found", "Webhook delivery not found"},
+	{ErrAPIKeyNotFound, http.StatusNotFound, "api_key_not_found", "API key not found"},
+	{ErrMethodNotAllowed, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed"},
+	{ErrInvalidTransferStatus, http.StatusConflict, "invalid_transfer_status", "Invalid transfer status"},
+	{ErrWebhookDisabled, http.StatusConflict, "webhook_disabled", "Webhook disabled"},
+	{ErrAPIKeyRevoked, http.StatusConflict, "api_key_revoked", "API key revoked"},
+	{ErrSameAccount, http.StatusUnprocessableEntity, "same_account", "Same source and destination account"},
+	{ErrInsufficientFunds, http.StatusUnprocessableEntity, "insufficient_funds", "Insufficient funds"},
+	{ErrCurrencyMismatch, http.StatusUnprocessableEntity, "currency_mismatch", "Currency mismatch"},
+	{ErrCurrencyDisabled, http.StatusUnprocessableEntity, "currency_disabled", "Currency disabled"},
+	{ErrUnavailable, http.StatusServiceUnavailable, "service_unavailable", "Service unavailable"},
+}
+
+// NewProblem builds the problem details of an error, falling back to a 500 internal error for errors of no known kind
+func NewProblem(ctx context.Context, err error) Problem {
+	p := Problem{Status: http.StatusInternalServerError, Code: "internal_error", Title: "Internal error", Detail: err.Error()}
+	for _, k := range problemKinds {
+		if errors.Is(err, k.kind) {
+			p.Status, p.Code, p.Title = k.status, k.code, k.title
+			break
+		}
+	}
+	p.Type = "urn:wservice:problem:" + p.Code
+	// A validation error also lists every invalid field
+	var verr ValidationError
+	if errors.As(err, &verr) {
+		p.Errors = verr.Fields
+	}
+	if path, ok := ctx.Value(httptransport.ContextKeyRequestPath).(string); ok {
+		p.Instance = path
+	}
+	return p
+}
+
+// EncodeError is the go-kit ServerErrorEncoder of every handler, it writes the error as RFC 7807 problem details with a matching HTTP status code
+func EncodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	if errors.Is(err, ErrUnauthenticated) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="wservice"`)
+	}
+	writeProblem(w, NewProblem(ctx, err))
+}
+
+// writeProblem writes problem details as an application/problem+json response
+func writeProblem(w http.ResponseWriter, p Problem) error {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	return json.NewEncoder(w).Encode(p)
+}
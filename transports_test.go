@@ -0,0 +1,78 @@
+package wservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceErrorKinds(t *testing.T) {
+	err := newError(ErrAccountNotFound, "The source account does not exist")
+	assert.EqualError(t, err, "The source account does not exist")
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+	assert.False(t, errors.Is(err, ErrInsufficientFunds))
+	err = dbError(errors.New("err: unexpected error"), &net.OpError{Op: "dial", Err: errors.New("connection refused")})
+	assert.True(t, errors.Is(err, ErrUnavailable))
+	err = dbError(errors.New("err: unexpected error"), errors.New("syntax error"))
+	assert.False(t, errors.Is(err, ErrUnavailable))
+}
+
+func TestNewProblem(t *testing.T) {
+	ctx := context.WithValue(context.Background(), httptransport.ContextKeyRequestPath, "/submittransfer")
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{newError(ErrInvalidRequest, "bad"), http.StatusBadRequest, "invalid_request"},
+		{newError(ErrAccountNotFound, "The source account does not exist"), http.StatusNotFound, "account_not_found"},
+		{newError(ErrMethodNotAllowed, "err: Verb can only be \"POST\""), http.StatusMethodNotAllowed, "method_not_allowed"},
+		{newError(ErrInvalidTransferStatus, "Only completed transfers can be reversed"), http.StatusConflict, "invalid_transfer_status"},
+		{newError(ErrInsufficientFunds, "Balance insuficient for transaction"), http.StatusUnprocessableEntity, "insufficient_funds"},
+		{newError(ErrUnavailable, "err: error beginning transaction in postgres"), http.StatusServiceUnavailable, "service_unavailable"},
+		{errors.New("something else"), http.StatusInternalServerError, "internal_error"},
+	}
+	for _, c := range cases {
+		p := NewProblem(ctx, c.err)
+		assert.Equal(t, c.status, p.Status, c.code)
+		assert.Equal(t, c.code, p.Code)
+		assert.Equal(t, "urn:wservice:problem:"+c.code, p.Type)
+		assert.Equal(t, c.err.Error(), p.Detail)
+		assert.Equal(t, "/submittransfer", p.Instance)
+	}
+}
+
+func TestEncodeResponseProblem(t *testing.T) {
+	w := httptest.NewRecorder()
+	response := submitTransferResponse{"error", "01D6KZ8W0R5V2F7T9G3H1J4K6M", 7, StatusFailed, newError(ErrInsufficientFunds, "Balance insuficient for transaction")}
+	assert.Nil(t, EncodeResponse(context.Background(), w, response))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	var p Problem
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&p))
+	assert.Equal(t, "insufficient_funds", p.Code)
+	assert.Equal(t, "01D6KZ8W0R5V2F7T9G3H1J4K6M", p.TransferID)
+	assert.Equal(t, int64(7), p.LegacyTransferID)
+
+	w = httptest.NewRecorder()
+	assert.Nil(t, EncodeResponse(context.Background(), w, submitTransferResponse{"success", "01D6KZ8W0R5V2F7T9G3H1J4K6N", 8, StatusCompleted, nil}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"result":"success","id":"01D6KZ8W0R5V2F7T9G3H1J4K6N","legacy_id":8,"status":"completed"}`, w.Body.String())
+}
+
+func TestDecodeErrors(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/submittransfer", nil)
+	_, err := DecodeSubmitTransferRequest(context.Background(), r)
+	assert.True(t, errors.Is(err, ErrMethodNotAllowed))
+	r = httptest.NewRequest(http.MethodPost, "/submittransfer", nil)
+	r.Body = http.NoBody
+	_, err = DecodeSubmitTransferRequest(context.Background(), r)
+	assert.True(t, errors.Is(err, ErrInvalidRequest))
+}
@@ -0,0 +1,58 @@
+package wservice
+
+import (
+	"crypto/rand"
+	"strings"
+	"time"
+)
+
+// ULIDs (https://github.com/ulid/spec) identify transfers in the API: 26 Crockford base32 characters encoding a 48-bit millisecond timestamp
+// followed by 80 random bits, so that they are unique across instances and sort in the order the transfers were made
+
+// crockfordAlphabet is the base32 alphabet of ULIDs, it leaves out I, L, O and U
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidLength is the number of characters of a ULID
+const ulidLength = 26
+
+// newULID returns a new ULID for the given time
+func newULID(t time.Time) (string, error) {
+	var id [16]byte
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	for i := 5; i >= 0; i-- {
+		id[i] = byte(ms)
+		ms >>= 8
+	}
+	if _, err := rand.Read(id[6:]); err != nil {
+		return "", err
+	}
+
+	// The 128 bits are encoded 5 at a time from the most significant end, the first character only carrying the top 3 bits
+	var out [ulidLength]byte
+	for i := ulidLength - 1; i >= 0; i-- {
+		bit := 128 - 5*(ulidLength-i)
+		var v byte
+		for j := 0; j < 5; j++ {
+			b := bit + j
+			if b < 0 {
+				continue
+			}
+			v = v<<1 | (id[b/8]>>(7-uint(b%8)))&1
+		}
+		out[i] = crockfordAlphabet[v]
+	}
+	return string(out[:]), nil
+}
+
+// isULID reports whether s is a well-formed ULID
+func isULID(s string) bool {
+	if len(s) != ulidLength || s[0] > '7' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(crockfordAlphabet, s[i]) == -1 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package wservice
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewULID(t *testing.T) {
+	// The timestamp part of the ULID spec example
+	id, err := newULID(time.Unix(0, 1469918176385*int64(time.Millisecond)))
+	assert.Nil(t, err)
+	assert.Equal(t, "01ARYZ6S41", id[:10])
+	assert.True(t, isULID(id))
+
+	other, err := newULID(time.Unix(0, 1469918176385*int64(time.Millisecond)))
+	assert.Nil(t, err)
+	assert.NotEqual(t, id, other)
+
+	// ULIDs of later times sort after the ones of earlier times
+	var ids []string
+	start := time.Date(2019, 3, 22, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 50; i++ {
+		id, err := newULID(start.Add(time.Duration(i) * time.Millisecond))
+		assert.Nil(t, err)
+		ids = append(ids, id)
+	}
+	assert.True(t, sort.StringsAreSorted(ids))
+}
+
+func TestIsULID(t *testing.T) {
+	assert.True(t, isULID("01ARZ3NDEKTSV4RRFFQ69G5FAV"))
+	assert.False(t, isULID("01ARZ3NDEKTSV4RRFFQ69G5FA"))
+	assert.False(t, isULID("01ARZ3NDEKTSV4RRFFQ69G5FAU"))
+	assert.False(t, isULID("81ARZ3NDEKTSV4RRFFQ69G5FAV"))
+	assert.False(t, isULID("42"))
+}
+
+func TestTransferKey(t *testing.T) {
+	ref, err := transferKey("42")
+	assert.Nil(t, err)
+	assert.Equal(t, transferRef{legacyID: 42}, ref)
+	column, key := refColumn(ref)
+	assert.Equal(t, "TransID", column)
+	assert.Equal(t, int64(42), key)
+	ref, err = transferKey("01arz3ndektsv4rrffq69g5fav")
+	assert.Nil(t, err)
+	column, key = refColumn(ref)
+	assert.Equal(t, "UID", column)
+	assert.Equal(t, "01ARZ3NDEKTSV4RRFFQ69G5FAV", key)
+	_, err = transferKey("-x")
+	assert.NotNil(t, err)
+}
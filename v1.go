@@ -0,0 +1,92 @@
+package wservice
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// The /v1 API serves the accounts and the transfers as resources: the router matches the verbs of every route, so its decoders do not check them and
+// the verbs a route is not served with are answered with 405 Method Not Allowed and an Allow header. The legacy routes it succeeds keep working, marked
+// as deprecated (see deprecated)
+
+// legacyDeprecation is the RFC 9745 Deprecation header of the legacy routes, the date the /v1 API succeeded them
+const legacyDeprecation = "@1792368000"
+
+// addV1Routes adds the routes of the /v1 API to the router, with the options of the handlers of the other routes
+func addV1Routes(r *mux.Router, svc WalletService, options []httptransport.ServerOption) {
+	// define a way to service a request for the ListAccountsEndpoint and the AccountEndpoint
+	listAccountsHandler := newHandler(svc, ScopeRead, MakeListAccountsEndpoint(svc), DecodeListAccountsRequest, options)
+	accountHandler := newHandler(svc, ScopeRead, MakeAccountEndpoint(svc), DecodeAccountRequest, options)
+	// define a way to service a request for the ListTransfersEndpoint, the CreateTransferEndpoint, the TransferResourceEndpoint and the CancelTransferEndpoint
+	listTransfersHandler := newHandler(svc, ScopeRead, MakeListTransfersEndpoint(svc), DecodeListTransfersRequest, options)
+	createTransferHandler := newHandler(svc, ScopeTransfer, MakeCreateTransferEndpoint(svc), DecodeCreateTransferRequest, options)
+	transferHandler := newHandler(svc, ScopeRead, MakeTransferResourceEndpoint(svc), DecodeTransferResourceRequest, options)
+	cancelTransferHandler := newHandler(svc, ScopeTransfer, MakeCancelTransferEndpoint(svc), DecodeTransferResourceRequest, options)
+	// The verbs a path is not served with are answered by the last route of each path
+	r.Handle("/v1/accounts", listAccountsHandler).Methods(http.MethodGet)
+	r.Handle("/v1/accounts", methodNotAllowedHandler("/v1/accounts", http.MethodGet))
+	r.Handle("/v1/accounts/{id}", accountHandler).Methods(http.MethodGet)
+	r.Handle("/v1/accounts/{id}", methodNotAllowedHandler("/v1/accounts/{id}", http.MethodGet))
+	r.Handle("/v1/transfers", listTransfersHandler).Methods(http.MethodGet)
+	r.Handle("/v1/transfers", createTransferHandler).Methods(http.MethodPost)
+	r.Handle("/v1/transfers", methodNotAllowedHandler("/v1/transfers", http.MethodGet, http.MethodPost))
+	r.Handle("/v1/transfers/{id}", transferHandler).Methods(http.MethodGet)
+	r.Handle("/v1/transfers/{id}", methodNotAllowedHandler("/v1/transfers/{id}", http.MethodGet))
+	r.Handle("/v1/transfers/{id}/cancel", cancelTransferHandler).Methods(http.MethodPost)
+	r.Handle("/v1/transfers/{id}/cancel", methodNotAllowedHandler("/v1/transfers/{id}/cancel", http.MethodPost))
+}
+
+// deprecated marks the responses of a legacy route as deprecated and links them to the route of the /v1 API that succeeds it. The variables of the
+// legacy route, such as {id}, are filled in the successor
+func deprecated(successor string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		link := successor
+		for name, value := range mux.Vars(req) {
+			link = strings.Replace(link, "{"+name+"}", url.PathEscape(value), 1)
+		}
+		w.Header().Set("Deprecation", legacyDeprecation)
+		w.Header().Set("Link", "<"+link+">; rel=\"successor-version\"")
+		next.ServeHTTP(w, req)
+	})
+}
+
+// DecodeListAccountsRequest exported to be accessible from outside the package (from main)
+func DecodeListAccountsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	// An optional "wallet" query parameter narrows the result down to the accounts of a single wallet
+	return accountsRequest{S: r.URL.Query().Get("wallet")}, nil
+}
+
+// DecodeAccountRequest exported to be accessible from outside the package (from main)
+func DecodeAccountRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return accountRequest{ID: mux.Vars(r)["id"]}, nil
+}
+
+// DecodeListTransfersRequest exported to be accessible from outside the package (from main)
+func DecodeListTransfersRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return nil, nil
+}
+
+// DecodeCreateTransferRequest exported to be accessible from outside the package (from main)
+// The body is the one of /submittransfer, checked the same way
+func DecodeCreateTransferRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request submitTransferRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	if err := checkSubmitTransferRequest(request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// DecodeTransferResourceRequest exported to be accessible from outside the package (from main)
+// It also decodes the requests to cancel a transfer, which have no body
+func DecodeTransferResourceRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return transferRequest{ID: mux.Vars(r)["id"]}, nil
+}
@@ -0,0 +1,152 @@
+package wservice
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveHTTP sends a request to the HTTP transport of a wallet service
+func serveHTTP(svc WalletService, method string, target string, body string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	rec := httptest.NewRecorder()
+	NewHTTPTransport(svc).ServeHTTP(rec, httptest.NewRequest(method, target, r))
+	return rec
+}
+
+func TestV1API(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		// A created transfer is answered with 201 Created and the location of the transfer
+		rec := serveHTTP(svc, http.MethodPost, "/v1/transfers", `{"from":"bob123","to":"alice456","amount":"2.5"}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		var created Transfer
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &created))
+		assert.Equal(t, "bob123", created.From)
+		assert.Equal(t, StatusCompleted, created.Status)
+		assert.Equal(t, "/v1/transfers/"+created.ID, rec.Header().Get("Location"))
+
+		rec = serveHTTP(svc, http.MethodGet, rec.Header().Get("Location"), "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var transfer Transfer
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &transfer))
+		assert.Equal(t, created.ID, transfer.ID)
+		assert.Equal(t, "2.500", transfer.Amount)
+		assert.NotEmpty(t, transfer.History)
+
+		// Only a pending transfer can be cancelled
+		rec = serveHTTP(svc, http.MethodPost, "/v1/transfers/"+created.ID+"/cancel", "")
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		pending := pendingTransfer(t, svc, "bob123", "alice456", "1")
+		rec = serveHTTP(svc, http.MethodPost, "/v1/transfers/"+pending.ID+"/cancel", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var cancelled Transfer
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &cancelled))
+		assert.Equal(t, StatusCancelled, cancelled.Status)
+
+		rec = serveHTTP(svc, http.MethodGet, "/v1/transfers", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var transfers listTransfersResponse
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &transfers))
+		assert.NotEmpty(t, transfers.Transfers)
+
+		rec = serveHTTP(svc, http.MethodGet, "/v1/accounts/bob123", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"id":"bob123","wallet_id":"bob","currency":"USD","balance":"299.850","initial_balance":"302.350"}`, rec.Body.String())
+
+		rec = serveHTTP(svc, http.MethodGet, "/v1/accounts?wallet=alice", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var accounts listAccountsResponse
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &accounts))
+		if assert.Len(t, accounts.Accounts, 2) {
+			assert.Equal(t, "alice457", accounts.Accounts[0].ID)
+			assert.Equal(t, "576.310", accounts.Accounts[1].Balance)
+		}
+		rec = serveHTTP(svc, http.MethodGet, "/v1/accounts", "")
+		accounts = listAccountsResponse{}
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &accounts))
+		assert.True(t, len(accounts.Accounts) > 2)
+	})
+}
+
+func TestV1Errors(t *testing.T) {
+	svc := newMemoryService(t)
+	for _, c := range []struct {
+		method, target, body string
+		status               int
+		code                 string
+	}{
+		{http.MethodGet, "/v1/accounts/nobody", "", http.StatusNotFound, "account_not_found"},
+		{http.MethodGet, "/v1/accounts?wallet=nobody", "", http.StatusNotFound, "wallet_not_found"},
+		{http.MethodGet, "/v1/transfers/01D6MK0GTBZ4W3K9XH8S2JQ5VN", "", http.StatusNotFound, "transfer_not_found"},
+		{http.MethodPost, "/v1/transfers/01D6MK0GTBZ4W3K9XH8S2JQ5VN/cancel", "", http.StatusNotFound, "transfer_not_found"},
+		{http.MethodPost, "/v1/transfers", `{"from":"bob123","from_wallet":"bob","to_wallet":"alice","amount":"1"}`, http.StatusBadRequest, "validation_failed"},
+		{http.MethodPost, "/v1/transfers", `{"from":"bob123"`, http.StatusBadRequest, "invalid_request"},
+	} {
+		rec := serveHTTP(svc, c.method, c.target, c.body)
+		assert.Equal(t, c.status, rec.Code, c.target)
+		var p Problem
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &p), c.target)
+		assert.Equal(t, c.code, p.Code, c.target)
+	}
+
+	// A refused transfer is recorded, the problem details tell where to find it
+	rec := serveHTTP(svc, http.MethodPost, "/v1/transfers", `{"from":"bob123","to":"alice456","amount":"100000"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Empty(t, rec.Header().Get("Location"))
+	var p Problem
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	assert.Equal(t, "insufficient_funds", p.Code)
+	failed, err := svc.GetTransfer(p.TransferID)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusFailed, failed.Status)
+
+	// The verbs a route is not served with are answered with the ones it is
+	for _, c := range []struct {
+		method, target, allow string
+	}{
+		{http.MethodPost, "/v1/accounts", "GET"},
+		{http.MethodDelete, "/v1/accounts/bob123", "GET"},
+		{http.MethodPut, "/v1/transfers", "GET, POST"},
+		{http.MethodDelete, "/v1/transfers/01D6MK0GTBZ4W3K9XH8S2JQ5VN", "GET"},
+		{http.MethodGet, "/v1/transfers/01D6MK0GTBZ4W3K9XH8S2JQ5VN/cancel", "POST"},
+	} {
+		rec := serveHTTP(svc, c.method, c.target, "")
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code, c.target)
+		assert.Equal(t, c.allow, rec.Header().Get("Allow"), c.target)
+		assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"), c.target)
+	}
+}
+
+func TestLegacyRoutesDeprecated(t *testing.T) {
+	svc := newMemoryService(t)
+	for _, c := range []struct {
+		method, target, body, successor string
+		status                          int
+	}{
+		{http.MethodGet, "/accounts", "", "/v1/accounts", http.StatusOK},
+		{http.MethodGet, "/transfers", "", "/v1/transfers", http.StatusOK},
+		{http.MethodPost, "/submittransfer", `{"from":"bob123","to":"alice456","amount":"1"}`, "/v1/transfers", http.StatusOK},
+		{http.MethodGet, "/transfers/01D6MK0GTBZ4W3K9XH8S2JQ5VN", "", "/v1/transfers/01D6MK0GTBZ4W3K9XH8S2JQ5VN", http.StatusNotFound},
+		{http.MethodDelete, "/accounts", "", "/v1/accounts", http.StatusMethodNotAllowed},
+	} {
+		// The legacy routes keep working as they did
+		rec := serveHTTP(svc, c.method, c.target, c.body)
+		assert.Equal(t, c.status, rec.Code, c.target)
+		assert.Equal(t, legacyDeprecation, rec.Header().Get("Deprecation"), c.target)
+		assert.Equal(t, "<"+c.successor+">; rel=\"successor-version\"", rec.Header().Get("Link"), c.target)
+	}
+
+	// The routes the /v1 API does not succeed are not deprecated
+	rec := serveHTTP(svc, http.MethodGet, "/currencies", "")
+	assert.Empty(t, rec.Header().Get("Deprecation"))
+	rec = serveHTTP(svc, http.MethodGet, "/v1/accounts", "")
+	assert.Empty(t, rec.Header().Get("Deprecation"))
+}
@@ -0,0 +1,366 @@
+package wservice
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The validation middleware sits in front of the wallet service and rejects malformed transfer requests before they reach the database,
+// returning a ValidationError that lists every invalid field rather than only the first one
+
+const (
+	// maxAmountIntegerDigits and maxAmountScale follow the decimal(9,3) type of the Amount and Balance columns
+	maxAmountIntegerDigits = 6
+	maxAmountScale         = 3
+	// maxIDLength follows the varchar(255) type of the AccountID and WalletID columns
+	maxIDLength = 255
+	// maxWebhookURLLength is the longest URL a webhook can be registered with, minWebhookSecretLength and maxWebhookSecretLength bound a secret chosen by the client
+	maxWebhookURLLength    = 2048
+	minWebhookSecretLength = 16
+	maxWebhookSecretLength = 255
+	// maxAPIKeyNameLength is the longest name an API key can be issued with
+	maxAPIKeyNameLength = 255
+)
+
+var (
+	// amountPattern only accepts plain positive decimal notation, so signs, exponents, "NaN" and "Inf" are refused
+	amountPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+	// idPattern is the format of account and wallet identifiers (e.g. "bob123" or "bankinterestusd")
+	idPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	// currencyPattern is the format of an ISO 4217 alphabetic currency code
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// FieldError is the validation failure of a single request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when one or more fields of a request are invalid, it is an ErrValidation
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Error returns every field failure in a single message
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + " " + f.Message
+	}
+	return "Validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the kind of the error so that errors.Is can match it
+func (e ValidationError) Unwrap() error {
+	return ErrValidation
+}
+
+// validator collects the field failures of a request
+type validator struct {
+	fields []FieldError
+}
+
+// fail records the failure of a field
+func (v *validator) fail(field string, message string) {
+	v.fields = append(v.fields, FieldError{Field: field, Message: message})
+}
+
+// id checks that a required account or wallet identifier is well formed
+func (v *validator) id(field string, id string) {
+	switch {
+	case id == "":
+		v.fail(field, "is required")
+	case len(id) > maxIDLength:
+		v.fail(field, "must be at most 255 characters long")
+	case !idPattern.MatchString(id):
+		v.fail(field, "may only contain letters, digits, '_' and '-'")
+	}
+}
+
+// currency checks that a required currency code is well formed, whether it is part of the registry is checked by the service
+func (v *validator) currency(field string, code string) {
+	switch {
+	case code == "":
+		v.fail(field, "is required")
+	case !currencyPattern.MatchString(code):
+		v.fail(field, "must be an ISO 4217 alphabetic code (e.g. \"USD\")")
+	}
+}
+
+// amount checks that a transfer amount is a positive, finite decimal that fits the Amount column. The precision of the
+// amount's currency (e.g. no decimals for JPY) is checked by the service once the currency of the accounts is known
+func (v *validator) amount(field string, amount string) {
+	if amount == "" {
+		v.fail(field, "is required")
+		return
+	}
+	if !amountPattern.MatchString(amount) {
+		v.fail(field, "must be a positive decimal number (e.g. \"30\" or \"12.50\")")
+		return
+	}
+	integer := strings.TrimLeft(strings.SplitN(amount, ".", 2)[0], "0")
+	switch {
+	case strings.Trim(amount, "0.") == "":
+		v.fail(field, "must be greater than zero")
+	case len(integer) > maxAmountIntegerDigits:
+		v.fail(field, "must be less than 1000000")
+	case amountScale(amount) > maxAmountScale:
+		v.fail(field, "must have at most 3 decimal places")
+	}
+}
+
+// webhook checks the URL, event types and optional secret of a new webhook
+func (v *validator) webhook(rawURL string, events []string, secret string) {
+	switch u, err := url.Parse(rawURL); {
+	case rawURL == "":
+		v.fail("url", "is required")
+	case len(rawURL) > maxWebhookURLLength:
+		v.fail("url", "must be at most "+strconv.Itoa(maxWebhookURLLength)+" characters long")
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+		v.fail("url", "must be an absolute http:// or https:// URL")
+	}
+	if len(events) == 0 {
+		v.fail("events", "must list at least one of "+strings.Join(webhookEvents, ", "))
+	}
+	for i, e := range events {
+		switch {
+		case !contains(webhookEvents, e):
+			v.fail("events", "must only list "+strings.Join(webhookEvents, ", ")+", got \""+e+"\"")
+		case contains(events[:i], e):
+			v.fail("events", "must not list \""+e+"\" twice")
+		}
+	}
+	if secret != "" && (len(secret) < minWebhookSecretLength || len(secret) > maxWebhookSecretLength) {
+		v.fail("secret", "must be between "+strconv.Itoa(minWebhookSecretLength)+" and "+strconv.Itoa(maxWebhookSecretLength)+" characters long, or left out to have one generated")
+	}
+}
+
+// apiKey checks the name and scopes of a new API key
+func (v *validator) apiKey(name string, scopes []string) {
+	switch {
+	case strings.TrimSpace(name) == "":
+		v.fail("name", "is required")
+	case len(name) > maxAPIKeyNameLength:
+		v.fail("name", "must be at most "+strconv.Itoa(maxAPIKeyNameLength)+" characters long")
+	}
+	if len(scopes) == 0 {
+		v.fail("scopes", "must list at least one of "+strings.Join(apiKeyScopes, ", "))
+	}
+	for i, s := range scopes {
+		switch {
+		case !contains(apiKeyScopes, s):
+			v.fail("scopes", "must only list "+strings.Join(apiKeyScopes, ", ")+", got \""+s+"\"")
+		case contains(scopes[:i], s):
+			v.fail("scopes", "must not list \""+s+"\" twice")
+		}
+	}
+}
+
+// err returns the ValidationError of the collected failures, or nil if there were none
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return ValidationError{Fields: v.fields}
+}
+
+// validatingMiddleware is the type of the wrapper around the core service and any other functionality layers
+type validatingMiddleware struct {
+	next WalletService
+}
+
+// NewValidating is how the validating middleware (validatingMiddleware struct) is constructed (the function is exported so it can be used from outside the package)
+func NewValidating(next WalletService) WalletService {
+	return &validatingMiddleware{
+		next: next,
+	}
+}
+
+// validateTransfer checks the source account, destination account and amount of a transfer
+func validateTransfer(fromAccount string, toAccount string, transferAmount string) error {
+	var v validator
+	v.id("from", fromAccount)
+	v.id("to", toAccount)
+	v.amount("amount", transferAmount)
+	return v.err()
+}
+
+// GetTable function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) GetTable(s string) ([]string, error) {
+	return mw.next.GetTable(s)
+}
+
+// DoTransfer function is implemented for the validating layer, only a valid transfer goes down to the next layer
+func (mw validatingMiddleware) DoTransfer(s string, t string, v string) (string, error) {
+	if err := validateTransfer(s, t, v); err != nil {
+		return "error", err
+	}
+	return mw.next.DoTransfer(s, t, v)
+}
+
+// GetCurrencies function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) GetCurrencies() ([]string, error) {
+	return mw.next.GetCurrencies()
+}
+
+// SetCurrencyEnabled function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) SetCurrencyEnabled(c string, e bool) (string, error) {
+	return mw.next.SetCurrencyEnabled(c, e)
+}
+
+// GetWallet function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) GetWallet(w string) ([]string, error) {
+	return mw.next.GetWallet(w)
+}
+
+// SetInterestRate function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) SetInterestRate(a string, r string, d string, e string) (string, error) {
+	return mw.next.SetInterestRate(a, r, d, e)
+}
+
+// AccrueInterest function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) AccrueInterest(d string) (string, error) {
+	return mw.next.AccrueInterest(d)
+}
+
+// PostInterest function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) PostInterest(m string) (string, error) {
+	return mw.next.PostInterest(m)
+}
+
+// PostDueInterest function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) PostDueInterest() (string, error) {
+	return mw.next.PostDueInterest()
+}
+
+// SubmitTransfer function is implemented for the validating layer, only a valid transfer goes down to the next layer
+func (mw validatingMiddleware) SubmitTransfer(s string, t string, v string) (Transfer, error) {
+	if err := validateTransfer(s, t, v); err != nil {
+		return Transfer{Status: StatusFailed}, err
+	}
+	return mw.next.SubmitTransfer(s, t, v)
+}
+
+// DoWalletTransfer function is implemented for the validating layer, only a valid transfer goes down to the next layer
+func (mw validatingMiddleware) DoWalletTransfer(s string, t string, c string, v string) (Transfer, error) {
+	var val validator
+	val.id("from_wallet", s)
+	val.id("to_wallet", t)
+	val.currency("currency", c)
+	val.amount("amount", v)
+	if err := val.err(); err != nil {
+		return Transfer{Status: StatusFailed}, err
+	}
+	return mw.next.DoWalletTransfer(s, t, c, v)
+}
+
+// GetTransfer function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) GetTransfer(id string) (Transfer, error) {
+	return mw.next.GetTransfer(id)
+}
+
+// ReverseTransfer function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) ReverseTransfer(id string) (Transfer, error) {
+	return mw.next.ReverseTransfer(id)
+}
+
+// CancelTransfer function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) CancelTransfer(id string) (Transfer, error) {
+	return mw.next.CancelTransfer(id)
+}
+
+// CreateWebhook function is implemented for the validating layer, only a webhook with a valid URL, event types and secret goes down to the next layer
+func (mw validatingMiddleware) CreateWebhook(u string, e []string, s string) (Webhook, error) {
+	var val validator
+	val.webhook(u, e, s)
+	if err := val.err(); err != nil {
+		return Webhook{}, err
+	}
+	return mw.next.CreateWebhook(u, e, s)
+}
+
+// GetWebhooks function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) GetWebhooks() ([]Webhook, error) {
+	return mw.next.GetWebhooks()
+}
+
+// GetWebhook function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) GetWebhook(id string) (Webhook, error) {
+	return mw.next.GetWebhook(id)
+}
+
+// SetWebhookEnabled function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) SetWebhookEnabled(id string, e bool) (Webhook, error) {
+	return mw.next.SetWebhookEnabled(id, e)
+}
+
+// DeleteWebhook function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) DeleteWebhook(id string) (string, error) {
+	return mw.next.DeleteWebhook(id)
+}
+
+// GetWebhookDeliveries function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) GetWebhookDeliveries(id string) ([]WebhookDelivery, error) {
+	return mw.next.GetWebhookDeliveries(id)
+}
+
+// ReplayWebhookDelivery function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) ReplayWebhookDelivery(id string, d string) (WebhookDelivery, error) {
+	return mw.next.ReplayWebhookDelivery(id, d)
+}
+
+// GetAccounts function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) GetAccounts(w string) ([]Account, error) {
+	return mw.next.GetAccounts(w)
+}
+
+// GetAccount function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) GetAccount(id string) (Account, error) {
+	return mw.next.GetAccount(id)
+}
+
+// GetTransfers function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) GetTransfers() ([]Transfer, error) {
+	return mw.next.GetTransfers()
+}
+
+// CreateAPIKey function is implemented for the validating layer, only an API key with a name and valid scopes goes down to the next layer
+func (mw validatingMiddleware) CreateAPIKey(name string, scopes []string) (APIKey, error) {
+	var val validator
+	val.apiKey(name, scopes)
+	if err := val.err(); err != nil {
+		return APIKey{}, err
+	}
+	return mw.next.CreateAPIKey(name, scopes)
+}
+
+// GetAPIKeys function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) GetAPIKeys() ([]APIKey, error) {
+	return mw.next.GetAPIKeys()
+}
+
+// RotateAPIKey function is implemented for the validating layer, only a grace period that is not negative goes down to the next layer
+func (mw validatingMiddleware) RotateAPIKey(id string, grace time.Duration) (APIKey, error) {
+	var val validator
+	if grace < 0 {
+		val.fail("grace", "must not be negative (0 expires the rotated key at once)")
+	}
+	if err := val.err(); err != nil {
+		return APIKey{}, err
+	}
+	return mw.next.RotateAPIKey(id, grace)
+}
+
+// RevokeAPIKey function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) RevokeAPIKey(id string) (APIKey, error) {
+	return mw.next.RevokeAPIKey(id)
+}
+
+// Authenticate function is implemented for the validating layer and passes the request through to the next layer
+func (mw validatingMiddleware) Authenticate(key string) (APIKey, error) {
+	return mw.next.Authenticate(key)
+}
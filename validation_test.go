@@ -0,0 +1,88 @@
+package wservice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAmount(t *testing.T) {
+	cases := []struct {
+		amount string
+		valid  bool
+	}{
+		{"30", true},
+		{"0.005", true},
+		{"12.50", true},
+		{"999999.999", true},
+		{"1.2300", true},
+		{"", false},
+		{"0", false},
+		{"0.000", false},
+		{"-30", false},
+		{"+30", false},
+		{"NaN", false},
+		{"Inf", false},
+		{"1e308", false},
+		{"1000000", false},
+		{"0.0001", false},
+		{"12.", false},
+		{" 30", false},
+	}
+	for _, c := range cases {
+		var v validator
+		v.amount("amount", c.amount)
+		assert.Equal(t, c.valid, v.err() == nil, c.amount)
+	}
+}
+
+func TestValidatingMiddleware(t *testing.T) {
+	// The next layer is never reached by an invalid request
+	svc := NewValidating(nil)
+	status, err := svc.DoTransfer("", "alice 456", "-30")
+	assert.Equal(t, "error", status)
+	assert.True(t, errors.Is(err, ErrValidation))
+	var verr ValidationError
+	assert.True(t, errors.As(err, &verr))
+	assert.Equal(t, []FieldError{
+		{"from", "is required"},
+		{"to", "may only contain letters, digits, '_' and '-'"},
+		{"amount", "must be a positive decimal number (e.g. \"30\" or \"12.50\")"},
+	}, verr.Fields)
+
+	transfer, err := svc.DoWalletTransfer("bob", "alice", "usd", "30")
+	assert.Equal(t, StatusFailed, transfer.Status)
+	assert.EqualError(t, err, "Validation failed: currency must be an ISO 4217 alphabetic code (e.g. \"USD\")")
+
+	p := NewProblem(context.Background(), err)
+	assert.Equal(t, http.StatusBadRequest, p.Status)
+	assert.Equal(t, "validation_failed", p.Code)
+	assert.Equal(t, []FieldError{{"currency", "must be an ISO 4217 alphabetic code (e.g. \"USD\")"}}, p.Errors)
+}
+
+func TestDecodeSubmitTransferValidation(t *testing.T) {
+	cases := []struct {
+		body string
+		kind error
+	}{
+		{`{"from":"bob123","to":"alice456","amount":"30","memo":"x"}`, ErrInvalidRequest},
+		{`{"from":"bob123","to":"alice456","amount":"30"} {}`, ErrInvalidRequest},
+		{`{"from":"bob123","from_wallet":"bob","to_wallet":"alice","currency":"USD","amount":"30"}`, ErrValidation},
+		{`{"from":"bob123","to":"alice456","currency":"USD","amount":"30"}`, ErrValidation},
+		{`{"from":"` + strings.Repeat("a", maxRequestBodySize) + `"}`, ErrRequestTooLarge},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/submittransfer", strings.NewReader(c.body))
+		_, err := DecodeSubmitTransferRequest(context.Background(), r)
+		assert.True(t, errors.Is(err, c.kind), err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/submittransfer", strings.NewReader(`{"from_wallet":"bob","to_wallet":"alice","currency":"USD","amount":"30"}`))
+	request, err := DecodeSubmitTransferRequest(context.Background(), r)
+	assert.Nil(t, err)
+	assert.Equal(t, submitTransferRequest{FromWallet: "bob", ToWallet: "alice", Currency: "USD", Amount: "30"}, request)
+}
@@ -0,0 +1,58 @@
+package wservice
+
+// Wallets group the per-currency accounts of a single owner under one wallet ID (the WalletID column of the Accounts table),
+// so that a wallet holds at most one balance per currency and transfers can be addressed by wallet plus currency
+
+// GetWallet is a ledger type method that fetches every per-currency balance grouped under the given wallet ID
+func (l ledger) GetWallet(walletID string) ([]string, error) {
+	var accounts []Account
+	err := l.store.View(func(tx LedgerTx) error {
+		var err error
+		accounts, err = tx.WalletAccounts(walletID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []string
+	for _, a := range accounts {
+		rString := "Wallet: " + walletID + "  Account: " + a.ID + "  Balance = " + formatAmount(a.Balance) + " " + a.Currency + "  Initial Balance = " + formatAmount(a.InitialBalance)
+		results = append(results, rString)
+	}
+	// A wallet only exists through the accounts grouped under it
+	if len(results) == 0 {
+		var ErrNoWallet = newError(ErrWalletNotFound, "The wallet "+walletID+" does not exist")
+		return nil, ErrNoWallet
+	}
+	results = append(results, "Success.")
+	return results, nil
+}
+
+// DoWalletTransfer is a ledger type method that resolves the source and destination wallets to their accounts in the given currency inside the
+// transaction of the transfer, and moves the funds between those accounts with all the checks of a regular transfer, returning the resulting transfer
+func (l ledger) DoWalletTransfer(fromWallet string, toWallet string, currency string, transferAmount string) (Transfer, error) {
+	return l.submitTransfer(transferAmount, func(tx LedgerTx) (string, string, error) {
+		fromAccount, err := walletAccount(tx, fromWallet, currency)
+		if err != nil {
+			return "", "", err
+		}
+		toAccount, err := walletAccount(tx, toWallet, currency)
+		return fromAccount, toAccount, err
+	})
+}
+
+// walletAccount returns the ID of the account holding the wallet's balance in the given currency
+func walletAccount(tx LedgerTx, walletID string, currency string) (string, error) {
+	accounts, err := tx.WalletAccounts(walletID)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range accounts {
+		if a.Currency == currency {
+			return a.ID, nil
+		}
+	}
+	var ErrNoBalance = newError(ErrAccountNotFound, "The wallet "+walletID+" has no "+currency+" balance")
+	return "", ErrNoBalance
+}
@@ -0,0 +1,244 @@
+package wservice
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// The webhook dispatcher is an EventPublisher of the outbox relay: publishing an event queues a delivery of it for every enabled webhook subscribed to its type,
+// in a single transaction, so the relay marks the event published once every delivery is safely recorded. The deliveries are then sent on their own schedule,
+// a slow or failing receiver never holding up the relay or the other webhooks' deliveries. Every attempt claims its deliveries first, so that several instances
+// of the service can run the dispatcher on the same ledger without sending a delivery twice at the same time
+
+// The headers of a webhook delivery. The signature is "sha256=" followed by the hex HMAC-SHA256, keyed with the secret of the webhook, of the timestamp,
+// a "." and the body (see SignWebhook). Receivers should check it and refuse timestamps too far in the past to stop replayed requests
+const (
+	WebhookEventHeader     = "X-Wservice-Event"
+	WebhookDeliveryHeader  = "X-Wservice-Delivery"
+	WebhookTimestampHeader = "X-Wservice-Timestamp"
+	WebhookSignatureHeader = "X-Wservice-Signature"
+)
+
+// SignWebhook exported to be accessible from outside the package (from the receivers of the webhooks)
+// SignWebhook returns the signature of a delivery body sent at the given Unix time with the secret of its webhook
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcher queues the events relayed by the outbox as deliveries to the subscribed webhooks and sends them
+type WebhookDispatcher struct {
+	store  Store
+	client *http.Client
+	cfg    WebhooksConfig
+	// now is the clock the attempts are scheduled with (replaced by tests)
+	now func() time.Time
+}
+
+// NewWebhookDispatcher exported to be accessible from outside the package (from main)
+// NewWebhookDispatcher creates the webhook dispatcher of the wallet service created by NewServiceFromConfig
+func NewWebhookDispatcher(svc WalletService, cfg WebhooksConfig) (*WebhookDispatcher, error) {
+	store, ok := storeOf(svc)
+	if !ok {
+		var ErrNoStore = errors.New("err: only the wallet service created by NewServiceFromConfig has webhooks")
+		return nil, ErrNoStore
+	}
+	return &WebhookDispatcher{store: store, client: &http.Client{Timeout: time.Duration(cfg.Timeout)}, cfg: cfg, now: time.Now}, nil
+}
+
+// Publish queues a delivery of the event, as the relay publishes it, for every enabled webhook subscribed to its type. An event relayed again is not queued twice
+func (d *WebhookDispatcher) Publish(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	now := d.now()
+	return d.store.Update(func(tx LedgerTx) error {
+		webhooks, err := tx.Webhooks()
+		if err != nil {
+			return err
+		}
+		for _, w := range webhooks {
+			if !w.Enabled || !w.subscribes(e.Type) {
+				continue
+			}
+			if err := tx.InsertDelivery(WebhookDelivery{WebhookID: w.ID, EventSeq: e.Seq, EventType: e.Type, Payload: body, NextAttempt: now}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// webhookAttempt is a delivery claimed by the dispatcher together with the webhook it goes to
+type webhookAttempt struct {
+	delivery WebhookDelivery
+	webhook  Webhook
+}
+
+// Deliver sends the deliveries that are due, up to a batch of them, and records the outcome of every attempt. It returns how many were attempted
+func (d *WebhookDispatcher) Deliver(ctx context.Context) (int, error) {
+	now := d.now()
+	var attempts []webhookAttempt
+	err := d.store.Update(func(tx LedgerTx) error {
+		attempts = nil
+		due, err := tx.DueDeliveries(now, d.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+		// Claim the deliveries by moving their next attempt past the time it takes to send them all, another instance leaves them alone until then
+		// and sends them again only if this one stopped before recording the outcome
+		lease := now.Add(time.Duration(len(due))*time.Duration(d.cfg.Timeout) + time.Duration(d.cfg.Interval))
+		for _, delivery := range due {
+			w, err := tx.Webhook(delivery.WebhookID)
+			if err != nil {
+				return err
+			}
+			claimed := delivery
+			claimed.NextAttempt = lease
+			if err := tx.UpdateDelivery(claimed); err != nil {
+				return err
+			}
+			attempts = append(attempts, webhookAttempt{delivery: delivery, webhook: w})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// The deliveries are sent outside of any transaction, so a slow receiver never holds up the transfers
+	for i, a := range attempts {
+		if ctx.Err() != nil {
+			return i, ctx.Err()
+		}
+		code, sendErr := d.send(ctx, a.webhook, a.delivery)
+		// An attempt cut short by the shutdown is not the receiver's fault, the delivery is sent again once its claim runs out
+		if ctx.Err() != nil {
+			return i, ctx.Err()
+		}
+		if err := d.store.Update(func(tx LedgerTx) error { return d.record(tx, a.delivery.ID, code, sendErr) }); err != nil {
+			return i, err
+		}
+	}
+	return len(attempts), nil
+}
+
+// send POSTs a delivery to its webhook, signed with the secret of the webhook. It returns the response code, if there was a response, and an error
+// unless the receiver answered with a 2xx
+func (d *WebhookDispatcher) send(ctx context.Context, w Webhook, delivery WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(w.Secret, timestamp, delivery.Payload))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxRequestBodySize))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.New("err: the webhook answered " + resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// record records the outcome of an attempt to send a delivery. A failed delivery is retried after an exponential backoff until it runs out of attempts, and
+// its webhook is disabled once it reaches the configured number of consecutive failures. Nothing is recorded if the webhook was deleted in the meantime
+func (d *WebhookDispatcher) record(tx LedgerTx, deliveryID int64, code int, sendErr error) error {
+	delivery, err := tx.Delivery(deliveryID)
+	if err == errNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w, err := tx.Webhook(delivery.WebhookID)
+	if err != nil {
+		return err
+	}
+	now := d.now()
+	delivery.Attempts++
+	delivery.ResponseCode = code
+	if sendErr == nil {
+		delivery.Status, delivery.LastError, delivery.DeliveredAt = DeliveryDelivered, "", &now
+		w.Failures = 0
+	} else {
+		delivery.LastError = sendErr.Error()
+		if delivery.Attempts >= d.cfg.MaxAttempts {
+			delivery.Status = DeliveryFailed
+		} else {
+			delivery.NextAttempt = now.Add(d.backoff(delivery.Attempts))
+		}
+		w.Failures++
+		if d.cfg.DisableAfter > 0 && w.Failures >= d.cfg.DisableAfter {
+			w.Enabled = false
+		}
+	}
+	if err := tx.UpdateDelivery(delivery); err != nil {
+		return err
+	}
+	return tx.UpdateWebhook(w)
+}
+
+// backoff returns how long to wait after the given number of failed attempts: the configured backoff, doubled after every attempt up to the maximum backoff
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	wait, max := time.Duration(d.cfg.Backoff), time.Duration(d.cfg.MaxBackoff)
+	for i := 1; i < attempts && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		return max
+	}
+	return wait
+}
+
+// RunWebhookDispatcher exported to be accessible from outside the package (from main)
+// RunWebhookDispatcher sends the due deliveries every interval until stop is closed. A full batch is followed by the next one right away
+func RunWebhookDispatcher(d *WebhookDispatcher, logger log.Logger, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	ticker := time.NewTicker(time.Duration(d.cfg.Interval))
+	defer ticker.Stop()
+	for {
+		n, err := d.Deliver(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Log("msg", "webhook deliveries failed", "attempted", n, "err", err)
+		}
+		if err == nil && n == d.cfg.BatchSize {
+			select {
+			case <-stop:
+				return
+			default:
+				continue
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package wservice
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhooks let clients subscribe a URL to some of the events of the outbox. The webhook dispatcher (see webhook_dispatcher.go) turns every relayed event
+// into a delivery per subscribed webhook, POSTs it signed with the secret of the webhook and retries it with exponential backoff until the receiver answers
+// with a 2xx. Every delivery is recorded with the last response code and error so it can be inspected and replayed, and a webhook whose deliveries keep
+// failing is disabled until it is enabled again
+
+// EventAccountLowBalance is written when a transfer takes the balance of its source account below the configured low balance threshold, its payload is a LowBalance
+const EventAccountLowBalance = "account.low_balance"
+
+// webhookEvents are the event types a webhook can subscribe to
+var webhookEvents = []string{EventTransferCompleted, EventTransferReversed, EventAccountLowBalance}
+
+// The statuses of a webhook delivery: pending until the receiver accepts it, failed once every attempt was used up
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// webhookDeliveriesLimit is the number of most recent deliveries GetWebhookDeliveries returns
+const webhookDeliveriesLimit = 100
+
+// Webhook is a subscription of a URL to some event types. The secret signs the deliveries and is only returned when the webhook is created. Failures counts
+// the consecutive failed delivery attempts, the webhook being disabled when it reaches the webhooks.disable_after setting
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	Enabled   bool      `json:"enabled"`
+	Failures  int       `json:"consecutive_failures"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// subscribes reports whether the webhook subscribed to an event type
+func (w Webhook) subscribes(eventType string) bool {
+	return contains(w.Events, eventType)
+}
+
+// WebhookDelivery is an event to deliver to a webhook. Payload is the event exactly as it is POSTed, ResponseCode and LastError are the outcome of the last attempt
+type WebhookDelivery struct {
+	ID           int64           `json:"id"`
+	WebhookID    string          `json:"webhook_id"`
+	EventSeq     int64           `json:"event_seq"`
+	EventType    string          `json:"event_type"`
+	Payload      json.RawMessage `json:"-"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	ResponseCode int             `json:"response_code,omitempty"`
+	LastError    string          `json:"last_error,omitempty"`
+	NextAttempt  time.Time       `json:"next_attempt_at"`
+	DeliveredAt  *time.Time      `json:"delivered_at,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// LowBalance is the payload of an account.low_balance event
+type LowBalance struct {
+	AccountID  string `json:"account_id"`
+	WalletID   string `json:"wallet_id"`
+	Currency   string `json:"currency"`
+	Balance    string `json:"balance"`
+	Threshold  string `json:"threshold"`
+	TransferID string `json:"transfer_id"`
+}
+
+// emitLowBalance writes an account.low_balance event when a transfer of amount out of source (as it was before the transfer) takes its balance
+// from at least the low balance threshold to below it, so an account that stays low does not report it again on every transfer
+func (l ledger) emitLowBalance(tx LedgerTx, source Account, amount string, transferID string) error {
+	if l.lowBalance == "" {
+		return nil
+	}
+	threshold, err := parseAmount(l.lowBalance)
+	if err != nil {
+		return err
+	}
+	before, err := parseAmount(source.Balance)
+	if err != nil {
+		return err
+	}
+	value, err := parseAmount(amount)
+	if err != nil {
+		return err
+	}
+	after := new(big.Rat).Sub(before, value)
+	if before.Cmp(threshold) < 0 || after.Cmp(threshold) >= 0 {
+		return nil
+	}
+	return l.emit(tx, EventAccountLowBalance, LowBalance{
+		AccountID:  source.ID,
+		WalletID:   source.WalletID,
+		Currency:   source.Currency,
+		Balance:    after.FloatString(storeAmountScale),
+		Threshold:  l.lowBalance,
+		TransferID: transferID,
+	})
+}
+
+// newWebhookSecret returns a random secret to sign the deliveries of a webhook with
+func newWebhookSecret() (string, error) {
+	var secret [32]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret[:]), nil
+}
+
+// webhookKey returns the ULID of a webhook out of the ID it was requested with
+func webhookKey(id string) (string, error) {
+	if uid := strings.ToUpper(id); isULID(uid) {
+		return uid, nil
+	}
+	var ErrID = newError(ErrInvalidRequest, "The webhook ID must be a ULID")
+	return "", ErrID
+}
+
+// errNoWebhook is returned when the requested webhook does not exist
+var errNoWebhook = newError(ErrWebhookNotFound, "The webhook does not exist")
+
+// CreateWebhook is a ledger type method that subscribes a URL to some event types. A secret is generated unless one is given, and the webhook is returned
+// with its secret, which is never returned again
+func (l ledger) CreateWebhook(url string, events []string, secret string) (Webhook, error) {
+	var err error
+	if secret == "" {
+		if secret, err = newWebhookSecret(); err != nil {
+			return Webhook{}, err
+		}
+	}
+	id, err := newULID(l.now())
+	if err != nil {
+		return Webhook{}, err
+	}
+	w := Webhook{ID: id, URL: url, Events: events, Secret: secret, Enabled: true, CreatedAt: l.clockTime()}
+	err = l.store.Update(func(tx LedgerTx) error {
+		var err error
+		w, err = tx.InsertWebhook(w)
+		return err
+	})
+	if err != nil {
+		return Webhook{}, err
+	}
+	return w, nil
+}
+
+// GetWebhooks is a ledger type method that lists every webhook, without their secrets
+func (l ledger) GetWebhooks() ([]Webhook, error) {
+	var webhooks []Webhook
+	err := l.store.View(func(tx LedgerTx) error {
+		var err error
+		webhooks, err = tx.Webhooks()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+// GetWebhook is a ledger type method that fetches a single webhook, without its secret
+func (l ledger) GetWebhook(id string) (Webhook, error) {
+	key, err := webhookKey(id)
+	if err != nil {
+		return Webhook{}, err
+	}
+	var w Webhook
+	err = l.store.View(func(tx LedgerTx) error {
+		var err error
+		if w, err = tx.Webhook(key); err == errNotFound {
+			return errNoWebhook
+		}
+		return err
+	})
+	if err != nil {
+		return Webhook{}, err
+	}
+	w.Secret = ""
+	return w, nil
+}
+
+// SetWebhookEnabled is a ledger type method that enables or disables a webhook. Enabling a webhook (e.g. once its receiver is fixed) also clears its
+// count of consecutive failures
+func (l ledger) SetWebhookEnabled(id string, enabled bool) (Webhook, error) {
+	key, err := webhookKey(id)
+	if err != nil {
+		return Webhook{}, err
+	}
+	var w Webhook
+	err = l.store.Update(func(tx LedgerTx) error {
+		var err error
+		if w, err = tx.Webhook(key); err == errNotFound {
+			return errNoWebhook
+		} else if err != nil {
+			return err
+		}
+		if enabled && !w.Enabled {
+			w.Failures = 0
+		}
+		w.Enabled = enabled
+		return tx.UpdateWebhook(w)
+	})
+	if err != nil {
+		return Webhook{}, err
+	}
+	w.Secret = ""
+	return w, nil
+}
+
+// DeleteWebhook is a ledger type method that deletes a webhook together with its deliveries
+func (l ledger) DeleteWebhook(id string) (string, error) {
+	key, err := webhookKey(id)
+	if err != nil {
+		return "", err
+	}
+	err = l.store.Update(func(tx LedgerTx) error {
+		if err := tx.DeleteWebhook(key); err == errNotFound {
+			return errNoWebhook
+		} else if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return "Webhook " + key + " deleted", nil
+}
+
+// GetWebhookDeliveries is a ledger type method that lists the most recent deliveries of a webhook, newest first
+func (l ledger) GetWebhookDeliveries(id string) ([]WebhookDelivery, error) {
+	key, err := webhookKey(id)
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []WebhookDelivery
+	err = l.store.View(func(tx LedgerTx) error {
+		if _, err := tx.Webhook(key); err == errNotFound {
+			return errNoWebhook
+		} else if err != nil {
+			return err
+		}
+		var err error
+		deliveries, err = tx.Deliveries(key, webhookDeliveriesLimit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// ReplayWebhookDelivery is a ledger type method that sends a delivery of a webhook again, whatever its status: it goes back to pending with a fresh
+// set of attempts and is picked up by the next run of the dispatcher. The webhook must be enabled
+func (l ledger) ReplayWebhookDelivery(id string, delivery string) (WebhookDelivery, error) {
+	key, err := webhookKey(id)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	deliveryID, err := strconv.ParseInt(delivery, 10, 64)
+	if err != nil {
+		var ErrID = newError(ErrInvalidRequest, "The delivery ID must be a number")
+		return WebhookDelivery{}, ErrID
+	}
+	var d WebhookDelivery
+	err = l.store.Update(func(tx LedgerTx) error {
+		w, err := tx.Webhook(key)
+		if err == errNotFound {
+			return errNoWebhook
+		} else if err != nil {
+			return err
+		}
+		d, err = tx.Delivery(deliveryID)
+		if err == errNotFound || (err == nil && d.WebhookID != key) {
+			var ErrNoDelivery = newError(ErrDeliveryNotFound, "The delivery does not exist")
+			return ErrNoDelivery
+		} else if err != nil {
+			return err
+		}
+		if !w.Enabled {
+			var ErrDisabled = newError(ErrWebhookDisabled, "The webhook is disabled, enable it before replaying its deliveries")
+			return ErrDisabled
+		}
+		d.Status, d.Attempts, d.LastError, d.NextAttempt, d.DeliveredAt = DeliveryPending, 0, "", l.now(), nil
+		return tx.UpdateDelivery(d)
+	})
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	return d, nil
+}
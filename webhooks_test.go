@@ -0,0 +1,407 @@
+package wservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookReceiver is a local receiver of webhook deliveries that answers every request with its current status
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+// newWebhookReceiver starts a receiver answering with the given status
+func newWebhookReceiver(t *testing.T, status int) *webhookReceiver {
+	r := &webhookReceiver{status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests, r.bodies = append(r.requests, req), append(r.bodies, body)
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// answer changes the status the receiver answers with
+func (r *webhookReceiver) answer(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+// received returns how many deliveries the receiver got
+func (r *webhookReceiver) received() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+// testDispatcher creates a webhook dispatcher whose clock is read from clock
+func testDispatcher(t *testing.T, svc WalletService, clock *time.Time, change func(cfg *WebhooksConfig)) *WebhookDispatcher {
+	cfg := DefaultConfig().Webhooks
+	cfg.Enabled, cfg.Timeout = true, Duration(5*time.Second)
+	if change != nil {
+		change(&cfg)
+	}
+	d, err := NewWebhookDispatcher(svc, cfg)
+	assert.Nil(t, err)
+	d.now = func() time.Time { return *clock }
+	return d
+}
+
+// webhookDeliveries returns the deliveries of a webhook, failing the test if they cannot be read
+func webhookDeliveries(t *testing.T, svc WalletService, id string) []WebhookDelivery {
+	deliveries, err := svc.GetWebhookDeliveries(id)
+	assert.Nil(t, err)
+	return deliveries
+}
+
+func TestWebhookSubscriptions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		created, err := svc.CreateWebhook("https://hooks.example.com/wservice", []string{EventTransferCompleted, EventAccountLowBalance}, "")
+		assert.Nil(t, err)
+		assert.True(t, isULID(created.ID))
+		assert.True(t, strings.HasPrefix(created.Secret, "whsec_"))
+		assert.True(t, created.Enabled)
+		assert.False(t, created.CreatedAt.IsZero())
+
+		// The secret is only returned on creation
+		webhooks, err := svc.GetWebhooks()
+		assert.Nil(t, err)
+		assert.Len(t, webhooks, 1)
+		assert.Equal(t, created.ID, webhooks[0].ID)
+		assert.Equal(t, []string{EventTransferCompleted, EventAccountLowBalance}, webhooks[0].Events)
+		assert.Empty(t, webhooks[0].Secret)
+		w, err := svc.GetWebhook(strings.ToLower(created.ID))
+		assert.Nil(t, err)
+		assert.Equal(t, "https://hooks.example.com/wservice", w.URL)
+		assert.Empty(t, w.Secret)
+
+		w, err = svc.SetWebhookEnabled(created.ID, false)
+		assert.Nil(t, err)
+		assert.False(t, w.Enabled)
+
+		_, err = svc.GetWebhook("hook")
+		assert.True(t, errors.Is(err, ErrInvalidRequest))
+		_, err = svc.GetWebhook("01ARZ3NDEKTSV4RRFFQ69G5FAV")
+		assert.True(t, errors.Is(err, ErrWebhookNotFound))
+
+		_, err = svc.DeleteWebhook(created.ID)
+		assert.Nil(t, err)
+		_, err = svc.GetWebhook(created.ID)
+		assert.True(t, errors.Is(err, ErrWebhookNotFound))
+		_, err = svc.DeleteWebhook(created.ID)
+		assert.True(t, errors.Is(err, ErrWebhookNotFound))
+	})
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		receiver := newWebhookReceiver(t, http.StatusNoContent)
+		clock := time.Now()
+		d := testDispatcher(t, svc, &clock, nil)
+		secret := "0123456789abcdef"
+		hook, err := svc.CreateWebhook(receiver.URL, []string{EventTransferCompleted}, secret)
+		assert.Nil(t, err)
+		other, err := svc.CreateWebhook(receiver.URL, []string{EventTransferReversed}, "")
+		assert.Nil(t, err)
+
+		transfer, err := svc.SubmitTransfer("bob123", "alice456", "2.35")
+		assert.Nil(t, err)
+		n, err := RelayEvents(context.Background(), svc, d, 10)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+		// An event relayed again is not queued twice
+		assert.Nil(t, d.Publish(context.Background(), outboxEvents(t, svc)[0]))
+		assert.Len(t, webhookDeliveries(t, svc, hook.ID), 1)
+		assert.Len(t, webhookDeliveries(t, svc, other.ID), 0)
+
+		n, err = d.Deliver(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, 1, receiver.received())
+		req, body := receiver.requests[0], receiver.bodies[0]
+		assert.Equal(t, http.MethodPost, req.Method)
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		assert.Equal(t, EventTransferCompleted, req.Header.Get(WebhookEventHeader))
+		timestamp, err := strconv.ParseInt(req.Header.Get(WebhookTimestampHeader), 10, 64)
+		assert.Nil(t, err)
+		assert.Equal(t, clock.Unix(), timestamp)
+		assert.Equal(t, SignWebhook(secret, timestamp, body), req.Header.Get(WebhookSignatureHeader))
+		assert.NotEqual(t, SignWebhook("another secret", timestamp, body), req.Header.Get(WebhookSignatureHeader))
+		var event Event
+		assert.Nil(t, json.Unmarshal(body, &event))
+		assert.Equal(t, EventTransferCompleted, event.Type)
+		var delivered Transfer
+		assert.Nil(t, json.Unmarshal(event.Payload, &delivered))
+		assert.Equal(t, transfer.ID, delivered.ID)
+
+		deliveries := webhookDeliveries(t, svc, hook.ID)
+		assert.Equal(t, strconv.FormatInt(deliveries[0].ID, 10), req.Header.Get(WebhookDeliveryHeader))
+		assert.Equal(t, DeliveryDelivered, deliveries[0].Status)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+		assert.Equal(t, http.StatusNoContent, deliveries[0].ResponseCode)
+		assert.Equal(t, event.Seq, deliveries[0].EventSeq)
+		assert.NotNil(t, deliveries[0].DeliveredAt)
+
+		n, err = d.Deliver(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+	})
+}
+
+func TestWebhookRetry(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		receiver := newWebhookReceiver(t, http.StatusInternalServerError)
+		clock := time.Now()
+		d := testDispatcher(t, svc, &clock, func(cfg *WebhooksConfig) {
+			cfg.MaxAttempts, cfg.Backoff, cfg.MaxBackoff, cfg.DisableAfter = 3, Duration(10*time.Second), Duration(15*time.Second), 0
+		})
+		hook, err := svc.CreateWebhook(receiver.URL, []string{EventTransferCompleted}, "")
+		assert.Nil(t, err)
+		_, err = svc.DoTransfer("bob123", "alice456", "1")
+		assert.Nil(t, err)
+		_, err = RelayEvents(context.Background(), svc, d, 10)
+		assert.Nil(t, err)
+
+		// The first failure is retried after the backoff, and not before
+		n, err := d.Deliver(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+		delivery := webhookDeliveries(t, svc, hook.ID)[0]
+		assert.Equal(t, DeliveryPending, delivery.Status)
+		assert.Equal(t, 1, delivery.Attempts)
+		assert.Equal(t, http.StatusInternalServerError, delivery.ResponseCode)
+		assert.Equal(t, "err: the webhook answered 500 Internal Server Error", delivery.LastError)
+		assert.True(t, delivery.NextAttempt.Equal(clock.Add(10*time.Second)))
+		n, err = d.Deliver(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+
+		// The backoff doubles up to the maximum backoff
+		clock = clock.Add(10 * time.Second)
+		n, err = d.Deliver(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+		delivery = webhookDeliveries(t, svc, hook.ID)[0]
+		assert.Equal(t, 2, delivery.Attempts)
+		assert.True(t, delivery.NextAttempt.Equal(clock.Add(15*time.Second)))
+
+		// Once the attempts are used up the delivery fails for good
+		clock = clock.Add(15 * time.Second)
+		n, err = d.Deliver(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+		delivery = webhookDeliveries(t, svc, hook.ID)[0]
+		assert.Equal(t, DeliveryFailed, delivery.Status)
+		assert.Equal(t, 3, delivery.Attempts)
+		clock = clock.Add(time.Hour)
+		n, err = d.Deliver(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+		assert.Equal(t, 3, receiver.received())
+		w, err := svc.GetWebhook(hook.ID)
+		assert.Nil(t, err)
+		assert.True(t, w.Enabled)
+		assert.Equal(t, 3, w.Failures)
+
+		// A failed delivery can be replayed once the receiver is fixed, which also clears the failures of the webhook
+		receiver.answer(http.StatusOK)
+		replayed, err := svc.ReplayWebhookDelivery(hook.ID, strconv.FormatInt(delivery.ID, 10))
+		assert.Nil(t, err)
+		assert.Equal(t, DeliveryPending, replayed.Status)
+		assert.Equal(t, 0, replayed.Attempts)
+		n, err = d.Deliver(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+		delivery = webhookDeliveries(t, svc, hook.ID)[0]
+		assert.Equal(t, DeliveryDelivered, delivery.Status)
+		assert.Equal(t, http.StatusOK, delivery.ResponseCode)
+		assert.Empty(t, delivery.LastError)
+		w, err = svc.GetWebhook(hook.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, w.Failures)
+
+		_, err = svc.ReplayWebhookDelivery(hook.ID, "42")
+		assert.True(t, errors.Is(err, ErrDeliveryNotFound))
+		_, err = svc.ReplayWebhookDelivery(hook.ID, "last")
+		assert.True(t, errors.Is(err, ErrInvalidRequest))
+	})
+}
+
+func TestWebhookAutoDisable(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		receiver := newWebhookReceiver(t, http.StatusServiceUnavailable)
+		clock := time.Now()
+		d := testDispatcher(t, svc, &clock, func(cfg *WebhooksConfig) {
+			cfg.MaxAttempts, cfg.DisableAfter = 5, 2
+		})
+		hook, err := svc.CreateWebhook(receiver.URL, []string{EventTransferCompleted}, "")
+		assert.Nil(t, err)
+		for i := 0; i < 2; i++ {
+			_, err = svc.DoTransfer("bob123", "alice456", "1")
+			assert.Nil(t, err)
+		}
+		_, err = RelayEvents(context.Background(), svc, d, 10)
+		assert.Nil(t, err)
+
+		// The second consecutive failure disables the webhook, whose deliveries are then left alone
+		n, err := d.Deliver(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 2, n)
+		w, err := svc.GetWebhook(hook.ID)
+		assert.Nil(t, err)
+		assert.False(t, w.Enabled)
+		assert.Equal(t, 2, w.Failures)
+		clock = clock.Add(time.Hour)
+		n, err = d.Deliver(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+		// Nor does it get the new events
+		_, err = svc.DoTransfer("bob123", "alice456", "1")
+		assert.Nil(t, err)
+		_, err = RelayEvents(context.Background(), svc, d, 10)
+		assert.Nil(t, err)
+		deliveries := webhookDeliveries(t, svc, hook.ID)
+		assert.Len(t, deliveries, 2)
+		_, err = svc.ReplayWebhookDelivery(hook.ID, strconv.FormatInt(deliveries[0].ID, 10))
+		assert.True(t, errors.Is(err, ErrWebhookDisabled))
+
+		// Enabling it again resumes the pending deliveries
+		receiver.answer(http.StatusAccepted)
+		w, err = svc.SetWebhookEnabled(hook.ID, true)
+		assert.Nil(t, err)
+		assert.True(t, w.Enabled)
+		assert.Equal(t, 0, w.Failures)
+		n, err = d.Deliver(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 2, n)
+		for _, delivery := range webhookDeliveries(t, svc, hook.ID) {
+			assert.Equal(t, DeliveryDelivered, delivery.Status)
+			assert.Equal(t, 2, delivery.Attempts)
+		}
+		assert.Equal(t, 4, receiver.received())
+	})
+}
+
+func TestWebhookDeleteCascades(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		receiver := newWebhookReceiver(t, http.StatusOK)
+		clock := time.Now()
+		d := testDispatcher(t, svc, &clock, nil)
+		hook, err := svc.CreateWebhook(receiver.URL, []string{EventTransferCompleted}, "")
+		assert.Nil(t, err)
+		_, err = svc.DoTransfer("bob123", "alice456", "1")
+		assert.Nil(t, err)
+		_, err = RelayEvents(context.Background(), svc, d, 10)
+		assert.Nil(t, err)
+		_, err = svc.DeleteWebhook(hook.ID)
+		assert.Nil(t, err)
+		n, err := d.Deliver(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+		assert.Equal(t, 0, receiver.received())
+	})
+}
+
+func TestLowBalanceEvent(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		l := svc.(ledger)
+		l.lowBalance = "300"
+		svc = l
+		// bob123 starts with 302.35: only the transfer that crosses the threshold reports it
+		var transfers []Transfer
+		for _, amount := range []string{"1", "2", "1"} {
+			transfer, err := svc.SubmitTransfer("bob123", "alice456", amount)
+			assert.Nil(t, err)
+			transfers = append(transfers, transfer)
+		}
+		var types []string
+		var low LowBalance
+		for _, e := range outboxEvents(t, svc) {
+			types = append(types, e.Type)
+			if e.Type == EventAccountLowBalance {
+				assert.Nil(t, json.Unmarshal(e.Payload, &low))
+			}
+		}
+		assert.Equal(t, []string{EventTransferCompleted, EventTransferCompleted, EventAccountLowBalance, EventTransferCompleted}, types)
+		assert.Equal(t, LowBalance{AccountID: "bob123", WalletID: low.WalletID, Currency: "USD", Balance: "299.350", Threshold: "300", TransferID: transfers[1].ID}, low)
+		assert.NotEmpty(t, low.WalletID)
+	})
+}
+
+func TestWebhookHTTP(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc WalletService) {
+		handler := NewHTTPTransport(NewValidating(svc))
+		serve := func(method string, target string, body string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+			return rec
+		}
+
+		rec := serve(http.MethodPost, "/webhooks", `{"url":"ftp://hooks","events":["transfer.created","transfer.completed","transfer.completed"],"secret":"short"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		var p Problem
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&p))
+		assert.Equal(t, "validation_failed", p.Code)
+		assert.Equal(t, []FieldError{
+			{Field: "url", Message: "must be an absolute http:// or https:// URL"},
+			{Field: "events", Message: "must only list transfer.completed, transfer.reversed, account.low_balance, got \"transfer.created\""},
+			{Field: "events", Message: "must not list \"transfer.completed\" twice"},
+			{Field: "secret", Message: "must be between 16 and 255 characters long, or left out to have one generated"},
+		}, p.Errors)
+
+		rec = serve(http.MethodPost, "/webhooks", `{"url":"https://hooks.example.com/wservice","events":["transfer.completed"]}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var created struct{ V Webhook }
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&created))
+		assert.NotEmpty(t, created.V.Secret)
+		id := created.V.ID
+
+		rec = serve(http.MethodGet, "/webhooks", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"id":"`+id+`"`)
+		assert.NotContains(t, rec.Body.String(), "secret")
+
+		rec = serve(http.MethodPatch, "/webhooks/"+id, `{}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"field":"enabled"`)
+		rec = serve(http.MethodPatch, "/webhooks/"+id, `{"enabled":false}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"enabled":false`)
+
+		rec = serve(http.MethodPut, "/webhooks/"+id, `{"enabled":true}`)
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+		assert.Equal(t, "GET, PATCH, DELETE", rec.Header().Get("Allow"))
+		assert.Contains(t, rec.Body.String(), `Verb can only be \"GET\" or \"PATCH\" or \"DELETE\" for endpoint \"/webhooks/{id}\"`)
+
+		rec = serve(http.MethodGet, "/webhooks/"+id+"/deliveries", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		rec = serve(http.MethodPost, "/webhooks/"+id+"/deliveries/42/replay", "")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"code":"delivery_not_found"`)
+
+		rec = serve(http.MethodDelete, "/webhooks/"+id, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		rec = serve(http.MethodGet, "/webhooks/"+id, "")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"code":"webhook_not_found"`)
+	})
+}